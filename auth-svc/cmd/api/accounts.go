@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/mailer"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+// Lifetimes of the links sent by email, used when the environment doesn't override
+// them.
+const (
+	defaultResetTTL  = time.Hour
+	defaultVerifyTTL = 48 * time.Hour
+)
+
+var (
+	errInvalidResetToken  = errors.New("invalid or expired password reset token")
+	errInvalidVerifyToken = errors.New("invalid or expired email verification token")
+	errEmailNotVerified   = errors.New("email address has not been verified")
+)
+
+// mailConfig is read from the environment at start up.
+type mailConfig struct {
+	// appURL is where the front end serves the reset-password and verify-email pages
+	// the emailed links point at.
+	appURL          string
+	resetTTL        time.Duration
+	verifyTTL       time.Duration
+	requireVerified bool
+}
+
+func loadMailConfig() (mailConfig, error) {
+	cfg := mailConfig{
+		appURL:          strings.TrimSuffix(envOrDefault("APP_URL", "http://localhost"), "/"),
+		requireVerified: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+	}
+
+	var err error
+
+	cfg.resetTTL, err = durationFromEnv("PASSWORD_RESET_TTL", defaultResetTTL)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.verifyTTL, err = durationFromEnv("EMAIL_VERIFICATION_TTL", defaultVerifyTTL)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// ForgotPassword emails a password reset link to the user. It answers the same way
+// whether or not the account exists, so it can't be used to find out who has one.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetByEmail(r.Context(), strings.TrimSpace(requestPayload.Email))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	case user.Active == 1:
+		app.sendUserToken(r.Context(), user, data.PurposePasswordReset, "password_reset.tmpl", "/reset-password", app.Mail.resetTTL)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "if an account exists for that email, a password reset link has been sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ResetPassword sets a new password using the token from a reset email. Every session
+// the user had is revoked, and any lockout on the account is lifted.
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Passwords.Check(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, stored, ok := app.userFromToken(w, r, data.PurposePasswordReset, requestPayload.Token, errInvalidResetToken)
+	if !ok {
+		return
+	}
+
+	err = app.Models.UserToken.Use(r.Context(), stored)
+	if err != nil {
+		app.userTokenError(w, err, errInvalidResetToken)
+		return
+	}
+
+	err = app.Models.User.ResetPassword(r.Context(), user, requestPayload.Password, data.Event{
+		Type:   data.EventPasswordChanged,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+		Detail: "reset with an emailed link",
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.RefreshToken.RevokeUser(r.Context(), user.ID, data.Event{
+		Type:   data.EventTokenRevoked,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+		Detail: "every session revoked after a password reset",
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.LoginAttempt.ClearEmail(r.Context(), throttleKey(user.Email))
+	if err != nil {
+		log.Println("Error clearing failed logins after password reset:", err)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "password has been reset",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// VerifyEmail marks the user's email address as verified using the token from a
+// verification email.
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, stored, ok := app.userFromToken(w, r, data.PurposeEmailVerification, requestPayload.Token, errInvalidVerifyToken)
+	if !ok {
+		return
+	}
+
+	err = app.Models.UserToken.Use(r.Context(), stored)
+	if err != nil {
+		app.userTokenError(w, err, errInvalidVerifyToken)
+		return
+	}
+
+	err = app.Models.User.VerifyEmail(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("verified %s", user.Email),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ResendVerification emails a new verification link to a user who hasn't verified
+// their address yet. Like ForgotPassword, it doesn't reveal whether the account exists.
+func (app *application) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetByEmail(r.Context(), strings.TrimSpace(requestPayload.Email))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	case user.Active == 1 && user.EmailVerifiedAt == nil:
+		app.sendVerification(r.Context(), user)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "if that email needs verifying, a verification link has been sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// userFromToken looks up an unused, unexpired token and the active user it was issued
+// to, sending invalid as a 400 if there isn't one.
+func (app *application) userFromToken(w http.ResponseWriter, r *http.Request, purpose, plain string, invalid error) (*data.User, *data.UserToken, bool) {
+	stored, err := app.Models.UserToken.GetByHash(r.Context(), purpose, token.Hash(plain))
+	if err != nil {
+		app.userTokenError(w, err, invalid)
+		return nil, nil, false
+	}
+
+	if stored.UsedAt.Valid || time.Now().After(stored.ExpiresAt) {
+		app.errorJSON(w, invalid)
+		return nil, nil, false
+	}
+
+	user, err := app.Models.User.GetOne(r.Context(), stored.UserID)
+	if err != nil {
+		app.userTokenError(w, err, invalid)
+		return nil, nil, false
+	}
+
+	if user.Active != 1 {
+		app.errorJSON(w, invalid)
+		return nil, nil, false
+	}
+
+	return user, stored, true
+}
+
+func (app *application) userTokenError(w http.ResponseWriter, err, invalid error) {
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, data.ErrTokenUsed) {
+		app.errorJSON(w, invalid)
+		return
+	}
+
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+// sendVerification emails the user a link to verify their address.
+func (app *application) sendVerification(ctx context.Context, user *data.User) {
+	app.sendUserToken(ctx, user, data.PurposeEmailVerification, "email_verification.tmpl", "/verify-email", app.Mail.verifyTTL)
+}
+
+// sendUserToken issues a single use token and emails it to the user as a link to path
+// on the front end. Mail is sent in the background; failures are only logged, as
+// the caller's response mustn't depend on them.
+func (app *application) sendUserToken(ctx context.Context, user *data.User, purpose, tmpl, path string, ttl time.Duration) {
+	plain, hash, err := token.NewOneTimeToken()
+	if err != nil {
+		log.Println("Error creating", purpose, "token:", err)
+		return
+	}
+
+	err = app.Models.UserToken.Insert(ctx, data.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		log.Println("Error storing", purpose, "token:", err)
+		return
+	}
+
+	name := user.FirstName
+	if name == "" {
+		name = user.Email
+	}
+
+	msg, err := mailer.Render(user.Email, tmpl, map[string]string{
+		"Name":    name,
+		"Link":    app.Mail.appURL + path + "?token=" + url.QueryEscape(plain),
+		"Expires": humanDuration(ttl),
+	})
+	if err != nil {
+		log.Println("Error rendering", tmpl, "email:", err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err := app.Mailer.Send(ctx, msg)
+		if err != nil {
+			log.Println("Error sending", purpose, "email:", err)
+		}
+	}()
+}
+
+// humanDuration formats a link lifetime for an email, e.g. "1 hour" or "30 minutes".
+func humanDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	if d >= time.Hour && d%time.Hour == 0 {
+		return plural(int(d/time.Hour), "hour")
+	}
+
+	return plural(int(d.Round(time.Minute)/time.Minute), "minute")
+}
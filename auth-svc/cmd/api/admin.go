@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+type contextKey string
+
+const adminContextKey = contextKey("admin")
+
+var errSessionRevoked = errors.New("session has been revoked")
+
+// loadAdmins parses the comma separated list of email addresses allowed to manage users.
+func loadAdmins(raw string) map[string]bool {
+	admins := make(map[string]bool)
+
+	for _, email := range strings.Split(raw, ",") {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			admins[email] = true
+		}
+	}
+
+	return admins
+}
+
+// requireAdmin only lets through requests with an access token of an active user that
+// is listed in ADMIN_EMAILS or has the "*" permission, which the admin role grants.
+// Access tokens are only issued once a login has passed every factor the user needs,
+// so a password alone is never enough, and a token stops working as soon as its
+// session is revoked. The admin is stored in the request context so changes can be
+// attributed to them.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		unauthorized := func(err error) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="auth-svc"`)
+			app.errorJSON(w, err, http.StatusUnauthorized)
+		}
+
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || bearer == "" {
+			unauthorized(errors.New("missing or malformed authorization header"))
+			return
+		}
+
+		claims, err := app.Tokens.Verify(bearer)
+		if err != nil {
+			unauthorized(err)
+			return
+		}
+
+		if claims.SessionID != "" {
+			revoked, err := app.Models.Session.IsRevoked(r.Context(), claims.SessionID)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				unauthorized(errSessionRevoked)
+				return
+			}
+		}
+
+		id, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			unauthorized(token.ErrInvalid)
+			return
+		}
+
+		user, err := app.Models.User.GetOne(r.Context(), id)
+		if err != nil || user.Active != 1 {
+			unauthorized(token.ErrInvalid)
+			return
+		}
+
+		if !app.Admins[strings.ToLower(user.Email)] {
+			permissions, err := app.Models.User.Permissions(r.Context(), user.ID)
+			if err != nil {
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+
+			if !slices.Contains(permissions, allPermissions) {
+				app.errorJSON(w, errors.New("admin access required"), http.StatusForbidden)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), adminContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// adminFromContext returns the admin stored by requireAdmin.
+func adminFromContext(r *http.Request) *data.User {
+	user, ok := r.Context().Value(adminContextKey).(*data.User)
+	if !ok {
+		panic("missing admin value in request context")
+	}
+
+	return user
+}
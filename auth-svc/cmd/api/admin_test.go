@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	key, err := token.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &token.Issuer{Keys: token.NewKeySet(key), Issuer: "http://auth-svc", Audience: "service-hub", TTL: time.Minute}
+
+	users := newFakeUsers(
+		data.User{ID: 1, Email: "Admin@example.com", Active: 1},
+		data.User{ID: 2, Email: "role-admin@example.com", Active: 1},
+		data.User{ID: 3, Email: "alice@example.com", Active: 1},
+		data.User{ID: 4, Email: "gone@example.com", Active: 0},
+	)
+	users.permissions = map[int][]string{2: {allPermissions}, 3: {"users:read"}}
+
+	app := &application{
+		Models: data.Models{User: users},
+		Tokens: issuer,
+		Admins: loadAdmins("admin@example.com, gone@example.com"),
+	}
+
+	h := app.requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(adminFromContext(r).Email))
+	}))
+
+	bearer := func(id int) string {
+		signed, _, err := issuer.Issue(token.Claims{Subject: strconv.Itoa(id)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+
+	other := &token.Issuer{Keys: token.NewKeySet(key), Issuer: "http://elsewhere", Audience: "service-hub", TTL: time.Minute}
+	foreign, _, err := other.Issue(token.Claims{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no credentials", "", http.StatusUnauthorized},
+		// A password alone would skip the second factor of users with MFA.
+		{"basic auth", "Basic QWRtaW5AZXhhbXBsZS5jb206cGFzc3dvcmQ=", http.StatusUnauthorized},
+		{"malformed token", "Bearer abc", http.StatusUnauthorized},
+		{"other issuer", "Bearer " + foreign, http.StatusUnauthorized},
+		{"unknown user", bearer(9), http.StatusUnauthorized},
+		{"inactive admin", bearer(4), http.StatusUnauthorized},
+		{"not an admin", bearer(3), http.StatusForbidden},
+		{"listed in ADMIN_EMAILS", bearer(1), http.StatusOK},
+		{"admin role", bearer(2), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d %s", tt.want, rec.Code, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
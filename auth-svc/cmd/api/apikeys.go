@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+// allScopes is the scope that grants access to every service.
+const allScopes = "*"
+
+var (
+	scopePattern     = regexp.MustCompile(`^(\*|[a-z0-9][a-z0-9_:.-]*)$`)
+	errInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+)
+
+// apiKeyResponse is sent when a key is created or rotated. It is the only time the
+// plain text key is ever shown.
+type apiKeyResponse struct {
+	Key    *data.APIKey `json:"key"`
+	APIKey string       `json:"api_key"`
+}
+
+// ListAPIKeys returns every API key, without their values.
+func (app *application) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.Models.APIKey.GetAll(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d API keys", len(keys)),
+		Data:    keys,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateAPIKey issues a new API key with a name, a list of scopes and an optional
+// expiry.
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	requestPayload.Name = strings.TrimSpace(requestPayload.Name)
+	if requestPayload.Name == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	err = validateScopes(requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if requestPayload.ExpiresAt != nil && !requestPayload.ExpiresAt.After(time.Now()) {
+		app.errorJSON(w, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	plain, prefix, hash, err := token.NewAPIKey()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	id, err := app.Models.APIKey.Insert(r.Context(), data.APIKey{
+		Name:      requestPayload.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    requestPayload.Scopes,
+		ExpiresAt: requestPayload.ExpiresAt,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	key, err := app.Models.APIKey.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("created API key %d (%s) with scopes %s", key.ID, key.Name, strings.Join(key.Scopes, ",")))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("created API key %d, store it now as it won't be shown again", key.ID),
+		Data:    apiKeyResponse{Key: key, APIKey: plain},
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// RotateAPIKey replaces a key's value, keeping its name, scopes and expiry. The old
+// value stops working straight away.
+func (app *application) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.apiKeyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if key.RevokedAt != nil {
+		app.errorJSON(w, errors.New("revoked keys can't be rotated"), http.StatusConflict)
+		return
+	}
+
+	plain, prefix, hash, err := token.NewAPIKey()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.APIKey.Rotate(r.Context(), key, prefix, hash)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	key, err = app.Models.APIKey.GetOne(r.Context(), key.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("rotated API key %d (%s)", key.ID, key.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("rotated API key %d, store it now as it won't be shown again", key.ID),
+		Data:    apiKeyResponse{Key: key, APIKey: plain},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RevokeAPIKey permanently disables a key. The record is kept for auditing.
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.apiKeyFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.APIKey.Revoke(r.Context(), key)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("revoked API key %d (%s)", key.ID, key.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("revoked API key %d", key.ID),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// VerifyAPIKey is called by other services to check a key presented to them. It returns
+// the key's name and scopes, and fails with 403 when a scope is asked for that the key
+// doesn't have.
+func (app *application) VerifyAPIKey(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		APIKey string `json:"api_key"`
+		Scope  string `json:"scope"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := app.Models.APIKey.GetByHash(r.Context(), token.Hash(requestPayload.APIKey))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errInvalidAPIKey, http.StatusUnauthorized)
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !key.Usable(time.Now()) {
+		app.errorJSON(w, errInvalidAPIKey, http.StatusUnauthorized)
+		return
+	}
+
+	if requestPayload.Scope != "" && !hasScope(key.Scopes, requestPayload.Scope) {
+		app.errorJSON(w, fmt.Errorf("API key doesn't have the %s scope", requestPayload.Scope), http.StatusForbidden)
+		return
+	}
+
+	err = app.Models.APIKey.Touch(r.Context(), key)
+	if err != nil {
+		log.Println("Error recording API key use:", err)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "valid",
+		Data: map[string]any{
+			"id":         key.ID,
+			"name":       key.Name,
+			"scopes":     key.Scopes,
+			"expires_at": key.ExpiresAt,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// apiKeyFromURL loads the API key named by the {id} URL parameter, sending an error
+// response and returning false if it can't.
+func (app *application) apiKeyFromURL(w http.ResponseWriter, r *http.Request) (*data.APIKey, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, errors.New("invalid API key id"))
+		return nil, false
+	}
+
+	key, err := app.Models.APIKey.GetOne(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("API key not found"), http.StatusNotFound)
+			return nil, false
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return key, true
+}
+
+// validateScopes requires at least one scope, each a lower case name such as
+// "hostbill", or "*" for every service.
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !scopePattern.MatchString(scope) {
+			return fmt.Errorf("%q is not a valid scope", scope)
+		}
+	}
+
+	return nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, allScopes)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Connection pool defaults, sized for a few replicas sharing one Postgres.
+const (
+	defaultMaxOpenConns = 25
+	defaultMaxIdleConns = 25
+	defaultMaxIdleTime  = 15 * time.Minute
+	defaultMaxLifetime  = time.Hour
+
+	// readyTimeout bounds the ping made by the readiness check.
+	readyTimeout = 2 * time.Second
+)
+
+// dbConfig is the DSN and connection pool limits. Each setting has an environment
+// variable, which a flag of the same name overrides.
+type dbConfig struct {
+	dsn          string
+	maxOpenConns int
+	maxIdleConns int
+	maxIdleTime  time.Duration
+	maxLifetime  time.Duration
+}
+
+// loadDBConfig reads the pool settings from the environment, then from args.
+func loadDBConfig(args []string) (dbConfig, error) {
+	cfg := dbConfig{dsn: os.Getenv("DSN")}
+
+	var err error
+
+	cfg.maxOpenConns, err = intFromEnv("DB_MAX_OPEN_CONNS", defaultMaxOpenConns)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.maxIdleConns, err = intFromEnv("DB_MAX_IDLE_CONNS", defaultMaxIdleConns)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.maxIdleTime, err = durationFromEnv("DB_MAX_IDLE_TIME", defaultMaxIdleTime)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.maxLifetime, err = durationFromEnv("DB_MAX_LIFETIME", defaultMaxLifetime)
+	if err != nil {
+		return cfg, err
+	}
+
+	fs := flag.NewFlagSet("authApp", flag.ContinueOnError)
+	fs.StringVar(&cfg.dsn, "db-dsn", cfg.dsn, "Postgres DSN (DSN)")
+	fs.IntVar(&cfg.maxOpenConns, "db-max-open-conns", cfg.maxOpenConns, "Maximum open connections (DB_MAX_OPEN_CONNS)")
+	fs.IntVar(&cfg.maxIdleConns, "db-max-idle-conns", cfg.maxIdleConns, "Maximum idle connections (DB_MAX_IDLE_CONNS)")
+	fs.DurationVar(&cfg.maxIdleTime, "db-max-idle-time", cfg.maxIdleTime, "Close connections idle for longer (DB_MAX_IDLE_TIME)")
+	fs.DurationVar(&cfg.maxLifetime, "db-max-lifetime", cfg.maxLifetime, "Close connections older than this (DB_MAX_LIFETIME)")
+
+	err = fs.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.maxOpenConns < 1 || cfg.maxIdleConns < 1 {
+		return cfg, errors.New("the pool needs at least one open and one idle connection")
+	}
+	if cfg.maxIdleConns > cfg.maxOpenConns {
+		return cfg, errors.New("db-max-idle-conns must not be more than db-max-open-conns")
+	}
+	if cfg.maxIdleTime <= 0 || cfg.maxLifetime <= 0 {
+		return cfg, errors.New("db-max-idle-time and db-max-lifetime must be positive")
+	}
+
+	return cfg, nil
+}
+
+func openDB(cfg dbConfig) (*sql.DB, error) {
+	db, err := sql.Open("pgx", cfg.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.maxOpenConns)
+	db.SetMaxIdleConns(cfg.maxIdleConns)
+	db.SetConnMaxIdleTime(cfg.maxIdleTime)
+	db.SetConnMaxLifetime(cfg.maxLifetime)
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Connects to DB, waits for DB connection if it is taking a while.
+func connectToDB(cfg dbConfig) *sql.DB {
+	counts := 0
+	for {
+		connection, err := openDB(cfg)
+		if err != nil {
+			log.Println("Postgres not ready yet...")
+			counts++
+		} else {
+			log.Println("Connected to Postgres...")
+			return connection
+		}
+
+		if counts > 10 {
+			log.Println(err)
+			return nil
+		}
+
+		log.Println("Backing off for 2 seconds...")
+		time.Sleep(2 * time.Second)
+		continue
+	}
+}
+
+// Ready reports whether the service can take traffic, which it can while Postgres
+// answers a ping. The pool stats help tell an exhausted pool from a down database.
+func (app *application) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	stats := app.DB.Stats()
+	pool := map[string]any{
+		"open_connections": stats.OpenConnections,
+		"in_use":           stats.InUse,
+		"idle":             stats.Idle,
+		"wait_count":       stats.WaitCount,
+		"wait_duration":    stats.WaitDuration.String(),
+	}
+
+	err := app.DB.PingContext(ctx)
+	if err != nil {
+		log.Println("Readiness check failed:", err)
+
+		payload := jsonResponse{
+			Error:   true,
+			Message: "database unavailable",
+			Data:    pool,
+		}
+
+		app.writeJSON(w, http.StatusServiceUnavailable, payload)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "ready",
+		Data:    pool,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
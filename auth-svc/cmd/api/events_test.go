@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+)
+
+func TestPublishing(t *testing.T) {
+	event := &data.Event{
+		ID:         42,
+		Type:       data.EventPasswordChanged,
+		UserID:     7,
+		Email:      "alice@example.com",
+		IP:         "10.0.0.1",
+		Actor:      "admin@example.com",
+		OccurredAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	key, msg, err := publishing(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "auth.password.changed" {
+		t.Errorf("expected routing key auth.password.changed, got %q", key)
+	}
+	if msg.MessageId != "auth-svc-event-42" {
+		t.Errorf("expected a message ID from the event ID, got %q", msg.MessageId)
+	}
+
+	var body map[string]any
+	err = json.Unmarshal(msg.Body, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body["name"] != "authentication" {
+		t.Errorf("expected name authentication, got %v", body["name"])
+	}
+	if want := "password.changed alice@example.com from 10.0.0.1 by admin@example.com"; body["data"] != want {
+		t.Errorf("expected data %q, got %v", want, body["data"])
+	}
+	if body["type"] != "password.changed" || body["user_id"] != float64(7) {
+		t.Errorf("expected the event fields in the body, got %v", body)
+	}
+}
+
+func TestDescribeEvent(t *testing.T) {
+	tests := []struct {
+		event data.Event
+		want  string
+	}{
+		{data.Event{Type: data.EventLockout, IP: "10.0.0.1", Detail: "ip locked"}, "lockout from 10.0.0.1: ip locked"},
+		{data.Event{Type: data.EventTokenRevoked, UserID: 3}, "token.revoked user 3"},
+	}
+
+	for _, tt := range tests {
+		got := describeEvent(&tt.event)
+		if got != tt.want {
+			t.Errorf("describeEvent(%+v) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
@@ -5,14 +5,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
 )
 
+var errInvalidCredentials = errors.New("invalid credentials")
+
 func (app *application) Authenticate(w http.ResponseWriter, r *http.Request) {
 	// Define a struct to hold the request payload
 	var requestPayload struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Factor   string `json:"factor"`
+		Passcode string `json:"passcode"`
 	}
 
 	// Read the JSON payload from the request body and decode it into the requestPayload struct
@@ -23,26 +31,67 @@ func (app *application) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email := throttleKey(requestPayload.Email)
+	ip := clientIP(r, app.Throttle.trustedProxies)
+
+	// Slow down and lock out repeated failures for the account or the caller's IP
+	if app.throttleLogin(w, r, email, ip) {
+		return
+	}
+
 	// Validate the user against the database using the provided email
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	user, err := app.Models.User.GetByEmail(r.Context(), requestPayload.Email)
 	if err != nil {
 		// If the user is not found or there's an error, send an "invalid credentials" error response
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		app.recordLogin(email, ip, false)
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
-	// Check if the provided password matches the user's password
+	// Check if the provided password matches the user's password, and that the user is active
 	valid, err := user.PasswordMatches(requestPayload.Password)
-	if err != nil || !valid {
+	if err != nil || !valid || user.Active != 1 {
 		// If there's an error or the password doesn't match, send an "invalid credentials" error response
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		app.recordLogin(email, ip, false)
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
-	// Log authentcation
-	err = app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
+	app.recordLogin(email, ip, true)
+
+	// Upgrade bcrypt hashes, and argon2id hashes made with older parameters, now that
+	// we have the plain text password
+	_, err = app.Models.User.RehashPassword(r.Context(), user, requestPayload.Password)
 	if err != nil {
-		app.errorJSON(w, err)
+		log.Println("Error rehashing password:", err)
+	}
+
+	if app.Mail.requireVerified && user.EmailVerifiedAt == nil {
+		app.errorJSON(w, errEmailNotVerified, http.StatusForbidden)
+		return
+	}
+
+	// Users enrolled in MFA also have to pass a Duo second factor
+	if user.MFAEnabled {
+		app.secondFactor(w, r, user, requestPayload.Factor, requestPayload.Passcode)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin sends the user a new token pair, recording the login with the new
+// session.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	// Issue an access token and start a new refresh token session
+	tokens, err := app.issueTokens(r.Context(), user, "", app.device(r), data.Event{
+		Type:   data.EventLoginSucceeded,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -50,21 +99,23 @@ func (app *application) Authenticate(w http.ResponseWriter, r *http.Request) {
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
+		Data:    tokens,
 	}
 
-	// Send the success response with the user data
+	// Send the success response with the user and tokens
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
 func (app *application) logRequest(name, data string) error {
 	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+		Name    string `json:"name"`
+		Data    string `json:"data"`
+		Service string `json:"service"`
 	}
 
 	entry.Name = name
 	entry.Data = data
+	entry.Service = "auth-svc"
 
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 	logServiceURL := "http://logger-svc/log"
@@ -73,12 +124,17 @@ func (app *application) logRequest(name, data string) error {
 	if err != nil {
 		return err
 	}
+	// logger-svc only takes entries from callers with a key.
+	if key := os.Getenv("LOG_API_KEY"); key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
 
 	client := &http.Client{}
-	_, err = client.Do(request)
+	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
 
 	return nil
 }
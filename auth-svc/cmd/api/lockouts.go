@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+)
+
+// Login throttling defaults, used when the environment doesn't override them.
+const (
+	defaultMaxFailures   = 5
+	defaultIPMaxFailures = 50
+	defaultDelayAfter    = 3
+	defaultFailureWindow = 15 * time.Minute
+	defaultLockout       = 15 * time.Minute
+)
+
+var errTooManyAttempts = errors.New("too many failed login attempts, try again later")
+
+// throttleConfig controls how failed logins are slowed down and locked out. Failures
+// are counted per account and per source IP within window. From delayAfter failures
+// on, each further attempt has to wait twice as long as the last, and at maxFailures
+// the account is locked for lockout.
+type throttleConfig struct {
+	maxFailures    int
+	ipMaxFailures  int
+	delayAfter     int
+	window         time.Duration
+	lockout        time.Duration
+	trustedProxies []*net.IPNet
+}
+
+func loadThrottleConfig() (throttleConfig, error) {
+	cfg := throttleConfig{}
+
+	var err error
+
+	cfg.maxFailures, err = intFromEnv("LOGIN_MAX_FAILURES", defaultMaxFailures)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.ipMaxFailures, err = intFromEnv("LOGIN_IP_MAX_FAILURES", defaultIPMaxFailures)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.delayAfter, err = intFromEnv("LOGIN_DELAY_AFTER", defaultDelayAfter)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.window, err = durationFromEnv("LOGIN_FAILURE_WINDOW", defaultFailureWindow)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.lockout, err = durationFromEnv("LOGIN_LOCKOUT", defaultLockout)
+	if err != nil {
+		return cfg, err
+	}
+
+	// Failures older than the window are forgotten, which would end a longer lockout
+	// early.
+	if cfg.lockout > cfg.window {
+		return cfg, errors.New("LOGIN_LOCKOUT must not be longer than LOGIN_FAILURE_WINDOW")
+	}
+
+	cfg.trustedProxies, err = parseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// wait returns how long after now the next attempt has to wait, given failures failed
+// attempts of which the last was at last. Zero means the attempt may go ahead.
+func (t throttleConfig) wait(failures, limit int, last, now time.Time) time.Duration {
+	var delay time.Duration
+
+	switch {
+	case failures >= limit:
+		delay = t.lockout
+	case failures >= t.delayAfter:
+		// 1s, 2s, 4s... but never longer than a lockout.
+		delay = t.lockout
+		if n := failures - t.delayAfter; n < 30 {
+			delay = min(time.Second<<n, t.lockout)
+		}
+	default:
+		return 0
+	}
+
+	return max(last.Add(delay).Sub(now), 0)
+}
+
+// lockedUntil returns when an account or IP with the given failures is unlocked again,
+// or the zero time if it isn't locked.
+func (t throttleConfig) lockedUntil(count data.FailureCount, limit int) time.Time {
+	if count.Failures < limit {
+		return time.Time{}
+	}
+
+	return count.Last.Add(t.lockout)
+}
+
+// loginRetryAfter checks the recent failures for the account and source IP, and
+// returns how long the caller has to wait before trying to log in again.
+func (app *application) loginRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now()
+	since := now.Add(-app.Throttle.window)
+
+	byEmail, err := app.Models.LoginAttempt.FailuresByEmail(ctx, email, since)
+	if err != nil {
+		return 0, err
+	}
+
+	byIP, err := app.Models.LoginAttempt.FailuresByIP(ctx, ip, since)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(
+		app.Throttle.wait(byEmail.Failures, app.Throttle.maxFailures, byEmail.Last, now),
+		app.Throttle.wait(byIP.Failures, app.Throttle.ipMaxFailures, byIP.Last, now),
+	), nil
+}
+
+// throttleLogin rejects the request with a 429 if the account or source IP has to wait
+// before trying again, and reports whether it did.
+func (app *application) throttleLogin(w http.ResponseWriter, r *http.Request, email, ip string) bool {
+	retryAfter, err := app.loginRetryAfter(r.Context(), email, ip)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return true
+	}
+
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		app.errorJSON(w, errTooManyAttempts, http.StatusTooManyRequests)
+		return true
+	}
+
+	return false
+}
+
+// recordLogin stores the outcome of a password check. A failure that locks the account
+// or source IP is sent to the logger service as a lockout event. It doesn't use the
+// request's context, so a client hanging up can't stop a failure being counted.
+func (app *application) recordLogin(email, ip string, success bool) {
+	ctx := context.Background()
+
+	var events []data.Event
+	if !success {
+		events = append(events, data.Event{Type: data.EventLoginFailed, Email: email, IP: ip, Detail: "invalid credentials"})
+	}
+
+	err := app.Models.LoginAttempt.Insert(ctx, data.LoginAttempt{Email: email, IP: ip, Success: success}, events...)
+	if err != nil {
+		log.Println("Error recording login attempt:", err)
+		return
+	}
+
+	if success {
+		return
+	}
+
+	since := time.Now().Add(-app.Throttle.window)
+
+	byEmail, err := app.Models.LoginAttempt.FailuresByEmail(ctx, email, since)
+	if err != nil {
+		log.Println("Error counting failed logins:", err)
+		return
+	}
+	if byEmail.Failures == app.Throttle.maxFailures {
+		app.recordLockout(ctx, data.Event{
+			Type:   data.EventLockout,
+			Email:  email,
+			IP:     ip,
+			Detail: fmt.Sprintf("account locked after %d failed logins", byEmail.Failures),
+		})
+	}
+
+	byIP, err := app.Models.LoginAttempt.FailuresByIP(ctx, ip, since)
+	if err != nil {
+		log.Println("Error counting failed logins:", err)
+		return
+	}
+	if byIP.Failures == app.Throttle.ipMaxFailures {
+		app.recordLockout(ctx, data.Event{
+			Type:   data.EventLockout,
+			IP:     ip,
+			Detail: fmt.Sprintf("ip locked after %d failed logins", byIP.Failures),
+		})
+	}
+}
+
+func (app *application) recordLockout(ctx context.Context, event data.Event) {
+	err := app.Models.Event.Insert(ctx, event)
+	if err != nil {
+		log.Println("Error recording lockout event:", err)
+	}
+}
+
+// lockoutResponse describes a locked account or IP.
+type lockoutResponse struct {
+	data.FailureCount
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// ListLockouts returns the accounts and source IPs that are currently locked out.
+func (app *application) ListLockouts(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	since := now.Add(-app.Throttle.window)
+
+	emails, err := app.Models.LoginAttempt.EmailsOverLimit(r.Context(), since, app.Throttle.maxFailures)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	ips, err := app.Models.LoginAttempt.IPsOverLimit(r.Context(), since, app.Throttle.ipMaxFailures)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	locked := func(counts []data.FailureCount, limit int) []lockoutResponse {
+		out := []lockoutResponse{}
+		for _, count := range counts {
+			until := app.Throttle.lockedUntil(count, limit)
+			if until.After(now) {
+				out = append(out, lockoutResponse{FailureCount: count, LockedUntil: until})
+			}
+		}
+		return out
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "lockouts",
+		Data: map[string][]lockoutResponse{
+			"accounts": locked(emails, app.Throttle.maxFailures),
+			"ips":      locked(ips, app.Throttle.ipMaxFailures),
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UnlockUser clears a user's failed logins, lifting any lockout or delay.
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.LoginAttempt.ClearEmail(r.Context(), throttleKey(user.Email))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("unlocked user %d (%s)", user.ID, user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("unlocked user %d", user.ID),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UnlockIP clears the failed logins from a source IP.
+func (app *application) UnlockIP(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(chi.URLParam(r, "ip"))
+	if ip == nil {
+		app.errorJSON(w, errors.New("invalid ip address"))
+		return
+	}
+
+	err := app.Models.LoginAttempt.ClearIP(r.Context(), ip.String())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("unlocked ip %s", ip))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("unlocked ip %s", ip),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// throttleKey normalises an email address so failures for the same account are counted
+// together whatever its case.
+func throttleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientIP returns the caller's IP address. X-Forwarded-For is only believed when the
+// request comes from a trusted proxy such as the broker, and then the rightmost
+// address that isn't itself a trusted proxy is used.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !ipIn(host, trusted) {
+		return host
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+
+		host = ip.String()
+		if !ipIn(host, trusted) {
+			break
+		}
+	}
+
+	return host
+}
+
+func ipIn(addr string, nets []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseCIDRs parses a comma separated list of CIDR ranges or single IP addresses.
+func parseCIDRs(raw string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, s := range strings.Split(raw, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func intFromEnv(key string, fallback int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive number", key)
+	}
+
+	return n, nil
+}
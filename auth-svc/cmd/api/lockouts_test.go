@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottleWait(t *testing.T) {
+	cfg := throttleConfig{maxFailures: 5, delayAfter: 2, lockout: 15 * time.Minute}
+	now := time.Now()
+
+	tests := []struct {
+		failures int
+		last     time.Time
+		want     time.Duration
+	}{
+		{0, time.Time{}, 0},
+		{1, now, 0},
+		{2, now, time.Second},
+		{3, now, 2 * time.Second},
+		{4, now.Add(-time.Second), 3 * time.Second},
+		{4, now.Add(-time.Minute), 0},
+		{5, now, 15 * time.Minute},
+		{6, now.Add(-5 * time.Minute), 10 * time.Minute},
+		{5, now.Add(-16 * time.Minute), 0},
+	}
+
+	for _, tt := range tests {
+		got := cfg.wait(tt.failures, cfg.maxFailures, tt.last, now)
+		if got != tt.want {
+			t.Errorf("wait(%d failures, %s ago) = %s, want %s", tt.failures, now.Sub(tt.last), got, tt.want)
+		}
+	}
+
+	// The progressive delay never exceeds a lockout.
+	cfg = throttleConfig{maxFailures: 100, delayAfter: 1, lockout: time.Minute}
+	if got := cfg.wait(50, cfg.maxFailures, now, now); got != time.Minute {
+		t.Errorf("expected the delay to be capped at the lockout, got %s", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseCIDRs("10.0.0.0/8, 192.168.1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct", "203.0.113.7:4000", "", "203.0.113.7"},
+		{"untrusted proxy is ignored", "203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:4000", "198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:4000", "198.51.100.1, 192.168.1.5", "198.51.100.1"},
+		{"spoofed entries before the client", "10.1.2.3:4000", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+		{"trusted proxy without header", "192.168.1.5:4000", "", "192.168.1.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/authenticate", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	_, err = parseCIDRs("not-an-ip")
+	if err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+}
@@ -13,6 +13,9 @@ import (
 	_ "github.com/jackc/pgx/v4/stdlib"
 
 	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/mailer"
+	"github.com/cloudkey-io/service-hub/auth-svc/password"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
 )
 
 const port = "80"
@@ -20,71 +23,135 @@ const port = "80"
 var counts int64
 
 type application struct {
-	DB     *sql.DB
-	Models data.Models
+	DB         *sql.DB
+	Models     data.Models
+	Admins     map[string]bool
+	Tokens     *token.Issuer
+	RefreshTTL time.Duration
+	// MFA is nil when duo-svc isn't configured.
+	MFA          *duoSvc
+	ChallengeTTL time.Duration
+	Throttle     throttleConfig
+	Mailer       mailer.Mailer
+	Mail         mailConfig
+	Passwords    *password.Policy
+	// EventRetention is how long published auth events are kept.
+	EventRetention time.Duration
+	// OIDCIssuer identifies auth-svc to OpenID Connect clients.
+	OIDCIssuer string
 }
 
 func main() {
+	// "authApp migrate up|down [n]|status" manages the schema and exits.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		dbCfg, err := loadDBConfig(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		conn := connectToDB(dbCfg)
+		if conn == nil {
+			log.Fatal("Can't connect to Postgres!")
+		}
+		err = migrate(os.Stdout, conn, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	log.Println("Starting authentication service...")
 
-	conn := connectToDB()
+	dbCfg, err := loadDBConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conn := connectToDB(dbCfg)
 	if conn == nil {
 		log.Panic("Can't connect to Postgres!")
 	}
 
-	// Set up config
-	app := application{
-		DB:     conn,
-		Models: data.New(conn),
+	models := data.New(conn)
+
+	// Replicas can all migrate on start up, the migrations take a lock. Set
+	// AUTO_MIGRATE=false to only migrate with the migrate command.
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		err = migrate(os.Stdout, conn, []string{"up"})
+		if err != nil {
+			log.Panic(err)
+		}
 	}
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", port),
-		Handler: app.routes(),
+	tokenCfg, err := loadTokenConfig()
+	if err != nil {
+		log.Panic(err)
 	}
 
-	err := srv.ListenAndServe()
+	issuer, err := newIssuer(tokenCfg)
 	if err != nil {
 		log.Panic(err)
 	}
-}
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+	challengeTTL, err := durationFromEnv("MFA_CHALLENGE_TTL", defaultChallengeTTL)
 	if err != nil {
-		return nil, err
+		log.Panic(err)
 	}
 
-	err = db.Ping()
+	throttle, err := loadThrottleConfig()
 	if err != nil {
-		return nil, err
+		log.Panic(err)
 	}
 
-	return db, nil
-}
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Panic(err)
+	}
 
-// Connects to DB, waits for DB connection if it is taking a while.
-func connectToDB() *sql.DB {
-	dsn := os.Getenv("DSN")
+	mailCfg, err := loadMailConfig()
+	if err != nil {
+		log.Panic(err)
+	}
 
-	counts := 0
-	for {
-		connection, err := openDB(dsn)
-		if err != nil {
-			log.Println("Postgres not ready yet...")
-			counts++
-		} else {
-			log.Println("Connected to Postgres...")
-			return connection
-		}
+	passwords, hashParams, err := loadPasswordConfig()
+	if err != nil {
+		log.Panic(err)
+	}
+	data.SetHashParams(hashParams)
 
-		if counts > 10 {
-			log.Println(err)
-			return nil
-		}
+	relayCfg, err := loadRelayConfig()
+	if err != nil {
+		log.Panic(err)
+	}
 
-		log.Println("Backing off for 2 seconds...")
-		time.Sleep(2 * time.Second)
-		continue
+	// Set up config
+	app := application{
+		DB:           conn,
+		Models:       models,
+		Admins:       loadAdmins(os.Getenv("ADMIN_EMAILS")),
+		Tokens:       issuer,
+		RefreshTTL:   tokenCfg.refreshTTL,
+		MFA:          newDuoSvc(),
+		ChallengeTTL: challengeTTL,
+		Throttle:     throttle,
+		Mailer:       mail,
+		Mail:         mailCfg,
+		Passwords:    passwords,
+
+		EventRetention: relayCfg.retention,
+		OIDCIssuer:     tokenCfg.oidcIssuer,
+	}
+
+	go app.cleanupExpired(time.Hour)
+	go app.relayEvents(relayCfg)
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: app.routes(),
+	}
+
+	err = srv.ListenAndServe()
+	if err != nil {
+		log.Panic(err)
 	}
 }
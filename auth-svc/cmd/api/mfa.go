@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+// Second factors a login can use. Push is the default.
+const (
+	factorPush     = "push"
+	factorPasscode = "passcode"
+)
+
+// Results duo-svc reports for preauth, auth and auth_status calls.
+const (
+	mfaAuth    = "auth"
+	mfaAllow   = "allow"
+	mfaEnroll  = "enroll"
+	mfaWaiting = "waiting"
+)
+
+// defaultChallengeTTL is how long a user has to approve a push, matching Duo's own
+// push timeout.
+const defaultChallengeTTL = time.Minute
+
+var (
+	errMFADenied        = errors.New("second factor denied")
+	errMFAEnroll        = errors.New("a Duo device must be enrolled before logging in")
+	errMFAUnavailable   = errors.New("a second factor is required but Duo is not configured")
+	errInvalidChallenge = errors.New("invalid or expired mfa challenge")
+	errInvalidFactor    = errors.New("factor must be push or passcode")
+)
+
+// mfaChallengeResponse is sent back instead of tokens while a login waits for a Duo push
+// to be approved. The client polls /authenticate/mfa with the challenge.
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	Challenge   string `json:"challenge"`
+	Status      string `json:"status"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// mfaResult is duo-svc's view of a Duo preauth or auth response.
+type mfaResult struct {
+	Result    string `json:"result"`
+	Status    string `json:"status"`
+	StatusMsg string `json:"status_msg"`
+	Txid      string `json:"txid"`
+}
+
+// duoSvc calls duo-svc's second factor endpoints, authenticating with an API key that
+// has the duo scope.
+type duoSvc struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// newDuoSvc returns a duo-svc client configured from DUO_SVC_URL and DUO_SVC_API_KEY,
+// or nil when DUO_SVC_URL isn't set. Users with MFA enabled can't log in without it.
+func newDuoSvc() *duoSvc {
+	baseURL := os.Getenv("DUO_SVC_URL")
+	if baseURL == "" {
+		log.Println("DUO_SVC_URL not set, second factor logins are unavailable")
+		return nil
+	}
+
+	return &duoSvc{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  os.Getenv("DUO_SVC_API_KEY"),
+		client:  &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Preauth asks Duo whether the user has to authenticate, is allowed or denied outright,
+// or still has to enroll.
+func (d *duoSvc) Preauth(username string) (*mfaResult, error) {
+	return d.call(http.MethodPost, "/v1/auth/preauth", "preauth", map[string]string{"username": username})
+}
+
+// Auth sends a push, or checks a passcode. Pushes are async and return a txid to poll
+// with AuthStatus.
+func (d *duoSvc) Auth(username, factor, passcode string) (*mfaResult, error) {
+	return d.call(http.MethodPost, "/v1/auth/auth", "auth", map[string]string{
+		"username": username,
+		"factor":   factor,
+		"passcode": passcode,
+	})
+}
+
+// AuthStatus reports whether a push has been approved, denied, or is still waiting.
+func (d *duoSvc) AuthStatus(txid string) (*mfaResult, error) {
+	return d.call(http.MethodGet, "/v1/auth/auth_status?txid="+url.QueryEscape(txid), "auth", nil)
+}
+
+// call makes a request to duo-svc and decodes the result from the envelope key.
+func (d *duoSvc) call(method, path, key string, body any) (*mfaResult, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&buf).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	request, err := http.NewRequest(method, d.baseURL+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-API-Key", d.apiKey)
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("calling duo-svc: %w", err)
+	}
+	defer response.Body.Close()
+
+	var envelope map[string]json.RawMessage
+
+	err = json.NewDecoder(response.Body).Decode(&envelope)
+	if err != nil {
+		return nil, fmt.Errorf("decoding duo-svc response: %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("duo-svc returned %d: %s", response.StatusCode, envelope["error"])
+	}
+
+	var result mfaResult
+
+	err = json.Unmarshal(envelope[key], &result)
+	if err != nil {
+		return nil, fmt.Errorf("decoding duo-svc response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// secondFactor runs the Duo check for a user whose password has already been verified.
+// Passcodes and users Duo lets straight through are logged in right away; pushes
+// start a challenge for the client to poll.
+func (app *application) secondFactor(w http.ResponseWriter, r *http.Request, user *data.User, factor, passcode string) {
+	if app.MFA == nil {
+		app.errorJSON(w, errMFAUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	if factor == "" {
+		factor = factorPush
+	}
+	if factor != factorPush && factor != factorPasscode {
+		app.errorJSON(w, errInvalidFactor)
+		return
+	}
+
+	preauth, err := app.MFA.Preauth(user.Email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	switch preauth.Result {
+	case mfaAllow:
+		app.completeLogin(w, r, user)
+		return
+	case mfaEnroll:
+		app.errorJSON(w, errMFAEnroll, http.StatusForbidden)
+		return
+	case mfaAuth:
+	default:
+		// Denied, or a result we don't know, so fail closed.
+		app.mfaDenied(w, r, user)
+		return
+	}
+
+	result, err := app.MFA.Auth(user.Email, factor, passcode)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if factor == factorPasscode {
+		if result.Result != mfaAllow {
+			app.mfaDenied(w, r, user)
+			return
+		}
+
+		app.completeLogin(w, r, user)
+		return
+	}
+
+	plain, hash, err := token.NewOneTimeToken()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.MFAChallenge.Insert(r.Context(), data.MFAChallenge{
+		UserID:        user.ID,
+		ChallengeHash: hash,
+		Txid:          result.Txid,
+		ExpiresAt:     time.Now().Add(app.ChallengeTTL),
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Duo push sent, waiting for approval",
+		Data: mfaChallengeResponse{
+			MFARequired: true,
+			Challenge:   plain,
+			Status:      mfaWaiting,
+			ExpiresIn:   int(app.ChallengeTTL.Seconds()),
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CompleteMFA polls a login that is waiting for a Duo push. It answers 200 while the
+// push is pending, and issues tokens once it has been approved.
+func (app *application) CompleteMFA(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Challenge string `json:"challenge"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := app.Models.MFAChallenge.GetByHash(r.Context(), token.Hash(requestPayload.Challenge))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errInvalidChallenge, http.StatusUnauthorized)
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if challenge.CompletedAt.Valid || time.Now().After(challenge.ExpiresAt) {
+		app.errorJSON(w, errInvalidChallenge, http.StatusUnauthorized)
+		return
+	}
+
+	if app.MFA == nil {
+		app.errorJSON(w, errMFAUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	status, err := app.MFA.AuthStatus(challenge.Txid)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if status.Result == mfaWaiting {
+		payload := jsonResponse{
+			Error:   false,
+			Message: status.StatusMsg,
+			Data: mfaChallengeResponse{
+				MFARequired: true,
+				Challenge:   requestPayload.Challenge,
+				Status:      mfaWaiting,
+				ExpiresIn:   int(time.Until(challenge.ExpiresAt).Seconds()),
+			},
+		}
+
+		app.writeJSON(w, http.StatusOK, payload)
+		return
+	}
+
+	// Approved or not, the challenge is finished, and only one poll may act on it.
+	err = app.Models.MFAChallenge.Complete(r.Context(), challenge)
+	if err != nil {
+		if errors.Is(err, data.ErrChallengeCompleted) {
+			app.errorJSON(w, errInvalidChallenge, http.StatusUnauthorized)
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(r.Context(), challenge.UserID)
+	if err != nil || user.Active != 1 {
+		app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	if status.Result != mfaAllow {
+		app.mfaDenied(w, r, user)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// mfaDenied records and rejects a login Duo didn't approve.
+func (app *application) mfaDenied(w http.ResponseWriter, r *http.Request, user *data.User) {
+	app.recordMFADenied(r, user)
+	app.errorJSON(w, errMFADenied, http.StatusUnauthorized)
+}
+
+func (app *application) recordMFADenied(r *http.Request, user *data.User) {
+	app.recordEvent(r, data.Event{
+		Type:   data.EventLoginFailed,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+		Detail: "second factor denied",
+	})
+}
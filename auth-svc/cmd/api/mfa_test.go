@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+)
+
+// fakeDuoSvc stands in for duo-svc. alice has to authenticate, bob is allowed straight
+// through and everyone else still has to enroll.
+func fakeDuoSvc(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	reply := func(w http.ResponseWriter, key string, result mfaResult) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{key: result})
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/auth/preauth", func(w http.ResponseWriter, r *http.Request) {
+		var input struct{ Username string }
+		json.NewDecoder(r.Body).Decode(&input)
+
+		switch input.Username {
+		case "alice@example.com":
+			reply(w, "preauth", mfaResult{Result: mfaAuth})
+		case "bob@example.com":
+			reply(w, "preauth", mfaResult{Result: mfaAllow})
+		default:
+			reply(w, "preauth", mfaResult{Result: mfaEnroll})
+		}
+	})
+
+	mux.HandleFunc("POST /v1/auth/auth", func(w http.ResponseWriter, r *http.Request) {
+		var input struct{ Username, Factor, Passcode string }
+		json.NewDecoder(r.Body).Decode(&input)
+
+		switch {
+		case input.Factor == factorPush:
+			reply(w, "auth", mfaResult{Txid: "tx-1"})
+		case input.Passcode == "123456":
+			reply(w, "auth", mfaResult{Result: mfaAllow, Status: "allow"})
+		default:
+			reply(w, "auth", mfaResult{Result: "deny", Status: "deny"})
+		}
+	})
+
+	mux.HandleFunc("GET /v1/auth/auth_status", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("txid") != "tx-1" {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "duo: 40401 Resource not found"})
+			return
+		}
+
+		reply(w, "auth", mfaResult{Result: mfaWaiting, StatusMsg: "Pushed a login request to your device..."})
+	})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "ck_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid, expired or revoked API key"})
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+func TestDuoSvc(t *testing.T) {
+	ts := fakeDuoSvc(t)
+	defer ts.Close()
+
+	duo := &duoSvc{baseURL: ts.URL, apiKey: "ck_test", client: ts.Client()}
+
+	preauth, err := duo.Preauth("alice@example.com")
+	if err != nil {
+		t.Fatalf("Preauth: %v", err)
+	}
+	if preauth.Result != mfaAuth {
+		t.Errorf("expected alice to have to authenticate, got %q", preauth.Result)
+	}
+
+	push, err := duo.Auth("alice@example.com", factorPush, "")
+	if err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	if push.Txid != "tx-1" {
+		t.Errorf("expected a txid for a push, got %q", push.Txid)
+	}
+
+	status, err := duo.AuthStatus(push.Txid)
+	if err != nil {
+		t.Fatalf("AuthStatus: %v", err)
+	}
+	if status.Result != mfaWaiting {
+		t.Errorf("expected the push to be waiting, got %q", status.Result)
+	}
+
+	passcode, err := duo.Auth("alice@example.com", factorPasscode, "123456")
+	if err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	if passcode.Result != mfaAllow {
+		t.Errorf("expected the passcode to be allowed, got %q", passcode.Result)
+	}
+
+	_, err = duo.AuthStatus("unknown")
+	if err == nil {
+		t.Error("expected an error from a failed duo-svc call")
+	}
+
+	duo.apiKey = "ck_wrong"
+	_, err = duo.Preauth("alice@example.com")
+	if err == nil {
+		t.Error("expected an error with the wrong API key")
+	}
+}
+
+func TestSecondFactorRejectsBeforeIssuingTokens(t *testing.T) {
+	ts := fakeDuoSvc(t)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		mfa    *duoSvc
+		email  string
+		factor string
+		status int
+	}{
+		{"duo not configured", nil, "alice@example.com", "", http.StatusServiceUnavailable},
+		{"unsupported factor", &duoSvc{baseURL: ts.URL, apiKey: "ck_test", client: ts.Client()}, "alice@example.com", "sms", http.StatusBadRequest},
+		{"not enrolled", &duoSvc{baseURL: ts.URL, apiKey: "ck_test", client: ts.Client()}, "carol@example.com", factorPush, http.StatusForbidden},
+		{"duo-svc rejects the API key", &duoSvc{baseURL: ts.URL, apiKey: "ck_wrong", client: ts.Client()}, "alice@example.com", factorPush, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := application{MFA: tt.mfa, ChallengeTTL: time.Minute}
+			rr := httptest.NewRecorder()
+
+			req := httptest.NewRequest(http.MethodPost, "/authenticate", nil)
+
+			app.secondFactor(rr, req, &data.User{ID: 1, Email: tt.email, Active: 1, MFAEnabled: true}, tt.factor, "")
+
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+
+			var payload jsonResponse
+			json.NewDecoder(rr.Body).Decode(&payload)
+			if !payload.Error || payload.Data != nil {
+				t.Errorf("expected an error without tokens, got %+v", payload)
+			}
+		})
+	}
+}
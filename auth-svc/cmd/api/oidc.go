@@ -0,0 +1,643 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+// authCodeTTL is how long a client has to exchange an authorization code.
+const authCodeTTL = time.Minute
+
+// Scopes a client can ask for. openid is required; email and profile add the user's
+// email address and name to the ID token.
+const (
+	scopeOpenID  = "openid"
+	scopeEmail   = "email"
+	scopeProfile = "profile"
+)
+
+var errPasscodeRequired = errors.New("enter the passcode from your Duo app")
+
+// pkceVerifier is the form RFC 7636 gives code verifiers, and S256 challenges are
+// always 43 characters of it.
+var (
+	pkceVerifier  = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+	pkceChallenge = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var authorizeTemplate = template.Must(template.ParseFS(templateFS, "templates/authorize.page.gohtml"))
+
+// OpenIDConfiguration serves the discovery document clients configure themselves
+// from.
+func (app *application) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	issuer := strings.TrimSuffix(app.OIDCIssuer, "/")
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	app.writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{scopeOpenID, scopeEmail, scopeProfile},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "sid",
+			"email", "email_verified", "name", "given_name", "family_name",
+		},
+	}, headers)
+}
+
+// authorizeRequest holds the parameters of an authorization request, which the login
+// form passes back when it is posted.
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func parseAuthorizeRequest(v url.Values) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        v.Get("response_type"),
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		Scope:               v.Get("scope"),
+		State:               v.Get("state"),
+		Nonce:               v.Get("nonce"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+	}
+}
+
+// validate checks everything but the client and redirect URI, and returns the OAuth
+// error code and description to send back to the client if the request is invalid.
+// Every client has to use PKCE with S256.
+func (req authorizeRequest) validate() (string, string) {
+	if req.ResponseType != "code" {
+		return "unsupported_response_type", "only the code response type is supported"
+	}
+	if !slices.Contains(strings.Fields(req.Scope), scopeOpenID) {
+		return "invalid_scope", "the openid scope is required"
+	}
+	if req.CodeChallengeMethod != "S256" || !pkceChallenge.MatchString(req.CodeChallenge) {
+		return "invalid_request", "a PKCE code_challenge with code_challenge_method S256 is required"
+	}
+
+	return "", ""
+}
+
+// redirectURL adds params to a client's redirect URI.
+func redirectURL(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// authorizePage is the data the login form is rendered with.
+type authorizePage struct {
+	Client  *data.OIDCClient
+	Request authorizeRequest
+	Email   string
+	Error   string
+}
+
+// renderAuthorize shows the login form. It may not be framed, so it can't be used for
+// clickjacking.
+func (app *application) renderAuthorize(w http.ResponseWriter, status int, page authorizePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+
+	err := authorizeTemplate.Execute(w, page)
+	if err != nil {
+		log.Println("Error rendering login form:", err)
+	}
+}
+
+// authorizeClient looks up the client of an authorization request. If the client or
+// its redirect URI is unknown there is nowhere safe to send the user back to, so an
+// error page is shown instead and false returned.
+func (app *application) authorizeClient(w http.ResponseWriter, r *http.Request, req authorizeRequest) (*data.OIDCClient, bool) {
+	client, err := app.Models.OIDCClient.GetByClientID(r.Context(), req.ClientID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("Error getting oidc client:", err)
+			app.renderAuthorize(w, http.StatusInternalServerError, authorizePage{Error: "Something went wrong, please try again."})
+			return nil, false
+		}
+
+		app.renderAuthorize(w, http.StatusBadRequest, authorizePage{Error: "Unknown client."})
+		return nil, false
+	}
+
+	if !client.AllowsRedirect(req.RedirectURI) {
+		app.renderAuthorize(w, http.StatusBadRequest, authorizePage{Error: "The redirect URI is not registered for this client."})
+		return nil, false
+	}
+
+	if code, description := req.validate(); code != "" {
+		http.Redirect(w, r, redirectURL(req.RedirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {req.State},
+		}), http.StatusFound)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// Authorize shows the login form for an authorization request.
+func (app *application) Authorize(w http.ResponseWriter, r *http.Request) {
+	req := parseAuthorizeRequest(r.URL.Query())
+
+	client, ok := app.authorizeClient(w, r, req)
+	if !ok {
+		return
+	}
+
+	app.renderAuthorize(w, http.StatusOK, authorizePage{Client: client, Request: req})
+}
+
+// AuthorizeLogin checks the credentials posted from the login form and, if they are
+// good, sends the user back to the client with an authorization code. Logins here are
+// throttled and recorded like those through /authenticate. Users enrolled in MFA have
+// to enter a Duo passcode, since a form post can't wait for a push.
+func (app *application) AuthorizeLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.renderAuthorize(w, http.StatusBadRequest, authorizePage{Error: "Invalid form."})
+		return
+	}
+
+	req := parseAuthorizeRequest(r.PostForm)
+
+	client, ok := app.authorizeClient(w, r, req)
+	if !ok {
+		return
+	}
+
+	page := authorizePage{Client: client, Request: req, Email: r.PostForm.Get("email")}
+
+	email := throttleKey(page.Email)
+	ip := app.requestIP(r)
+
+	retryAfter, err := app.loginRetryAfter(r.Context(), email, ip)
+	if err != nil {
+		log.Println("Error checking failed logins:", err)
+		page.Error = "Something went wrong, please try again."
+		app.renderAuthorize(w, http.StatusInternalServerError, page)
+		return
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second).Seconds())))
+		page.Error = "Too many failed attempts, please try again later."
+		app.renderAuthorize(w, http.StatusTooManyRequests, page)
+		return
+	}
+
+	plainPassword := r.PostForm.Get("password")
+
+	user, err := app.Models.User.GetByEmail(r.Context(), page.Email)
+	if err == nil {
+		var valid bool
+		valid, err = user.PasswordMatches(plainPassword)
+		if err == nil && (!valid || user.Active != 1) {
+			err = errInvalidCredentials
+		}
+	}
+	if err != nil {
+		app.recordLogin(email, ip, false)
+		page.Error = "Invalid email or password."
+		app.renderAuthorize(w, http.StatusUnauthorized, page)
+		return
+	}
+
+	app.recordLogin(email, ip, true)
+
+	_, err = app.Models.User.RehashPassword(r.Context(), user, plainPassword)
+	if err != nil {
+		log.Println("Error rehashing password:", err)
+	}
+
+	if app.Mail.requireVerified && user.EmailVerifiedAt == nil {
+		page.Error = "Please verify your email address before signing in."
+		app.renderAuthorize(w, http.StatusForbidden, page)
+		return
+	}
+
+	if user.MFAEnabled {
+		err = app.passcodeFactor(r, user, r.PostForm.Get("passcode"))
+		if err != nil {
+			status := http.StatusUnauthorized
+			switch {
+			case errors.Is(err, errMFAEnroll):
+				status = http.StatusForbidden
+			case errors.Is(err, errMFAUnavailable):
+				status = http.StatusServiceUnavailable
+			case !errors.Is(err, errPasscodeRequired) && !errors.Is(err, errMFADenied):
+				log.Println("Error checking second factor:", err)
+				err = errors.New("something went wrong, please try again")
+				status = http.StatusInternalServerError
+			}
+
+			page.Error = capitalize(err.Error()) + "."
+			app.renderAuthorize(w, status, page)
+			return
+		}
+	}
+
+	plain, hash, err := token.NewOneTimeToken()
+	if err == nil {
+		err = app.Models.AuthCode.Insert(r.Context(), data.AuthCode{
+			CodeHash:      hash,
+			ClientID:      client.ClientID,
+			UserID:        user.ID,
+			RedirectURI:   req.RedirectURI,
+			Scope:         req.Scope,
+			Nonce:         req.Nonce,
+			CodeChallenge: req.CodeChallenge,
+			UserAgent:     r.UserAgent(),
+			IP:            ip,
+			AuthTime:      time.Now(),
+			ExpiresAt:     time.Now().Add(authCodeTTL),
+		})
+	}
+	if err != nil {
+		log.Println("Error storing authorization code:", err)
+		page.Error = "Something went wrong, please try again."
+		app.renderAuthorize(w, http.StatusInternalServerError, page)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL(req.RedirectURI, url.Values{
+		"code":  {plain},
+		"state": {req.State},
+	}), http.StatusSeeOther)
+}
+
+// passcodeFactor checks a Duo passcode for a user whose password has already been
+// verified. Users Duo lets straight through don't need one.
+func (app *application) passcodeFactor(r *http.Request, user *data.User, passcode string) error {
+	if app.MFA == nil {
+		return errMFAUnavailable
+	}
+
+	preauth, err := app.MFA.Preauth(user.Email)
+	if err != nil {
+		return err
+	}
+
+	switch preauth.Result {
+	case mfaAllow:
+		return nil
+	case mfaEnroll:
+		return errMFAEnroll
+	case mfaAuth:
+	default:
+		app.recordMFADenied(r, user)
+		return errMFADenied
+	}
+
+	if passcode == "" {
+		return errPasscodeRequired
+	}
+
+	result, err := app.MFA.Auth(user.Email, factorPasscode, passcode)
+	if err != nil {
+		return err
+	}
+
+	if result.Result != mfaAllow {
+		app.recordMFADenied(r, user)
+		return errMFADenied
+	}
+
+	return nil
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// oauthTokenResponse is the token endpoint's answer, as OAuth 2.0 and OpenID Connect
+// define it.
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// oauthError sends an error in the form OAuth 2.0 clients expect.
+func (app *application) oauthError(w http.ResponseWriter, status int, code, description string) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+	if status == http.StatusUnauthorized {
+		headers.Set("WWW-Authenticate", `Basic realm="auth-svc"`)
+	}
+
+	app.writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	}, headers)
+}
+
+// oauthServerError logs err and sends a server_error without its details.
+func (app *application) oauthServerError(w http.ResponseWriter, err error) {
+	log.Println("Error in oauth request:", err)
+	app.oauthError(w, http.StatusInternalServerError, "server_error", "internal error")
+}
+
+// Token exchanges an authorization code, or a refresh token, for tokens. Clients with
+// a secret authenticate with HTTP Basic or client_secret in the form; public clients
+// only send their client_id, and rely on PKCE.
+func (app *application) Token(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "the request body must be a form")
+		return
+	}
+
+	client, ok := app.tokenClient(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		app.exchangeCode(w, r, client)
+	case "refresh_token":
+		tokens, err := app.refresh(r, r.PostForm.Get("refresh_token"))
+		if err != nil {
+			if errors.Is(err, errInvalidRefreshToken) {
+				app.oauthError(w, http.StatusBadRequest, "invalid_grant", errInvalidRefreshToken.Error())
+				return
+			}
+
+			app.oauthServerError(w, err)
+			return
+		}
+
+		app.writeTokens(w, oauthTokenResponse{
+			AccessToken:  tokens.AccessToken,
+			TokenType:    tokens.TokenType,
+			ExpiresIn:    tokens.ExpiresIn,
+			RefreshToken: tokens.RefreshToken,
+		})
+	default:
+		app.oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+// tokenClient authenticates the client calling the token endpoint.
+func (app *application) tokenClient(w http.ResponseWriter, r *http.Request) (*data.OIDCClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// The credentials are form encoded before they go into the header.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.Models.OIDCClient.GetByClientID(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client")
+			return nil, false
+		}
+
+		app.oauthServerError(w, err)
+		return nil, false
+	}
+
+	if !client.Public() && subtle.ConstantTimeCompare([]byte(token.Hash(secret)), []byte(client.SecretHash)) != 1 {
+		app.oauthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return nil, false
+	}
+
+	return client, true
+}
+
+// exchangeCode issues tokens for an authorization code, once, to the client it was
+// issued to, when the code verifier matches the challenge sent to the authorization
+// endpoint.
+func (app *application) exchangeCode(w http.ResponseWriter, r *http.Request, client *data.OIDCClient) {
+	invalidGrant := func() {
+		app.oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+	}
+
+	code, err := app.Models.AuthCode.GetByHash(r.Context(), token.Hash(r.PostForm.Get("code")))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			invalidGrant()
+			return
+		}
+
+		app.oauthServerError(w, err)
+		return
+	}
+
+	if code.ClientID != client.ClientID ||
+		code.RedirectURI != r.PostForm.Get("redirect_uri") ||
+		time.Now().After(code.ExpiresAt) ||
+		!verifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		invalidGrant()
+		return
+	}
+
+	err = app.Models.AuthCode.Use(r.Context(), code)
+	if err != nil {
+		if errors.Is(err, data.ErrCodeUsed) {
+			invalidGrant()
+			return
+		}
+
+		app.oauthServerError(w, err)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(r.Context(), code.UserID)
+	if err != nil || user.Active != 1 {
+		invalidGrant()
+		return
+	}
+
+	tokens, err := app.issueTokens(r.Context(), user, "", data.Session{
+		UserAgent: code.UserAgent,
+		IP:        code.IP,
+	}, data.Event{
+		Type:   data.EventLoginSucceeded,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     code.IP,
+		Detail: fmt.Sprintf("signed in to %s", client.Name),
+	})
+	if err != nil {
+		app.oauthServerError(w, err)
+		return
+	}
+
+	scopes := strings.Fields(code.Scope)
+
+	claims := token.IDClaims{
+		Issuer:    strings.TrimSuffix(app.OIDCIssuer, "/"),
+		Subject:   strconv.Itoa(user.ID),
+		Audience:  client.ClientID,
+		AuthTime:  code.AuthTime.Unix(),
+		Nonce:     code.Nonce,
+		SessionID: tokens.SessionID,
+	}
+	if slices.Contains(scopes, scopeEmail) {
+		claims.Email = user.Email
+		claims.EmailVerified = user.EmailVerifiedAt != nil
+	}
+	if slices.Contains(scopes, scopeProfile) {
+		claims.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims.GivenName = user.FirstName
+		claims.FamilyName = user.LastName
+	}
+
+	idToken, err := app.Tokens.IssueID(claims)
+	if err != nil {
+		app.oauthServerError(w, err)
+		return
+	}
+
+	app.writeTokens(w, oauthTokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    tokens.TokenType,
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      idToken,
+		Scope:        code.Scope,
+	})
+}
+
+func (app *application) writeTokens(w http.ResponseWriter, tokens oauthTokenResponse) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Pragma", "no-cache")
+
+	app.writeJSON(w, http.StatusOK, tokens, headers)
+}
+
+// verifyPKCE reports whether verifier hashes to the S256 challenge.
+func verifyPKCE(verifier, challenge string) bool {
+	if !pkceVerifier.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// UserInfo returns the claims about the user an access token was issued to, while its
+// session is still active.
+func (app *application) UserInfo(w http.ResponseWriter, r *http.Request) {
+	invalidToken := func() {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		app.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+	}
+
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		invalidToken()
+		return
+	}
+
+	claims, err := app.Tokens.Verify(bearer)
+	if err != nil {
+		invalidToken()
+		return
+	}
+
+	if claims.SessionID != "" {
+		revoked, err := app.Models.Session.IsRevoked(r.Context(), claims.SessionID)
+		if err != nil {
+			app.oauthServerError(w, err)
+			return
+		}
+		if revoked {
+			invalidToken()
+			return
+		}
+	}
+
+	id, _ := strconv.Atoi(claims.Subject)
+
+	user, err := app.Models.User.GetOne(r.Context(), id)
+	if err != nil || user.Active != 1 {
+		invalidToken()
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            claims.Subject,
+		"email":          user.Email,
+		"email_verified": user.EmailVerifiedAt != nil,
+		"name":           strings.TrimSpace(user.FirstName + " " + user.LastName),
+		"given_name":     user.FirstName,
+		"family_name":    user.LastName,
+	}, headers)
+}
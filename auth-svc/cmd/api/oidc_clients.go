@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+// oidcClientResponse is sent when a confidential client is created. It is the only
+// time the plain text secret is ever shown.
+type oidcClientResponse struct {
+	Client       *data.OIDCClient `json:"client"`
+	ClientSecret string           `json:"client_secret,omitempty"`
+}
+
+// ListOIDCClients returns every OpenID Connect client, without their secrets.
+func (app *application) ListOIDCClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.Models.OIDCClient.GetAll(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d clients", len(clients)),
+		Data:    clients,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateOIDCClient registers a client with a name and its redirect URIs. Confidential
+// clients get a secret; public ones, such as a single page app, don't.
+func (app *application) CreateOIDCClient(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	requestPayload.Name = strings.TrimSpace(requestPayload.Name)
+	if requestPayload.Name == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	err = validateRedirectURIs(requestPayload.RedirectURIs)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	clientID, secret, hash, err := token.NewClientCredentials()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if requestPayload.Public {
+		secret, hash = "", ""
+	}
+
+	id, err := app.Models.OIDCClient.Insert(r.Context(), data.OIDCClient{
+		ClientID:     clientID,
+		Name:         requestPayload.Name,
+		SecretHash:   hash,
+		RedirectURIs: requestPayload.RedirectURIs,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	client, err := app.Models.OIDCClient.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("created oidc client %d (%s)", client.ID, client.Name))
+
+	message := fmt.Sprintf("created public client %d", client.ID)
+	if secret != "" {
+		message = fmt.Sprintf("created client %d, store the secret now as it won't be shown again", client.ID)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    oidcClientResponse{Client: client, ClientSecret: secret},
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// GetOIDCClient returns one client.
+func (app *application) GetOIDCClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := app.oidcClientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("client %d", client.ID),
+		Data:    client,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UpdateOIDCClient changes a client's name or redirect URIs. Fields left out are kept.
+func (app *application) UpdateOIDCClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := app.oidcClientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Name         *string  `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Name != nil {
+		client.Name = strings.TrimSpace(*requestPayload.Name)
+		if client.Name == "" {
+			app.errorJSON(w, errors.New("name is required"))
+			return
+		}
+	}
+
+	if requestPayload.RedirectURIs != nil {
+		err = validateRedirectURIs(requestPayload.RedirectURIs)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		client.RedirectURIs = requestPayload.RedirectURIs
+	}
+
+	err = app.Models.OIDCClient.Update(r.Context(), client)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("updated oidc client %d (%s)", client.ID, client.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("updated client %d", client.ID),
+		Data:    client,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeleteOIDCClient removes a client, so users can no longer sign in to it.
+func (app *application) DeleteOIDCClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := app.oidcClientFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.OIDCClient.Delete(r.Context(), client)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("deleted oidc client %d (%s)", client.ID, client.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted client %d", client.ID),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// oidcClientFromURL loads the client named by the {id} URL parameter, sending an error
+// response and returning false if it can't.
+func (app *application) oidcClientFromURL(w http.ResponseWriter, r *http.Request) (*data.OIDCClient, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, errors.New("invalid client id"))
+		return nil, false
+	}
+
+	client, err := app.Models.OIDCClient.GetOne(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("client not found"), http.StatusNotFound)
+			return nil, false
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// validateRedirectURIs requires at least one redirect URI, each an absolute https URL
+// without a fragment. Plain http is allowed for localhost, for development and the
+// test client.
+func validateRedirectURIs(uris []string) error {
+	if len(uris) == 0 {
+		return errors.New("at least one redirect URI is required")
+	}
+
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			return fmt.Errorf("invalid redirect URI %q", uri)
+		}
+
+		switch u.Scheme {
+		case "https":
+		case "http":
+			host := u.Hostname()
+			if host != "localhost" && !net.ParseIP(host).IsLoopback() {
+				return fmt.Errorf("redirect URI %q must use https", uri)
+			}
+		default:
+			return fmt.Errorf("invalid redirect URI %q", uri)
+		}
+	}
+
+	return nil
+}
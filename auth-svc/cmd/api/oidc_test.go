@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAuthorizeRequestValidate(t *testing.T) {
+	challenge := pkceS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+
+	valid := authorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "portal",
+		RedirectURI:         "https://portal.example.com/callback",
+		Scope:               "openid email",
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: "S256",
+	}
+	if code, description := valid.validate(); code != "" {
+		t.Errorf("expected request to be valid, got %s: %s", code, description)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*authorizeRequest)
+		want   string
+	}{
+		{"implicit flow", func(req *authorizeRequest) { req.ResponseType = "token" }, "unsupported_response_type"},
+		{"no openid scope", func(req *authorizeRequest) { req.Scope = "email profile" }, "invalid_scope"},
+		{"no challenge", func(req *authorizeRequest) { req.CodeChallenge = "" }, "invalid_request"},
+		{"plain method", func(req *authorizeRequest) { req.CodeChallengeMethod = "plain" }, "invalid_request"},
+		{"short challenge", func(req *authorizeRequest) { req.CodeChallenge = challenge[:20] }, "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid
+			tt.change(&req)
+
+			if code, _ := req.validate(); code != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, code)
+			}
+		})
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// Example from RFC 7636, appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !verifyPKCE(verifier, challenge) {
+		t.Error("expected the RFC 7636 verifier to match its challenge")
+	}
+	if verifyPKCE(verifier[:42]+"x", challenge) {
+		t.Error("expected a different verifier to be rejected")
+	}
+	if verifyPKCE("short", pkceS256("short")) {
+		t.Error("expected a verifier shorter than 43 characters to be rejected")
+	}
+}
+
+func TestRedirectURL(t *testing.T) {
+	got := redirectURL("https://portal.example.com/callback?tenant=acme", url.Values{
+		"code":  {"abc"},
+		"state": {"x y"},
+	})
+
+	want := "https://portal.example.com/callback?code=abc&state=x+y&tenant=acme"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestValidateRedirectURIs(t *testing.T) {
+	valid := []string{"https://portal.example.com/callback", "http://localhost:8085/callback", "http://127.0.0.1/cb"}
+	if err := validateRedirectURIs(valid); err != nil {
+		t.Errorf("expected %v to be valid, got %v", valid, err)
+	}
+
+	if err := validateRedirectURIs(nil); err == nil {
+		t.Error("expected at least one redirect URI to be required")
+	}
+
+	invalid := []string{
+		"/callback",
+		"http://portal.example.com/callback",
+		"https://portal.example.com/callback#done",
+		"javascript:alert(1)",
+		"https://portal.example.com/a b",
+	}
+	for _, uri := range invalid {
+		if err := validateRedirectURIs([]string{uri}); err == nil {
+			t.Errorf("expected %q to be rejected", uri)
+		}
+	}
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	app := &application{OIDCIssuer: "https://auth.example.com/"}
+
+	rr := httptest.NewRecorder()
+	app.OpenIDConfiguration(rr, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+
+	var doc map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc["issuer"] != "https://auth.example.com" {
+		t.Errorf("expected the issuer without a trailing slash, got %v", doc["issuer"])
+	}
+	if doc["token_endpoint"] != "https://auth.example.com/oauth/token" {
+		t.Errorf("unexpected token endpoint %v", doc["token_endpoint"])
+	}
+	if doc["jwks_uri"] != "https://auth.example.com/.well-known/jwks.json" {
+		t.Errorf("unexpected jwks_uri %v", doc["jwks_uri"])
+	}
+}
+
+func pkceS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
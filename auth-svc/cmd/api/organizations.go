@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+// Limits on organization fields.
+const (
+	maxOrgNameLength    = 200
+	maxExternalIDLength = 200
+)
+
+var errDuplicateOrganization = errors.New("another organization already has that CRM or vendor ID")
+
+type externalIDsPayload struct {
+	VCD   *string `json:"vcd"`
+	Veeam *string `json:"veeam"`
+	Zerto *string `json:"zerto"`
+	Duo   *string `json:"duo"`
+}
+
+type organizationPayload struct {
+	Name        *string             `json:"name"`
+	CRMID       *string             `json:"crm_id"`
+	Status      *string             `json:"status"`
+	ExternalIDs *externalIDsPayload `json:"external_ids"`
+}
+
+// apply copies the fields set in the payload to org, trimming spaces.
+func (p organizationPayload) apply(org *data.Organization) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = strings.TrimSpace(*src)
+		}
+	}
+
+	set(&org.Name, p.Name)
+	set(&org.CRMID, p.CRMID)
+	set(&org.Status, p.Status)
+
+	if p.ExternalIDs != nil {
+		set(&org.ExternalIDs.VCD, p.ExternalIDs.VCD)
+		set(&org.ExternalIDs.Veeam, p.ExternalIDs.Veeam)
+		set(&org.ExternalIDs.Zerto, p.ExternalIDs.Zerto)
+		set(&org.ExternalIDs.Duo, p.ExternalIDs.Duo)
+	}
+}
+
+// ListOrganizations returns one page of organizations. Supports the page, page_size,
+// q (search) and status query parameters.
+func (app *application) ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	query := data.OrganizationQuery{
+		Search:   strings.TrimSpace(qs.Get("q")),
+		Status:   qs.Get("status"),
+		Page:     1,
+		PageSize: defaultPageSize,
+	}
+
+	var err error
+
+	if query.Status != "" && !validOrgStatus(query.Status) {
+		app.errorJSON(w, fmt.Errorf("status must be %s, %s or %s", data.OrgActive, data.OrgSuspended, data.OrgClosed))
+		return
+	}
+
+	if v := qs.Get("page"); v != "" {
+		query.Page, err = strconv.Atoi(v)
+		if err != nil || query.Page < 1 {
+			app.errorJSON(w, errors.New("page must be a positive number"))
+			return
+		}
+	}
+
+	if v := qs.Get("page_size"); v != "" {
+		query.PageSize, err = strconv.Atoi(v)
+		if err != nil || query.PageSize < 1 || query.PageSize > maxPageSize {
+			app.errorJSON(w, fmt.Errorf("page_size must be between 1 and %d", maxPageSize))
+			return
+		}
+	}
+
+	orgs, total, err := app.Models.Organization.List(r.Context(), query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d organizations", total),
+		Data: map[string]any{
+			"organizations": orgs,
+			"page":          query.Page,
+			"page_size":     query.PageSize,
+			"total":         total,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetOrganization returns one organization by ID.
+func (app *application) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("organization %d", org.ID),
+		Data:    org,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateOrganization adds an organization. Only the name is required, and new
+// organizations are active unless the request says otherwise.
+func (app *application) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var requestPayload organizationPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	org := data.Organization{Status: data.OrgActive}
+	requestPayload.apply(&org)
+
+	err = validateOrganization(&org)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkOrganizationIDs(r.Context(), org)
+	if err != nil {
+		app.orgError(w, err)
+		return
+	}
+
+	id, err := app.Models.Organization.Insert(r.Context(), org)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	created, err := app.Models.Organization.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("created organization %d (%s)", created.ID, created.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("created organization %d", created.ID),
+		Data:    created,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// UpdateOrganization changes an organization's name, CRM ID, status or external IDs.
+// Fields left out of the request are not changed, and an empty external ID unlinks the
+// vendor tenant.
+func (app *application) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload organizationPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	requestPayload.apply(org)
+
+	err = validateOrganization(org)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkOrganizationIDs(r.Context(), *org)
+	if err != nil {
+		app.orgError(w, err)
+		return
+	}
+
+	err = app.Models.Organization.Update(r.Context(), org)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("updated organization %d (%s), status is %s", org.ID, org.Name, org.Status))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("updated organization %d", org.ID),
+		Data:    org,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeleteOrganization permanently removes an organization and its memberships. Closing
+// it with UpdateOrganization keeps the record.
+func (app *application) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.Organization.Delete(r.Context(), org)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("deleted organization %d (%s)", org.ID, org.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted organization %d", org.ID),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ListMembers returns the users that belong to an organization.
+func (app *application) ListMembers(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeMembers(w, r, org, fmt.Sprintf("members of organization %d", org.ID))
+}
+
+// AddMember makes a user a member of an organization.
+func (app *application) AddMember(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		UserID int `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(r.Context(), requestPayload.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("user %d does not exist", requestPayload.UserID))
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.Organization.AddMember(r.Context(), org, user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("added user %d (%s) to organization %d (%s)", user.ID, user.Email, org.ID, org.Name))
+
+	app.writeMembers(w, r, org, fmt.Sprintf("added user %d to organization %d", user.ID, org.ID))
+}
+
+// RemoveMember takes a user out of an organization.
+func (app *application) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "user"))
+	if err != nil || userID < 1 {
+		app.errorJSON(w, errors.New("invalid user id"))
+		return
+	}
+
+	err = app.Models.Organization.RemoveMember(r.Context(), org, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("user %d is not a member", userID), http.StatusNotFound)
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("removed user %d from organization %d (%s)", userID, org.ID, org.Name))
+
+	app.writeMembers(w, r, org, fmt.Sprintf("removed user %d from organization %d", userID, org.ID))
+}
+
+// GetUserOrganizations returns every organization a user belongs to, whatever its
+// status.
+func (app *application) GetUserOrganizations(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	orgs, err := app.Models.User.Organizations(r.Context(), user.ID, false)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("organizations of user %d", user.ID),
+		Data:    orgs,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *application) writeMembers(w http.ResponseWriter, r *http.Request, org *data.Organization, message string) {
+	members, err := app.Models.Organization.Members(r.Context(), org)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    members,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// orgFromURL loads the organization named by the {id} URL parameter, sending an error
+// response and returning false if it can't.
+func (app *application) orgFromURL(w http.ResponseWriter, r *http.Request) (*data.Organization, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, errors.New("invalid organization id"))
+		return nil, false
+	}
+
+	org, err := app.Models.Organization.GetOne(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("organization not found"), http.StatusNotFound)
+			return nil, false
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return org, true
+}
+
+// checkOrganizationIDs returns errDuplicateOrganization if another organization already
+// has org's CRM ID or one of its external IDs.
+func (app *application) checkOrganizationIDs(ctx context.Context, org data.Organization) error {
+	_, err := app.Models.Organization.GetConflicting(ctx, org)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	return errDuplicateOrganization
+}
+
+// orgError sends the right status for an error returned by checkOrganizationIDs.
+func (app *application) orgError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errDuplicateOrganization) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	}
+
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+// orgClaims turns organizations into the org claims of an access token.
+func orgClaims(orgs []*data.Organization) []token.Org {
+	claims := make([]token.Org, 0, len(orgs))
+	for _, org := range orgs {
+		claims = append(claims, token.Org{
+			ID:    org.ID,
+			Name:  org.Name,
+			VCD:   org.ExternalIDs.VCD,
+			Veeam: org.ExternalIDs.Veeam,
+			Zerto: org.ExternalIDs.Zerto,
+			Duo:   org.ExternalIDs.Duo,
+		})
+	}
+
+	return claims
+}
+
+func validOrgStatus(status string) bool {
+	switch status {
+	case data.OrgActive, data.OrgSuspended, data.OrgClosed:
+		return true
+	}
+
+	return false
+}
+
+// validateOrganization checks an organization has a name, a known status and external
+// IDs of a sensible length.
+func validateOrganization(org *data.Organization) error {
+	if org.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(org.Name) > maxOrgNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxOrgNameLength)
+	}
+
+	if !validOrgStatus(org.Status) {
+		return fmt.Errorf("status must be %s, %s or %s", data.OrgActive, data.OrgSuspended, data.OrgClosed)
+	}
+
+	ids := []struct{ field, id string }{
+		{"crm_id", org.CRMID},
+		{"external_ids.vcd", org.ExternalIDs.VCD},
+		{"external_ids.veeam", org.ExternalIDs.Veeam},
+		{"external_ids.zerto", org.ExternalIDs.Zerto},
+		{"external_ids.duo", org.ExternalIDs.Duo},
+	}
+	for _, v := range ids {
+		if len(v.id) > maxExternalIDLength {
+			return fmt.Errorf("%s must be at most %d characters", v.field, maxExternalIDLength)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/password"
+)
+
+// loadPasswordConfig reads the password policy and the argon2id parameters for new
+// hashes from the environment.
+func loadPasswordConfig() (*password.Policy, password.Params, error) {
+	policy := password.DefaultPolicy()
+	params := password.DefaultParams
+
+	var err error
+
+	policy.MinLength, err = intFromEnv("PASSWORD_MIN_LENGTH", policy.MinLength)
+	if err != nil {
+		return nil, params, err
+	}
+
+	if policy.MinLength > policy.MaxLength {
+		return nil, params, fmt.Errorf("PASSWORD_MIN_LENGTH must not be more than %d", policy.MaxLength)
+	}
+
+	if path := os.Getenv("PASSWORD_BREACHED_FILE"); path != "" {
+		err = policy.LoadBreachedFile(path)
+		if err != nil {
+			return nil, params, fmt.Errorf("loading PASSWORD_BREACHED_FILE: %w", err)
+		}
+
+		log.Printf("Loaded %d breached passwords", policy.Breached())
+	}
+
+	memory, err := intFromEnv("PASSWORD_ARGON2_MEMORY", int(params.Memory))
+	if err != nil {
+		return nil, params, err
+	}
+
+	iterations, err := intFromEnv("PASSWORD_ARGON2_ITERATIONS", int(params.Iterations))
+	if err != nil {
+		return nil, params, err
+	}
+
+	parallelism, err := intFromEnv("PASSWORD_ARGON2_PARALLELISM", int(params.Parallelism))
+	if err != nil {
+		return nil, params, err
+	}
+
+	if parallelism > 255 {
+		return nil, params, fmt.Errorf("PASSWORD_ARGON2_PARALLELISM must be at most 255")
+	}
+
+	params.Memory = uint32(memory)
+	params.Iterations = uint32(iterations)
+	params.Parallelism = uint8(parallelism)
+
+	return policy, params, nil
+}
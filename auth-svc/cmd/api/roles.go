@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+)
+
+// allPermissions grants every permission in every service.
+const allPermissions = "*"
+
+var (
+	roleNamePattern   = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	permissionPattern = regexp.MustCompile(`^(\*|(\*|[a-z][a-z0-9_]*):(\*|[a-z][a-z0-9_]*))$`)
+)
+
+// ListRoles returns every role with its permissions.
+func (app *application) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.Models.Role.GetAll(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d roles", len(roles)),
+		Data:    roles,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateRole adds a role with a name, description and list of permissions.
+func (app *application) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !roleNamePattern.MatchString(requestPayload.Name) {
+		app.errorJSON(w, errors.New("name must be lower case letters, digits and dashes"))
+		return
+	}
+
+	err = validatePermissions(requestPayload.Permissions)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_, err = app.Models.Role.GetByName(r.Context(), requestPayload.Name)
+	if err == nil {
+		app.errorJSON(w, errors.New("a role with that name already exists"), http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_, err = app.Models.Role.Insert(r.Context(), data.Role{
+		Name:        requestPayload.Name,
+		Description: strings.TrimSpace(requestPayload.Description),
+		Permissions: requestPayload.Permissions,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	role, err := app.Models.Role.GetByName(r.Context(), requestPayload.Name)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("created role %s with permissions %s", role.Name, strings.Join(role.Permissions, ",")))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("created role %s", role.Name),
+		Data:    role,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// UpdateRole changes a role's description and replaces its permissions. Fields left
+// out of the request are not changed.
+func (app *application) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Description *string   `json:"description"`
+		Permissions *[]string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Description != nil {
+		role.Description = strings.TrimSpace(*requestPayload.Description)
+	}
+	if requestPayload.Permissions != nil {
+		err = validatePermissions(*requestPayload.Permissions)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		role.Permissions = *requestPayload.Permissions
+	}
+
+	err = app.Models.Role.Update(r.Context(), role)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("updated role %s, permissions are now %s", role.Name, strings.Join(role.Permissions, ",")))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("updated role %s", role.Name),
+		Data:    role,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeleteRole removes a role from every user that has it and deletes it. Built in roles
+// can't be deleted.
+func (app *application) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.Role.Delete(r.Context(), role)
+	if err != nil {
+		if errors.Is(err, data.ErrBuiltinRole) {
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("deleted role %s", role.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted role %s", role.Name),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetUserRoles returns the roles assigned to a user and the permissions they add up to.
+func (app *application) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserRoles(w, r, user, fmt.Sprintf("roles of user %d", user.ID))
+}
+
+// AssignUserRole gives a user a role.
+func (app *application) AssignUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	role, err := app.Models.Role.GetByName(r.Context(), requestPayload.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, fmt.Errorf("role %q does not exist", requestPayload.Role))
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.User.AssignRole(r.Context(), user.ID, role.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("gave user %d (%s) the %s role", user.ID, user.Email, role.Name))
+
+	app.writeUserRoles(w, r, user, fmt.Sprintf("gave user %d the %s role", user.ID, role.Name))
+}
+
+// RemoveUserRole takes a role away from a user.
+func (app *application) RemoveUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	role, ok := app.roleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Models.User.RemoveRole(r.Context(), user.ID, role.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("removed the %s role from user %d (%s)", role.Name, user.ID, user.Email))
+
+	app.writeUserRoles(w, r, user, fmt.Sprintf("removed the %s role from user %d", role.Name, user.ID))
+}
+
+func (app *application) writeUserRoles(w http.ResponseWriter, r *http.Request, user *data.User, message string) {
+	roles, err := app.Models.User.Roles(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	permissions, err := app.Models.User.Permissions(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data: map[string]any{
+			"roles":       roles,
+			"permissions": permissions,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// roleFromURL loads the role named by the {role} URL parameter, sending an error
+// response and returning false if it can't.
+func (app *application) roleFromURL(w http.ResponseWriter, r *http.Request) (*data.Role, bool) {
+	role, err := app.Models.Role.GetByName(r.Context(), chi.URLParam(r, "role"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("role not found"), http.StatusNotFound)
+			return nil, false
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return role, true
+}
+
+// validatePermissions checks every permission has the form "resource:action", where
+// either half can be "*", or is "*" on its own.
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !permissionPattern.MatchString(permission) {
+			return fmt.Errorf("%q is not a valid permission, expected resource:action", permission)
+		}
+	}
+
+	return nil
+}
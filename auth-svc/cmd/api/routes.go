@@ -16,13 +16,104 @@ func (app *application) routes() http.Handler {
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Get("/health/ready", app.Ready)
 
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/authenticate/mfa", app.CompleteMFA)
+	mux.Post("/token/refresh", app.RefreshTokens)
+	mux.Post("/logout", app.Logout)
+	mux.Get("/.well-known/jwks.json", app.JWKS)
+	mux.Get("/sessions/revoked", app.RevokedSessions)
+
+	// OpenID Connect provider.
+	mux.Get("/.well-known/openid-configuration", app.OpenIDConfiguration)
+	mux.Get("/oauth/authorize", app.Authorize)
+	mux.Post("/oauth/authorize", app.AuthorizeLogin)
+	mux.Post("/oauth/token", app.Token)
+	mux.Get("/oauth/userinfo", app.UserInfo)
+	mux.Post("/oauth/userinfo", app.UserInfo)
+	mux.Post("/password/forgot", app.ForgotPassword)
+	mux.Post("/password/reset", app.ResetPassword)
+	mux.Post("/email/verify", app.VerifyEmail)
+	mux.Post("/email/verify/resend", app.ResendVerification)
+
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Get("/", app.ListUsers)
+		mux.Post("/", app.CreateUser)
+		mux.Get("/{id}", app.GetUser)
+		mux.Put("/{id}", app.UpdateUser)
+		mux.Delete("/{id}", app.DeleteUser)
+		mux.Post("/{id}/deactivate", app.DeactivateUser)
+		mux.Post("/{id}/password", app.ResetUserPassword)
+		mux.Get("/{id}/roles", app.GetUserRoles)
+		mux.Post("/{id}/roles", app.AssignUserRole)
+		mux.Delete("/{id}/roles/{role}", app.RemoveUserRole)
+		mux.Delete("/{id}/lockout", app.UnlockUser)
+		mux.Get("/{id}/organizations", app.GetUserOrganizations)
+		mux.Get("/{id}/sessions", app.ListUserSessions)
+		mux.Delete("/{id}/sessions", app.RevokeUserSessions)
+		mux.Delete("/{id}/sessions/{session}", app.RevokeUserSession)
+	})
+
+	mux.Route("/organizations", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Get("/", app.ListOrganizations)
+		mux.Post("/", app.CreateOrganization)
+		mux.Get("/{id}", app.GetOrganization)
+		mux.Put("/{id}", app.UpdateOrganization)
+		mux.Delete("/{id}", app.DeleteOrganization)
+		mux.Get("/{id}/members", app.ListMembers)
+		mux.Post("/{id}/members", app.AddMember)
+		mux.Delete("/{id}/members/{user}", app.RemoveMember)
+	})
+
+	mux.Route("/oidc/clients", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Get("/", app.ListOIDCClients)
+		mux.Post("/", app.CreateOIDCClient)
+		mux.Get("/{id}", app.GetOIDCClient)
+		mux.Put("/{id}", app.UpdateOIDCClient)
+		mux.Delete("/{id}", app.DeleteOIDCClient)
+	})
+
+	mux.Route("/lockouts", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Get("/", app.ListLockouts)
+		mux.Delete("/ips/{ip}", app.UnlockIP)
+	})
+
+	mux.Route("/roles", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Get("/", app.ListRoles)
+		mux.Post("/", app.CreateRole)
+		mux.Put("/{role}", app.UpdateRole)
+		mux.Delete("/{role}", app.DeleteRole)
+	})
+
+	mux.Route("/api-keys", func(mux chi.Router) {
+		mux.Post("/verify", app.VerifyAPIKey)
+
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.requireAdmin)
+
+			mux.Get("/", app.ListAPIKeys)
+			mux.Post("/", app.CreateAPIKey)
+			mux.Post("/{id}/rotate", app.RotateAPIKey)
+			mux.Delete("/{id}", app.RevokeAPIKey)
+		})
+	})
+
 	return mux
 }
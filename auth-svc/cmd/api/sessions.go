@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+)
+
+// revocationLeeway covers the clock skew services allow for when checking an access
+// token's expiry, so a revoked session is listed for as long as any of its tokens
+// could still be accepted.
+const revocationLeeway = time.Minute
+
+// device is the user agent and IP a request came from, recorded with its session.
+func (app *application) device(r *http.Request) data.Session {
+	return data.Session{
+		UserAgent: r.UserAgent(),
+		IP:        app.requestIP(r),
+	}
+}
+
+// ListUserSessions lists a user's active sessions, with the device and IP each was
+// last used from.
+func (app *application) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := app.Models.Session.ForUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("sessions of user %d", user.ID),
+		Data:    sessions,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RevokeUserSession logs a user out of one session. Its refresh tokens stop working
+// at once, and its access tokens as soon as services next fetch the revocation list.
+func (app *application) RevokeUserSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "session")
+
+	err := app.Models.Session.Revoke(r.Context(), user.ID, id, data.Event{
+		Type:   data.EventTokenRevoked,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+		Actor:  adminFromContext(r).Email,
+		Detail: fmt.Sprintf("session %s revoked", id),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("session not found"), http.StatusNotFound)
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("revoked session %s of user %d (%s)", id, user.ID, user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("revoked session %s", id),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RevokeUserSessions logs a user out everywhere.
+func (app *application) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.revokeSessions(r, user, "all sessions revoked")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("revoked all sessions of user %d (%s)", user.ID, user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("revoked all sessions of user %d", user.ID),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// revokeSessions logs user out everywhere on an admin's behalf, giving the reason
+// in the token.revoked event.
+func (app *application) revokeSessions(r *http.Request, user *data.User, reason string) error {
+	return app.Models.RefreshToken.RevokeUser(r.Context(), user.ID, data.Event{
+		Type:   data.EventTokenRevoked,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+		Actor:  adminFromContext(r).Email,
+		Detail: reason,
+	})
+}
+
+// RevokedSessions lists the sessions revoked while their access tokens may still be
+// valid. Services reject access tokens whose sid is listed, so they don't have to call
+// auth-svc for every request. They hear about revocations from session.revoked events
+// and poll this to catch up on any they missed, e.g. while RabbitMQ was down. Session
+// IDs grant nothing on their own, so like the JWKS it needs no credentials.
+func (app *application) RevokedSessions(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().Add(-app.Tokens.TTL - revocationLeeway)
+
+	ids, err := app.Models.Session.Revoked(r.Context(), since)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "no-store")
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "revoked sessions",
+		Data:    map[string]any{"sessions": ids},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload, headers)
+}
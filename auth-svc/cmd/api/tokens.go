@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+// Token lifetimes used when the environment doesn't override them.
+const (
+	defaultAccessTTL   = 15 * time.Minute
+	defaultRefreshTTL  = 30 * 24 * time.Hour
+	defaultKeyRotation = 24 * time.Hour
+)
+
+var errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// tokenResponse is sent back whenever a new token pair is issued.
+type tokenResponse struct {
+	User         *data.User `json:"user"`
+	AccessToken  string     `json:"access_token"`
+	RefreshToken string     `json:"refresh_token"`
+	TokenType    string     `json:"token_type"`
+	ExpiresIn    int        `json:"expires_in"`
+	// SessionID is the sid of the access token, which ID tokens carry too.
+	SessionID string `json:"-"`
+}
+
+// tokenConfig is read from the environment at start up.
+type tokenConfig struct {
+	keysDir     string
+	keyRotation time.Duration
+	issuer      string
+	audience    string
+	// oidcIssuer is the issuer of ID tokens, which has to be the URL OpenID Connect
+	// clients reach auth-svc at. Access tokens keep issuer.
+	oidcIssuer string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func loadTokenConfig() (tokenConfig, error) {
+	cfg := tokenConfig{
+		keysDir:  os.Getenv("JWT_KEYS_DIR"),
+		issuer:   envOrDefault("JWT_ISSUER", "http://auth-svc"),
+		audience: envOrDefault("JWT_AUDIENCE", "service-hub"),
+	}
+	cfg.oidcIssuer = envOrDefault("OIDC_ISSUER", cfg.issuer)
+
+	var err error
+
+	cfg.keyRotation, err = durationFromEnv("JWT_KEY_ROTATION", defaultKeyRotation)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.accessTTL, err = durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTTL)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.refreshTTL, err = durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTTL)
+	if err != nil {
+		return cfg, err
+	}
+
+	// Retired keys are published for one rotation, so rotating any faster than tokens
+	// expire would break tokens that are still valid.
+	if cfg.keyRotation < cfg.accessTTL {
+		return cfg, errors.New("JWT_KEY_ROTATION must not be shorter than ACCESS_TOKEN_TTL")
+	}
+
+	return cfg, nil
+}
+
+// newIssuer loads the signing keys from JWT_KEYS_DIR. Without a key directory an
+// ephemeral key is generated and rotated in the background, which is fine for
+// development but means tokens don't survive a restart.
+func newIssuer(cfg tokenConfig) (*token.Issuer, error) {
+	var keys *token.KeySet
+
+	if cfg.keysDir != "" {
+		var err error
+
+		keys, err = token.LoadKeyDir(cfg.keysDir)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		log.Println("JWT_KEYS_DIR not set, using ephemeral signing keys")
+
+		key, err := token.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = token.NewKeySet(key)
+
+		go rotateKeys(keys, cfg.keyRotation)
+	}
+
+	return &token.Issuer{
+		Keys:     keys,
+		Issuer:   cfg.issuer,
+		Audience: cfg.audience,
+		TTL:      cfg.accessTTL,
+	}, nil
+}
+
+// rotateKeys replaces the signing key every interval, keeping the previous key
+// published so tokens it signed stay valid until they expire.
+func rotateKeys(keys *token.KeySet, interval time.Duration) {
+	for range time.Tick(interval) {
+		key, err := token.GenerateKey()
+		if err != nil {
+			log.Println("Error rotating signing key:", err)
+			continue
+		}
+
+		keys.Rotate(key, 2)
+		log.Println("Rotated signing key to", key.ID)
+	}
+}
+
+// cleanupExpired periodically deletes refresh tokens, sessions, authorization codes,
+// mfa challenges and emailed tokens that have expired, login attempts too old to count towards a
+// lockout and auth events published longer ago than the retention.
+func (app *application) cleanupExpired(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx := context.Background()
+
+		err := app.Models.RefreshToken.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			log.Println("Error deleting expired refresh tokens:", err)
+		}
+
+		err = app.Models.Session.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			log.Println("Error deleting expired sessions:", err)
+		}
+
+		err = app.Models.AuthCode.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			log.Println("Error deleting expired authorization codes:", err)
+		}
+
+		err = app.Models.MFAChallenge.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			log.Println("Error deleting expired mfa challenges:", err)
+		}
+
+		err = app.Models.UserToken.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			log.Println("Error deleting expired user tokens:", err)
+		}
+
+		err = app.Models.LoginAttempt.DeleteOlderThan(ctx, time.Now().Add(-app.Throttle.window))
+		if err != nil {
+			log.Println("Error deleting old login attempts:", err)
+		}
+
+		err = app.Models.Event.DeletePublished(ctx, time.Now().Add(-app.EventRetention))
+		if err != nil {
+			log.Println("Error deleting published auth events:", err)
+		}
+	}
+}
+
+// issueTokens creates an access token and a refresh token for user. The access token
+// carries the user's current roles, permissions and active organizations, so changes
+// apply from the next refresh. An empty family starts a new login session. device is
+// recorded as the session's latest user agent and IP, and events along with the
+// refresh token.
+func (app *application) issueTokens(ctx context.Context, user *data.User, family string, device data.Session, events ...data.Event) (*tokenResponse, error) {
+	roles, err := app.Models.User.Roles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.Models.User.Permissions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs, err := app.Models.User.Organizations(ctx, user.ID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if family == "" {
+		family, err = token.NewSessionID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	accessToken, _, err := app.Tokens.Issue(token.Claims{
+		Subject:     strconv.Itoa(user.ID),
+		SessionID:   family,
+		Email:       user.Email,
+		Roles:       roles,
+		Permissions: permissions,
+		Orgs:        orgClaims(orgs),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, hash, err := token.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Models.RefreshToken.Insert(ctx, data.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  family,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(app.RefreshTTL),
+	}, device, events...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		User:         user,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(app.Tokens.TTL.Seconds()),
+		SessionID:    family,
+	}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. Each refresh token can
+// only be used once; presenting a used one revokes the whole session.
+func (app *application) RefreshTokens(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := app.refresh(r, requestPayload.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) {
+			app.errorJSON(w, errInvalidRefreshToken, http.StatusUnauthorized)
+			return
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "refreshed",
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// refresh spends a refresh token and issues a new pair in the same session. It returns
+// errInvalidRefreshToken if the token is unknown, used, expired or revoked, or its
+// user is no longer active.
+func (app *application) refresh(r *http.Request, plain string) (*tokenResponse, error) {
+	stored, err := app.Models.RefreshToken.GetByHash(r.Context(), token.Hash(plain))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errInvalidRefreshToken
+		}
+
+		return nil, err
+	}
+
+	err = app.Models.RefreshToken.Use(r.Context(), stored)
+	if err != nil {
+		if errors.Is(err, data.ErrTokenReused) {
+			return nil, errInvalidRefreshToken
+		}
+
+		return nil, err
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	user, err := app.Models.User.GetOne(r.Context(), stored.UserID)
+	if err != nil || user.Active != 1 {
+		return nil, errInvalidRefreshToken
+	}
+
+	tokens, err := app.issueTokens(r.Context(), user, stored.FamilyID, app.device(r))
+	if err != nil {
+		if errors.Is(err, data.ErrSessionRevoked) {
+			return nil, errInvalidRefreshToken
+		}
+
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Logout revokes the session the refresh token belongs to. Services stop accepting
+// the access tokens already handed out for it once its session.revoked event reaches
+// them, or when they next fetch the revoked sessions.
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	stored, err := app.Models.RefreshToken.GetByHash(r.Context(), token.Hash(requestPayload.RefreshToken))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Nothing to revoke, which is what the client wanted anyway.
+	case err != nil:
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	default:
+		err = app.Models.RefreshToken.RevokeFamily(r.Context(), stored, data.Event{
+			Type:   data.EventTokenRevoked,
+			UserID: stored.UserID,
+			IP:     app.requestIP(r),
+			Detail: "logged out",
+		})
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "logged out",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// JWKS publishes the public keys access tokens can be verified with.
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	app.writeJSON(w, http.StatusOK, app.Tokens.Keys.JWKS(), headers)
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+func durationFromEnv(key string, fallback time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration, e.g. 15m", key)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+)
+
+// Page sizes for ListUsers.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errDuplicateEmail = errors.New("a user with that email already exists")
+
+type userPayload struct {
+	Email     *string `json:"email"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Password  *string `json:"password"`
+	Active    *bool   `json:"active"`
+	// MFAEnabled enrolls the user in Duo second factor logins.
+	MFAEnabled *bool `json:"mfa_enabled"`
+}
+
+// ListUsers returns one page of users. Supports the page, page_size and q (search)
+// query parameters.
+func (app *application) ListUsers(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	query := data.UserQuery{
+		Search:   strings.TrimSpace(qs.Get("q")),
+		Page:     1,
+		PageSize: defaultPageSize,
+	}
+
+	var err error
+
+	if v := qs.Get("page"); v != "" {
+		query.Page, err = strconv.Atoi(v)
+		if err != nil || query.Page < 1 {
+			app.errorJSON(w, errors.New("page must be a positive number"))
+			return
+		}
+	}
+
+	if v := qs.Get("page_size"); v != "" {
+		query.PageSize, err = strconv.Atoi(v)
+		if err != nil || query.PageSize < 1 || query.PageSize > maxPageSize {
+			app.errorJSON(w, fmt.Errorf("page_size must be between 1 and %d", maxPageSize))
+			return
+		}
+	}
+
+	users, total, err := app.Models.User.List(r.Context(), query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d users", total),
+		Data: map[string]any{
+			"users":     users,
+			"page":      query.Page,
+			"page_size": query.PageSize,
+			"total":     total,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetUser returns one user by ID.
+func (app *application) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("user %d", user.ID),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateUser adds a new user. Email and password are required, and new users are
+// active unless the request says otherwise.
+func (app *application) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var requestPayload userPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Email == nil || requestPayload.Password == nil {
+		app.errorJSON(w, errors.New("email and password are required"))
+		return
+	}
+
+	user := data.User{
+		Email:    strings.TrimSpace(*requestPayload.Email),
+		Password: *requestPayload.Password,
+		Active:   1,
+	}
+	if requestPayload.FirstName != nil {
+		user.FirstName = *requestPayload.FirstName
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = *requestPayload.LastName
+	}
+	if requestPayload.Active != nil && !*requestPayload.Active {
+		user.Active = 0
+	}
+	if requestPayload.MFAEnabled != nil {
+		user.MFAEnabled = *requestPayload.MFAEnabled
+	}
+
+	err = validateEmail(user.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Passwords.Check(user.Password)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.checkEmailAvailable(r.Context(), user.Email, 0)
+	if err != nil {
+		app.emailError(w, err)
+		return
+	}
+
+	id, err := app.Models.User.Insert(r.Context(), user, data.Event{
+		Type:  data.EventUserCreated,
+		Email: user.Email,
+		IP:    app.requestIP(r),
+		Actor: adminFromContext(r).Email,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	created, err := app.Models.User.GetOne(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("created user %d (%s)", created.ID, created.Email))
+	app.sendVerification(r.Context(), created)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("created user %d", created.ID),
+		Data:    created,
+	}
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// UpdateUser changes a user's email, name or active flag. Fields left out of the
+// request are not changed. Passwords are changed with ResetUserPassword.
+func (app *application) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload userPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Password != nil {
+		app.errorJSON(w, errors.New("use the password endpoint to change a password"))
+		return
+	}
+
+	emailChanged := false
+
+	if requestPayload.Email != nil {
+		email := strings.TrimSpace(*requestPayload.Email)
+
+		err = validateEmail(email)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		err = app.checkEmailAvailable(r.Context(), email, user.ID)
+		if err != nil {
+			app.emailError(w, err)
+			return
+		}
+
+		// A new address has to be verified again.
+		if email != user.Email {
+			user.EmailVerifiedAt = nil
+			emailChanged = true
+		}
+		user.Email = email
+	}
+	if requestPayload.FirstName != nil {
+		user.FirstName = *requestPayload.FirstName
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = *requestPayload.LastName
+	}
+	if requestPayload.Active != nil {
+		if !*requestPayload.Active && user.ID == adminFromContext(r).ID {
+			app.errorJSON(w, errors.New("admins can't deactivate themselves"), http.StatusConflict)
+			return
+		}
+
+		user.Active = 0
+		if *requestPayload.Active {
+			user.Active = 1
+		}
+	}
+	if requestPayload.MFAEnabled != nil {
+		user.MFAEnabled = *requestPayload.MFAEnabled
+	}
+
+	err = app.Models.User.Update(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if user.Active == 0 {
+		err = app.revokeSessions(r, user, "user deactivated")
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.audit(r, fmt.Sprintf("updated user %d (%s)", user.ID, user.Email))
+	if emailChanged {
+		app.sendVerification(r.Context(), user)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("updated user %d", user.ID),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeactivateUser marks a user inactive, which stops them from logging in while
+// keeping their record, and revokes their sessions.
+func (app *application) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == adminFromContext(r).ID {
+		app.errorJSON(w, errors.New("admins can't deactivate themselves"), http.StatusConflict)
+		return
+	}
+
+	user.Active = 0
+
+	err := app.Models.User.Update(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.revokeSessions(r, user, "user deactivated")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("deactivated user %d (%s)", user.ID, user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deactivated user %d", user.ID),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeleteUser permanently removes a user.
+func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if user.ID == adminFromContext(r).ID {
+		app.errorJSON(w, errors.New("admins can't delete themselves"), http.StatusConflict)
+		return
+	}
+
+	err := app.Models.User.Delete(r.Context(), user.ID, data.Event{
+		Type:   data.EventUserDeleted,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+		Actor:  adminFromContext(r).Email,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("deleted user %d (%s)", user.ID, user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deleted user %d", user.ID),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ResetUserPassword sets a new password for a user, and revokes every session they
+// had, as a reset through an emailed link does.
+func (app *application) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Passwords.Check(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.User.ResetPassword(r.Context(), user, requestPayload.Password, data.Event{
+		Type:   data.EventPasswordChanged,
+		UserID: user.ID,
+		Email:  user.Email,
+		IP:     app.requestIP(r),
+		Actor:  adminFromContext(r).Email,
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.revokeSessions(r, user, "every session revoked after a password reset")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, fmt.Sprintf("reset the password of user %d (%s)", user.ID, user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("reset the password of user %d", user.ID),
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// userFromURL loads the user named by the {id} URL parameter, sending an error
+// response and returning false if it can't.
+func (app *application) userFromURL(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, errors.New("invalid user id"))
+		return nil, false
+	}
+
+	user, err := app.Models.User.GetOne(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+			return nil, false
+		}
+
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// checkEmailAvailable returns errDuplicateEmail if a user other than exceptID already
+// has the email address.
+func (app *application) checkEmailAvailable(ctx context.Context, email string, exceptID int) error {
+	existing, err := app.Models.User.GetByEmail(ctx, email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	case existing.ID != exceptID:
+		return errDuplicateEmail
+	}
+
+	return nil
+}
+
+// emailError sends the right status for an error returned by checkEmailAvailable.
+func (app *application) emailError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errDuplicateEmail) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	}
+
+	app.errorJSON(w, err, http.StatusInternalServerError)
+}
+
+// audit records a change made through the admin API in logger-svc. The change has
+// already happened by the time it's audited, so a logging failure doesn't fail the
+// request.
+func (app *application) audit(r *http.Request, action string) {
+	err := app.logRequest("audit", fmt.Sprintf("%s %s", adminFromContext(r).Email, action))
+	if err != nil {
+		log.Println("Error sending audit event:", err)
+	}
+}
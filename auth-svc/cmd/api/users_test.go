@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+	"github.com/cloudkey-io/service-hub/auth-svc/password"
+)
+
+// fakeUsers is an in-memory data.UserRepository.
+type fakeUsers struct {
+	users       map[int]*data.User
+	permissions map[int][]string
+	events      []data.Event
+}
+
+func newFakeUsers(users ...data.User) *fakeUsers {
+	f := &fakeUsers{users: make(map[int]*data.User)}
+	for _, u := range users {
+		f.users[u.ID] = &u
+	}
+
+	return f
+}
+
+func (f *fakeUsers) sorted() []*data.User {
+	users := []*data.User{}
+	for _, u := range f.users {
+		copied := *u
+		users = append(users, &copied)
+	}
+
+	slices.SortFunc(users, func(a, b *data.User) int { return a.ID - b.ID })
+
+	return users
+}
+
+func (f *fakeUsers) GetAll(ctx context.Context) ([]*data.User, error) {
+	return f.sorted(), nil
+}
+
+func (f *fakeUsers) List(ctx context.Context, q data.UserQuery) ([]*data.User, int, error) {
+	matched := []*data.User{}
+	for _, u := range f.sorted() {
+		if strings.Contains(u.Email, q.Search) {
+			matched = append(matched, u)
+		}
+	}
+
+	start := min((q.Page-1)*q.PageSize, len(matched))
+	end := min(start+q.PageSize, len(matched))
+
+	return matched[start:end], len(matched), nil
+}
+
+func (f *fakeUsers) GetByEmail(ctx context.Context, email string) (*data.User, error) {
+	for _, u := range f.sorted() {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeUsers) GetOne(ctx context.Context, id int) (*data.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	copied := *u
+	return &copied, nil
+}
+
+func (f *fakeUsers) Insert(ctx context.Context, user data.User, events ...data.Event) (int, error) {
+	user.ID = len(f.users) + 1
+	f.users[user.ID] = &user
+	f.events = append(f.events, events...)
+
+	return user.ID, nil
+}
+
+func (f *fakeUsers) Update(ctx context.Context, user *data.User) error {
+	copied := *user
+	f.users[user.ID] = &copied
+
+	return nil
+}
+
+func (f *fakeUsers) Delete(ctx context.Context, id int, events ...data.Event) error {
+	delete(f.users, id)
+	f.events = append(f.events, events...)
+
+	return nil
+}
+
+func (f *fakeUsers) ResetPassword(ctx context.Context, user *data.User, plainText string, events ...data.Event) error {
+	f.users[user.ID].Password = plainText
+	f.events = append(f.events, events...)
+
+	return nil
+}
+
+func (f *fakeUsers) RehashPassword(ctx context.Context, user *data.User, plainText string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeUsers) VerifyEmail(ctx context.Context, user *data.User) error {
+	return nil
+}
+
+func (f *fakeUsers) Roles(ctx context.Context, userID int) ([]string, error) {
+	return []string{}, nil
+}
+
+func (f *fakeUsers) Permissions(ctx context.Context, userID int) ([]string, error) {
+	return append([]string{}, f.permissions[userID]...), nil
+}
+
+func (f *fakeUsers) AssignRole(ctx context.Context, userID, roleID int) error {
+	return nil
+}
+
+func (f *fakeUsers) RemoveRole(ctx context.Context, userID, roleID int) error {
+	return nil
+}
+
+func (f *fakeUsers) Organizations(ctx context.Context, userID int, activeOnly bool) ([]*data.Organization, error) {
+	return []*data.Organization{}, nil
+}
+
+// newUsersTestApp serves the user handlers as admin, without the database backed
+// admin check.
+func newUsersTestApp(users *fakeUsers, admin *data.User) http.Handler {
+	app := &application{
+		Models:    data.Models{User: users},
+		Passwords: password.DefaultPolicy(),
+	}
+
+	mux := chi.NewRouter()
+	mux.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey, admin)))
+		})
+	})
+
+	mux.Get("/users", app.ListUsers)
+	mux.Post("/users", app.CreateUser)
+	mux.Get("/users/{id}", app.GetUser)
+	mux.Put("/users/{id}", app.UpdateUser)
+	mux.Delete("/users/{id}", app.DeleteUser)
+
+	return mux
+}
+
+func serve(t *testing.T, h http.Handler, method, target, body string) (int, jsonResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var res jsonResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("%s %s: decoding %q: %v", method, target, rec.Body.String(), err)
+	}
+
+	return rec.Code, res
+}
+
+func TestUserHandlers(t *testing.T) {
+	admin := data.User{ID: 1, Email: "admin@example.com", Active: 1}
+	users := newFakeUsers(admin, data.User{ID: 2, Email: "alice@example.com", Active: 1})
+	h := newUsersTestApp(users, &admin)
+
+	code, res := serve(t, h, http.MethodGet, "/users/2", "")
+	if code != http.StatusOK || res.Error {
+		t.Fatalf("GET /users/2 = %d %+v", code, res)
+	}
+
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{http.MethodGet, "/users/3", "", http.StatusNotFound},
+		{http.MethodGet, "/users/abc", "", http.StatusBadRequest},
+		{http.MethodGet, "/users?page_size=1000", "", http.StatusBadRequest},
+		{http.MethodPost, "/users", `{"email": "alice@example.com", "password": "correct horse 42"}`, http.StatusConflict},
+		{http.MethodPost, "/users", `{"email": "Alice@Example.com", "password": "correct horse 42"}`, http.StatusConflict},
+		{http.MethodPost, "/users", `{"email": "bob@example.com", "password": "short1"}`, http.StatusBadRequest},
+		{http.MethodPost, "/users", `{"email": "not an email", "password": "correct horse 42"}`, http.StatusBadRequest},
+		{http.MethodPut, "/users/2", `{"password": "correct horse 42"}`, http.StatusBadRequest},
+		{http.MethodPut, "/users/2", `{"email": "admin@example.com"}`, http.StatusConflict},
+		{http.MethodPut, "/users/2", `{"email": "ADMIN@example.com"}`, http.StatusConflict},
+		{http.MethodPut, "/users/1", `{"active": false}`, http.StatusConflict},
+		{http.MethodDelete, "/users/1", "", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		code, res := serve(t, h, tt.method, tt.target, tt.body)
+		if code != tt.want || !res.Error {
+			t.Errorf("%s %s = %d %+v, want %d", tt.method, tt.target, code, res, tt.want)
+		}
+	}
+
+	if len(users.users) != 2 || users.users[1].Active != 1 || len(users.events) != 0 {
+		t.Errorf("expected failed requests to leave the users alone, got %+v", users.sorted())
+	}
+
+	code, res = serve(t, h, http.MethodGet, "/users?q=alice", "")
+	if code != http.StatusOK {
+		t.Fatalf("GET /users = %d %+v", code, res)
+	}
+	page := res.Data.(map[string]any)
+	if page["total"] != float64(1) {
+		t.Errorf("expected 1 user matching alice, got %v", page["total"])
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/data"
+)
+
+func TestValidateEmail(t *testing.T) {
+	valid := []string{"admin@example.com", "first.last+tag@sub.example.org"}
+	for _, email := range valid {
+		if err := validateEmail(email); err != nil {
+			t.Errorf("expected %q to be valid, got %v", email, err)
+		}
+	}
+
+	invalid := []string{"", "admin", "admin@", "admin@localhost", "Admin <admin@example.com>", " admin@example.com"}
+	for _, email := range invalid {
+		if err := validateEmail(email); err == nil {
+			t.Errorf("expected %q to be rejected", email)
+		}
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	valid := []string{"*", "zerto:delete", "zerto:*", "*:read", "api_keys:manage"}
+	if err := validatePermissions(valid); err != nil {
+		t.Errorf("expected %v to be valid, got %v", valid, err)
+	}
+
+	for _, permission := range []string{"", "zerto", "Zerto:delete", "zerto:delete:now", "zerto:", ":read"} {
+		if err := validatePermissions([]string{permission}); err == nil {
+			t.Errorf("expected %q to be rejected", permission)
+		}
+	}
+}
+
+func TestValidateOrganization(t *testing.T) {
+	valid := data.Organization{Name: "Acme", Status: data.OrgActive, ExternalIDs: data.ExternalIDs{VCD: "acme"}}
+	if err := validateOrganization(&valid); err != nil {
+		t.Errorf("expected %+v to be valid, got %v", valid, err)
+	}
+
+	invalid := map[string]data.Organization{
+		"no name":        {Status: data.OrgActive},
+		"long name":      {Name: strings.Repeat("a", maxOrgNameLength+1), Status: data.OrgActive},
+		"unknown status": {Name: "Acme", Status: "deleted"},
+		"long vendor id": {Name: "Acme", Status: data.OrgActive, ExternalIDs: data.ExternalIDs{Zerto: strings.Repeat("z", maxExternalIDLength+1)}},
+	}
+	for name, org := range invalid {
+		if err := validateOrganization(&org); err == nil {
+			t.Errorf("%s: expected %+v to be rejected", name, org)
+		}
+	}
+}
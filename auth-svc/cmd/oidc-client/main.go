@@ -0,0 +1,346 @@
+// Command oidc-client is a small relying party for trying out auth-svc's OpenID
+// Connect provider end to end. It runs the authorization code flow with PKCE against
+// a registered client, verifies the ID token against the provider's JWKS and prints
+// the ID token claims and the userinfo response.
+//
+//	go run ./cmd/oidc-client -issuer http://localhost:8081 -client-id <id> -client-secret <secret>
+//
+// The client must have http://localhost:8085/callback (or the -listen address) as a
+// redirect URI.
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudkey-io/service-hub/auth-svc/token"
+)
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+}
+
+type config struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	listen       string
+	scope        string
+}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.issuer, "issuer", "http://localhost:8081", "OpenID Connect issuer")
+	flag.StringVar(&cfg.clientID, "client-id", "", "client ID")
+	flag.StringVar(&cfg.clientSecret, "client-secret", "", "client secret, empty for a public client")
+	flag.StringVar(&cfg.listen, "listen", "localhost:8085", "address to receive the callback on")
+	flag.StringVar(&cfg.scope, "scope", "openid email profile", "scopes to ask for")
+	flag.Parse()
+
+	if cfg.clientID == "" {
+		log.Fatal("-client-id is required")
+	}
+
+	provider, err := discover(cfg.issuer)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	verifier := randomString(32)
+	state := randomString(16)
+	nonce := randomString(16)
+	redirectURI := "http://" + cfg.listen + "/callback"
+
+	sum := sha256.Sum256([]byte(verifier))
+	authorize := provider.AuthorizationEndpoint + "?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cfg.clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {cfg.scope},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+
+	fmt.Println("Open this URL in a browser to sign in:")
+	fmt.Println()
+	fmt.Println(authorize)
+	fmt.Println()
+
+	done := make(chan error, 1)
+	srv := &http.Server{Addr: cfg.listen}
+
+	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		err := callback(cfg, provider, r, redirectURI, verifier, state, nonce)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Signed in, see the terminal for the results.")
+		}
+
+		done <- err
+	})
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			done <- err
+		}
+	}()
+
+	err = <-done
+	_ = srv.Shutdown(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// callback exchanges the code it was sent, checks the ID token and fetches userinfo.
+func callback(cfg config, provider *discovery, r *http.Request, redirectURI, verifier, state, nonce string) error {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return fmt.Errorf("authorization failed: %s: %s", e, q.Get("error_description"))
+	}
+	if q.Get("state") != state {
+		return errors.New("state does not match")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	if cfg.clientSecret == "" {
+		form.Set("client_id", cfg.clientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cfg.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(cfg.clientID), url.QueryEscape(cfg.clientSecret))
+	}
+
+	var tokens tokenResponse
+	err = do(req, &tokens)
+	if err != nil {
+		return fmt.Errorf("exchanging code: %w", err)
+	}
+
+	claims, err := verifyIDToken(provider, tokens.IDToken, cfg.clientID, nonce)
+	if err != nil {
+		return fmt.Errorf("verifying ID token: %w", err)
+	}
+
+	printJSON("ID token claims", claims)
+
+	req, err = http.NewRequest(http.MethodGet, provider.UserinfoEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+
+	var userinfo map[string]any
+	err = do(req, &userinfo)
+	if err != nil {
+		return fmt.Errorf("fetching userinfo: %w", err)
+	}
+
+	printJSON("userinfo", userinfo)
+
+	if userinfo["sub"] != claims.Subject {
+		return errors.New("userinfo sub does not match the ID token")
+	}
+
+	return nil
+}
+
+func discover(issuer string) (*discovery, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	req, err := http.NewRequest(http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var provider discovery
+	err = do(req, &provider)
+	if err != nil {
+		return nil, fmt.Errorf("fetching discovery document: %w", err)
+	}
+
+	if provider.Issuer != issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", provider.Issuer, issuer)
+	}
+
+	return &provider, nil
+}
+
+// verifyIDToken checks the ID token's signature against the provider's JWKS and its
+// issuer, audience, nonce and expiry, as a relying party must.
+func verifyIDToken(provider *discovery, idToken, clientID, nonce string) (*token.IDClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unexpected algorithm %q", header.Algorithm)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, provider.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks token.JWKS
+	err = do(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	var pub *rsa.PublicKey
+	for _, key := range jwks.Keys {
+		if key.KeyID == header.KeyID {
+			pub, err = publicKey(key)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if pub == nil {
+		return nil, fmt.Errorf("no key %q in the JWKS", header.KeyID)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return nil, errors.New("bad signature")
+	}
+
+	var claims token.IDClaims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.Issuer != provider.Issuer:
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case claims.Audience != clientID:
+		return nil, fmt.Errorf("unexpected audience %q", claims.Audience)
+	case claims.Nonce != nonce:
+		return nil, errors.New("nonce does not match")
+	case time.Now().Unix() >= claims.ExpiresAt:
+		return nil, errors.New("token has expired")
+	}
+
+	return &claims, nil
+}
+
+func publicKey(key token.JWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// do sends req and decodes a JSON response into v, returning the body as the error
+// for anything but a 200.
+func do(req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func printJSON(title string, v any) {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Printf("%s:\n%s\n\n", title, b)
+}
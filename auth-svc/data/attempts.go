@@ -0,0 +1,166 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LoginAttempt is one password check made by Authenticate, kept so repeated failures
+// can be throttled per account and per source IP.
+type LoginAttempt struct {
+	ID        int
+	Email     string
+	IP        string
+	Success   bool
+	CreatedAt time.Time
+}
+
+// FailureCount is the number of failed logins for one account or IP, and when the last
+// of them happened.
+type FailureCount struct {
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"`
+	Last     time.Time `json:"last_failure"`
+}
+
+// LoginAttemptModel stores login attempts.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a login attempt.
+func (m LoginAttemptModel) Insert(ctx context.Context, attempt LoginAttempt, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into login_attempts (email, ip, success, created_at) values ($1, $2, $3, $4)`
+
+	return withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, stmt, attempt.Email, attempt.IP, attempt.Success, time.Now())
+		return err
+	})
+}
+
+// FailuresByEmail counts the failed logins for an account since the given time. A
+// successful login starts the count over.
+func (m LoginAttemptModel) FailuresByEmail(ctx context.Context, email string, since time.Time) (FailureCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select count(*), max(created_at) from login_attempts
+	where email = $1 and not success and created_at > $2
+	and created_at > coalesce((select max(created_at) from login_attempts where email = $1 and success), '-infinity')`
+
+	return scanFailures(m.DB.QueryRowContext(ctx, query, email, since), email)
+}
+
+// FailuresByIP counts the failed logins from a source IP since the given time, across
+// every account.
+func (m LoginAttemptModel) FailuresByIP(ctx context.Context, ip string, since time.Time) (FailureCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select count(*), max(created_at) from login_attempts
+	where ip = $1 and not success and created_at > $2`
+
+	return scanFailures(m.DB.QueryRowContext(ctx, query, ip, since), ip)
+}
+
+func scanFailures(row *sql.Row, key string) (FailureCount, error) {
+	count := FailureCount{Key: key}
+
+	var last sql.NullTime
+
+	err := row.Scan(&count.Failures, &last)
+	if err != nil {
+		return count, err
+	}
+	count.Last = last.Time
+
+	return count, nil
+}
+
+// EmailsOverLimit returns the accounts with at least limit failed logins since the
+// given time, not counting failures before their last successful login.
+func (m LoginAttemptModel) EmailsOverLimit(ctx context.Context, since time.Time, limit int) ([]FailureCount, error) {
+	query := `select email, count(*), max(created_at) from login_attempts f
+	where not success and created_at > $1
+	and created_at > coalesce((select max(created_at) from login_attempts s where s.email = f.email and s.success), '-infinity')
+	group by email having count(*) >= $2 order by max(created_at) desc`
+
+	return m.queryFailures(ctx, query, since, limit)
+}
+
+// IPsOverLimit returns the source IPs with at least limit failed logins since the given
+// time.
+func (m LoginAttemptModel) IPsOverLimit(ctx context.Context, since time.Time, limit int) ([]FailureCount, error) {
+	query := `select ip, count(*), max(created_at) from login_attempts
+	where not success and created_at > $1
+	group by ip having count(*) >= $2 order by max(created_at) desc`
+
+	return m.queryFailures(ctx, query, since, limit)
+}
+
+func (m LoginAttemptModel) queryFailures(ctx context.Context, query string, since time.Time, limit int) ([]FailureCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []FailureCount{}
+
+	for rows.Next() {
+		var count FailureCount
+
+		err := rows.Scan(&count.Key, &count.Failures, &count.Last)
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// ClearEmail forgets the failed logins for an account, which unlocks it.
+func (m LoginAttemptModel) ClearEmail(ctx context.Context, email string) error {
+	return m.clearFailures(ctx, `delete from login_attempts where email = $1 and not success`, email)
+}
+
+// ClearIP forgets the failed logins from a source IP, which unlocks it.
+func (m LoginAttemptModel) ClearIP(ctx context.Context, ip string) error {
+	return m.clearFailures(ctx, `delete from login_attempts where ip = $1 and not success`, ip)
+}
+
+func (m LoginAttemptModel) clearFailures(ctx context.Context, stmt, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteOlderThan removes attempts made before the given time.
+func (m LoginAttemptModel) DeleteOlderThan(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from login_attempts where created_at < $1`
+
+	_, err := m.DB.ExecContext(ctx, stmt, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
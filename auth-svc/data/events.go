@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Auth event types. They are published with the routing key "auth.<type>".
+const (
+	EventLoginSucceeded  = "login.succeeded"
+	EventLoginFailed     = "login.failed"
+	EventLockout         = "lockout"
+	EventPasswordChanged = "password.changed"
+	EventTokenRevoked    = "token.revoked"
+	EventSessionRevoked  = "session.revoked"
+	EventUserCreated     = "user.created"
+	EventUserDeleted     = "user.deleted"
+)
+
+// Event is something that happened to an account, such as a login or a password
+// change. Events are written to the auth_events outbox table in the same transaction
+// as the change they describe, then published to RabbitMQ, so one is never recorded
+// without the change or lost when RabbitMQ is down.
+type Event struct {
+	ID     int64  `json:"id"`
+	Type   string `json:"type"`
+	UserID int    `json:"user_id,omitempty"`
+	Email  string `json:"email,omitempty"`
+	IP     string `json:"ip,omitempty"`
+	// Actor is the admin who made the change, if it wasn't the user.
+	Actor  string `json:"actor,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// SessionID is the session a session.revoked event is about.
+	SessionID  string    `json:"session_id,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertEvents writes events to the outbox with ex, which is normally the transaction
+// making the change the events describe.
+func insertEvents(ctx context.Context, ex execer, events []Event) error {
+	stmt := `insert into auth_events (type, user_id, email, ip, actor, detail, session_id, occurred_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	for _, e := range events {
+		var userID sql.NullInt64
+		if e.UserID != 0 {
+			userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+		}
+
+		occurredAt := e.OccurredAt
+		if occurredAt.IsZero() {
+			occurredAt = time.Now()
+		}
+
+		_, err := ex.ExecContext(ctx, stmt, e.Type, userID, e.Email, e.IP, e.Actor, e.Detail, e.SessionID, occurredAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withEvents runs fn in a transaction and writes events in the same transaction, so
+// the events are stored if and only if the change is. fn may fill in the events,
+// e.g. with the ID of a row it inserted.
+func withEvents(ctx context.Context, conn *sql.DB, events []Event, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	err = insertEvents(ctx, tx, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EventModel stores the auth events waiting in the outbox.
+type EventModel struct {
+	DB *sql.DB
+}
+
+// Insert records events that don't go with any other change, such as a lockout.
+func (m EventModel) Insert(ctx context.Context, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return withEvents(ctx, m.DB, events, func(tx *sql.Tx) error { return nil })
+}
+
+// PublishPending passes up to limit unpublished events, oldest first, to publish and
+// marks them published if it returns nil. The events stay locked until then, so
+// replicas relaying at the same time skip them instead of publishing them twice. It
+// returns the number of events published.
+func (m EventModel) PublishPending(ctx context.Context, limit int, publish func([]*Event) error) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `select id, type, coalesce(user_id, 0), email, ip, actor, detail, session_id, occurred_at
+		from auth_events
+		where published_at is null
+		order by id
+		limit $1
+		for update skip locked`
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.UserID,
+			&event.Email,
+			&event.IP,
+			&event.Actor,
+			&event.Detail,
+			&event.SessionID,
+			&event.OccurredAt,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		events = append(events, &event)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	err = publish(events)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+
+	_, err = tx.ExecContext(ctx, `update auth_events set published_at = $1 where id = any($2)`, time.Now(), ids)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(events), tx.Commit()
+}
+
+// DeletePublished removes events published before the given time.
+func (m EventModel) DeletePublished(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from auth_events where published_at < $1`, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrChallengeCompleted is returned when a login that is waiting on a second factor has
+// already been completed, or was denied.
+var ErrChallengeCompleted = errors.New("mfa challenge has already been completed")
+
+// MFAChallenge is a login that passed the password check and is waiting for the user
+// to approve a Duo push. The client polls it with the challenge token, of which only a
+// hash is stored.
+type MFAChallenge struct {
+	ID            int
+	UserID        int
+	ChallengeHash string
+	Txid          string
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+	CompletedAt   sql.NullTime
+}
+
+// MFAChallengeModel stores MFA challenges.
+type MFAChallengeModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a new challenge.
+func (m MFAChallengeModel) Insert(ctx context.Context, challenge MFAChallenge) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into mfa_challenges (user_id, challenge_hash, txid, expires_at, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		challenge.UserID,
+		challenge.ChallengeHash,
+		challenge.Txid,
+		challenge.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetByHash returns one challenge by the hash of its token.
+func (m MFAChallengeModel) GetByHash(ctx context.Context, hash string) (*MFAChallenge, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, challenge_hash, txid, expires_at, created_at, completed_at
+	from mfa_challenges where challenge_hash = $1`
+
+	var challenge MFAChallenge
+	row := m.DB.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&challenge.ID,
+		&challenge.UserID,
+		&challenge.ChallengeHash,
+		&challenge.Txid,
+		&challenge.ExpiresAt,
+		&challenge.CreatedAt,
+		&challenge.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// Complete marks challenge as finished, so it can only ever log the
+// user in once. Returns ErrChallengeCompleted if it was already finished.
+func (m MFAChallengeModel) Complete(ctx context.Context, challenge *MFAChallenge) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update mfa_challenges set completed_at = $1 where id = $2 and completed_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), challenge.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrChallengeCompleted
+	}
+
+	return nil
+}
+
+// DeleteExpired removes challenges that expired before the given time.
+func (m MFAChallengeModel) DeleteExpired(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from mfa_challenges where expires_at < $1`
+
+	_, err := m.DB.ExecContext(ctx, stmt, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_create_roles.up.sql":   {Data: []byte("create table roles ();")},
+		"0002_create_roles.down.sql": {Data: []byte("drop table roles;")},
+		"0001_create_users.up.sql":   {Data: []byte("create table users ();")},
+		"0001_create_users.down.sql": {Data: []byte("drop table users;")},
+	}
+
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 || migrations[0].Name != "create_users" || migrations[1].Version != 2 {
+		t.Fatalf("expected users then roles, got %+v", migrations)
+	}
+	if migrations[1].Down != "drop table roles;" {
+		t.Errorf("expected the down migration to be loaded, got %q", migrations[1].Down)
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"bad name": {
+			"create_users.up.sql": {Data: []byte("")},
+		},
+		"missing down": {
+			"0001_create_users.up.sql": {Data: []byte("create table users ();")},
+		},
+		"gap": {
+			"0001_create_users.up.sql":   {Data: []byte("create table users ();")},
+			"0001_create_users.down.sql": {Data: []byte("drop table users;")},
+			"0003_create_roles.up.sql":   {Data: []byte("create table roles ();")},
+			"0003_create_roles.down.sql": {Data: []byte("drop table roles;")},
+		},
+		"mismatched names": {
+			"0001_create_users.up.sql":    {Data: []byte("create table users ();")},
+			"0001_create_people.down.sql": {Data: []byte("drop table users;")},
+		},
+	}
+
+	for name, fsys := range tests {
+		_, err := loadMigrations(fsys)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// The embedded migrations have to load, and every up has to have a down, or the
+// service won't start.
+func TestEmbeddedMigrations(t *testing.T) {
+	sub, err := fs.Sub(migrationFS, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := loadMigrations(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrations[0].Name != "create_users" || !strings.Contains(migrations[0].Up, "create table if not exists users") {
+		t.Errorf("expected the first migration to create the users table, got %s", migrations[0].Name)
+	}
+}
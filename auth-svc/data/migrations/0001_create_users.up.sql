@@ -0,0 +1,14 @@
+-- The users table predates migrations, so it may already exist. user_active is an
+-- integer flag (1 active, 0 inactive) and maps to User.Active.
+create table if not exists users (
+	id serial primary key,
+	email varchar(255) not null unique,
+	first_name varchar(255) not null default '',
+	last_name varchar(255) not null default '',
+	password varchar(60) not null,
+	user_active integer not null default 0,
+	created_at timestamp not null default now(),
+	updated_at timestamp not null default now()
+);
+
+comment on column users.user_active is '1 when the user may log in, 0 when deactivated';
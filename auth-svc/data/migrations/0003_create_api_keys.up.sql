@@ -0,0 +1,12 @@
+create table if not exists api_keys (
+	id serial primary key,
+	name text not null,
+	prefix text not null,
+	key_hash text not null unique,
+	scopes text not null default '',
+	expires_at timestamp,
+	last_used_at timestamp,
+	created_at timestamp not null,
+	updated_at timestamp not null,
+	revoked_at timestamp
+);
@@ -0,0 +1,50 @@
+create table if not exists roles (
+	id serial primary key,
+	name text not null unique,
+	description text not null default '',
+	builtin boolean not null default false,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create table if not exists role_permissions (
+	role_id integer not null references roles (id) on delete cascade,
+	permission text not null,
+	primary key (role_id, permission)
+);
+
+create table if not exists user_roles (
+	user_id integer not null references users (id) on delete cascade,
+	role_id integer not null references roles (id) on delete cascade,
+	primary key (user_id, role_id)
+);
+
+-- The built in roles. Roles that already exist keep their permissions, since they may
+-- have been changed.
+with inserted as (
+	insert into roles (name, description, builtin, created_at, updated_at) values
+		('admin', 'Full access to every service', true, now(), now()),
+		('provisioner', 'Creates, changes and removes customer resources', true, now(), now()),
+		('support', 'Reads everything and updates existing customer resources', true, now(), now()),
+		('read-only', 'Reads everything', true, now(), now())
+	on conflict (name) do nothing
+	returning id, name
+)
+insert into role_permissions (role_id, permission)
+select inserted.id, p.permission
+from inserted
+join (values
+	('admin', '*'),
+	('provisioner', 'sso:*'),
+	('provisioner', 'veeam:*'),
+	('provisioner', 'vcd:*'),
+	('provisioner', 'zerto:*'),
+	('provisioner', 'logs:write'),
+	('support', '*:read'),
+	('support', 'sso:update'),
+	('support', 'veeam:update'),
+	('support', 'vcd:update'),
+	('support', 'zerto:update'),
+	('support', 'logs:write'),
+	('read-only', '*:read')
+) as p (role, permission) on p.role = inserted.name;
@@ -0,0 +1,2 @@
+drop table if exists mfa_challenges;
+alter table users drop column if exists mfa_enabled;
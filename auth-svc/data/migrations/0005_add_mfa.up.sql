@@ -0,0 +1,11 @@
+alter table users add column if not exists mfa_enabled boolean not null default false;
+
+create table if not exists mfa_challenges (
+	id serial primary key,
+	user_id integer not null references users (id) on delete cascade,
+	challenge_hash text not null unique,
+	txid text not null,
+	expires_at timestamp not null,
+	created_at timestamp not null,
+	completed_at timestamp
+);
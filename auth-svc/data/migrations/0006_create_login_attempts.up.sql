@@ -0,0 +1,10 @@
+create table if not exists login_attempts (
+	id serial primary key,
+	email text not null,
+	ip text not null,
+	success boolean not null,
+	created_at timestamp not null
+);
+
+create index if not exists login_attempts_email_idx on login_attempts (email, created_at);
+create index if not exists login_attempts_ip_idx on login_attempts (ip, created_at);
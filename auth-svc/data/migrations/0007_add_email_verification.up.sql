@@ -0,0 +1,11 @@
+alter table users add column if not exists email_verified_at timestamp;
+
+create table if not exists user_tokens (
+	id serial primary key,
+	user_id integer not null references users (id) on delete cascade,
+	purpose text not null,
+	token_hash text not null unique,
+	expires_at timestamp not null,
+	created_at timestamp not null,
+	used_at timestamp
+);
@@ -0,0 +1,2 @@
+-- Fails while any argon2id hashes are stored, since they don't fit.
+alter table users alter column password type varchar(60);
@@ -0,0 +1,2 @@
+-- argon2id hashes in PHC format are longer than the 60 characters bcrypt needed.
+alter table users alter column password type text;
@@ -0,0 +1,2 @@
+drop table if exists organization_members;
+drop table if exists organizations;
@@ -0,0 +1,29 @@
+create table if not exists organizations (
+	id serial primary key,
+	name text not null,
+	crm_id text not null default '',
+	status text not null default 'active',
+	vcd_org_id text not null default '',
+	veeam_org_id text not null default '',
+	zerto_org_id text not null default '',
+	duo_account_id text not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+-- Empty means not linked yet, otherwise each CRM and vendor ID belongs to one
+-- organization.
+create unique index if not exists organizations_crm_id_idx on organizations (crm_id) where crm_id <> '';
+create unique index if not exists organizations_vcd_org_id_idx on organizations (vcd_org_id) where vcd_org_id <> '';
+create unique index if not exists organizations_veeam_org_id_idx on organizations (veeam_org_id) where veeam_org_id <> '';
+create unique index if not exists organizations_zerto_org_id_idx on organizations (zerto_org_id) where zerto_org_id <> '';
+create unique index if not exists organizations_duo_account_id_idx on organizations (duo_account_id) where duo_account_id <> '';
+
+create table if not exists organization_members (
+	organization_id integer not null references organizations (id) on delete cascade,
+	user_id integer not null references users (id) on delete cascade,
+	created_at timestamp not null,
+	primary key (organization_id, user_id)
+);
+
+create index if not exists organization_members_user_id_idx on organization_members (user_id);
@@ -0,0 +1,15 @@
+-- The outbox for auth events. Rows are written in the same transaction as the change
+-- they describe, and published to RabbitMQ afterwards.
+create table if not exists auth_events (
+	id bigserial primary key,
+	type text not null,
+	user_id integer,
+	email text not null default '',
+	ip text not null default '',
+	actor text not null default '',
+	detail text not null default '',
+	occurred_at timestamp not null,
+	published_at timestamp
+);
+
+create index if not exists auth_events_unpublished_idx on auth_events (id) where published_at is null;
@@ -0,0 +1,24 @@
+-- A session is one login, i.e. one refresh token family, identified by the sid claim
+-- of its access tokens. There is no foreign key to users: a deleted user's sessions
+-- stay revoked, and listed as such, until their access tokens have expired.
+create table if not exists sessions (
+	id text primary key,
+	user_id integer not null,
+	user_agent text not null default '',
+	ip text not null default '',
+	created_at timestamp not null,
+	last_seen_at timestamp not null,
+	expires_at timestamp not null,
+	revoked_at timestamp
+);
+
+create index if not exists sessions_user_id_idx on sessions (user_id);
+create index if not exists sessions_revoked_at_idx on sessions (revoked_at) where revoked_at is not null;
+
+-- Sessions started before this migration, so they can be listed and revoked too.
+insert into sessions (id, user_id, created_at, last_seen_at, expires_at)
+select family_id, min(user_id), min(created_at), max(created_at), max(expires_at)
+from refresh_tokens
+group by family_id
+having bool_or(revoked_at is null and expires_at > now())
+on conflict (id) do nothing;
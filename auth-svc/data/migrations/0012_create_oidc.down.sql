@@ -0,0 +1,2 @@
+drop table if exists oidc_codes;
+drop table if exists oidc_clients;
@@ -0,0 +1,29 @@
+-- OpenID Connect clients, such as the support portal, that users sign in to through
+-- auth-svc. Public clients, which can't keep a secret, have an empty secret_hash and
+-- rely on PKCE alone. redirect_uris is space separated.
+create table if not exists oidc_clients (
+	id serial primary key,
+	client_id text not null unique,
+	name text not null,
+	secret_hash text not null default '',
+	redirect_uris text not null,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+-- Authorization codes, which a client exchanges for tokens once.
+create table if not exists oidc_codes (
+	id serial primary key,
+	code_hash text not null unique,
+	client_id text not null references oidc_clients (client_id) on delete cascade,
+	user_id integer not null references users (id) on delete cascade,
+	redirect_uri text not null,
+	scope text not null,
+	nonce text not null default '',
+	code_challenge text not null,
+	user_agent text not null default '',
+	ip text not null default '',
+	auth_time timestamp not null,
+	expires_at timestamp not null,
+	used_at timestamp
+);
@@ -0,0 +1,3 @@
+-- Emails are matched case insensitively, so two users can't differ only by the case
+-- of their email. This fails if such users already exist; merge or rename them first.
+create unique index if not exists users_email_lower_idx on users (lower(email));
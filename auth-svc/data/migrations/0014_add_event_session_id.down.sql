@@ -0,0 +1 @@
+alter table auth_events drop column if exists session_id;
@@ -0,0 +1,3 @@
+-- The session a session.revoked event is about, so services checking access tokens
+-- can reject the session's tokens as soon as the event reaches them.
+alter table auth_events add column if not exists session_id text not null default '';
@@ -0,0 +1,10 @@
+delete from role_permissions
+using roles
+where role_permissions.role_id = roles.id
+	and roles.builtin
+	and (roles.name, role_permissions.permission) in (
+		('provisioner', 'tenants:*'),
+		('provisioner', 'inventory:read'),
+		('support', 'inventory:read'),
+		('read-only', 'inventory:read')
+	);
@@ -0,0 +1,14 @@
+-- Tenant onboarding and the inventory of hostbill-svc. The provisioner orders tenants
+-- and reads what was provisioned for them; support and read-only read the inventory,
+-- which '*:read' gave them only as long as it hasn't been taken away.
+insert into role_permissions (role_id, permission)
+select roles.id, p.permission
+from roles
+join (values
+	('provisioner', 'tenants:*'),
+	('provisioner', 'inventory:read'),
+	('support', 'inventory:read'),
+	('read-only', 'inventory:read')
+) as p (role, permission) on p.role = roles.name
+where roles.builtin
+on conflict do nothing;
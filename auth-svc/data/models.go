@@ -3,24 +3,45 @@ package data
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"log"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/cloudkey-io/service-hub/auth-svc/password"
 )
 
 const dbTimeout = time.Second * 3
 
-var db *sql.DB
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// hashParams are the argon2id parameters new password hashes are made with.
+var hashParams = password.DefaultParams
+
+// SetHashParams changes the parameters new password hashes are made with. Existing
+// hashes are upgraded by RehashPassword as users log in.
+func SetHashParams(p password.Params) {
+	hashParams = p
+}
 
 // New is the function used to create an instance of the data package. It returns the type
 // Model, which embeds all the types we want to be available to our application.
 func New(dbPool *sql.DB) Models {
-	db = dbPool
-
 	return Models{
-		User: User{},
+		User:         UserModel{DB: dbPool},
+		RefreshToken: RefreshTokenModel{DB: dbPool},
+		APIKey:       APIKeyModel{DB: dbPool},
+		Role:         RoleModel{DB: dbPool},
+		MFAChallenge: MFAChallengeModel{DB: dbPool},
+		LoginAttempt: LoginAttemptModel{DB: dbPool},
+		UserToken:    UserTokenModel{DB: dbPool},
+		Organization: OrganizationModel{DB: dbPool},
+		Event:        EventModel{DB: dbPool},
+		Session:      SessionModel{DB: dbPool},
+		OIDCClient:   OIDCClientModel{DB: dbPool},
+		AuthCode:     AuthCodeModel{DB: dbPool},
 	}
 }
 
@@ -28,30 +49,96 @@ func New(dbPool *sql.DB) Models {
 // in this type is available to us throughout the application, anywhere that the
 // app variable is used, provided that the model is also added in the New function.
 type Models struct {
-	User User
+	User         UserRepository
+	RefreshToken RefreshTokenModel
+	APIKey       APIKeyModel
+	Role         RoleModel
+	MFAChallenge MFAChallengeModel
+	LoginAttempt LoginAttemptModel
+	UserToken    UserTokenModel
+	Organization OrganizationModel
+	Event        EventModel
+	Session      SessionModel
+	OIDCClient   OIDCClientModel
+	AuthCode     AuthCodeModel
 }
 
 // User is the structure which holds one user from the database.
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name,omitempty"`
-	LastName  string    `json:"last_name,omitempty"`
-	Password  string    `json:"-"`
-	Active    int       `json:"active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int    `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Password  string `json:"-"`
+	Active    int    `json:"active"`
+	// MFAEnabled requires a Duo second factor on login.
+	MFAEnabled bool `json:"mfa_enabled"`
+	// EmailVerifiedAt is nil until the user follows the link in their verification email.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// UserRepository stores users and their roles and organizations. UserModel keeps
+// them in Postgres; handler tests swap in a fake.
+type UserRepository interface {
+	GetAll(ctx context.Context) ([]*User, error)
+	List(ctx context.Context, q UserQuery) ([]*User, int, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetOne(ctx context.Context, id int) (*User, error)
+	Insert(ctx context.Context, user User, events ...Event) (int, error)
+	Update(ctx context.Context, user *User) error
+	Delete(ctx context.Context, id int, events ...Event) error
+	ResetPassword(ctx context.Context, user *User, plainText string, events ...Event) error
+	RehashPassword(ctx context.Context, user *User, plainText string) (bool, error)
+	VerifyEmail(ctx context.Context, user *User) error
+	Roles(ctx context.Context, userID int) ([]string, error)
+	Permissions(ctx context.Context, userID int) ([]string, error)
+	AssignRole(ctx context.Context, userID, roleID int) error
+	RemoveRole(ctx context.Context, userID, roleID int) error
+	Organizations(ctx context.Context, userID int, activeOnly bool) ([]*Organization, error)
+}
+
+// UserModel is the Postgres UserRepository. Every query is bound by the caller's
+// context, so a cancelled request stops its queries, and by dbTimeout.
+type UserModel struct {
+	DB *sql.DB
+}
+
+const userColumns = `id, email, first_name, last_name, password, user_active, mfa_enabled, email_verified_at, created_at, updated_at`
+
+func scanUser(row scanner, extra ...any) (*User, error) {
+	var user User
+
+	dest := []any{
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Active,
+		&user.MFAEnabled,
+		&user.EmailVerifiedAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
 }
 
 // GetAll returns a slice of all users, sorted by last name
-func (u *User) GetAll() ([]*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m UserModel) GetAll(ctx context.Context) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at
-	from users order by last_name`
+	query := `select ` + userColumns + ` from users order by last_name`
 
-	rows, err := db.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -60,86 +147,98 @@ func (u *User) GetAll() ([]*User, error) {
 	var users []*User
 
 	for rows.Next() {
-		var user User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Password,
-			&user.Active,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			log.Println("Error scanning", err)
 			return nil, err
 		}
 
-		users = append(users, &user)
+		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
+}
+
+// UserQuery describes one page of a user listing. Search matches email, first name and
+// last name case insensitively.
+type UserQuery struct {
+	Search   string
+	Page     int
+	PageSize int
 }
 
-// GetByEmail returns one user by email
-func (u *User) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// List returns one page of users matching the query, sorted by last name, along with
+// the total number of matching users.
+func (m UserModel) List(ctx context.Context, q UserQuery) ([]*User, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select ` + userColumns + `, count(*) over()
+	from users
+	where $1 = '' or email ilike $1 or first_name ilike $1 or last_name ilike $1
+	order by last_name, id
+	limit $2 offset $3`
 
-	var user User
-	row := db.QueryRowContext(ctx, query, email)
+	search := ""
+	if q.Search != "" {
+		search = "%" + escapeLike(q.Search) + "%"
+	}
 
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.Active,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	rows, err := m.DB.QueryContext(ctx, query, search, q.PageSize, (q.Page-1)*q.PageSize)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer rows.Close()
 
-	return &user, nil
+	users := []*User{}
+	total := 0
+
+	for rows.Next() {
+		user, err := scanUser(rows, &total)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, 0, err
+		}
+
+		users = append(users, user)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
 }
 
-// GetOne returns one user by id
-func (u *User) GetOne(id int) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// escapeLike escapes the characters that have a special meaning in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// GetByEmail returns one user by email, compared case insensitively.
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select ` + userColumns + ` from users where lower(email) = lower($1)`
 
-	var user User
-	row := db.QueryRowContext(ctx, query, id)
+	return scanUser(m.DB.QueryRowContext(ctx, query, email))
+}
 
-	err := row.Scan(
-		&user.ID,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Password,
-		&user.Active,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
+// GetOne returns one user by id
+func (m UserModel) GetOne(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
 
-	return &user, nil
+	query := `select ` + userColumns + ` from users where id = $1`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, id))
 }
 
-// Update updates one user in the database, using the information
-// stored in the receiver u
-func (u *User) Update() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Update saves everything but the password of user.
+func (m UserModel) Update(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `update users set
@@ -147,17 +246,21 @@ func (u *User) Update() error {
 		first_name = $2,
 		last_name = $3,
 		user_active = $4,
-		updated_at = $5
-		where id = $6
+		mfa_enabled = $5,
+		email_verified_at = $6,
+		updated_at = $7
+		where id = $8
 	`
 
-	_, err := db.ExecContext(ctx, stmt,
-		u.Email,
-		u.FirstName,
-		u.LastName,
-		u.Active,
+	_, err := m.DB.ExecContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Active,
+		user.MFAEnabled,
+		user.EmailVerifiedAt,
 		time.Now(),
-		u.ID,
+		user.ID,
 	)
 	if err != nil {
 		return err
@@ -166,59 +269,63 @@ func (u *User) Update() error {
 	return nil
 }
 
-// Delete deletes one user from the database, by User.ID
-func (u *User) Delete() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Delete deletes one user from the database, by ID, and revokes their sessions.
+func (m UserModel) Delete(ctx context.Context, id int, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `delete from users where id = $1`
 
-	_, err := db.ExecContext(ctx, stmt, u.ID)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// DeleteByID deletes one user from the database, by ID
-func (u *User) DeleteByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	stmt := `delete from users where id = $1`
+	return withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		// Sessions outlive the user, so services keep rejecting its access tokens.
+		err := revokeUserSessions(ctx, tx, id)
+		if err != nil {
+			return err
+		}
 
-	_, err := db.ExecContext(ctx, stmt, id)
-	if err != nil {
+		_, err = tx.ExecContext(ctx, stmt, id)
 		return err
-	}
-
-	return nil
+	})
 }
 
-// Insert inserts a new user into the database, and returns the ID of the newly inserted row
-func (u *User) Insert(user User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// Insert inserts a new user into the database, and returns the ID of the newly inserted
+// row. Events without a user ID get the new user's.
+func (m UserModel) Insert(ctx context.Context, user User, events ...Event) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := password.Hash(user.Password, hashParams)
 	if err != nil {
 		return 0, err
 	}
 
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, user_active, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, user_active, mfa_enabled, email_verified_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
+
+	err = withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, stmt,
+			user.Email,
+			user.FirstName,
+			user.LastName,
+			hashedPassword,
+			user.Active,
+			user.MFAEnabled,
+			user.EmailVerifiedAt,
+			time.Now(),
+		).Scan(&newID)
+		if err != nil {
+			return err
+		}
 
-	err = db.QueryRowContext(ctx, stmt,
-		user.Email,
-		user.FirstName,
-		user.LastName,
-		hashedPassword,
-		user.Active,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
+		for i := range events {
+			if events[i].UserID == 0 {
+				events[i].UserID = newID
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -227,38 +334,68 @@ func (u *User) Insert(user User) (int, error) {
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (u *User) ResetPassword(password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m UserModel) ResetPassword(ctx context.Context, user *User, plainText string, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := password.Hash(plainText, hashParams)
 	if err != nil {
 		return err
 	}
 
 	stmt := `update users set password = $1 where id = $2`
-	_, err = db.ExecContext(ctx, stmt, hashedPassword, u.ID)
+
+	err = withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, stmt, hashedPassword, user.ID)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
+	user.Password = hashedPassword
+
 	return nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
-func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
+// RehashPassword stores a new hash of plainText, which must already have been checked
+// with PasswordMatches, if the current hash is bcrypt or uses outdated argon2id
+// parameters. It reports whether the hash was replaced.
+func (m UserModel) RehashPassword(ctx context.Context, user *User, plainText string) (bool, error) {
+	if !password.NeedsRehash(user.Password, hashParams) {
+		return false, nil
+	}
+
+	err := m.ResetPassword(ctx, user, plainText)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
 	}
 
 	return true, nil
 }
+
+// VerifyEmail marks the user's current email address as verified.
+func (m UserModel) VerifyEmail(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	stmt := `update users set email_verified_at = $1 where id = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, now, user.ID)
+	if err != nil {
+		return err
+	}
+
+	user.EmailVerifiedAt = &now
+
+	return nil
+}
+
+// PasswordMatches compares a user supplied password with the hash we have stored for
+// the user, which may be argon2id or, for passwords set before argon2id was
+// introduced, bcrypt. If the password and hash match, we return true; otherwise, we
+// return false.
+func (u *User) PasswordMatches(plainText string) (bool, error) {
+	return password.Verify(plainText, u.Password)
+}
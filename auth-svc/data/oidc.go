@@ -0,0 +1,284 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrCodeUsed is returned when an authorization code is exchanged a second time.
+var ErrCodeUsed = errors.New("authorization code has already been used")
+
+// OIDCClient is an application users sign in to with OpenID Connect. Only a hash of a
+// confidential client's secret is stored; public clients have none.
+type OIDCClient struct {
+	ID           int       `json:"id"`
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	SecretHash   string    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+const oidcClientColumns = `id, client_id, name, secret_hash, redirect_uris, created_at, updated_at`
+
+func scanOIDCClient(row scanner) (*OIDCClient, error) {
+	var client OIDCClient
+	var redirectURIs string
+
+	err := row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.Name,
+		&client.SecretHash,
+		&redirectURIs,
+		&client.CreatedAt,
+		&client.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.RedirectURIs = strings.Fields(redirectURIs)
+
+	return &client, nil
+}
+
+// Public reports whether the client has no secret, like a single page app.
+func (c *OIDCClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirect reports whether uri is registered for the client. Redirect URIs are
+// compared exactly, as OAuth 2.0 Security Best Current Practice asks.
+func (c *OIDCClient) AllowsRedirect(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// OIDCClientModel stores OIDC clients.
+type OIDCClientModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns every client, by name.
+func (m OIDCClientModel) GetAll(ctx context.Context) ([]*OIDCClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select `+oidcClientColumns+` from oidc_clients order by name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OIDCClient{}
+
+	for rows.Next() {
+		client, err := scanOIDCClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// GetOne returns one client by id.
+func (m OIDCClientModel) GetOne(ctx context.Context, id int) (*OIDCClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + oidcClientColumns + ` from oidc_clients where id = $1`
+
+	return scanOIDCClient(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetByClientID returns one client by its OAuth client_id.
+func (m OIDCClientModel) GetByClientID(ctx context.Context, clientID string) (*OIDCClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + oidcClientColumns + ` from oidc_clients where client_id = $1`
+
+	return scanOIDCClient(m.DB.QueryRowContext(ctx, query, clientID))
+}
+
+// Insert stores a new client and returns its ID.
+func (m OIDCClientModel) Insert(ctx context.Context, client OIDCClient) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into oidc_clients (client_id, name, secret_hash, redirect_uris, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		client.ClientID,
+		client.Name,
+		client.SecretHash,
+		strings.Join(client.RedirectURIs, " "),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// Update saves the name and redirect URIs of client.
+func (m OIDCClientModel) Update(ctx context.Context, client *OIDCClient) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update oidc_clients set name = $1, redirect_uris = $2, updated_at = $3 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, client.Name, strings.Join(client.RedirectURIs, " "), time.Now(), client.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes client, along with its outstanding codes.
+// Sessions already started through it carry on until they are revoked or expire.
+func (m OIDCClientModel) Delete(ctx context.Context, client *OIDCClient) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from oidc_clients where id = $1`, client.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AuthCode is an authorization code issued to a client when a user signs in. Only a
+// hash of the code is stored, along with the PKCE challenge the client has to answer
+// to exchange it, and the user's device for the session it starts.
+type AuthCode struct {
+	ID            int
+	CodeHash      string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	UserAgent     string
+	IP            string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+// AuthCodeModel stores OIDC authorization codes.
+type AuthCodeModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a new authorization code.
+func (m AuthCodeModel) Insert(ctx context.Context, code AuthCode) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into oidc_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce,
+			code_challenge, user_agent, ip, auth_time, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.UserAgent,
+		code.IP,
+		code.AuthTime,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetByHash returns one authorization code by the hash of its value.
+func (m AuthCodeModel) GetByHash(ctx context.Context, hash string) (*AuthCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+			user_agent, ip, auth_time, expires_at, used_at
+		from oidc_codes where code_hash = $1`
+
+	var code AuthCode
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(
+		&code.ID,
+		&code.CodeHash,
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.UserAgent,
+		&code.IP,
+		&code.AuthTime,
+		&code.ExpiresAt,
+		&code.UsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+// Use marks code as exchanged, returning ErrCodeUsed if it already
+// was, so each code gets one set of tokens.
+func (m AuthCodeModel) Use(ctx context.Context, code *AuthCode) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update oidc_codes set used_at = $1 where id = $2 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), code.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCodeUsed
+	}
+
+	return nil
+}
+
+// DeleteExpired removes codes that expired before the given time.
+func (m AuthCodeModel) DeleteExpired(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from oidc_codes where expires_at < $1`, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,353 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Organization statuses. Only members of active organizations get the organization in
+// their access tokens.
+const (
+	OrgActive    = "active"
+	OrgSuspended = "suspended"
+	OrgClosed    = "closed"
+)
+
+// ExternalIDs link an organization to its tenant in each vendor platform. Empty means
+// the organization has no tenant there (yet).
+type ExternalIDs struct {
+	// VCD is the VMware Cloud Director org.
+	VCD string `json:"vcd"`
+	// Veeam is the Veeam Service Provider Console organization.
+	Veeam string `json:"veeam"`
+	// Zerto is the Zerto ZORG.
+	Zerto string `json:"zerto"`
+	// Duo is the Duo Accounts API sub-account.
+	Duo string `json:"duo"`
+}
+
+// Organization is a customer, the tenant users act on behalf of.
+type Organization struct {
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	CRMID       string      `json:"crm_id"`
+	Status      string      `json:"status"`
+	ExternalIDs ExternalIDs `json:"external_ids"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// OrganizationQuery describes one page of an organization listing. Search matches the
+// name and CRM ID, and an empty Status matches every status.
+type OrganizationQuery struct {
+	Search   string
+	Status   string
+	Page     int
+	PageSize int
+}
+
+// Member is a user's membership of an organization.
+type Member struct {
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const organizationColumns = `id, name, crm_id, status, vcd_org_id, veeam_org_id, zerto_org_id, duo_account_id, created_at, updated_at`
+
+func scanOrganization(row scanner, extra ...any) (*Organization, error) {
+	var org Organization
+
+	dest := []any{
+		&org.ID,
+		&org.Name,
+		&org.CRMID,
+		&org.Status,
+		&org.ExternalIDs.VCD,
+		&org.ExternalIDs.Veeam,
+		&org.ExternalIDs.Zerto,
+		&org.ExternalIDs.Duo,
+		&org.CreatedAt,
+		&org.UpdatedAt,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// OrganizationModel stores organizations and their members.
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// List returns one page of organizations sorted by name, and the total number that
+// match the query.
+func (m OrganizationModel) List(ctx context.Context, q OrganizationQuery) ([]*Organization, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + organizationColumns + `, count(*) over()
+	from organizations
+	where ($1 = '' or name ilike $1 or crm_id ilike $1) and ($2 = '' or status = $2)
+	order by name, id
+	limit $3 offset $4`
+
+	search := ""
+	if q.Search != "" {
+		search = "%" + escapeLike(q.Search) + "%"
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, search, q.Status, q.PageSize, (q.Page-1)*q.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+	total := 0
+
+	for rows.Next() {
+		org, err := scanOrganization(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		orgs = append(orgs, org)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return orgs, total, nil
+}
+
+// GetOne returns one organization by ID.
+func (m OrganizationModel) GetOne(ctx context.Context, id int) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + organizationColumns + ` from organizations where id = $1`
+
+	return scanOrganization(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetConflicting returns an organization other than org that already has org's CRM ID
+// or one of its external IDs, or sql.ErrNoRows if there is none.
+func (m OrganizationModel) GetConflicting(ctx context.Context, org Organization) (*Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + organizationColumns + ` from organizations
+	where id <> $1 and (
+		($2 <> '' and crm_id = $2) or
+		($3 <> '' and vcd_org_id = $3) or
+		($4 <> '' and veeam_org_id = $4) or
+		($5 <> '' and zerto_org_id = $5) or
+		($6 <> '' and duo_account_id = $6))
+	order by id
+	limit 1`
+
+	row := m.DB.QueryRowContext(ctx, query,
+		org.ID,
+		org.CRMID,
+		org.ExternalIDs.VCD,
+		org.ExternalIDs.Veeam,
+		org.ExternalIDs.Zerto,
+		org.ExternalIDs.Duo,
+	)
+
+	return scanOrganization(row)
+}
+
+// Insert creates a new organization and returns its ID.
+func (m OrganizationModel) Insert(ctx context.Context, org Organization) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into organizations (name, crm_id, status, vcd_org_id, veeam_org_id, zerto_org_id, duo_account_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		org.Name,
+		org.CRMID,
+		org.Status,
+		org.ExternalIDs.VCD,
+		org.ExternalIDs.Veeam,
+		org.ExternalIDs.Zerto,
+		org.ExternalIDs.Duo,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// Update saves org.
+func (m OrganizationModel) Update(ctx context.Context, org *Organization) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update organizations set
+		name = $1,
+		crm_id = $2,
+		status = $3,
+		vcd_org_id = $4,
+		veeam_org_id = $5,
+		zerto_org_id = $6,
+		duo_account_id = $7,
+		updated_at = $8
+		where id = $9`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		org.Name,
+		org.CRMID,
+		org.Status,
+		org.ExternalIDs.VCD,
+		org.ExternalIDs.Veeam,
+		org.ExternalIDs.Zerto,
+		org.ExternalIDs.Duo,
+		time.Now(),
+		org.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete deletes org and its memberships. The users
+// themselves are kept.
+func (m OrganizationModel) Delete(ctx context.Context, org *Organization) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from organizations where id = $1`, org.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Members returns the users that belong to org, sorted by email.
+func (m OrganizationModel) Members(ctx context.Context, org *Organization) ([]*Member, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select u.id, u.email, u.first_name, u.last_name, m.created_at
+		from organization_members m
+		join users u on u.id = m.user_id
+		where m.organization_id = $1
+		order by u.email`
+
+	rows, err := m.DB.QueryContext(ctx, query, org.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*Member{}
+
+	for rows.Next() {
+		var member Member
+		err := rows.Scan(
+			&member.UserID,
+			&member.Email,
+			&member.FirstName,
+			&member.LastName,
+			&member.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &member)
+	}
+
+	return members, rows.Err()
+}
+
+// AddMember makes a user a member of org. Adding a member twice is not an error.
+func (m OrganizationModel) AddMember(ctx context.Context, org *Organization, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into organization_members (organization_id, user_id, created_at)
+		values ($1, $2, $3) on conflict do nothing`
+
+	_, err := m.DB.ExecContext(ctx, stmt, org.ID, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveMember takes a user out of org. It returns
+// sql.ErrNoRows if the user wasn't a member.
+func (m OrganizationModel) RemoveMember(ctx context.Context, org *Organization, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from organization_members where organization_id = $1 and user_id = $2`
+
+	res, err := m.DB.ExecContext(ctx, stmt, org.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Organizations returns the organizations a user belongs to, sorted by name. With
+// activeOnly set, suspended and closed organizations are left out.
+func (m UserModel) Organizations(ctx context.Context, userID int, activeOnly bool) ([]*Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select o.id, o.name, o.crm_id, o.status, o.vcd_org_id, o.veeam_org_id, o.zerto_org_id, o.duo_account_id, o.created_at, o.updated_at
+		from organizations o
+		join organization_members m on m.organization_id = o.id
+		where m.user_id = $1 and (not $2 or o.status = $3)
+		order by o.name, o.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, activeOnly, OrgActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+
+	for rows.Next() {
+		org, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, org)
+	}
+
+	return orgs, rows.Err()
+}
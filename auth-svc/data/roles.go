@@ -0,0 +1,297 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrBuiltinRole is returned when trying to delete one of the built in roles.
+var ErrBuiltinRole = errors.New("built in roles can't be deleted")
+
+// Role is a named set of permissions that can be assigned to users. Permissions take
+// the form "resource:action", e.g. "zerto:delete", where either half can be "*", and
+// "*" on its own grants everything.
+type Role struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Builtin     bool      `json:"builtin"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RoleModel stores roles and their permissions.
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns every role with its permissions, sorted by name.
+func (m RoleModel) GetAll(ctx context.Context) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, builtin, created_at, updated_at from roles order by name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.Builtin,
+			&role.CreatedAt,
+			&role.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		role.Permissions, err = m.permissions(ctx, role.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return roles, nil
+}
+
+// GetByName returns one role by name.
+func (m RoleModel) GetByName(ctx context.Context, name string) (*Role, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, builtin, created_at, updated_at from roles where name = $1`
+
+	var role Role
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(
+		&role.ID,
+		&role.Name,
+		&role.Description,
+		&role.Builtin,
+		&role.CreatedAt,
+		&role.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	role.Permissions, err = m.permissions(ctx, role.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (m RoleModel) permissions(ctx context.Context, roleID int) ([]string, error) {
+	rows, err := m.DB.QueryContext(ctx, `select permission from role_permissions where role_id = $1 order by permission`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+// Insert creates a new role with its permissions and returns its ID.
+func (m RoleModel) Insert(ctx context.Context, role Role) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	stmt := `insert into roles (name, description, created_at, updated_at)
+		values ($1, $2, $3, $3) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, role.Name, role.Description, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, permission := range role.Permissions {
+		_, err = tx.ExecContext(ctx, `insert into role_permissions (role_id, permission) values ($1, $2)`, newID, permission)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return newID, tx.Commit()
+}
+
+// Update saves the description and replaces the permissions of role.
+func (m RoleModel) Update(ctx context.Context, role *Role) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update roles set description = $1, updated_at = $2 where id = $3`, role.Description, time.Now(), role.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from role_permissions where role_id = $1`, role.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range role.Permissions {
+		_, err = tx.ExecContext(ctx, `insert into role_permissions (role_id, permission) values ($1, $2)`, role.ID, permission)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete deletes role, removing it from every user that had it.
+func (m RoleModel) Delete(ctx context.Context, role *Role) error {
+	if role.Builtin {
+		return ErrBuiltinRole
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from roles where id = $1`, role.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Roles returns the names of the roles assigned to a user.
+func (m UserModel) Roles(ctx context.Context, userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select r.name from roles r
+		join user_roles ur on ur.role_id = r.id
+		where ur.user_id = $1
+		order by r.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// Permissions returns every permission a user has through their roles, without
+// duplicates.
+func (m UserModel) Permissions(ctx context.Context, userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select distinct rp.permission from role_permissions rp
+		join user_roles ur on ur.role_id = rp.role_id
+		where ur.user_id = $1
+		order by rp.permission`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+// AssignRole gives a user a role. Assigning a role twice is not an error.
+func (m UserModel) AssignRole(ctx context.Context, userID, roleID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_roles (user_id, role_id) values ($1, $2) on conflict do nothing`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveRole takes a role away from a user.
+func (m UserModel) RemoveRole(ctx context.Context, userID, roleID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_roles where user_id = $1 and role_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrSessionRevoked is returned when a token is issued for a session that has been
+// revoked in the meantime.
+var ErrSessionRevoked = errors.New("session has been revoked")
+
+// Session is one login of a user, made up of the refresh tokens handed out for it. Its
+// ID is the family ID of those tokens and the sid claim of its access tokens.
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// touchSession records that session was used, creating it on the first login, and
+// returns ErrSessionRevoked if it has been revoked.
+func touchSession(ctx context.Context, tx *sql.Tx, session Session) error {
+	stmt := `insert into sessions (id, user_id, user_agent, ip, created_at, last_seen_at, expires_at)
+		values ($1, $2, $3, $4, $5, $5, $6)
+		on conflict (id) do update
+		set user_agent = excluded.user_agent, ip = excluded.ip,
+			last_seen_at = excluded.last_seen_at, expires_at = excluded.expires_at
+		where sessions.revoked_at is null
+		returning id`
+
+	var id string
+	err := tx.QueryRowContext(ctx, stmt,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		time.Now(),
+		session.ExpiresAt,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSessionRevoked
+	}
+
+	return err
+}
+
+// SessionModel stores login sessions.
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// ForUser returns the user's sessions that are neither revoked nor expired, the most
+// recently used first.
+func (m SessionModel) ForUser(ctx context.Context, userID int) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at
+		from sessions
+		where user_id = $1 and revoked_at is null and expires_at > $2
+		order by last_seen_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+			&session.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Revoke revokes one of the user's sessions and its refresh tokens. It returns
+// sql.ErrNoRows if the user has no such session, or it was already revoked.
+func (m SessionModel) Revoke(ctx context.Context, userID int, id string, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		now := time.Now()
+
+		n, err := revokeSessions(ctx, tx, now, `id = $2 and user_id = $3`, id, userID)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`,
+			now, id)
+		return err
+	})
+}
+
+// revokeSessions revokes the sessions matching where, whose placeholders start at $2,
+// and writes a session.revoked event for each in tx. The events are what tell other
+// services to stop accepting the sessions' access tokens. It returns the number of
+// sessions revoked.
+func revokeSessions(ctx context.Context, tx *sql.Tx, now time.Time, where string, args ...any) (int, error) {
+	query := `update sessions set revoked_at = $1 where ` + where + ` and revoked_at is null returning id, user_id`
+
+	rows, err := tx.QueryContext(ctx, query, append([]any{now}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var events []Event
+
+	for rows.Next() {
+		event := Event{Type: EventSessionRevoked, OccurredAt: now}
+
+		err := rows.Scan(&event.SessionID, &event.UserID)
+		if err != nil {
+			return 0, err
+		}
+
+		events = append(events, event)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	// The rows have to be closed before the transaction can run anything else.
+	rows.Close()
+
+	return len(events), insertEvents(ctx, tx, events)
+}
+
+// IsRevoked reports whether the session with the given ID has been revoked. Unknown
+// sessions count as revoked.
+func (m SessionModel) IsRevoked(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var revokedAt *time.Time
+	err := m.DB.QueryRowContext(ctx, `select revoked_at from sessions where id = $1`, id).Scan(&revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return revokedAt != nil, nil
+}
+
+// Revoked returns the IDs of sessions revoked since the given time. Access tokens
+// issued before that have expired, so older revocations don't need to be listed.
+func (m SessionModel) Revoked(ctx context.Context, since time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select id from sessions where revoked_at >= $1 order by revoked_at`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// DeleteExpired removes sessions whose refresh tokens all expired before the given
+// time.
+func (m SessionModel) DeleteExpired(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from sessions where expires_at < $1`, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
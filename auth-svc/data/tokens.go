@@ -0,0 +1,184 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrTokenReused is returned when a refresh token that was already used or revoked is
+// presented again. Its whole family is revoked when that happens, since either the
+// client or an attacker holds a stolen copy.
+var ErrTokenReused = errors.New("refresh token has already been used")
+
+// RefreshToken is one refresh token issued to a user. Tokens handed out by rotating
+// another token share its family, so a login session can be revoked as a whole.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RevokedAt sql.NullTime
+}
+
+// RefreshTokenModel stores refresh tokens and the sessions they belong to.
+type RefreshTokenModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a new refresh token, along with events such as the login it was
+// issued for. The token's session is created, or marked as seen, with the device
+// details in session; if it has been revoked, ErrSessionRevoked is returned.
+func (m RefreshTokenModel) Insert(ctx context.Context, token RefreshToken, session Session, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (user_id, family_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	session.ID = token.FamilyID
+	session.UserID = token.UserID
+	session.ExpiresAt = token.ExpiresAt
+
+	return withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		err := touchSession(ctx, tx, session)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, stmt,
+			token.UserID,
+			token.FamilyID,
+			token.TokenHash,
+			token.ExpiresAt,
+			time.Now(),
+		)
+		return err
+	})
+}
+
+// GetByHash returns one refresh token by the hash of its value.
+func (m RefreshTokenModel) GetByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, family_id, token_hash, expires_at, created_at, revoked_at
+	from refresh_tokens where token_hash = $1`
+
+	var token RefreshToken
+	row := m.DB.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Use marks token as spent, so it can be exchanged exactly once.
+// If it was already spent or revoked, the whole family is revoked and ErrTokenReused is
+// returned.
+func (m RefreshTokenModel) Use(ctx context.Context, token *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where id = $2 and revoked_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), token.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		err = m.RevokeFamily(ctx, token, Event{
+			Type:   EventTokenRevoked,
+			UserID: token.UserID,
+			Detail: "refresh token reused, session revoked",
+		})
+		if err != nil {
+			return err
+		}
+
+		return ErrTokenReused
+	}
+
+	return nil
+}
+
+// RevokeFamily revokes every token in token's family, and so its session.
+func (m RefreshTokenModel) RevokeFamily(ctx context.Context, token *RefreshToken, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		now := time.Now()
+
+		_, err := tx.ExecContext(ctx,
+			`update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`,
+			now, token.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		_, err = revokeSessions(ctx, tx, now, `id = $2`, token.FamilyID)
+		return err
+	})
+}
+
+// RevokeUser revokes every refresh token and session the user holds, logging them out
+// everywhere.
+func (m RefreshTokenModel) RevokeUser(ctx context.Context, userID int, events ...Event) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return withEvents(ctx, m.DB, events, func(tx *sql.Tx) error {
+		return revokeUserSessions(ctx, tx, userID)
+	})
+}
+
+// revokeUserSessions revokes all of a user's refresh tokens and sessions in tx.
+func revokeUserSessions(ctx context.Context, tx *sql.Tx, userID int) error {
+	now := time.Now()
+
+	_, err := tx.ExecContext(ctx,
+		`update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`,
+		now, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = revokeSessions(ctx, tx, now, `user_id = $2`, userID)
+	return err
+}
+
+// DeleteExpired removes tokens that expired before the given time.
+func (m RefreshTokenModel) DeleteExpired(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from refresh_tokens where expires_at < $1`
+
+	_, err := m.DB.ExecContext(ctx, stmt, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Purposes a UserToken can be issued for.
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+)
+
+// ErrTokenUsed is returned when a single use token has already been used.
+var ErrTokenUsed = errors.New("token has already been used")
+
+// UserToken is a single use token emailed to a user, for resetting their password or
+// verifying their email address. Only a hash of the token is stored.
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+// UserTokenModel stores single use tokens sent to users.
+type UserTokenModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a new token, replacing any unused token the user has for the same
+// purpose so only the latest email works.
+func (m UserTokenModel) Insert(ctx context.Context, token UserToken) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from user_tokens where user_id = $1 and purpose = $2 and used_at is null`,
+		token.UserID, token.Purpose)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into user_tokens (user_id, purpose, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		token.UserID,
+		token.Purpose,
+		token.TokenHash,
+		token.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetByHash returns one token for the given purpose by the hash of its value.
+func (m UserTokenModel) GetByHash(ctx context.Context, purpose, hash string) (*UserToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, purpose, token_hash, expires_at, created_at, used_at
+	from user_tokens where purpose = $1 and token_hash = $2`
+
+	var token UserToken
+	row := m.DB.QueryRowContext(ctx, query, purpose, hash)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.UsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Use marks token as used, so it works exactly once. Returns
+// ErrTokenUsed if it was already used.
+func (m UserTokenModel) Use(ctx context.Context, token *UserToken) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update user_tokens set used_at = $1 where id = $2 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), token.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrTokenUsed
+	}
+
+	return nil
+}
+
+// DeleteExpired removes tokens that expired before the given time.
+func (m UserTokenModel) DeleteExpired(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_tokens where expires_at < $1`
+
+	_, err := m.DB.ExecContext(ctx, stmt, before)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
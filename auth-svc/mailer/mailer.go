@@ -0,0 +1,214 @@
+// Package mailer sends the emails auth-svc needs, such as password resets and address
+// verification, through SMTP or, for local development, to files or the log.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Message is one email, ready to be sent.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Render builds a message from one of the embedded templates, which define a "subject"
+// and a "body" template each.
+func Render(to, name string, data any) (Message, error) {
+	tmpl, err := template.New("").ParseFS(templateFS, "templates/"+name)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, body bytes.Buffer
+
+	err = tmpl.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	err = tmpl.ExecuteTemplate(&body, "body", data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimSpace(body.String()) + "\n",
+	}, nil
+}
+
+// FromEnv returns the mailer selected by MAILER: "smtp" (SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD), "file" (MAILER_DIR) or "log", the default.
+// MAIL_FROM sets the sender.
+func FromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "CloudKey <no-reply@cloudkey.io>"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		port := 587
+		if v := os.Getenv("SMTP_PORT"); v != "" {
+			var err error
+
+			port, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("SMTP_PORT must be a number: %w", err)
+			}
+		}
+
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("SMTP_HOST must be set when MAILER is smtp")
+		}
+
+		return &SMTP{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "./mail"
+		}
+
+		return &File{Dir: dir, From: from}, nil
+	case "", "log":
+		return &File{From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q, must be smtp, file or log", os.Getenv("MAILER"))
+	}
+}
+
+// SMTP sends mail through an SMTP server, upgrading to TLS with STARTTLS when the
+// server offers it. Username may be empty for servers that don't need to
+// authenticate.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers msg.
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	from, err := envelopeAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, from, []string{msg.To}, format(m.From, msg))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("sending mail to %s: %w", msg.To, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// File writes each message to its own .eml file in Dir, or to the log when Dir is
+// empty. Meant for local development and tests.
+type File struct {
+	Dir  string
+	From string
+}
+
+// Send writes msg out.
+func (m *File) Send(ctx context.Context, msg Message) error {
+	raw := format(m.From, msg)
+
+	if m.Dir == "" {
+		log.Printf("mail to %s:\n%s", msg.To, raw)
+		return nil
+	}
+
+	err := os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitize(msg.To))
+
+	return os.WriteFile(filepath.Join(m.Dir, name), raw, 0o644)
+}
+
+// format builds a plain text RFC 5322 message.
+func format(from string, msg Message) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return b.Bytes()
+}
+
+// envelopeAddress returns the bare address from a "Name <address>" sender.
+func envelopeAddress(from string) (string, error) {
+	start, end := strings.LastIndex(from, "<"), strings.LastIndex(from, ">")
+	if start == -1 && end == -1 {
+		return strings.TrimSpace(from), nil
+	}
+	if start == -1 || end < start {
+		return "", fmt.Errorf("invalid sender %q", from)
+	}
+
+	return from[start+1 : end], nil
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,81 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data := map[string]string{
+		"Name":    "Alice",
+		"Link":    "https://portal.example.com/reset-password?token=abc",
+		"Expires": "1 hour",
+	}
+
+	for _, name := range []string{"password_reset.tmpl", "email_verification.tmpl"} {
+		msg, err := Render("alice@example.com", name, data)
+		if err != nil {
+			t.Fatalf("Render(%s): %v", name, err)
+		}
+
+		if msg.To != "alice@example.com" || msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+			t.Errorf("%s: unexpected header fields %+v", name, msg)
+		}
+		if !strings.Contains(msg.Body, data["Link"]) || !strings.HasPrefix(msg.Body, "Hi Alice,") {
+			t.Errorf("%s: unexpected body %q", name, msg.Body)
+		}
+	}
+
+	_, err := Render("alice@example.com", "missing.tmpl", data)
+	if err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &File{Dir: dir, From: "CloudKey <no-reply@cloudkey.io>"}
+
+	err := m.Send(context.Background(), Message{To: "alice@example.com", Subject: "Hello", Body: "line one\nline two\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*alice@example.com.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one message file, got %v (%v)", files, err)
+	}
+
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"From: CloudKey <no-reply@cloudkey.io>\r\n", "To: alice@example.com\r\n", "Subject: Hello\r\n", "\r\n\r\nline one\r\nline two\r\n"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("expected message to contain %q, got %q", want, raw)
+		}
+	}
+}
+
+func TestEnvelopeAddress(t *testing.T) {
+	tests := map[string]string{
+		"no-reply@cloudkey.io":            "no-reply@cloudkey.io",
+		"CloudKey <no-reply@cloudkey.io>": "no-reply@cloudkey.io",
+	}
+
+	for from, want := range tests {
+		got, err := envelopeAddress(from)
+		if err != nil || got != want {
+			t.Errorf("envelopeAddress(%q) = %q, %v, want %q", from, got, err, want)
+		}
+	}
+
+	_, err := envelopeAddress("CloudKey no-reply@cloudkey.io>")
+	if err == nil {
+		t.Error("expected an error for a malformed sender")
+	}
+}
@@ -0,0 +1,117 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast.
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := Hash("correct horse 42", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("unexpected hash format %s", hash)
+	}
+
+	ok, err := Verify("correct horse 42", hash)
+	if err != nil || !ok {
+		t.Errorf("expected the password to match, got %v, %v", ok, err)
+	}
+
+	ok, err = Verify("wrong horse 42", hash)
+	if err != nil || ok {
+		t.Errorf("expected the wrong password not to match, got %v, %v", ok, err)
+	}
+
+	other, _ := Hash("correct horse 42", testParams)
+	if other == hash {
+		t.Error("expected every hash to get its own salt")
+	}
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse 42"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := Verify("correct horse 42", string(hash))
+	if err != nil || !ok {
+		t.Errorf("expected the bcrypt hash to match, got %v, %v", ok, err)
+	}
+
+	ok, err = Verify("wrong horse 42", string(hash))
+	if err != nil || ok {
+		t.Errorf("expected the wrong password not to match, got %v, %v", ok, err)
+	}
+
+	_, err = Verify("correct horse 42", "plaintext")
+	if err == nil {
+		t.Error("expected an error for an unknown hash format")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("correct horse 42"), bcrypt.MinCost)
+	if !NeedsRehash(string(bcryptHash), testParams) {
+		t.Error("expected bcrypt hashes to need a rehash")
+	}
+
+	hash, _ := Hash("correct horse 42", testParams)
+	if NeedsRehash(hash, testParams) {
+		t.Error("expected a hash with the current parameters to be kept")
+	}
+
+	stronger := testParams
+	stronger.Iterations = 2
+	if !NeedsRehash(hash, stronger) {
+		t.Error("expected a hash with old parameters to need a rehash")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+
+	err := policy.LoadBreached(strings.NewReader(strings.Join([]string{
+		"# top passwords",
+		"password1234",
+		"",
+		// SHA-1 of "correct horse 42", in the Have I Been Pwned format.
+		"5AAC0C2531F7229672F3E8FBA2EB2BAE1E14476A:12",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"correct horse 43", true},
+		{"short1", false},
+		{"no digits at all here", false},
+		{"123456789012345", false},
+		{string(make([]byte, 80)), false},
+		{strings.Repeat("a1", 65), false},
+		{"password1234", false},
+		{"correct horse 42", false},
+	}
+
+	for _, tt := range tests {
+		err := policy.Check(tt.password)
+		if (err == nil) != tt.valid {
+			t.Errorf("Check(%q): expected valid=%v, got %v", tt.password, tt.valid, err)
+		}
+	}
+
+	if policy.Breached() != 2 {
+		t.Errorf("expected 2 breached entries, got %d", policy.Breached())
+	}
+}
@@ -0,0 +1,113 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ErrBreached is returned for a password that appears in the breached password list.
+var ErrBreached = errors.New("password has appeared in a data breach, choose another")
+
+// Policy decides which passwords users may choose.
+type Policy struct {
+	MinLength int
+	MaxLength int
+
+	breached map[[sha1.Size]byte]struct{}
+}
+
+// DefaultPolicy asks for 12 to 128 characters with at least one letter and one digit,
+// and has no breached password list.
+func DefaultPolicy() *Policy {
+	return &Policy{MinLength: 12, MaxLength: 128}
+}
+
+// Check returns an error describing why password doesn't meet the policy, if it
+// doesn't.
+func (p *Policy) Check(password string) error {
+	if len(password) < p.MinLength || len(password) > p.MaxLength {
+		return fmt.Errorf("password must be between %d and %d characters", p.MinLength, p.MaxLength)
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+
+	if !letter || !digit {
+		return errors.New("password must contain at least one letter and one digit")
+	}
+
+	if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+		return ErrBreached
+	}
+
+	return nil
+}
+
+// LoadBreachedFile adds the passwords in the file at path to the breached list, see
+// LoadBreached.
+func (p *Policy) LoadBreachedFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.LoadBreached(f)
+}
+
+// LoadBreached adds passwords to the breached list, one per line. A line is either
+// the password itself or its SHA-1 in hex, optionally followed by ":count" as in the
+// Have I Been Pwned downloads. Blank lines and lines starting with # are skipped.
+func (p *Policy) LoadBreached(r io.Reader) error {
+	if p.breached == nil {
+		p.breached = make(map[[sha1.Size]byte]struct{})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if sum, ok := parseSHA1(line); ok {
+			p.breached[sum] = struct{}{}
+			continue
+		}
+
+		p.breached[sha1.Sum([]byte(line))] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+// Breached returns the number of entries in the breached list.
+func (p *Policy) Breached() int {
+	return len(p.breached)
+}
+
+func parseSHA1(line string) ([sha1.Size]byte, bool) {
+	var sum [sha1.Size]byte
+
+	hash, _, _ := strings.Cut(line, ":")
+	if len(hash) != hex.EncodedLen(sha1.Size) {
+		return sum, false
+	}
+
+	_, err := hex.Decode(sum[:], []byte(hash))
+	return sum, err == nil
+}
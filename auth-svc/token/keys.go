@@ -0,0 +1,215 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keyBits is the size of generated RSA keys.
+const keyBits = 2048
+
+// Key is an RSA signing key, identified by the RFC 7638 thumbprint of its public half
+// so the ID stays the same across restarts.
+type Key struct {
+	ID      string
+	Private *rsa.PrivateKey
+	Created time.Time
+}
+
+// NewKey wraps an existing private key.
+func NewKey(private *rsa.PrivateKey) *Key {
+	return &Key{
+		ID:      thumbprint(&private.PublicKey),
+		Private: private,
+		Created: time.Now(),
+	}
+}
+
+// GenerateKey creates a new random signing key.
+func GenerateKey() (*Key, error) {
+	private, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(private), nil
+}
+
+// KeySet holds the key currently used for signing along with older keys that are still
+// published so tokens they signed keep verifying until they expire.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*Key // newest first
+}
+
+// NewKeySet returns a set containing keys, where the last key is used for signing.
+func NewKeySet(keys ...*Key) *KeySet {
+	s := &KeySet{}
+	for _, key := range keys {
+		s.keys = append([]*Key{key}, s.keys...)
+	}
+
+	return s
+}
+
+// LoadKeyDir reads every PEM encoded RSA private key (PKCS #1 or PKCS #8) in dir. Files
+// are taken in name order and the last one signs, so keys can be rotated by adding a
+// file that sorts after the current one, e.g. 2024-06-01.pem.
+func LoadKeyDir(dir string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pem keys found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	var keys []*Key
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys...), nil
+}
+
+func loadKey(path string) (*Key, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("not a PEM file")
+	}
+
+	if private, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewKey(private), nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+
+	return NewKey(private), nil
+}
+
+// Rotate makes key the signing key and drops all but the newest retain keys.
+func (s *KeySet) Rotate(key *Key, retain int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append([]*Key{key}, s.keys...)
+	if retain > 0 && len(s.keys) > retain {
+		s.keys = s.keys[:retain]
+	}
+}
+
+// Signing returns the key new tokens are signed with.
+func (s *KeySet) Signing() *Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.keys) == 0 {
+		return nil
+	}
+
+	return s.keys[0]
+}
+
+// Public returns the public key with the given ID, if it is still in the set.
+func (s *KeySet) Public(kid string) (*rsa.PublicKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		if key.ID == kid {
+			return &key.Private.PublicKey, true
+		}
+	}
+
+	return nil, false
+}
+
+// JWK is the JSON Web Key representation of an RSA public key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set, as served to services that verify tokens.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key in the set.
+func (s *KeySet) JWKS() JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range s.keys {
+		n, e := encodePublic(&key.Private.PublicKey)
+		set.Keys = append(set.Keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: algorithm,
+			KeyID:     key.ID,
+			N:         n,
+			E:         e,
+		})
+	}
+
+	return set
+}
+
+func encodePublic(pub *rsa.PublicKey) (n, e string) {
+	n = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+	e = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+
+	return n, e
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of an RSA public key.
+func thumbprint(pub *rsa.PublicKey) string {
+	n, e := encodePublic(pub)
+
+	// The members must be in lexicographic order with no whitespace, which is what
+	// encoding/json produces for this struct.
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{e, "RSA", n})
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,286 @@
+// Package token issues and verifies the RS256 signed JWT access tokens handed out by
+// auth-svc, signs OpenID Connect ID tokens, and generates the opaque refresh tokens
+// and secrets that go with them.
+package token
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const algorithm = "RS256"
+
+// leeway allows for clock skew between services when checking exp and nbf.
+const leeway = 30 * time.Second
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token has expired")
+)
+
+// Claims are the contents of an access token.
+type Claims struct {
+	Issuer      string   `json:"iss"`
+	Subject     string   `json:"sub"`
+	Audience    string   `json:"aud"`
+	ExpiresAt   int64    `json:"exp"`
+	NotBefore   int64    `json:"nbf"`
+	IssuedAt    int64    `json:"iat"`
+	ID          string   `json:"jti"`
+	SessionID   string   `json:"sid,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Orgs        []Org    `json:"orgs,omitempty"`
+}
+
+// Org is an organization the subject belongs to, with its tenant in each vendor
+// platform, so services can scope requests without looking the organization up.
+type Org struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	VCD   string `json:"vcd,omitempty"`
+	Veeam string `json:"veeam,omitempty"`
+	Zerto string `json:"zerto,omitempty"`
+	Duo   string `json:"duo,omitempty"`
+}
+
+// IDClaims are the contents of an OpenID Connect ID token. Unlike an access token, its
+// issuer is the OIDC issuer and its audience the client it was issued to.
+type IDClaims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Audience      string `json:"aud"`
+	ExpiresAt     int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+	AuthTime      int64  `json:"auth_time"`
+	Nonce         string `json:"nonce,omitempty"`
+	SessionID     string `json:"sid,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Issuer signs access tokens with the current key of its key set.
+type Issuer struct {
+	Keys     *KeySet
+	Issuer   string
+	Audience string
+	TTL      time.Duration
+}
+
+// Issue fills in the registered claims (iss, aud, iat, nbf, exp and jti) and returns
+// the signed token along with the claims it contains.
+func (i *Issuer) Issue(claims Claims) (string, Claims, error) {
+	key := i.Keys.Signing()
+	if key == nil {
+		return "", Claims{}, errors.New("no signing key")
+	}
+
+	id, err := randomString(16)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	now := time.Now()
+	claims.Issuer = i.Issuer
+	claims.Audience = i.Audience
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.ExpiresAt = now.Add(i.TTL).Unix()
+	claims.ID = id
+
+	signed, err := sign(key, claims)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	return signed, claims, nil
+}
+
+// IssueID signs an ID token, filling in iat and exp. The caller sets the issuer and
+// audience.
+func (i *Issuer) IssueID(claims IDClaims) (string, error) {
+	key := i.Keys.Signing()
+	if key == nil {
+		return "", errors.New("no signing key")
+	}
+
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(i.TTL).Unix()
+
+	return sign(key, claims)
+}
+
+func sign(key *Key, claims any) (string, error) {
+	h, err := json.Marshal(header{Algorithm: algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key.Private, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks a token's signature against the key set and validates its issuer,
+// audience and lifetime.
+func (i *Issuer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+
+	var h header
+	err := decodeSegment(parts[0], &h)
+	if err != nil || h.Algorithm != algorithm {
+		return nil, ErrInvalid
+	}
+
+	pub, ok := i.Keys.Public(h.KeyID)
+	if !ok {
+		return nil, ErrInvalid
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	if claims.Issuer != i.Issuer || claims.Audience != i.Audience {
+		return nil, ErrInvalid
+	}
+
+	now := time.Now()
+	if now.Add(-leeway).Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	if now.Add(leeway).Unix() < claims.NotBefore {
+		return nil, ErrInvalid
+	}
+
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// NewRefreshToken returns a random opaque refresh token and the hash to store for it.
+// Only the hash is ever written to the database.
+func NewRefreshToken() (plain, hash string, err error) {
+	plain, err = randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return plain, Hash(plain), nil
+}
+
+// NewSessionID returns a random ID for a new login session.
+func NewSessionID() (string, error) {
+	return randomString(16)
+}
+
+// NewClientCredentials returns a random OpenID Connect client ID, a client secret and
+// the hash to store for the secret.
+func NewClientCredentials() (id, secret, hash string, err error) {
+	id, err = randomString(16)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	secret, hash, err = NewRefreshToken()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return id, secret, hash, nil
+}
+
+// NewOneTimeToken returns a random token for a single use flow, such as an mfa
+// challenge, a password reset or an email verification link, and the hash to store
+// for it.
+func NewOneTimeToken() (plain, hash string, err error) {
+	return NewRefreshToken()
+}
+
+// apiKeyPrefix marks API keys so they are easy to spot, e.g. by secret scanners.
+const apiKeyPrefix = "ck_"
+
+// NewAPIKey returns a random API key, the short prefix shown in listings to tell keys
+// apart, and the hash to store for it.
+func NewAPIKey() (plain, prefix, hash string, err error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	plain = apiKeyPrefix + secret
+	return plain, plain[:len(apiKeyPrefix)+8], Hash(plain), nil
+}
+
+// Hash returns the hash a refresh token, one time token, API key or client secret is
+// stored and looked up by. The values are random and long, so a plain SHA-256 is
+// enough.
+func Hash(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("reading random bytes: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,158 @@
+package token
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestIssuer(t *testing.T) *Issuer {
+	t.Helper()
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	return &Issuer{
+		Keys:     NewKeySet(key),
+		Issuer:   "http://auth-svc",
+		Audience: "service-hub",
+		TTL:      time.Minute,
+	}
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	orgs := []Org{{ID: 7, Name: "Acme", VCD: "acme-vcd", Zerto: "zorg-1"}}
+
+	signed, issued, err := issuer.Issue(Claims{Subject: "42", SessionID: "session-1", Email: "user@example.com", Orgs: orgs})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	claims, err := issuer.Verify(signed)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "42" || claims.Email != "user@example.com" || claims.ID != issued.ID || claims.SessionID != "session-1" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if len(claims.Orgs) != 1 || claims.Orgs[0] != orgs[0] {
+		t.Errorf("expected orgs %+v, got %+v", orgs, claims.Orgs)
+	}
+
+	parts := strings.Split(signed, ".")
+	tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+	if _, err := issuer.Verify(tampered); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected tampered token to be invalid, got %v", err)
+	}
+
+	other := *issuer
+	other.Audience = "someone-else"
+	if _, err := other.Verify(signed); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected wrong audience to be invalid, got %v", err)
+	}
+
+	issuer.TTL = -time.Hour
+	expired, _, _ := issuer.Issue(Claims{Subject: "42"})
+	if _, err := issuer.Verify(expired); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected expired token, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	issuer := newTestIssuer(t)
+	first := issuer.Keys.Signing()
+
+	signed, _, err := issuer.Issue(Claims{Subject: "42"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	second, _ := GenerateKey()
+	issuer.Keys.Rotate(second, 2)
+
+	if issuer.Keys.Signing().ID != second.ID {
+		t.Errorf("expected the new key to sign")
+	}
+	if _, err := issuer.Verify(signed); err != nil {
+		t.Errorf("expected token from the previous key to verify, got %v", err)
+	}
+
+	jwks := issuer.Keys.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != second.ID || jwks.Keys[1].KeyID != first.ID {
+		t.Errorf("expected both keys to be published, newest first, got %+v", jwks.Keys)
+	}
+
+	third, _ := GenerateKey()
+	issuer.Keys.Rotate(third, 2)
+
+	if _, err := issuer.Verify(signed); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected token from a retired key to be invalid, got %v", err)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// Example from RFC 7638, section 3.1.
+	n := "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	e := "AQAB"
+
+	nb, _ := base64.RawURLEncoding.DecodeString(n)
+	eb, _ := base64.RawURLEncoding.DecodeString(e)
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nb),
+		E: int(new(big.Int).SetBytes(eb).Int64()),
+	}
+
+	if got := thumbprint(pub); got != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected thumbprint %s", got)
+	}
+}
+
+func TestIssueID(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	signed, err := issuer.IssueID(IDClaims{
+		Issuer:   "https://auth.example.com",
+		Subject:  "42",
+		Audience: "portal",
+		Nonce:    "n-0S6_WzA2Mj",
+		Email:    "user@example.com",
+	})
+	if err != nil {
+		t.Fatalf("IssueID: %v", err)
+	}
+
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a compact JWS, got %q", signed)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims IDClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "https://auth.example.com" || claims.Audience != "portal" || claims.Nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if claims.ExpiresAt-claims.IssuedAt != int64(issuer.TTL.Seconds()) {
+		t.Errorf("expected the token to live for the issuer's TTL, got %+v", claims)
+	}
+
+	// An ID token's audience is the client, so it is never accepted as an access token.
+	if _, err := issuer.Verify(signed); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ID token to be rejected as an access token, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Package apikey checks API keys presented by machine callers against auth-svc, which
+// holds the hashed keys, their scopes and expiry.
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Header is the request header machine callers send their key in.
+const Header = "X-API-Key"
+
+// cacheTTL is how long a successful verification is reused, so a revoked key keeps
+// working for at most this long.
+const cacheTTL = time.Minute
+
+var (
+	ErrMissing   = errors.New("missing API key")
+	ErrInvalid   = errors.New("invalid, expired or revoked API key")
+	ErrForbidden = errors.New("API key doesn't have the required scope")
+)
+
+// Key describes a verified API key.
+type Key struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// HasScope reports whether the key grants scope, either directly or through "*".
+func (k *Key) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, "*")
+}
+
+type cached struct {
+	key     *Key
+	expires time.Time
+}
+
+// Client verifies keys with auth-svc's verify endpoint and caches the results.
+type Client struct {
+	verifyURL string
+	client    *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cached
+}
+
+// NewClient returns a Client that calls verifyURL, e.g.
+// http://auth-svc/api-keys/verify.
+func NewClient(verifyURL string) *Client {
+	return &Client{
+		verifyURL: verifyURL,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		cache:     make(map[[sha256.Size]byte]cached),
+	}
+}
+
+// Verify checks that key is valid and grants scope.
+func (c *Client) Verify(ctx context.Context, key, scope string) (*Key, error) {
+	if key == "" {
+		return nil, ErrMissing
+	}
+
+	id := sha256.Sum256([]byte(key))
+	now := time.Now()
+
+	c.mu.Lock()
+	hit, ok := c.cache[id]
+	c.mu.Unlock()
+
+	if !ok || now.After(hit.expires) {
+		verified, err := c.verify(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		hit = cached{key: verified, expires: now.Add(cacheTTL)}
+		if verified.ExpiresAt != nil && verified.ExpiresAt.Before(hit.expires) {
+			hit.expires = *verified.ExpiresAt
+		}
+
+		c.mu.Lock()
+		c.cache[id] = hit
+		c.mu.Unlock()
+	}
+
+	if !hit.key.HasScope(scope) {
+		return nil, ErrForbidden
+	}
+
+	return hit.key, nil
+}
+
+func (c *Client) verify(ctx context.Context, key string) (*Key, error) {
+	body, err := json.Marshal(map[string]string{"api_key": key})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.verifyURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("verifying API key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, ErrInvalid
+	default:
+		return nil, fmt.Errorf("verifying API key: unexpected status %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Data Key `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("decoding API key: %w", err)
+	}
+
+	return &payload.Data, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the verified key.
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key stored by NewContext, if any.
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
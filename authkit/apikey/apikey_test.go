@@ -0,0 +1,59 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		var body struct {
+			APIKey string `json:"api_key"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if body.APIKey != "ck_good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"error": false,
+			"data":  map[string]any{"id": 7, "name": "hostbill", "scopes": []string{"broker"}},
+		})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	ctx := context.Background()
+
+	key, err := c.Verify(ctx, "ck_good", "broker")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if key.ID != 7 || key.Name != "hostbill" {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	if _, err := c.Verify(ctx, "ck_good", "hostbill"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden for a missing scope, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the verified key to be cached, got %d calls", calls)
+	}
+
+	if _, err := c.Verify(ctx, "ck_bad", "broker"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+
+	if _, err := c.Verify(ctx, "", "broker"); !errors.Is(err, ErrMissing) {
+		t.Errorf("expected ErrMissing, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Package authevents follows the auth events auth-svc publishes to RabbitMQ, so
+// services can act on them as they happen instead of waiting to poll auth-svc.
+package authevents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// Exchange is the topic exchange auth-svc publishes its events on.
+	Exchange = "logs_topic"
+
+	// SessionRevoked is the routing key of the event auth-svc publishes for each
+	// revoked session.
+	SessionRevoked = "auth.session.revoked"
+
+	maxBackoff = time.Minute
+)
+
+var errNoSession = errors.New("event has no session_id")
+
+// Revoker is told about every revoked session. *jwtauth.Verifier is one.
+type Revoker interface {
+	Revoke(sid string)
+}
+
+// WatchRevocations passes the session of every session.revoked event to r until ctx
+// is done, reconnecting to RabbitMQ at url with a growing delay whenever it goes away.
+// Each caller gets its own queue, so every replica hears about every session. Events
+// published while it is disconnected are missed, so services also poll auth-svc's
+// revoked sessions to catch up.
+func WatchRevocations(ctx context.Context, url string, r Revoker) {
+	backoff := time.Second
+
+	for {
+		started := time.Now()
+
+		err := consume(ctx, url, r)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+		log.Printf("Stopped following session revocations, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// consume connects to RabbitMQ and passes revoked sessions to r until the connection
+// is lost or ctx is done.
+func consume(ctx context.Context, url string, r Revoker) error {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	err = ch.ExchangeDeclare(Exchange, "topic", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	// A server named queue that goes away with the connection.
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(q.Name, SessionRevoked, Exchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := ch.ConsumeWithContext(ctx, q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Following session revocations on", Exchange)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case err := <-closed:
+			return err
+
+		case d, ok := <-deliveries:
+			if !ok {
+				return errors.New("delivery channel closed")
+			}
+
+			sid, err := sessionID(d.Body)
+			if err != nil {
+				log.Println("Error reading session.revoked event:", err)
+				continue
+			}
+
+			r.Revoke(sid)
+		}
+	}
+}
+
+// sessionID returns the session a session.revoked event is about.
+func sessionID(body []byte) (string, error) {
+	var event struct {
+		SessionID string `json:"session_id"`
+	}
+
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return "", err
+	}
+
+	if event.SessionID == "" {
+		return "", errNoSession
+	}
+
+	return event.SessionID, nil
+}
@@ -0,0 +1,45 @@
+package authevents
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSessionID(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "auth-svc event",
+			body: `{"name": "authentication", "data": "session.revoked user 7", "id": 12, "type": "session.revoked", "user_id": 7, "session_id": "0b4c", "occurred_at": "2024-06-01T12:00:00Z"}`,
+			want: "0b4c",
+		},
+		{name: "no session", body: `{"type": "session.revoked", "user_id": 7}`, wantErr: errNoSession},
+		{name: "not json", body: `session.revoked`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sessionID([]byte(tt.body))
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("expected an error, got session %q", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/cloudkey-io/service-hub/authkit
+
+go 1.22.2
+
+require github.com/rabbitmq/amqp091-go v1.10.0
@@ -0,0 +1,431 @@
+// Package jwtauth verifies the RS256 access tokens issued by auth-svc against the keys
+// it publishes at its JWKS endpoint, so requests can be authenticated without calling
+// auth-svc for each one.
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	algorithm = "RS256"
+
+	// leeway allows for clock skew with auth-svc when checking exp and nbf.
+	leeway = 30 * time.Second
+
+	// refreshInterval is how long fetched keys are trusted before the JWKS is fetched
+	// again, and minRefresh limits how often an unknown key ID can trigger a fetch.
+	refreshInterval = 10 * time.Minute
+	minRefresh      = 30 * time.Second
+
+	// RevocationInterval is how often WatchRevocations fetches the revoked sessions,
+	// and so about how long a revoked session's access tokens are still accepted.
+	RevocationInterval = 5 * time.Second
+)
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalid      = errors.New("invalid token")
+	ErrExpired      = errors.New("token has expired")
+	ErrRevoked      = errors.New("session has been revoked")
+)
+
+// Claims are the contents of an access token.
+type Claims struct {
+	Issuer      string   `json:"iss"`
+	Subject     string   `json:"sub"`
+	Audience    audience `json:"aud"`
+	ExpiresAt   int64    `json:"exp"`
+	NotBefore   int64    `json:"nbf"`
+	IssuedAt    int64    `json:"iat"`
+	ID          string   `json:"jti"`
+	SessionID   string   `json:"sid"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	Orgs        []Org    `json:"orgs"`
+}
+
+// Org is an organization the token's subject belongs to, with its tenant in each
+// vendor platform. Only active organizations are included.
+type Org struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	VCD   string `json:"vcd"`
+	Veeam string `json:"veeam"`
+	Zerto string `json:"zerto"`
+	Duo   string `json:"duo"`
+}
+
+// Org returns the organization with the given ID, if the subject belongs to it.
+func (c *Claims) Org(id int) (Org, bool) {
+	for _, org := range c.Orgs {
+		if org.ID == id {
+			return org, true
+		}
+	}
+
+	return Org{}, false
+}
+
+// HasPermission reports whether the token grants permission, given as
+// "resource:action". Granted permissions may use "*" for either half, or be "*" to
+// grant everything.
+func (c *Claims) HasPermission(permission string) bool {
+	resource, action, _ := strings.Cut(permission, ":")
+
+	for _, granted := range c.Permissions {
+		if granted == "*" || granted == permission {
+			return true
+		}
+
+		r, a, ok := strings.Cut(granted, ":")
+		if ok && (r == "*" || r == resource) && (a == "*" || a == action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// audience accepts both forms of the aud claim, a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verifier checks access tokens, caching the keys fetched from the JWKS URL.
+type Verifier struct {
+	jwksURL  string
+	issuer   string
+	audience string
+	client   *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+
+	// revoked maps revoked session IDs to when the Verifier learned of them.
+	revokedMu sync.RWMutex
+	revoked   map[string]time.Time
+}
+
+// NewVerifier returns a Verifier that accepts tokens signed by a key published at
+// jwksURL, for the given issuer and audience.
+func NewVerifier(jwksURL, issuer, audience string) *Verifier {
+	return &Verifier{
+		jwksURL:  jwksURL,
+		issuer:   issuer,
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		keys:     make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Verify checks a token's signature, issuer, audience and lifetime, and that its
+// session hasn't been revoked, and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil || header.Algorithm != algorithm {
+		return nil, ErrInvalid
+	}
+
+	pub, err := v.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	if claims.Issuer != v.issuer || !claims.Audience.contains(v.audience) {
+		return nil, ErrInvalid
+	}
+
+	now := time.Now()
+	if now.Add(-leeway).Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	if now.Add(leeway).Unix() < claims.NotBefore {
+		return nil, ErrInvalid
+	}
+
+	if v.isRevoked(claims.SessionID) {
+		return nil, ErrRevoked
+	}
+
+	return &claims, nil
+}
+
+func (v *Verifier) isRevoked(sid string) bool {
+	v.revokedMu.RLock()
+	defer v.revokedMu.RUnlock()
+
+	_, ok := v.revoked[sid]
+	return sid != "" && ok
+}
+
+// Revoke makes Verify reject the access tokens of session sid from now on. It is
+// called for each session.revoked event auth-svc publishes.
+func (v *Verifier) Revoke(sid string) {
+	v.revokedMu.Lock()
+	defer v.revokedMu.Unlock()
+
+	if v.revoked == nil {
+		v.revoked = make(map[string]time.Time)
+	}
+	v.revoked[sid] = time.Now()
+}
+
+// WatchRevocations fetches the sessions revoked in auth-svc from url every
+// RevocationInterval until ctx is done, after which Verify rejects their access tokens.
+// While auth-svc can't be reached the last list fetched is kept. Sessions passed to
+// Revoke are caught up with this way if their event was missed.
+func (v *Verifier) WatchRevocations(ctx context.Context, url string) {
+	ticker := time.NewTicker(RevocationInterval)
+	defer ticker.Stop()
+
+	failing := false
+
+	for {
+		err := v.fetchRevoked(ctx, url)
+		switch {
+		case err != nil && !failing:
+			log.Println("Error fetching revoked sessions:", err)
+			failing = true
+		case err == nil && failing:
+			log.Println("Fetching revoked sessions again")
+			failing = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchRevoked replaces the revoked sessions with the list at url, keeping sessions
+// passed to Revoke since the fetch started, which the list may have missed.
+func (v *Verifier) fetchRevoked(ctx context.Context, url string) error {
+	started := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Data struct {
+			Sessions []string `json:"sessions"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return fmt.Errorf("decoding revoked sessions: %w", err)
+	}
+
+	revoked := make(map[string]time.Time, len(list.Data.Sessions))
+	for _, sid := range list.Data.Sessions {
+		revoked[sid] = started
+	}
+
+	v.revokedMu.Lock()
+	for sid, at := range v.revoked {
+		if at.After(started) {
+			revoked[sid] = at
+		}
+	}
+	v.revoked = revoked
+	v.revokedMu.Unlock()
+
+	return nil
+}
+
+// key returns the public key with the given ID, fetching the JWKS again when the
+// cache is stale or the key is unknown, e.g. because auth-svc rotated its keys.
+func (v *Verifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	pub, ok := v.keys[kid]
+	stale := time.Since(v.fetched) > refreshInterval
+	if ok && !stale {
+		return pub, nil
+	}
+
+	if stale || time.Since(v.fetched) > minRefresh {
+		err := v.fetch(ctx)
+		if err != nil && !ok {
+			return nil, err
+		}
+	}
+
+	pub, ok = v.keys[kid]
+	if !ok {
+		return nil, ErrInvalid
+	}
+
+	return pub, nil
+}
+
+// fetch replaces the cached keys with the current JWKS. The caller must hold v.mu.
+func (v *Verifier) fetch(ctx context.Context) error {
+	v.fetched = time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	v.keys = keys
+
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, v)
+}
+
+// BearerToken returns the token from a request's Authorization header.
+func BearerToken(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", ErrMissingToken
+	}
+
+	return token, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the verified claims.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims stored by NewContext, if any.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
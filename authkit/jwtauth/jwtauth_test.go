@@ -0,0 +1,285 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testKeys serves a JWKS for the keys it holds and signs tokens with them.
+type testKeys struct {
+	keys    map[string]*rsa.PrivateKey
+	fetches atomic.Int32
+}
+
+func (k *testKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.fetches.Add(1)
+
+	type jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range k.keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	json.NewEncoder(w).Encode(set)
+}
+
+func (k *testKeys) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k.keys[kid], crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (k *testKeys) add(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.keys[kid] = key
+}
+
+func validClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   "http://auth-svc",
+		"aud":   "service-hub",
+		"sub":   "42",
+		"email": "user@example.com",
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	keys := &testKeys{keys: make(map[string]*rsa.PrivateKey)}
+	keys.add(t, "first")
+
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	v := NewVerifier(srv.URL, "http://auth-svc", "service-hub")
+	ctx := context.Background()
+
+	claims, err := v.Verify(ctx, keys.sign(t, "first", validClaims()))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "42" || claims.Email != "user@example.com" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = []string{"other"}
+	if _, err := v.Verify(ctx, keys.sign(t, "first", wrongAudience)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected wrong audience to be invalid, got %v", err)
+	}
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	if _, err := v.Verify(ctx, keys.sign(t, "first", expired)); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected expired token, got %v", err)
+	}
+
+	if _, err := v.Verify(ctx, "not.a.token"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected garbage to be invalid, got %v", err)
+	}
+}
+
+func TestVerifyRefetchesRotatedKeys(t *testing.T) {
+	keys := &testKeys{keys: make(map[string]*rsa.PrivateKey)}
+	keys.add(t, "first")
+
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	v := NewVerifier(srv.URL, "http://auth-svc", "service-hub")
+	ctx := context.Background()
+
+	if _, err := v.Verify(ctx, keys.sign(t, "first", validClaims())); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// auth-svc rotates to a new key. The first unknown key ID triggers a fetch once
+	// minRefresh has passed.
+	keys.add(t, "second")
+	v.fetched = time.Now().Add(-time.Minute)
+
+	if _, err := v.Verify(ctx, keys.sign(t, "second", validClaims())); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %v", err)
+	}
+
+	// Unknown key IDs don't cause a fetch on every request.
+	before := keys.fetches.Load()
+	keys.add(t, "third")
+	if _, err := v.Verify(ctx, keys.sign(t, "third", validClaims())); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected key to be unknown until the next refresh, got %v", err)
+	}
+	if keys.fetches.Load() != before {
+		t.Errorf("expected no fetch within minRefresh")
+	}
+}
+
+func TestVerifyRejectsRevokedSessions(t *testing.T) {
+	keys := &testKeys{keys: make(map[string]*rsa.PrivateKey)}
+	keys.add(t, "first")
+
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	revocations := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": false, "data": {"sessions": ["revoked-session"]}}`))
+	}))
+	defer revocations.Close()
+
+	v := NewVerifier(srv.URL, "http://auth-svc", "service-hub")
+	ctx := context.Background()
+
+	revoked := validClaims()
+	revoked["sid"] = "revoked-session"
+	token := keys.sign(t, "first", revoked)
+
+	if _, err := v.Verify(ctx, token); err != nil {
+		t.Fatalf("expected the session to be valid before fetching revocations, got %v", err)
+	}
+
+	err := v.fetchRevoked(ctx, revocations.URL)
+	if err != nil {
+		t.Fatalf("fetchRevoked: %v", err)
+	}
+
+	if _, err := v.Verify(ctx, token); !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected revoked session, got %v", err)
+	}
+
+	other := validClaims()
+	other["sid"] = "other-session"
+	if _, err := v.Verify(ctx, keys.sign(t, "first", other)); err != nil {
+		t.Errorf("expected other sessions to stay valid, got %v", err)
+	}
+	if _, err := v.Verify(ctx, keys.sign(t, "first", validClaims())); err != nil {
+		t.Errorf("expected tokens without a session to stay valid, got %v", err)
+	}
+}
+
+func TestRevokeKeepsSessionsPushedDuringFetch(t *testing.T) {
+	keys := &testKeys{keys: make(map[string]*rsa.PrivateKey)}
+	keys.add(t, "first")
+
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	v := NewVerifier(srv.URL, "http://auth-svc", "service-hub")
+	ctx := context.Background()
+
+	// The event for pushed-session arrives while the list, made before it was
+	// revoked, is on its way.
+	revocations := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.Revoke("pushed-session")
+		w.Write([]byte(`{"error": false, "data": {"sessions": ["listed-session"]}}`))
+	}))
+	defer revocations.Close()
+
+	// auth-svc stops listing a session once its access tokens have expired, so one
+	// revoked before the fetch and missing from the list is dropped.
+	v.Revoke("expired-session")
+
+	err := v.fetchRevoked(ctx, revocations.URL)
+	if err != nil {
+		t.Fatalf("fetchRevoked: %v", err)
+	}
+
+	tests := []struct {
+		sid  string
+		want error
+	}{
+		{"pushed-session", ErrRevoked},
+		{"listed-session", ErrRevoked},
+		{"expired-session", nil},
+	}
+
+	for _, tt := range tests {
+		claims := validClaims()
+		claims["sid"] = tt.sid
+
+		if _, err := v.Verify(ctx, keys.sign(t, "first", claims)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.sid, tt.want, err)
+		}
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		granted    []string
+		permission string
+		want       bool
+	}{
+		{[]string{"*"}, "zerto:delete", true},
+		{[]string{"zerto:delete"}, "zerto:delete", true},
+		{[]string{"zerto:*"}, "zerto:delete", true},
+		{[]string{"*:read"}, "zerto:read", true},
+		{[]string{"*:read"}, "zerto:delete", false},
+		{[]string{"veeam:*"}, "zerto:delete", false},
+		{nil, "zerto:delete", false},
+	}
+
+	for _, tt := range tests {
+		claims := Claims{Permissions: tt.granted}
+		if got := claims.HasPermission(tt.permission); got != tt.want {
+			t.Errorf("%v HasPermission(%q) = %v, want %v", tt.granted, tt.permission, got, tt.want)
+		}
+	}
+}
+
+func TestOrg(t *testing.T) {
+	var claims Claims
+	err := json.Unmarshal([]byte(`{"sub": "42", "orgs": [{"id": 7, "name": "Acme", "vcd": "acme-vcd"}]}`), &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	org, ok := claims.Org(7)
+	if !ok || org.Name != "Acme" || org.VCD != "acme-vcd" || org.Zerto != "" {
+		t.Errorf("expected org 7 with its VCD org, got %+v, %v", org, ok)
+	}
+
+	if _, ok := claims.Org(8); ok {
+		t.Error("expected no org 8")
+	}
+}
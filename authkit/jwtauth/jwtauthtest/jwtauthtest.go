@@ -0,0 +1,89 @@
+// Package jwtauthtest issues access tokens the way auth-svc does, for testing the
+// services that verify them with jwtauth.
+package jwtauthtest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	// Issuer and Audience are the claims tokens are issued with, the defaults the
+	// services expect.
+	Issuer   = "http://auth-svc"
+	Audience = "service-hub"
+
+	keyID = "test"
+)
+
+// Server publishes a key at its JWKS URL and signs tokens with it.
+type Server struct {
+	// JWKSURL is where the key is published, for jwtauth.NewVerifier.
+	JWKSURL string
+
+	key *rsa.PrivateKey
+}
+
+// NewServer starts a Server that is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	return &Server{JWKSURL: srv.URL, key: key}
+}
+
+// Token returns a signed access token with the claims, valid for an hour from now
+// for Issuer and Audience unless the claims say otherwise.
+func (s *Server) Token(t testing.TB, claims map[string]any) string {
+	t.Helper()
+
+	now := time.Now()
+	all := map[string]any{
+		"iss": Issuer,
+		"aud": Audience,
+		"sub": "1",
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		all[name] = value
+	}
+
+	h, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	c, _ := json.Marshal(all)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/cloudkey-io/service-hub/authkit/apikey"
+	"github.com/cloudkey-io/service-hub/authkit/authevents"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
+)
+
+// apiKeyScope is the scope an API key needs to call the broker.
+const apiKeyScope = "broker"
+
+// actionPermissions lists the permission a user's token needs for each action. API
+// keys with the broker scope may use every action.
+var actionPermissions = map[string]string{
+	"log": "logs:write",
+}
+
+var errNotPermitted = errors.New("you don't have permission to do that")
+
+// newVerifier returns a token verifier when AUTH_JWKS_URL is set. Without it token
+// verification is disabled. With AUTH_REVOCATIONS_URL or AUTH_EVENTS_URL set as well,
+// tokens of sessions revoked in auth-svc are rejected.
+func newVerifier() *jwtauth.Verifier {
+	url := os.Getenv("AUTH_JWKS_URL")
+	if url == "" {
+		return nil
+	}
+
+	v := jwtauth.NewVerifier(url, envOrDefault("JWT_ISSUER", "http://auth-svc"), envOrDefault("JWT_AUDIENCE", "service-hub"))
+
+	if revocations := os.Getenv("AUTH_REVOCATIONS_URL"); revocations != "" {
+		go v.WatchRevocations(context.Background(), revocations)
+	}
+	if events := os.Getenv("AUTH_EVENTS_URL"); events != "" {
+		go authevents.WatchRevocations(context.Background(), events, v)
+	}
+
+	return v
+}
+
+// newAPIKeyClient returns an API key client when AUTH_API_KEY_URL is set. Without it
+// API keys are not accepted.
+func newAPIKeyClient() *apikey.Client {
+	url := os.Getenv("AUTH_API_KEY_URL")
+	if url == "" {
+		return nil
+	}
+
+	return apikey.NewClient(url)
+}
+
+// authenticateRequest checks the caller's API key, or failing that its bearer token,
+// and returns a copy of the request carrying the key or the token's claims. Requests
+// pass through unchanged when neither check is enabled.
+func (app *application) authenticateRequest(r *http.Request) (*http.Request, error) {
+	if key := r.Header.Get(apikey.Header); key != "" && app.APIKeys != nil {
+		verified, err := app.APIKeys.Verify(r.Context(), key, apiKeyScope)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.WithContext(apikey.NewContext(r.Context(), verified)), nil
+	}
+
+	if app.Verifier == nil {
+		if app.APIKeys != nil {
+			return nil, apikey.ErrMissing
+		}
+
+		return r, nil
+	}
+
+	token, err := jwtauth.BearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := app.Verifier.Verify(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.WithContext(jwtauth.NewContext(r.Context(), claims)), nil
+}
+
+// authorize checks that the caller authenticated by authenticateRequest may use the
+// permission. Callers with an API key, or any caller when authentication is disabled,
+// are allowed.
+func (app *application) authorize(r *http.Request, permission string) error {
+	claims, ok := jwtauth.FromContext(r.Context())
+	if !ok || claims.HasPermission(permission) {
+		return nil
+	}
+
+	return errNotPermitted
+}
+
+// authorizeTenant checks that the caller authenticated by authenticateRequest may write
+// to tenant, the ID of an organization. Users may only write to the organizations in
+// their token. Callers with an API key, or any caller when authentication is disabled,
+// may write to every tenant.
+func (app *application) authorizeTenant(r *http.Request, tenant string) error {
+	claims, ok := jwtauth.FromContext(r.Context())
+	if !ok || tenant == "" {
+		return nil
+	}
+
+	id, err := strconv.Atoi(tenant)
+	if err != nil {
+		return errNotPermitted
+	}
+	if _, ok := claims.Org(id); !ok {
+		return errNotPermitted
+	}
+
+	return nil
+}
+
+// authError sends a 401 for missing or bad credentials, a 403 for an API key without
+// the broker scope or a user without the permission, and a 502 when auth-svc couldn't be reached to check them.
+func (app *application) authError(w http.ResponseWriter, err error) {
+	w.Header().Add("Vary", "Authorization, "+apikey.Header)
+
+	switch {
+	case errors.Is(err, apikey.ErrForbidden), errors.Is(err, errNotPermitted):
+		app.errorJSON(w, err, http.StatusForbidden)
+	case errors.Is(err, apikey.ErrMissing), errors.Is(err, apikey.ErrInvalid):
+		app.errorJSON(w, err, http.StatusUnauthorized)
+	case errors.Is(err, jwtauth.ErrMissingToken), errors.Is(err, jwtauth.ErrInvalid), errors.Is(err, jwtauth.ErrExpired),
+		errors.Is(err, jwtauth.ErrRevoked):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		app.errorJSON(w, err, http.StatusUnauthorized)
+	default:
+		app.errorJSON(w, err, http.StatusBadGateway)
+	}
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return fallback
+}
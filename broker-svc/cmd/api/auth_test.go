@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth/jwtauthtest"
+)
+
+func TestHandleSubmissionToken(t *testing.T) {
+	issuer := jwtauthtest.NewServer(t)
+
+	app := &application{Verifier: jwtauth.NewVerifier(issuer.JWKSURL, jwtauthtest.Issuer, jwtauthtest.Audience)}
+	// The session was logged out of, or revoked by an admin.
+	app.Verifier.Revoke("revoked-session")
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		// The token is accepted, but doesn't carry logs:write.
+		{"valid", issuer.Token(t, map[string]any{"sid": "other-session"}), http.StatusForbidden},
+		{"revoked session", issuer.Token(t, map[string]any{"sid": "revoked-session", "permissions": []string{"logs:write"}}), http.StatusUnauthorized},
+		{"expired", issuer.Token(t, map[string]any{"exp": 1, "permissions": []string{"logs:write"}}), http.StatusUnauthorized},
+		{"another organization's tenant", issuer.Token(t, map[string]any{"permissions": []string{"logs:write"}, "orgs": []map[string]any{{"id": 8}}}), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/handle", strings.NewReader(`{"action":"log","log":{"name":"event","data":"data","tenant_id":"7"}}`))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			rec := httptest.NewRecorder()
+			app.HandleSubmission(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestAuthorizeTenant(t *testing.T) {
+	user := &jwtauth.Claims{Permissions: []string{"logs:write"}, Orgs: []jwtauth.Org{{ID: 7, Name: "acme"}}}
+
+	tests := []struct {
+		name   string
+		claims *jwtauth.Claims
+		tenant string
+		want   error
+	}{
+		{"user's organization", user, "7", nil},
+		{"another organization", user, "8", errNotPermitted},
+		{"not an organization", user, "acme", errNotPermitted},
+		{"no tenant", user, "", nil},
+		// API keys with the broker scope write for every tenant.
+		{"API key", nil, "8", nil},
+	}
+
+	app := &application{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/handle", nil)
+			if tt.claims != nil {
+				r = r.WithContext(jwtauth.NewContext(r.Context(), tt.claims))
+			}
+
+			if err := app.authorizeTenant(r, tt.tenant); err != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/rpc"
+	"os"
 
 	"github.com/cloudkey-io/service-hub/broker-svc/event"
 )
@@ -14,17 +16,26 @@ import (
 type RequestPayload struct {
 	Action string      `json:"action"`
 	Auth   AuthPayload `json:"auth,omitempty"`
+	MFA    MFAPayload  `json:"mfa,omitempty"`
 	Log    LogPayload  `json:"log,omitempty"`
 }
 
 type AuthPayload struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Factor   string `json:"factor,omitempty"`
+	Passcode string `json:"passcode,omitempty"`
+}
+
+// MFAPayload polls a login that is waiting for a Duo push to be approved.
+type MFAPayload struct {
+	Challenge string `json:"challenge"`
 }
 
 type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json: data`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 // Broker is a test handler, just to make sure we can hit the broker from a web client
@@ -48,10 +59,35 @@ func (app *application) HandleSubmission(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Logging in is the only action that doesn't need an API key or access token.
+	if requestPayload.Action != "auth" && requestPayload.Action != "mfa" {
+		r, err = app.authenticateRequest(r)
+		if err != nil {
+			app.authError(w, err)
+			return
+		}
+
+		if permission, ok := actionPermissions[requestPayload.Action]; ok {
+			err = app.authorize(r, permission)
+			if err != nil {
+				app.authError(w, err)
+				return
+			}
+		}
+	}
+
 	switch requestPayload.Action {
 	case "auth":
-		app.authenticate(w, requestPayload.Auth)
+		app.authenticate(w, r, "http://auth-svc/authenticate", requestPayload.Auth)
+	case "mfa":
+		app.authenticate(w, r, "http://auth-svc/authenticate/mfa", requestPayload.MFA)
 	case "log":
+		err = app.authorizeTenant(r, requestPayload.Log.TenantID)
+		if err != nil {
+			app.authError(w, err)
+			return
+		}
+
 		app.logEventViaRPC(w, requestPayload.Log)
 	// case "log":
 	// 	app.logEventViaRabbitMQ(w, requestPayload.Log)
@@ -75,6 +111,10 @@ func (app *application) logItem(w http.ResponseWriter, entry LogPayload) {
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	// logger-svc only takes entries from callers with a key.
+	if key := os.Getenv("LOG_API_KEY"); key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
 
 	client := &http.Client{}
 
@@ -97,8 +137,11 @@ func (app *application) logItem(w http.ResponseWriter, entry LogPayload) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-// authenticate calls the authentication microservice and sends back the appropriate response
-func (app *application) authenticate(w http.ResponseWriter, a AuthPayload) {
+// authenticate calls the authentication microservice and sends back the appropriate response.
+// Logins waiting on a second factor are relayed with a 200, so the client can poll them
+// with the mfa action. Failed logins keep auth-svc's status, so callers can tell bad
+// credentials (401) from a lockout (429, with Retry-After).
+func (app *application) authenticate(w http.ResponseWriter, r *http.Request, url string, a any) {
 	// create some json we'll send to the auth microservice
 	jsonData, err := json.MarshalIndent(a, "", "\t")
 	if err != nil {
@@ -107,12 +150,17 @@ func (app *application) authenticate(w http.ResponseWriter, a AuthPayload) {
 	}
 
 	// call the service
-	request, err := http.NewRequest("POST", "http://auth-svc/authenticate", bytes.NewBuffer(jsonData))
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	// auth-svc throttles logins per source IP, so pass on who is really calling, and
+	// from which device, which is shown in the user's sessions.
+	request.Header.Set("X-Forwarded-For", forwardedFor(r))
+	request.Header.Set("User-Agent", r.UserAgent())
+
 	client := &http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
@@ -121,15 +169,6 @@ func (app *application) authenticate(w http.ResponseWriter, a AuthPayload) {
 	}
 	defer response.Body.Close()
 
-	// make sure we get back the correct status code
-	if response.StatusCode == http.StatusUnauthorized {
-		app.errorJSON(w, errors.New("invalid credentials"))
-		return
-	} else if response.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, errors.New("invalid credentials"))
-		return
-	}
-
 	// create a variable we'll read response.Body into
 	var jsonFromService jsonResponse
 
@@ -140,8 +179,24 @@ func (app *application) authenticate(w http.ResponseWriter, a AuthPayload) {
 		return
 	}
 
+	// make sure we get back the correct status code
+	switch response.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", response.Header.Get("Retry-After"))
+		app.errorJSON(w, errors.New(jsonFromService.Message), http.StatusTooManyRequests)
+		return
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		// Bad credentials, a denied second factor, or a Duo device still to enroll.
+		app.errorJSON(w, errors.New(jsonFromService.Message), response.StatusCode)
+		return
+	default:
+		app.errorJSON(w, errors.New("error calling auth service"), http.StatusBadGateway)
+		return
+	}
+
 	if jsonFromService.Error {
-		app.errorJSON(w, err, http.StatusUnauthorized)
+		app.errorJSON(w, errors.New(jsonFromService.Message), http.StatusUnauthorized)
 		return
 	}
 
@@ -150,11 +205,31 @@ func (app *application) authenticate(w http.ResponseWriter, a AuthPayload) {
 	payload.Message = "Authenticated!"
 	payload.Data = jsonFromService.Data
 
+	if response.StatusCode == http.StatusOK {
+		payload.Message = jsonFromService.Message
+		app.writeJSON(w, http.StatusOK, payload)
+		return
+	}
+
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
+// forwardedFor appends the caller's address to any X-Forwarded-For header it sent.
+func forwardedFor(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+		return prior + ", " + ip
+	}
+
+	return ip
+}
+
 func (app *application) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
+	err := app.pushToQueue(l)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -167,17 +242,12 @@ func (app *application) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *application) pushToQueue(name, msg string) error {
+func (app *application) pushToQueue(payload LogPayload) error {
 	emitter, err := event.NewEventEmitter(app.Rabbit)
 	if err != nil {
 		return err
 	}
 
-	payload := LogPayload{
-		Name: name,
-		Data: msg,
-	}
-
 	j, _ := json.MarshalIndent(&payload, "", "\t")
 	err = emitter.Push(string(j), "log.INFO")
 	if err != nil {
@@ -188,8 +258,10 @@ func (app *application) pushToQueue(name, msg string) error {
 }
 
 type RPCPayload struct {
-	Name string
-	Data string
+	APIKey   string
+	Name     string
+	Data     string
+	TenantID string
 }
 
 func (app *application) logEventViaRPC(w http.ResponseWriter, l LogPayload) {
@@ -199,9 +271,13 @@ func (app *application) logEventViaRPC(w http.ResponseWriter, l LogPayload) {
 		return
 	}
 
+	// logger-svc only takes entries from callers with a key, and checks the tenant
+	// against it.
 	rpcPayload := RPCPayload{
-		Name: l.Name,
-		Data: l.Data,
+		APIKey:   os.Getenv("LOG_API_KEY"),
+		Name:     l.Name,
+		Data:     l.Data,
+		TenantID: l.TenantID,
 	}
 
 	var result string
@@ -9,12 +9,17 @@ import (
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/cloudkey-io/service-hub/authkit/apikey"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
 )
 
 const port = "80"
 
 type application struct {
-	Rabbit *amqp.Connection
+	Rabbit   *amqp.Connection
+	Verifier *jwtauth.Verifier
+	APIKeys  *apikey.Client
 }
 
 func main() {
@@ -27,7 +32,9 @@ func main() {
 	defer rabbitConn.Close()
 
 	app := application{
-		Rabbit: rabbitConn,
+		Rabbit:   rabbitConn,
+		Verifier: newVerifier(),
+		APIKeys:  newAPIKeyClient(),
 	}
 
 	log.Printf("Server starting on port %s", port)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -38,8 +39,9 @@ func (consumer *Consumer) setup() error {
 }
 
 type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 func (consumer *Consumer) Listen(topics []string) error {
@@ -113,6 +115,10 @@ func logEvent(entry Payload) error {
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	// logger-svc only takes entries from callers with a key.
+	if key := os.Getenv("LOG_API_KEY"); key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
 
 	client := &http.Client{}
 
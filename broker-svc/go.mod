@@ -3,7 +3,10 @@ module github.com/cloudkey-io/service-hub/broker-svc
 go 1.22.2
 
 require (
-	github.com/go-chi/chi/v5 v5.0.12 // indirect
-	github.com/go-chi/cors v1.2.1 // indirect
-	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+	github.com/cloudkey-io/service-hub/authkit v0.0.0
+	github.com/go-chi/chi/v5 v5.0.12
+	github.com/go-chi/cors v1.2.1
+	github.com/rabbitmq/amqp091-go v1.10.0
 )
+
+replace github.com/cloudkey-io/service-hub/authkit => ../authkit
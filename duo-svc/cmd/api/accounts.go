@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Cloudkey-io/service-hub/duo-svc/duo"
+)
+
+// "POST /v1/accounts" endpoint. Creates a Duo subaccount for a tenant. A subaccount
+// with the same name is returned as it is, so a provisioning run that is retried
+// doesn't create a second one.
+func (app *application) createAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		app.failedValidationResponse(w, r, map[string]string{"name": "must be provided"})
+		return
+	}
+
+	accounts, err := app.duoClient.ListAccounts()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, account := range accounts.Response {
+		if account.Name == input.Name {
+			err = app.writeJSON(w, http.StatusOK, envelope{"account": account}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	res, err := app.duoClient.CreateAccount(duo.Account{Name: input.Name})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.Info("duo account created", "name", res.Response.Name, "account_id", res.Response.AccountId)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"account": res.Response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// "DELETE /v1/accounts/{id}" endpoint. Deletes a Duo subaccount by its account ID.
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := r.PathValue("id")
+
+	err := app.duoClient.DeleteAccount(accountID)
+	if err != nil {
+		switch {
+		case errors.Is(err, duo.ErrAccountNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Info("duo account deleted", "account_id", accountID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "account successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/Cloudkey-io/service-hub/duo-svc/duo"
+)
+
+// "POST /v1/auth/preauth" endpoint. Tells the caller whether a user has to complete a
+// second factor, and which devices they can use for it.
+func (app *application) preauthHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Username string `json:"username"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Username == "" {
+		app.failedValidationResponse(w, r, map[string]string{"username": "must be provided"})
+		return
+	}
+
+	res, err := app.authClient.Preauth(input.Username)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"preauth": res.Response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// "POST /v1/auth/auth" endpoint. Passcodes are checked straight away, pushes are sent
+// async and return a txid to poll with the auth_status endpoint.
+func (app *application) authHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Username string `json:"username"`
+		Factor   string `json:"factor"`
+		Device   string `json:"device"`
+		Passcode string `json:"passcode"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	errs := make(map[string]string)
+	if input.Username == "" {
+		errs["username"] = "must be provided"
+	}
+	if !slices.Contains([]string{duo.FactorPush, duo.FactorPasscode}, input.Factor) {
+		errs["factor"] = "must be push or passcode"
+	}
+	if input.Factor == duo.FactorPasscode && input.Passcode == "" {
+		errs["passcode"] = "must be provided"
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	res, err := app.authClient.Auth(duo.AuthRequest{
+		Username: input.Username,
+		Factor:   input.Factor,
+		Device:   input.Device,
+		Passcode: input.Passcode,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"auth": res.Response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// "GET /v1/auth/auth_status?txid=" endpoint. Reports whether an async push has been
+// approved, denied, or is still waiting on the user.
+func (app *application) authStatusHandler(w http.ResponseWriter, r *http.Request) {
+	txid := r.URL.Query().Get("txid")
+	if txid == "" {
+		app.badRequestResponse(w, r, errors.New("txid must be provided"))
+		return
+	}
+
+	res, err := app.authClient.AuthStatus(txid)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"auth": res.Response}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -11,6 +12,9 @@ import (
 	"time"
 
 	"github.com/Cloudkey-io/service-hub/duo-svc/duo"
+	"github.com/cloudkey-io/service-hub/authkit/apikey"
+	"github.com/cloudkey-io/service-hub/authkit/authevents"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
 )
 
 const version = "0.1.5"
@@ -20,12 +24,27 @@ type config struct {
 	env    string
 	useTLS bool
 	useLog bool
+	// apiKeyURL is auth-svc's API key verify endpoint.
+	apiKeyURL string
+	jwt       struct {
+		jwksURL  string
+		issuer   string
+		audience string
+		// revocationsURL lists the sessions revoked in auth-svc.
+		revocationsURL string
+		// eventsURL is the RabbitMQ auth-svc publishes session revocations to.
+		eventsURL string
+	}
 }
 
 type application struct {
 	config    config
 	logger    *slog.Logger
 	duoClient *duo.Client
+	verifier  *jwtauth.Verifier
+	// authClient talks to the Duo Auth API, which uses its own application keys.
+	authClient *duo.Client
+	apiKeys    *apikey.Client
 }
 
 func main() {
@@ -48,6 +67,18 @@ func main() {
 	// Defaults to false, use true for production.
 	flag.BoolVar(&cfg.useLog, "log", false, "Enable log file (true|false)")
 
+	// Access tokens issued by auth-svc are verified against the keys published at
+	// jwks-url. Leaving it empty disables token verification.
+	flag.StringVar(&cfg.jwt.jwksURL, "jwks-url", os.Getenv("AUTH_JWKS_URL"), "auth-svc JWKS URL, enables access token verification")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "http://auth-svc", "Expected access token issuer")
+	flag.StringVar(&cfg.jwt.audience, "jwt-audience", "service-hub", "Expected access token audience")
+	flag.StringVar(&cfg.jwt.revocationsURL, "revocations-url", os.Getenv("AUTH_REVOCATIONS_URL"), "auth-svc revoked sessions URL, rejects tokens of revoked sessions")
+	flag.StringVar(&cfg.jwt.eventsURL, "auth-events-url", os.Getenv("AUTH_EVENTS_URL"), "RabbitMQ URL auth-svc publishes to, rejects tokens of revoked sessions as they are revoked")
+
+	// The Duo Auth endpoints are called by auth-svc with an API key, which is checked
+	// against auth-svc. Leaving it empty disables API keys.
+	flag.StringVar(&cfg.apiKeyURL, "api-key-url", os.Getenv("AUTH_API_KEY_URL"), "auth-svc API key verify URL, enables API keys")
+
 	// We need to parse all CLI flags in order to use them as well.
 	flag.Parse()
 
@@ -75,10 +106,40 @@ func main() {
 
 	duoClient := duo.New(*duoApi)
 
+	// The Auth API belongs to a separate Duo application. Fall back to the Admin API
+	// keys when its own aren't set.
+	authClient := duoClient
+	if ikey := os.Getenv("DUO_AUTH_IKEY"); ikey != "" {
+		authApi := duo.NewDuoApi(
+			ikey,
+			os.Getenv("DUO_AUTH_SKEY"),
+			envOrDefault("DUO_AUTH_HOST", os.Getenv("DUO_HOST")),
+			"duo-svc",
+			duo.SetTimeout(30*time.Second),
+		)
+		authClient = duo.New(*authApi)
+	}
+
 	app := &application{
-		config:    cfg,
-		logger:    logger,
-		duoClient: duoClient,
+		config:     cfg,
+		logger:     logger,
+		duoClient:  duoClient,
+		authClient: authClient,
+	}
+
+	if cfg.apiKeyURL != "" {
+		app.apiKeys = apikey.NewClient(cfg.apiKeyURL)
+	}
+
+	if cfg.jwt.jwksURL != "" {
+		app.verifier = jwtauth.NewVerifier(cfg.jwt.jwksURL, cfg.jwt.issuer, cfg.jwt.audience)
+
+		if cfg.jwt.revocationsURL != "" {
+			go app.verifier.WatchRevocations(context.Background(), cfg.jwt.revocationsURL)
+		}
+		if cfg.jwt.eventsURL != "" {
+			go authevents.WatchRevocations(context.Background(), cfg.jwt.eventsURL, app.verifier)
+		}
 	}
 
 	// TLS Config is set up for modern web, maybe remove some of these settings if needed.
@@ -147,6 +208,14 @@ func setupLogger(useLog bool) io.Writer {
 	return logWriter
 }
 
+// envOrDefault returns the environment variable key, or fallback when it's unset.
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
 // // Loads environment variables from a file, use typical UNIX format in .env file.
 // func loadEnv(filename string) error {
 // 	// Open the file
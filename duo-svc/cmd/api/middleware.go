@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/cloudkey-io/service-hub/authkit/apikey"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
 )
 
 // Sets headers for incoming requests, we can set these as environment variables for the server
@@ -57,3 +61,80 @@ func (app *application) gracefulRecovery(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Rejects requests without a valid access token from auth-svc, and stores the token's
+// claims in the request context. Requests pass straight through when no JWKS URL is
+// configured.
+func (app *application) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.verifier == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Authorization")
+
+		token, err := jwtauth.BearerToken(r)
+		if err != nil {
+			app.invalidTokenResponse(w, r, err)
+			return
+		}
+
+		claims, err := app.verifier.Verify(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, jwtauth.ErrInvalid) || errors.Is(err, jwtauth.ErrExpired) || errors.Is(err, jwtauth.ErrRevoked) {
+				app.invalidTokenResponse(w, r, err)
+				return
+			}
+
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), claims)))
+	})
+}
+
+// Wraps a handler so only callers whose access token carries the given permission, in
+// "resource:action" form, can reach it. Runs after requireToken, so when token
+// verification is disabled there are no claims and every request is allowed.
+func (app *application) requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := jwtauth.FromContext(r.Context())
+		if ok && !claims.HasPermission(permission) {
+			app.notPermittedResponse(w, r, permission)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// The scope an API key needs to call the Duo Auth and account endpoints.
+const apiKeyScope = "duo"
+
+// Wraps a handler so only machine callers with an API key carrying the duo scope, such
+// as auth-svc and hostbill-svc, can reach it. Requests pass straight through when no API key verify URL
+// is configured.
+func (app *application) requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.apiKeys == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", apikey.Header)
+
+		verified, err := app.apiKeys.Verify(r.Context(), r.Header.Get(apikey.Header), apiKeyScope)
+		switch {
+		case errors.Is(err, apikey.ErrForbidden):
+			app.errorResponse(w, r, http.StatusForbidden, err.Error())
+		case errors.Is(err, apikey.ErrMissing), errors.Is(err, apikey.ErrInvalid):
+			app.errorResponse(w, r, http.StatusUnauthorized, err.Error())
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+		default:
+			next.ServeHTTP(w, r.WithContext(apikey.NewContext(r.Context(), verified)))
+		}
+	}
+}
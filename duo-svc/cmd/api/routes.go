@@ -11,8 +11,24 @@ func (app *application) routes() http.Handler {
 	// Register the relevant methods, URL patterns and handler functions for our
 	// endpoints using the HandleFunc() method.
 	mux.HandleFunc("GET /v1/healthcheck", app.healthcheckHandler)
-	mux.HandleFunc("POST /v1/sso", app.createSsoHandler)
-	mux.HandleFunc("GET /v1/sso/{id}", app.showSsoHandler)
+
+	// Second factor checks for auth-svc logins, which authenticate with an API key
+	// rather than an access token.
+	mux.HandleFunc("POST /v1/auth/preauth", app.requireAPIKey(app.preauthHandler))
+	mux.HandleFunc("POST /v1/auth/auth", app.requireAPIKey(app.authHandler))
+	mux.HandleFunc("GET /v1/auth/auth_status", app.requireAPIKey(app.authStatusHandler))
+
+	// Subaccounts are created and deleted by hostbill-svc when it provisions tenants,
+	// also with an API key.
+	mux.HandleFunc("POST /v1/accounts", app.requireAPIKey(app.createAccountHandler))
+	mux.HandleFunc("DELETE /v1/accounts/{id}", app.requireAPIKey(app.deleteAccountHandler))
+
+	// Every other endpoint needs an access token from auth-svc.
+	api := http.NewServeMux()
+	mux.Handle("/v1/", app.requireToken(api))
+
+	api.HandleFunc("POST /v1/sso", app.requirePermission("sso:create", app.createSsoHandler))
+	api.HandleFunc("GET /v1/sso/{id}", app.requirePermission("sso:read", app.showSsoHandler))
 
 	// Return mux router with middleware.
 	return app.gracefulRecovery(app.logRequest((commonHeaders(mux))))
@@ -0,0 +1,169 @@
+package duo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Auth API results. Preauth can return any of them, auth and auth_status only return
+// allow, deny or waiting.
+const (
+	ResultAuth    = "auth"
+	ResultAllow   = "allow"
+	ResultDeny    = "deny"
+	ResultEnroll  = "enroll"
+	ResultWaiting = "waiting"
+)
+
+// Factors supported by Auth.
+const (
+	FactorPush     = "push"
+	FactorPasscode = "passcode"
+)
+
+// Device is one of a user's enrolled devices, as returned by preauth.
+type Device struct {
+	Device       string   `json:"device"`
+	Type         string   `json:"type"`
+	DisplayName  string   `json:"display_name"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// PreauthResponse says whether a user may, must or can't authenticate, and with which
+// devices.
+type PreauthResponse struct {
+	Result    string   `json:"result"`
+	StatusMsg string   `json:"status_msg"`
+	Devices   []Device `json:"devices"`
+}
+
+// PreauthResult models the response of the preauth endpoint.
+type PreauthResult struct {
+	StatResult
+	Response PreauthResponse
+}
+
+// AuthResponse is the outcome of an authentication. Async push requests only carry a
+// Txid, which is then polled with AuthStatus.
+type AuthResponse struct {
+	Result    string `json:"result"`
+	Status    string `json:"status"`
+	StatusMsg string `json:"status_msg"`
+	Txid      string `json:"txid"`
+}
+
+// AuthResult models the response of the auth and auth_status endpoints.
+type AuthResult struct {
+	StatResult
+	Response AuthResponse
+}
+
+// AuthRequest describes a second factor authentication. Device defaults to "auto" and
+// Passcode is only used with FactorPasscode. Push requests are always sent async.
+type AuthRequest struct {
+	Username string
+	Factor   string
+	Device   string
+	Passcode string
+}
+
+// Preauth checks whether username needs to authenticate, is allowed through without
+// it, is denied, or still has to enroll.
+func (c *Client) Preauth(username string) (*PreauthResult, error) {
+	params := url.Values{}
+	params.Set("username", username)
+
+	res := &PreauthResult{}
+	err := c.authCall(http.MethodPost, "/auth/v2/preauth", params, res, &res.StatResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Auth starts a second factor authentication. Passcodes are checked straight away,
+// while pushes return a transaction ID to poll with AuthStatus.
+func (c *Client) Auth(req AuthRequest) (*AuthResult, error) {
+	params := url.Values{}
+	params.Set("username", req.Username)
+	params.Set("factor", req.Factor)
+
+	switch req.Factor {
+	case FactorPush:
+		device := req.Device
+		if device == "" {
+			device = "auto"
+		}
+		params.Set("device", device)
+		params.Set("async", "1")
+	case FactorPasscode:
+		if req.Passcode == "" {
+			return nil, errors.New("passcode is required")
+		}
+		params.Set("passcode", req.Passcode)
+	default:
+		return nil, fmt.Errorf("unsupported factor %q", req.Factor)
+	}
+
+	res := &AuthResult{}
+	err := c.authCall(http.MethodPost, "/auth/v2/auth", params, res, &res.StatResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AuthStatus returns the state of an async authentication started by Auth.
+func (c *Client) AuthStatus(txid string) (*AuthResult, error) {
+	params := url.Values{}
+	params.Set("txid", txid)
+
+	res := &AuthResult{}
+	err := c.authCall(http.MethodGet, "/auth/v2/auth_status", params, res, &res.StatResult)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// authCall makes a signed Auth API call, decodes the body into res, and turns a FAIL
+// stat into an error.
+func (c *Client) authCall(method, path string, params url.Values, res any, stat *StatResult) error {
+	_, body, err := c.SignedCall(method, path, params, UseTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, res)
+	if err != nil {
+		return err
+	}
+
+	if stat.Stat != "OK" {
+		return stat.error()
+	}
+
+	return nil
+}
+
+// error describes a FAIL response.
+func (s *StatResult) error() error {
+	msg := "unknown error"
+	if s.Message != nil {
+		msg = *s.Message
+	}
+	if s.Message_Detail != nil {
+		msg += ": " + *s.Message_Detail
+	}
+	if s.Code != nil {
+		return fmt.Errorf("duo: %d %s", *s.Code, msg)
+	}
+
+	return fmt.Errorf("duo: %s", msg)
+}
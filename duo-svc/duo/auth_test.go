@@ -0,0 +1,159 @@
+package duo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeDuo is a minimal stand in for the Duo Auth API. alice has to authenticate and
+// approves pushes, bob is allowed through, and everyone else must enroll.
+func fakeDuo(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	reply := func(w http.ResponseWriter, response any) {
+		json.NewEncoder(w).Encode(map[string]any{"stat": "OK", "response": response})
+	}
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Basic ") || r.Header.Get("Date") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"stat": "FAIL", "code": 40101, "message": "Missing request credentials"})
+			return
+		}
+
+		r.ParseForm()
+
+		switch r.URL.Path {
+		case "/auth/v2/preauth":
+			switch r.Form.Get("username") {
+			case "alice":
+				reply(w, map[string]any{
+					"result":     ResultAuth,
+					"status_msg": "Account is active",
+					"devices": []map[string]any{
+						{"device": "DPFZRS9FB0D46QFTM891", "type": "phone", "capabilities": []string{"push", "sms"}},
+					},
+				})
+			case "bob":
+				reply(w, map[string]any{"result": ResultAllow, "status_msg": "Allowing unknown user"})
+			default:
+				reply(w, map[string]any{"result": ResultEnroll, "status_msg": "Enroll an authentication device to proceed"})
+			}
+
+		case "/auth/v2/auth":
+			switch r.Form.Get("factor") {
+			case FactorPush:
+				if r.Form.Get("async") != "1" || r.Form.Get("device") != "auto" {
+					t.Errorf("expected an async push to the auto device, got %v", r.Form)
+				}
+				reply(w, map[string]any{"txid": "45f7c92b-f45f-4862-8545-e0f58e78075a"})
+			case FactorPasscode:
+				result := ResultDeny
+				if r.Form.Get("passcode") == "123456" {
+					result = ResultAllow
+				}
+				reply(w, map[string]any{"result": result, "status": result, "status_msg": "Passcode checked"})
+			}
+
+		case "/auth/v2/auth_status":
+			if r.Method != http.MethodGet || r.Form.Get("txid") != "45f7c92b-f45f-4862-8545-e0f58e78075a" {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]any{"stat": "FAIL", "code": 40401, "message": "Resource not found"})
+				return
+			}
+			reply(w, map[string]any{"result": ResultAllow, "status": "allow", "status_msg": "Success. Logging you in..."})
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestPreauth(t *testing.T) {
+	ts := fakeDuo(t)
+	defer ts.Close()
+
+	duo := buildAdminClient(ts.URL, nil)
+
+	tests := map[string]string{
+		"alice": ResultAuth,
+		"bob":   ResultAllow,
+		"carol": ResultEnroll,
+	}
+
+	for username, want := range tests {
+		res, err := duo.Preauth(username)
+		if err != nil {
+			t.Fatalf("Preauth(%s): %v", username, err)
+		}
+		if res.Response.Result != want {
+			t.Errorf("Preauth(%s): expected %s, got %s", username, want, res.Response.Result)
+		}
+	}
+
+	res, _ := duo.Preauth("alice")
+	if len(res.Response.Devices) != 1 || res.Response.Devices[0].Capabilities[0] != "push" {
+		t.Errorf("expected alice's phone, got %+v", res.Response.Devices)
+	}
+}
+
+func TestAuthPush(t *testing.T) {
+	ts := fakeDuo(t)
+	defer ts.Close()
+
+	duo := buildAdminClient(ts.URL, nil)
+
+	res, err := duo.Auth(AuthRequest{Username: "alice", Factor: FactorPush})
+	if err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	if res.Response.Txid == "" {
+		t.Fatal("expected a transaction ID for an async push")
+	}
+
+	status, err := duo.AuthStatus(res.Response.Txid)
+	if err != nil {
+		t.Fatalf("AuthStatus: %v", err)
+	}
+	if status.Response.Result != ResultAllow {
+		t.Errorf("expected the push to be approved, got %s", status.Response.Result)
+	}
+
+	_, err = duo.AuthStatus("unknown")
+	if err == nil || !strings.Contains(err.Error(), "40401") {
+		t.Errorf("expected a FAIL response to become an error, got %v", err)
+	}
+}
+
+func TestAuthPasscode(t *testing.T) {
+	ts := fakeDuo(t)
+	defer ts.Close()
+
+	duo := buildAdminClient(ts.URL, nil)
+
+	res, err := duo.Auth(AuthRequest{Username: "alice", Factor: FactorPasscode, Passcode: "123456"})
+	if err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	if res.Response.Result != ResultAllow {
+		t.Errorf("expected the right passcode to be allowed, got %s", res.Response.Result)
+	}
+
+	res, _ = duo.Auth(AuthRequest{Username: "alice", Factor: FactorPasscode, Passcode: "000000"})
+	if res.Response.Result != ResultDeny {
+		t.Errorf("expected the wrong passcode to be denied, got %s", res.Response.Result)
+	}
+
+	_, err = duo.Auth(AuthRequest{Username: "alice", Factor: FactorPasscode})
+	if err == nil {
+		t.Error("expected an error without a passcode")
+	}
+
+	_, err = duo.Auth(AuthRequest{Username: "alice", Factor: "sms"})
+	if err == nil {
+		t.Error("expected an error for an unsupported factor")
+	}
+}
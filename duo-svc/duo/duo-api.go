@@ -488,9 +488,11 @@ func New(base DuoApi) *Client {
 }
 
 // Account type represents a Duo subaccount, which maps to VCD/Zerto organizations.
+// ApiHostname is the API host the subaccount's own integrations use.
 type Account struct {
-	Name      string `json:"name"`
-	AccountId string `json:"account_id"`
+	Name        string `json:"name"`
+	AccountId   string `json:"account_id"`
+	ApiHostname string `json:"api_hostname,omitempty"`
 }
 
 // GetAccountResult models responses containing a single account.
@@ -499,6 +501,15 @@ type GetAccountResult struct {
 	Response Account
 }
 
+// GetAccountsResult models responses containing a list of accounts.
+type GetAccountsResult struct {
+	StatResult
+	Response []Account
+}
+
+// ErrAccountNotFound is returned when deleting a subaccount Duo doesn't know.
+var ErrAccountNotFound = errors.New("duo: account not found")
+
 // Common URL options
 
 // Limit sets the optional limit parameter for an API request.
@@ -563,21 +574,59 @@ func (c *Client) retrieveItems(
 //		"name":         name,
 //	}
 //	CreateAccount(testName string)
-func (c *Client) createAccount(a Account) (*GetAccountResult, error) {
-	path := "/accounts/v1/account/create"
+func (c *Client) CreateAccount(a Account) (*GetAccountResult, error) {
 	params := JSONParams{
 		"name": a.Name,
 	}
 
-	_, body, err := c.JSONSignedCall(http.MethodPost, path, params, UseTimeout)
+	res := &GetAccountResult{}
+	err := c.accountsCall("/accounts/v1/account/create", params, res, &res.StatResult)
 	if err != nil {
 		return nil, err
 	}
+	return res, nil
+}
 
-	res := &GetAccountResult{}
-	err = json.Unmarshal(body, res)
+// ListAccounts returns every subaccount of the parent account.
+func (c *Client) ListAccounts() (*GetAccountsResult, error) {
+	res := &GetAccountsResult{}
+	err := c.accountsCall("/accounts/v1/account/list", JSONParams{}, res, &res.StatResult)
 	if err != nil {
 		return nil, err
 	}
 	return res, nil
 }
+
+// DeleteAccount deletes a subaccount, with all of its users and integrations.
+func (c *Client) DeleteAccount(accountID string) error {
+	params := JSONParams{
+		"account_id": accountID,
+	}
+
+	res := &StatResult{}
+	err := c.accountsCall("/accounts/v1/account/delete", params, res, res)
+	if err != nil && res.Code != nil && *res.Code/100 == http.StatusNotFound {
+		return ErrAccountNotFound
+	}
+	return err
+}
+
+// accountsCall makes a signed Accounts API call, decodes the body into res, and turns a
+// FAIL stat into an error.
+func (c *Client) accountsCall(path string, params JSONParams, res any, stat *StatResult) error {
+	_, body, err := c.JSONSignedCall(http.MethodPost, path, params, UseTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, res)
+	if err != nil {
+		return err
+	}
+
+	if stat.Stat != "OK" {
+		return stat.error()
+	}
+
+	return nil
+}
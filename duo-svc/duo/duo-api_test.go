@@ -2,6 +2,7 @@ package duo
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -451,7 +452,8 @@ func getBodyParams(r *http.Request) (url.Values, error) {
 const createAccountResponse = `{
 	"stat": "OK",
 	"response": {
-		"name": null,
+		"name": "testing boii account",
+		"account_id": "DA9VZOC5X260DDV9Y7V3"
 	}
 }`
 
@@ -469,9 +471,9 @@ func TestCreateAccount(t *testing.T) {
 		Name: "testing boii account",
 	}
 
-	result, err := duo.createAccount(accountToCreate)
+	result, err := duo.CreateAccount(accountToCreate)
 	if err != nil {
-		t.Errorf("Unexpected error from createAccount call %v", err.Error())
+		t.Fatalf("Unexpected error from createAccount call %v", err.Error())
 	}
 	if result.Stat != "OK" {
 		t.Errorf("Expected OK, but got %s", result.Stat)
@@ -480,3 +482,69 @@ func TestCreateAccount(t *testing.T) {
 		t.Errorf("Expected Username to be %s, but got %s", accountToCreate.Name, result.Response.Name)
 	}
 }
+
+const listAccountsResponse = `{
+	"stat": "OK",
+	"response": [{
+		"name": "acme",
+		"account_id": "DA9VZOC5X260DDV9Y7V3",
+		"api_hostname": "api-abcdef12.duosecurity.com"
+	}]
+}`
+
+func TestListAccounts(t *testing.T) {
+	ts := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/accounts/v1/account/list" {
+				t.Errorf("Expected /accounts/v1/account/list, but got %s", r.URL.Path)
+			}
+			fmt.Fprintln(w, listAccountsResponse)
+		}),
+	)
+	defer ts.Close()
+
+	duo := buildAdminClient(ts.URL, nil)
+
+	result, err := duo.ListAccounts()
+	if err != nil {
+		t.Fatalf("Unexpected error from ListAccounts call %v", err.Error())
+	}
+	if len(result.Response) != 1 || result.Response[0].AccountId != "DA9VZOC5X260DDV9Y7V3" {
+		t.Errorf("Expected account DA9VZOC5X260DDV9Y7V3, but got %+v", result.Response)
+	}
+	if result.Response[0].ApiHostname != "api-abcdef12.duosecurity.com" {
+		t.Errorf("Expected api_hostname to be decoded, but got %q", result.Response[0].ApiHostname)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	ts := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var params map[string]string
+			err := json.NewDecoder(r.Body).Decode(&params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if params["account_id"] != "DA9VZOC5X260DDV9Y7V3" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintln(w, `{"stat": "FAIL", "code": 40401, "message": "Resource not found"}`)
+				return
+			}
+			fmt.Fprintln(w, `{"stat": "OK", "response": ""}`)
+		}),
+	)
+	defer ts.Close()
+
+	duo := buildAdminClient(ts.URL, nil)
+
+	err := duo.DeleteAccount("DA9VZOC5X260DDV9Y7V3")
+	if err != nil {
+		t.Fatalf("Unexpected error from DeleteAccount call %v", err.Error())
+	}
+
+	err = duo.DeleteAccount("DAUNKNOWN")
+	if !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("Expected ErrAccountNotFound, but got %v", err)
+	}
+}
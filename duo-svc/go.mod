@@ -1,3 +1,9 @@
 module github.com/Cloudkey-io/service-hub/duo-svc
 
 go 1.22.2
+
+require github.com/cloudkey-io/service-hub/authkit v0.0.0
+
+require github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+
+replace github.com/cloudkey-io/service-hub/authkit => ../authkit
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+type config struct {
+	port   int
+	env    string
+	useTLS bool
+	useLog bool
+	// file is the optional file of KEY=value settings, see loadConfig.
+	file string
+	// checkConfig prints the configuration and exits.
+	checkConfig bool
+	jwt         struct {
+		jwksURL  string
+		issuer   string
+		audience string
+		// revocationsURL lists the sessions revoked in auth-svc.
+		revocationsURL string
+		// eventsURL is the RabbitMQ auth-svc publishes session revocations to.
+		eventsURL string
+	}
+	apiKeyURL string
+	// noAuth serves every request without authentication, in development only.
+	noAuth bool
+	// veeamDeleteGrace is how long an archived Veeam organization is kept before it
+	// can be deleted.
+	veeamDeleteGrace time.Duration
+	// zertoDeleteGrace is the same for archived ZORGs.
+	zertoDeleteGrace time.Duration
+	// vendorDebug logs every vendor request and response, with secrets redacted.
+	vendorDebug bool
+	vcd         struct {
+		vendorConfig
+		username        string
+		password        string
+		apiVersion      string
+		providerVDC     string
+		networkPool     string
+		storagePolicy   string
+		externalNetwork string
+		adminRole       string
+	}
+	veeam struct {
+		vendorConfig
+		sessionPath     string
+		orgConfigsPath  string
+		username        string
+		password        string
+		backupServerUID string
+		repositoryUID   string
+		hostUID         string
+		// repositoryName is what new organizations see their repository called.
+		repositoryName string
+	}
+	zerto struct {
+		vendorConfig
+		sessionPath string
+		zorgsPath   string
+		username    string
+		password    string
+	}
+	// duo is duo-svc, which manages Duo subaccounts.
+	duo struct {
+		vendorConfig
+		apiKey string
+	}
+	// tenantStateDir is where the state of tenant provisioning workflows is kept.
+	tenantStateDir string
+	// dsn is the Postgres database of the tenant inventory.
+	dsn string
+	// secrets are the settings that aren't flags, which printConfig redacts.
+	secrets []secret
+}
+
+// vendorConfig is how to reach a vendor's appliance, and how far to trust it.
+type vendorConfig struct {
+	url       string
+	caFile    string
+	pinSHA256 string
+	insecure  bool
+	timeout   time.Duration
+}
+
+// secret is a setting that is only read from the environment, or from the file named
+// by <env>_FILE, such as a Docker or Kubernetes secret. It is never printed.
+type secret struct {
+	name  string
+	env   string
+	value *string
+}
+
+// settings binds flags to the environment variables they default to.
+type settings struct {
+	env     map[string]string
+	secrets []secret
+}
+
+// stringVar defines a string flag that defaults to the environment variable env.
+func (s *settings) stringVar(p *string, name, env, def, usage string) {
+	flag.StringVar(p, name, def, usage+" ("+env+")")
+	s.env[name] = env
+}
+
+// boolVar defines a bool flag that defaults to the environment variable env.
+func (s *settings) boolVar(p *bool, name, env string, def bool, usage string) {
+	flag.BoolVar(p, name, def, usage+" ("+env+")")
+	s.env[name] = env
+}
+
+// durationVar defines a duration flag that defaults to the environment variable env.
+func (s *settings) durationVar(p *time.Duration, name, env string, def time.Duration, usage string) {
+	flag.DurationVar(p, name, def, usage+" ("+env+")")
+	s.env[name] = env
+}
+
+// secretVar registers the secret read from env, called name in printConfig.
+func (s *settings) secretVar(p *string, name, env string) {
+	s.secrets = append(s.secrets, secret{name: name, env: env, value: p})
+}
+
+// vendorVars defines the connection flags of a vendor, -<name>-url and so on, with
+// defaults from <env>_URL and so on.
+func (s *settings) vendorVars(v *vendorConfig, name, env string) {
+	s.stringVar(&v.url, name+"-url", env+"_URL", "", name+" base URL, enables "+name)
+	s.stringVar(&v.caFile, name+"-ca-file", env+"_CA_FILE", "", "PEM bundle of the CAs trusted for "+name+", instead of the system's")
+	s.stringVar(&v.pinSHA256, name+"-pin-sha256", env+"_PIN_SHA256", "", "SHA-256 of the only "+name+" certificate accepted")
+	s.boolVar(&v.insecure, name+"-insecure", env+"_INSECURE", false, "Skip "+name+" certificate checks, for labs only")
+	s.durationVar(&v.timeout, name+"-timeout", env+"_TIMEOUT", 30*time.Second, "Timeout of each "+name+" request")
+}
+
+// loadConfig reads the configuration from the command line flags, then from the
+// environment for flags that weren't given, then from the file named by -config or
+// HOSTBILL_CONFIG for variables that aren't in the environment. The file has one
+// KEY=value per line, with the same names as the environment, as in an .envrc.
+func loadConfig() (config, error) {
+	var cfg config
+	s := &settings{env: map[string]string{}}
+
+	// port defines the port number for the API server.
+	// Defaults to 80 if not provided via CLI.
+	flag.IntVar(&cfg.port, "port", 80, "API server port")
+
+	// env represents the current environment the application is running in.
+	// Valid values are: development, staging, production.
+	// Defaults to "development" if not set via CLI.
+	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+
+	// Boolean useTLS gives the option to enable TLS.
+	// Defaults to false, use true for production.
+	flag.BoolVar(&cfg.useTLS, "tls", false, "Enable TLS (true|false)")
+
+	// Boolean useLog gives the option to enable logging to a file, as well as the usual stdout.
+	// Defaults to false, use true for production.
+	flag.BoolVar(&cfg.useLog, "log", false, "Enable log file (true|false)")
+
+	flag.StringVar(&cfg.file, "config", os.Getenv("HOSTBILL_CONFIG"), "File of KEY=value settings read after the environment (HOSTBILL_CONFIG)")
+	flag.BoolVar(&cfg.checkConfig, "check-config", false, "Print the configuration, with secrets redacted, check it and exit")
+
+	// Access tokens issued by auth-svc are verified against the keys published at
+	// jwks-url. Leaving it empty disables token verification.
+	s.stringVar(&cfg.jwt.jwksURL, "jwks-url", "AUTH_JWKS_URL", "", "auth-svc JWKS URL, enables access token verification")
+	s.stringVar(&cfg.jwt.issuer, "jwt-issuer", "AUTH_JWT_ISSUER", "http://auth-svc", "Expected access token issuer")
+	s.stringVar(&cfg.jwt.audience, "jwt-audience", "AUTH_JWT_AUDIENCE", "service-hub", "Expected access token audience")
+	s.stringVar(&cfg.jwt.revocationsURL, "revocations-url", "AUTH_REVOCATIONS_URL", "", "auth-svc revoked sessions URL, rejects tokens of revoked sessions")
+	s.stringVar(&cfg.jwt.eventsURL, "auth-events-url", "AUTH_EVENTS_URL", "", "RabbitMQ URL auth-svc publishes to, rejects tokens of revoked sessions as they are revoked")
+
+	// Machine callers such as HostBill webhooks send an API key instead, which is
+	// checked against auth-svc. Leaving it empty disables API keys.
+	s.stringVar(&cfg.apiKeyURL, "api-key-url", "AUTH_API_KEY_URL", "", "auth-svc API key verify URL, enables API keys")
+
+	// Without either, every request is refused, unless authentication is turned off
+	// explicitly for local development.
+	s.boolVar(&cfg.noAuth, "no-auth", "HOSTBILL_NO_AUTH", false, "Serve every request without authentication, in development only")
+
+	// Archived Veeam organizations keep their backups, and archived ZORGs their VPGs,
+	// for the grace period, in case the customer comes back.
+	s.durationVar(&cfg.veeamDeleteGrace, "veeam-delete-grace", "VEEAM_DELETE_GRACE", 30*24*time.Hour, "How long an archived Veeam organization is kept before it can be deleted")
+	s.durationVar(&cfg.zertoDeleteGrace, "zerto-delete-grace", "ZERTO_DELETE_GRACE", 30*24*time.Hour, "How long an archived ZORG is kept before it can be deleted")
+
+	// Each vendor has its own URL, trust and timeout: -vcd-url, -vcd-ca-file,
+	// -vcd-pin-sha256, -vcd-insecure and -vcd-timeout, and the same for veeam, zerto
+	// and duo, which is duo-svc. A vendor without a URL is disabled.
+	s.vendorVars(&cfg.vcd.vendorConfig, "vcd", "VCD")
+	s.vendorVars(&cfg.veeam.vendorConfig, "veeam", "VEEAM")
+	s.vendorVars(&cfg.zerto.vendorConfig, "zerto", "ZERTO")
+	s.vendorVars(&cfg.duo.vendorConfig, "duo", "DUO_SVC")
+	s.boolVar(&cfg.vendorDebug, "vendor-debug", "VENDOR_DEBUG", false, "Log vendor requests and responses, with secrets redacted")
+
+	// VCD organizations are provisioned with a provider login, into the provider VDC,
+	// network pool and storage policy given here.
+	s.stringVar(&cfg.vcd.username, "vcd-user", "VCD_USER", "", "VCD username, as user@System for a provider login")
+	s.stringVar(&cfg.vcd.apiVersion, "vcd-api-version", "VCD_API_VERSION", "38.0", "VCD API version")
+	s.stringVar(&cfg.vcd.providerVDC, "vcd-provider-vdc", "VCD_PROVIDER_VDC", "", "Provider VDC to create org VDCs from")
+	s.stringVar(&cfg.vcd.networkPool, "vcd-network-pool", "VCD_NETWORK_POOL", "", "Network pool for new org VDCs")
+	s.stringVar(&cfg.vcd.storagePolicy, "vcd-storage-policy", "VCD_STORAGE_POLICY", "", "Provider VDC storage policy for new org VDCs")
+	s.stringVar(&cfg.vcd.externalNetwork, "vcd-external-network", "VCD_EXTERNAL_NETWORK", "", "External network for edge gateways, none are created if empty")
+	s.stringVar(&cfg.vcd.adminRole, "vcd-admin-role", "VCD_ADMIN_ROLE", "Organization Administrator", "Role of the admin user created in each org")
+	s.secretVar(&cfg.vcd.password, "vcd-password", "VCD_PW")
+
+	// Veeam organizations are created in Enterprise Manager, on the backup server,
+	// repository and host given here.
+	s.stringVar(&cfg.veeam.sessionPath, "veeam-session-path", "VEEAM_SESSION", "/api/sessionMngr/?v=latest", "Enterprise Manager path sessions are opened at")
+	s.stringVar(&cfg.veeam.orgConfigsPath, "veeam-org-configs-path", "VEEAM_CREATE_ORG", "/api/vCloud/orgConfigs", "Enterprise Manager path of organization configurations")
+	s.stringVar(&cfg.veeam.username, "veeam-user", "VEEAM_USERNAME", "", "Enterprise Manager username")
+	s.stringVar(&cfg.veeam.backupServerUID, "veeam-backup-server-uid", "VEEAM_BACKUP_SERVER_UID", "", "UID of the backup server new organizations use")
+	s.stringVar(&cfg.veeam.repositoryUID, "veeam-repository-uid", "VEEAM_REPOSITORY_UID", "", "UID of the repository new organizations back up to")
+	s.stringVar(&cfg.veeam.hostUID, "veeam-host-uid", "VEEAM_HOST_UID", "", "UID of the vCloud host new organizations are on")
+	s.stringVar(&cfg.veeam.repositoryName, "veeam-repository-name", "VEEAM_REPOSITORY_NAME", "", "Name new organizations see their repository under, their own name if empty")
+	s.secretVar(&cfg.veeam.password, "veeam-password", "VEEAM_PASSWORD")
+
+	// ZORGs are created in the Zerto Cloud Manager.
+	s.stringVar(&cfg.zerto.sessionPath, "zerto-session-path", "ZERTO_SESSION_START", "/v1/session/add", "Zerto path sessions are started at")
+	s.stringVar(&cfg.zerto.zorgsPath, "zerto-zorgs-path", "ZERTO_CREATE_ZORG", "/v1/zorgs", "Zerto path of ZORGs")
+	s.stringVar(&cfg.zerto.username, "zerto-user", "ZERTO_USER", "", "Zerto username")
+	s.secretVar(&cfg.zerto.password, "zerto-password", "ZERTO_PW")
+
+	// Duo subaccounts are created through duo-svc, with a service-hub API key that
+	// has the duo scope.
+	s.secretVar(&cfg.duo.apiKey, "duo-api-key", "DUO_SVC_API_KEY")
+
+	// Tenants are provisioned in every vendor by a workflow whose state is kept here,
+	// so that it can be resumed after a restart.
+	s.stringVar(&cfg.tenantStateDir, "tenant-state-dir", "TENANT_STATE_DIR", "data/tenants", "Directory of tenant provisioning state")
+
+	// Every tenant and vendor resource provisioned is recorded in Postgres. Leaving it
+	// empty disables the inventory.
+	s.stringVar(&cfg.dsn, "db-dsn", "DSN", "", "Postgres DSN, enables the tenant inventory")
+
+	// We need to parse all CLI flags in order to use them as well.
+	flag.Parse()
+
+	file := map[string]string{}
+	if cfg.file != "" {
+		var err error
+		file, err = readEnvFile(cfg.file)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
+	}
+
+	lookup := func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := file[key]
+		return v, ok
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var errs []error
+	for name, key := range s.env {
+		v, ok := lookup(key)
+		if given[name] || !ok || v == "" {
+			continue
+		}
+
+		err := flag.Set(name, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q", key, v))
+		}
+	}
+
+	for _, sec := range s.secrets {
+		v, err := readSecret(lookup, sec.env)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		*sec.value = v
+	}
+
+	cfg.secrets = s.secrets
+
+	return cfg, errors.Join(errs...)
+}
+
+// readSecret returns the environment variable key, or the contents of the file named
+// by key_FILE without its trailing newline.
+func readSecret(lookup func(string) (string, bool), key string) (string, error) {
+	if v, ok := lookup(key); ok && v != "" {
+		return v, nil
+	}
+
+	path, ok := lookup(key + "_FILE")
+	if !ok || path == "" {
+		return "", nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", key, err)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// readEnvFile reads a file of KEY=value lines, use typical UNIX format in .env file.
+// Blank lines and comments are skipped, a leading export is allowed and values may be
+// quoted.
+func readEnvFile(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Ignore comments and empty lines
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		// Split the line at the first '='
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", filename, n)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		values[strings.TrimSpace(key)] = value
+	}
+
+	return values, scanner.Err()
+}
+
+// validate checks the configuration, so that mistakes are found at startup rather than
+// on the first order that needs them.
+func (cfg config) validate() error {
+	var errs []error
+	check := func(ok bool, name, message string) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("-%s: %s", name, message))
+		}
+	}
+
+	check(cfg.port > 0 && cfg.port < 65536, "port", "must be between 1 and 65535")
+	check(cfg.env == "development" || cfg.env == "staging" || cfg.env == "production", "env", "must be development, staging or production")
+
+	check(validURL(cfg.jwt.jwksURL), "jwks-url", "must be an http or https URL")
+	check(validURL(cfg.jwt.revocationsURL), "revocations-url", "must be an http or https URL")
+	check(cfg.jwt.revocationsURL == "" || cfg.jwt.jwksURL != "", "revocations-url", "needs -jwks-url")
+	check(validAMQPURL(cfg.jwt.eventsURL), "auth-events-url", "must be an amqp or amqps URL")
+	check(cfg.jwt.eventsURL == "" || cfg.jwt.jwksURL != "", "auth-events-url", "needs -jwks-url")
+	check(validURL(cfg.apiKeyURL), "api-key-url", "must be an http or https URL")
+	check(cfg.noAuth || cfg.jwt.jwksURL != "" || cfg.apiKeyURL != "", "jwks-url", "or -api-key-url is required, or -no-auth in development")
+	check(!cfg.noAuth || cfg.env == "development", "no-auth", "is only allowed in development")
+
+	check(cfg.veeamDeleteGrace >= 0, "veeam-delete-grace", "must not be negative")
+	check(cfg.zertoDeleteGrace >= 0, "zerto-delete-grace", "must not be negative")
+
+	checkVendor := func(v vendorConfig, name string) {
+		check(validURL(v.url), name+"-url", "must be an http or https URL")
+		check(v.timeout > 0, name+"-timeout", "must be greater than zero")
+		check(!v.insecure || cfg.env != "production", name+"-insecure", "is not allowed in production")
+
+		if v.caFile != "" {
+			_, err := os.Stat(v.caFile)
+			check(err == nil, name+"-ca-file", "must be a readable file")
+		}
+		if v.pinSHA256 != "" {
+			pin, err := hex.DecodeString(strings.ReplaceAll(v.pinSHA256, ":", ""))
+			check(err == nil && len(pin) == 32, name+"-pin-sha256", "must be a hex SHA-256")
+		}
+	}
+
+	checkVendor(cfg.vcd.vendorConfig, "vcd")
+	if cfg.vcd.url != "" {
+		check(cfg.vcd.username != "", "vcd-user", "is required with -vcd-url")
+		check(cfg.vcd.password != "", "vcd-password", "VCD_PW or VCD_PW_FILE is required with -vcd-url")
+		check(cfg.vcd.apiVersion != "", "vcd-api-version", "is required with -vcd-url")
+		check(cfg.vcd.providerVDC != "", "vcd-provider-vdc", "is required with -vcd-url")
+		check(cfg.vcd.networkPool != "", "vcd-network-pool", "is required with -vcd-url")
+		check(cfg.vcd.storagePolicy != "", "vcd-storage-policy", "is required with -vcd-url")
+		check(cfg.vcd.adminRole != "", "vcd-admin-role", "is required with -vcd-url")
+	}
+
+	checkVendor(cfg.veeam.vendorConfig, "veeam")
+	if cfg.veeam.url != "" {
+		check(strings.HasPrefix(cfg.veeam.sessionPath, "/"), "veeam-session-path", "must be a path starting with /")
+		check(strings.HasPrefix(cfg.veeam.orgConfigsPath, "/"), "veeam-org-configs-path", "must be a path starting with /")
+		check(cfg.veeam.username != "", "veeam-user", "is required with -veeam-url")
+		check(cfg.veeam.password != "", "veeam-password", "VEEAM_PASSWORD or VEEAM_PASSWORD_FILE is required with -veeam-url")
+		check(cfg.veeam.backupServerUID != "", "veeam-backup-server-uid", "is required with -veeam-url")
+		check(cfg.veeam.repositoryUID != "", "veeam-repository-uid", "is required with -veeam-url")
+		check(cfg.veeam.hostUID != "", "veeam-host-uid", "is required with -veeam-url")
+	}
+
+	checkVendor(cfg.zerto.vendorConfig, "zerto")
+	if cfg.zerto.url != "" {
+		check(strings.HasPrefix(cfg.zerto.sessionPath, "/"), "zerto-session-path", "must be a path starting with /")
+		check(strings.HasPrefix(cfg.zerto.zorgsPath, "/"), "zerto-zorgs-path", "must be a path starting with /")
+		check(cfg.zerto.username != "", "zerto-user", "is required with -zerto-url")
+		check(cfg.zerto.password != "", "zerto-password", "ZERTO_PW or ZERTO_PW_FILE is required with -zerto-url")
+	}
+
+	checkVendor(cfg.duo.vendorConfig, "duo")
+	if cfg.duo.url != "" {
+		check(cfg.duo.apiKey != "", "duo-api-key", "DUO_SVC_API_KEY or DUO_SVC_API_KEY_FILE is required with -duo-url")
+	}
+
+	check(cfg.tenantStateDir != "", "tenant-state-dir", "must not be empty")
+
+	return errors.Join(errs...)
+}
+
+// validURL reports whether s is empty or an absolute http or https URL.
+func validURL(s string) bool {
+	if s == "" {
+		return true
+	}
+
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validAMQPURL reports whether s is empty or an absolute amqp or amqps URL.
+func validAMQPURL(s string) bool {
+	if s == "" {
+		return true
+	}
+
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "amqp" || u.Scheme == "amqps") && u.Host != ""
+}
+
+// dsnPassword is the password of a Postgres DSN in key=value form.
+var dsnPassword = regexp.MustCompile(`(password=)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// printConfig writes the effective value of every flag, and whether each secret is
+// set, for -check-config. Passwords in URLs and DSNs are masked.
+func printConfig(w io.Writer, cfg config) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+		value = dsnPassword.ReplaceAllString(value, "${1}[REDACTED]")
+		fmt.Fprintf(tw, "%s\t%s\n", f.Name, value)
+	})
+
+	for _, s := range cfg.secrets {
+		value := ""
+		if *s.value != "" {
+			value = "[REDACTED]"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", s.name, value)
+	}
+
+	tw.Flush()
+}
+
+// http returns the vendorhttp configuration of the vendor.
+func (v vendorConfig) http(logger *slog.Logger, debug bool) vendorhttp.Config {
+	return vendorhttp.Config{
+		BaseURL:      v.url,
+		CAFile:       v.caFile,
+		PinnedSHA256: v.pinSHA256,
+		Insecure:     v.insecure,
+		Timeout:      v.timeout,
+		Logger:       logger,
+		Debug:        debug,
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// Connection pool limits. The inventory is written once per provisioning call, so a
+// small pool is plenty.
+const (
+	dbMaxOpenConns = 10
+	dbMaxIdleConns = 5
+	dbMaxIdleTime  = 15 * time.Minute
+	dbMaxLifetime  = time.Hour
+)
+
+// openDB connects to the Postgres at dsn, waiting up to a minute for it to come up.
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(dbMaxOpenConns)
+	db.SetMaxIdleConns(dbMaxIdleConns)
+	db.SetConnMaxIdleTime(dbMaxIdleTime)
+	db.SetConnMaxLifetime(dbMaxLifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for {
+		err = db.PingContext(ctx)
+		if err == nil {
+			return db, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, err
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
@@ -77,3 +77,17 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
+
+// Used to send a 401 Unauthorized response when the access token is missing, invalid or
+// expired.
+func (app *application) invalidTokenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	app.errorResponse(w, r, http.StatusUnauthorized, err.Error())
+}
+
+// Used to send a 403 Forbidden response when the caller's access token doesn't carry the
+// permission an endpoint needs.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request, permission string) {
+	message := fmt.Sprintf("the %s permission is required for this resource", permission)
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/inventory"
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+)
+
+// inventoryTimeout bounds each inventory query.
+const inventoryTimeout = 3 * time.Second
+
+// recordInventory records a tenant and resources created, archived or deleted for it.
+// The vendors are the source of truth, so a failure is logged rather than failing a
+// request whose changes have already been made.
+func (app *application) recordInventory(tenant inventory.Tenant, resources ...inventory.Resource) {
+	if app.inventory == nil {
+		return
+	}
+
+	// The request may be over, or cancelled, by the time the change is recorded.
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryTimeout)
+	defer cancel()
+
+	_, err := app.inventory.Record(ctx, tenant, resources...)
+	if err != nil {
+		app.logger.Error("recording inventory", "tenant", tenant.Name, "crm_identifier", tenant.CrmIdentifier, "error", err)
+	}
+}
+
+// vcdResources are the resources of a provisioned VCD organization, with a status.
+func vcdResources(result *services.VCDProvisionResult, status string) []inventory.Resource {
+	var resources []inventory.Resource
+	add := func(typ, id, name string) {
+		if id != "" {
+			resources = append(resources, inventory.Resource{Vendor: inventory.VendorVCD, Type: typ, ExternalID: id, Name: name, Status: status})
+		}
+	}
+
+	if result.Org != nil {
+		add(inventory.TypeOrg, result.Org.ID, result.Org.Name)
+	}
+	if result.VDC != nil {
+		add(inventory.TypeOrgVDC, result.VDC.ID, result.VDC.Name)
+	}
+	if result.Admin != nil {
+		add(inventory.TypeUser, result.Admin.ID, result.Admin.Username)
+	}
+	if result.EdgeGateway != nil {
+		add(inventory.TypeEdgeGateway, result.EdgeGateway.ID, result.EdgeGateway.Name)
+	}
+
+	return resources
+}
+
+// veeamResource is the inventory resource of a Veeam organization.
+func veeamResource(org *services.VeeamOrg, status string) inventory.Resource {
+	return inventory.Resource{Vendor: inventory.VendorVeeam, Type: inventory.TypeOrgConfig, ExternalID: org.UID, Name: org.Name, Status: status}
+}
+
+// zorgResource is the inventory resource of a ZORG.
+func zorgResource(zorg *services.Zorg, status string) inventory.Resource {
+	return inventory.Resource{Vendor: inventory.VendorZerto, Type: inventory.TypeZorg, ExternalID: zorg.ZorgIdentifier, Name: zorg.Name, Status: status}
+}
+
+// duoResource is the inventory resource of a Duo subaccount.
+func duoResource(account *services.DuoAccount, status string) inventory.Resource {
+	return inventory.Resource{Vendor: inventory.VendorDuo, Type: inventory.TypeAccount, ExternalID: account.AccountID, Name: account.Name, Status: status}
+}
+
+// "GET /api/v1/inventory/tenants" endpoint. Lists the tenants, with their resources,
+// filtered by any of crm_identifier, hostbill_order_id, hostbill_service_id, name,
+// vendor, external_id and status (of a resource), a page of limit at a time.
+func (app *application) listInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	if app.inventory == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the inventory is not configured")
+		return
+	}
+
+	qs := r.URL.Query()
+	filter := inventory.Filter{
+		CrmIdentifier:     qs.Get("crm_identifier"),
+		HostbillOrderID:   qs.Get("hostbill_order_id"),
+		HostbillServiceID: qs.Get("hostbill_service_id"),
+		Name:              qs.Get("name"),
+		Vendor:            qs.Get("vendor"),
+		ExternalID:        qs.Get("external_id"),
+		Status:            qs.Get("status"),
+		Limit:             100,
+	}
+
+	errs := map[string]string{}
+	if v := qs.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			errs["limit"] = "must be between 1 and 1000"
+		}
+		filter.Limit = n
+	}
+	if v := qs.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			errs["offset"] = "must not be negative"
+		}
+		filter.Offset = n
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), inventoryTimeout)
+	defer cancel()
+
+	tenants, total, err := app.inventory.List(ctx, filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"tenants":  tenants,
+		"metadata": map[string]int{"total": total, "limit": filter.Limit, "offset": filter.Offset},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// "GET /api/v1/inventory/tenants/{id}" endpoint. Shows a tenant by its inventory ID.
+func (app *application) showInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	if app.inventory == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the inventory is not configured")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), inventoryTimeout)
+	defer cancel()
+
+	tenant, err := app.inventory.Get(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, inventory.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tenant": tenant}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// "GET /api/v1/inventory/lookup/{ref}" endpoint. Finds the tenants known by {ref},
+// whichever identifier it is: an inventory ID, CRM identifier, HostBill order or
+// service ID, organization name, or the vendor ID of a resource, such as a Veeam UID.
+func (app *application) lookupInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	if app.inventory == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "the inventory is not configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), inventoryTimeout)
+	defer cancel()
+
+	tenants, err := app.inventory.Lookup(ctx, r.PathValue("ref"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(tenants) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tenants": tenants}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
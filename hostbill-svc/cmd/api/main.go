@@ -1,73 +1,200 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/inventory"
+	"github.com/CloudKey-io/hostbill-svc/internal/saga"
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+	"github.com/cloudkey-io/service-hub/authkit/apikey"
+	"github.com/cloudkey-io/service-hub/authkit/authevents"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
 )
 
 const version = "0.1.5"
 
-type config struct {
-	port   int
-	env    string
-	useTLS bool
-	useLog bool
-}
-
 type application struct {
-	config config
-	logger *slog.Logger
+	config   config
+	logger   *slog.Logger
+	verifier *jwtauth.Verifier
+	apiKeys  *apikey.Client
+	vcd      *services.VCDClient
+	veeam    *services.VeeamClient
+	zerto    *services.ZertoClient
+	duo      *services.DuoClient
+	// inventory records the tenants and vendor resources provisioned, when a database
+	// is configured.
+	inventory *inventory.Store
+	// tenants runs the workflows onboarding tenants in every vendor, and
+	// tenantPasswords holds the VCD admin passwords of those that haven't been handed
+	// out yet.
+	tenants         *saga.Runner
+	tenantPasswords sync.Map
 }
 
 func main() {
-	var cfg config
-
-	// port defines the port number for the API server.
-	// Defaults to 80 if not provided via CLI.
-	flag.IntVar(&cfg.port, "port", 80, "API server port")
-
-	// env represents the current environment the application is running in.
-	// Valid values are: development, staging, production.
-	// Defaults to "development" if not set via CLI.
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-
-	// Boolean useTLS gives the option to enable TLS.
-	// Defaults to false, use true for production.
-	flag.BoolVar(&cfg.useTLS, "tls", false, "Enable TLS (true|false)")
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	// Boolean useLog gives the option to enable logging to a file, as well as the usual stdout.
-	// Defaults to false, use true for production.
-	flag.BoolVar(&cfg.useLog, "log", false, "Enable log file (true|false)")
+	if cfg.checkConfig {
+		printConfig(os.Stdout, cfg)
 
-	// We need to parse all CLI flags in order to use them as well.
-	flag.Parse()
+		err = cfg.validate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\ninvalid configuration:\n%v\n", err)
+			os.Exit(1)
+		}
 
-	// Load environment variables from .envrc file.
-	// err := loadEnv(".envrc")
-	// if err != nil {
-	// 	fmt.Println("Error loading .envrc:", err)
-	// 	return
-	// }
+		fmt.Println("\nconfiguration is valid")
+		return
+	}
 
 	// Logging setup
 	logWriter := setupLogger(cfg.useLog)
 
 	// Create a new logger that writes to standard output (os.Stdout).
 	// Logger is configured with a text handler that formats log records as plain text.
-	// nil argument specifies that no additional handler options are provided.
-	logger := slog.New(slog.NewTextHandler(logWriter, nil))
+	// Vendor requests are logged at debug level, so -vendor-debug lowers the level.
+	logLevel := slog.LevelInfo
+	if cfg.vendorDebug {
+		logLevel = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(logWriter, &slog.HandlerOptions{Level: logLevel}))
+
+	err = cfg.validate()
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
 
 	app := &application{
 		config: cfg,
 		logger: logger,
 	}
 
+	if cfg.jwt.jwksURL != "" {
+		app.verifier = jwtauth.NewVerifier(cfg.jwt.jwksURL, cfg.jwt.issuer, cfg.jwt.audience)
+
+		if cfg.jwt.revocationsURL != "" {
+			go app.verifier.WatchRevocations(context.Background(), cfg.jwt.revocationsURL)
+		}
+		if cfg.jwt.eventsURL != "" {
+			go authevents.WatchRevocations(context.Background(), cfg.jwt.eventsURL, app.verifier)
+		}
+	}
+
+	if cfg.apiKeyURL != "" {
+		app.apiKeys = apikey.NewClient(cfg.apiKeyURL)
+	}
+
+	if cfg.dsn != "" {
+		db, err := openDB(cfg.dsn)
+		if err != nil {
+			logger.Error("connecting to postgres", "error", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err = inventory.Migrate(ctx, db)
+		cancel()
+		if err != nil {
+			logger.Error("migrating inventory", "error", err)
+			os.Exit(1)
+		}
+
+		app.inventory = inventory.New(db)
+	}
+
+	if cfg.vcd.url != "" {
+		vcd, err := services.NewVCDClient(services.VCDConfig{
+			Username:   cfg.vcd.username,
+			Password:   cfg.vcd.password,
+			APIVersion: cfg.vcd.apiVersion,
+			HTTP:       cfg.vcd.http(logger, cfg.vendorDebug),
+		})
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.vcd = vcd
+	}
+
+	if cfg.veeam.url != "" {
+		veeam, err := services.NewVeeamClient(services.VeeamClientConfig{
+			SessionPath:    cfg.veeam.sessionPath,
+			OrgConfigsPath: cfg.veeam.orgConfigsPath,
+			Username:       cfg.veeam.username,
+			Password:       cfg.veeam.password,
+			HTTP:           cfg.veeam.http(logger, cfg.vendorDebug),
+		})
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.veeam = veeam
+	}
+
+	if cfg.zerto.url != "" {
+		zerto, err := services.NewZertoClient(services.ZertoClientConfig{
+			SessionPath: cfg.zerto.sessionPath,
+			ZorgsPath:   cfg.zerto.zorgsPath,
+			Username:    cfg.zerto.username,
+			Password:    cfg.zerto.password,
+			HTTP:        cfg.zerto.http(logger, cfg.vendorDebug),
+		})
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.zerto = zerto
+	}
+
+	if cfg.duo.url != "" {
+		duo, err := services.NewDuoClient(services.DuoClientConfig{
+			APIKey: cfg.duo.apiKey,
+			HTTP:   cfg.duo.http(logger, cfg.vendorDebug),
+		})
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.duo = duo
+	}
+
+	// Tenants are only provisioned when every vendor is configured. Workflows that a
+	// shutdown interrupted carry on from where they stopped.
+	if app.vcd != nil && app.veeam != nil && app.zerto != nil && app.duo != nil {
+		store, err := saga.NewFileStore(cfg.tenantStateDir)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		app.tenants = saga.NewRunner(store, logger, app.tenantSteps()...)
+		app.tenants.Transient = transientError
+
+		err = app.tenants.Resume(context.Background())
+		if err != nil {
+			logger.Error("resuming tenant workflows", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// TLS Config is set up for modern web , maybe remove some of these settings if needed.
 	// TLS 1.3 remains unaffected by all of this, as all of its connections are considered
 	// safe while writing this for Go 1.22.
@@ -98,20 +225,60 @@ func main() {
 
 	logger.Info("starting server", "addr", srv.Addr, "env", cfg.env, "tls", cfg.useTLS, "log", cfg.useLog)
 
+	// Shut down on SIGINT or SIGTERM, letting requests finish and tenant workflows save
+	// their state, then log out of the vendor sessions so they don't linger until they
+	// time out.
+	shutdownErr := make(chan error, 1)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
 	if cfg.useTLS {
 		srv.TLSConfig = tlsConfig
-		err := srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-		if err != nil {
-			logger.Error(err.Error())
-			os.Exit(1)
-		}
+		err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
 	} else {
-		err := srv.ListenAndServe()
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if app.tenants != nil {
+		err = app.tenants.Shutdown(ctx)
 		if err != nil {
-			logger.Error(err.Error())
-			os.Exit(1)
+			logger.Error("stopping tenant workflows", "error", err)
 		}
 	}
+
+	if app.veeam != nil {
+		app.veeam.Close(ctx)
+	}
+	if app.zerto != nil {
+		app.zerto.Close(ctx)
+	}
+	if app.vcd != nil {
+		app.vcd.Close(ctx)
+	}
+
+	logger.Info("stopped server", "addr", srv.Addr)
 }
 
 // setupLogger configures the logging output based on the useLog flag.
@@ -132,37 +299,3 @@ func setupLogger(useLog bool) io.Writer {
 	}
 	return logWriter
 }
-
-// Loads environment variables from a file, use typical UNIX format in .env file.
-// func loadEnv(filename string) error {
-// 	// Open the file
-// 	file, err := os.Open(filename)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	defer file.Close()
-//
-// 	scanner := bufio.NewScanner(file)
-// 	for scanner.Scan() {
-// 		// Read each line
-// 		line := scanner.Text()
-//
-// 		// Ignore comments and empty lines
-// 		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
-// 			continue
-// 		}
-//
-// 		// Split the line at the first '='
-// 		parts := strings.SplitN(line, "=", 2)
-// 		if len(parts) != 2 {
-// 			continue // skip malformed lines
-// 		}
-//
-// 		// Set the environment variable
-// 		key := parts[0]
-// 		value := parts[1]
-// 		os.Setenv(key, value)
-// 	}
-//
-// 	return scanner.Err()
-// }
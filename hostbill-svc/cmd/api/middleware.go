@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/cloudkey-io/service-hub/authkit/apikey"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
 )
 
 // Sets headers for incoming requests, we can set these as environment variables for the server
@@ -59,3 +63,75 @@ func (app *application) gracefulRecovery(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// The scope an API key needs to call hostbill-svc.
+const apiKeyScope = "hostbill"
+
+// Requires either an API key with the hostbill scope or a valid access token from
+// auth-svc, and stores the key or the token's claims in the request context. Requests
+// only pass straight through when authentication was turned off with -no-auth.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.noAuth {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Authorization, "+apikey.Header)
+
+		if key := r.Header.Get(apikey.Header); key != "" && app.apiKeys != nil {
+			verified, err := app.apiKeys.Verify(r.Context(), key, apiKeyScope)
+			switch {
+			case errors.Is(err, apikey.ErrForbidden):
+				app.errorResponse(w, r, http.StatusForbidden, err.Error())
+			case errors.Is(err, apikey.ErrInvalid):
+				app.errorResponse(w, r, http.StatusUnauthorized, err.Error())
+			case err != nil:
+				app.serverErrorResponse(w, r, err)
+			default:
+				next.ServeHTTP(w, r.WithContext(apikey.NewContext(r.Context(), verified)))
+			}
+			return
+		}
+
+		if app.verifier == nil {
+			app.errorResponse(w, r, http.StatusUnauthorized, apikey.ErrMissing.Error())
+			return
+		}
+
+		token, err := jwtauth.BearerToken(r)
+		if err != nil {
+			app.invalidTokenResponse(w, r, err)
+			return
+		}
+
+		claims, err := app.verifier.Verify(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, jwtauth.ErrInvalid) || errors.Is(err, jwtauth.ErrExpired) || errors.Is(err, jwtauth.ErrRevoked) {
+				app.invalidTokenResponse(w, r, err)
+				return
+			}
+
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(jwtauth.NewContext(r.Context(), claims)))
+	})
+}
+
+// Wraps a handler so only callers with the given permission, in "resource:action" form,
+// can reach it. Access tokens must carry the permission; API keys with the hostbill
+// scope may call every endpoint. Runs after authenticate, so when authentication is
+// turned off with -no-auth there are no claims and every request is allowed.
+func (app *application) requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := jwtauth.FromContext(r.Context())
+		if ok && !claims.HasPermission(permission) {
+			app.notPermittedResponse(w, r, permission)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth/jwtauthtest"
+)
+
+func TestAuthenticateToken(t *testing.T) {
+	issuer := jwtauthtest.NewServer(t)
+
+	app := &application{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		verifier: jwtauth.NewVerifier(issuer.JWKSURL, jwtauthtest.Issuer, jwtauthtest.Audience),
+	}
+	// The session was logged out of, or revoked by an admin.
+	app.verifier.Revoke("revoked-session")
+
+	handler := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"valid", issuer.Token(t, map[string]any{"sid": "other-session"}), http.StatusOK},
+		{"revoked session", issuer.Token(t, map[string]any{"sid": "revoked-session"}), http.StatusUnauthorized},
+		{"expired", issuer.Token(t, map[string]any{"exp": 1}), http.StatusUnauthorized},
+		{"not a token", "not-a-token", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tenants", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected %d, got %d %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
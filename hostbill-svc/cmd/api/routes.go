@@ -10,33 +10,59 @@ func (app *application) routes() http.Handler {
 	// Healthcheck endpoint
 	mux.HandleFunc("GET /api/v1/healthcheck", app.healthcheckHandler)
 
+	// Every other endpoint needs an API key or an access token from auth-svc. The
+	// healthcheck pattern is more specific than "/api/v1/", so it stays open.
+	api := http.NewServeMux()
+	mux.Handle("/api/v1/", app.authenticate(api))
+
 	// SSO endpoints
 	// We should only need POST, PUT, and DELETE endpoints, this is for updating SAML
 	// on both VCD and Duo.
-	mux.HandleFunc("PUT /api/v1/sso", app.updateSsoHandler)
+	api.HandleFunc("PUT /api/v1/sso", app.requirePermission("sso:update", app.updateSsoHandler))
 	// Need to add in "archiving" functionality, then we would eventually
 	// delete resources.
-	mux.HandleFunc("DELETE /api/v1/sso", app.deleteSsoHandler)
+	api.HandleFunc("DELETE /api/v1/sso", app.requirePermission("sso:delete", app.deleteSsoHandler))
 
-	// Zerto endpoints
+	// VCD endpoints
+	// Provisions an organization, with its org VDC, admin user and edge gateway, for
+	// a HostBill order.
+	api.HandleFunc("POST /api/v1/vcd", app.requirePermission("vcd:create", app.createVcdHandler))
+
+	// Veeam endpoints
 	// We should only need POST, PUT, and  DELETE endpoints, users can still
 	// manage and "get" data from their self-serve portal. Unless we want
 	// GET data showing up in Hostbill.
-	mux.HandleFunc("POST /api/v1/veeam", app.createVeeamHandler)
-	mux.HandleFunc("PUT /api/v1/veeam", app.updateVeeamHandler)
-	// Need to add in "archiving" functionality, then we would eventually
-	// delete resources.
-	mux.HandleFunc("DELETE /api/v1/veeam", app.deleteVeeamHandler)
+	api.HandleFunc("POST /api/v1/veeam", app.requirePermission("veeam:create", app.createVeeamHandler))
+	// The {id} of an organization is its Veeam UID or its name. Archiving stops its
+	// jobs, and it can only be deleted once it has been archived for the grace period.
+	api.HandleFunc("PUT /api/v1/veeam/{id}", app.requirePermission("veeam:update", app.updateVeeamHandler))
+	api.HandleFunc("POST /api/v1/veeam/{id}/archive", app.requirePermission("veeam:delete", app.archiveVeeamHandler))
+	api.HandleFunc("DELETE /api/v1/veeam/{id}", app.requirePermission("veeam:delete", app.deleteVeeamHandler))
 
-	// Veeam endpoints
+	// Zerto endpoints
 	// We should only need POST, PUT, and  DELETE endpoints, users can still
 	// manage and "get" data from their self-serve portal. Unless we want
 	// GET data showing up in Hostbill.
-	mux.HandleFunc("POST /api/v1/zerto", app.createZertoHandler)
-	mux.HandleFunc("PUT /api/v1/zerto", app.updateZertoHandler)
-	// Need to add in "archiving" functionality, then we would eventually
-	// delete resources.
-	mux.HandleFunc("DELETE /api/v1/zerto", app.deleteZertoHandler)
+	api.HandleFunc("POST /api/v1/zerto", app.requirePermission("zerto:create", app.createZertoHandler))
+	// The {id} of a ZORG is its CRM identifier, the HostBill order it was created for.
+	// Archiving removes its users' access, and it can only be deleted once it has been
+	// archived for the grace period.
+	api.HandleFunc("PUT /api/v1/zerto/{id}", app.requirePermission("zerto:update", app.updateZertoHandler))
+	api.HandleFunc("POST /api/v1/zerto/{id}/archive", app.requirePermission("zerto:delete", app.archiveZertoHandler))
+	api.HandleFunc("DELETE /api/v1/zerto/{id}", app.requirePermission("zerto:delete", app.deleteZertoHandler))
+
+	// Tenant endpoints
+	// Onboards a customer in VCD, Veeam, Zerto and Duo in one workflow, whose state is
+	// shown by its CRM identifier. If a step fails for good, the earlier ones are undone.
+	api.HandleFunc("POST /api/v1/tenants", app.requirePermission("tenants:create", app.createTenantHandler))
+	api.HandleFunc("GET /api/v1/tenants/{id}", app.requirePermission("tenants:read", app.showTenantHandler))
+
+	// Inventory endpoints
+	// Every tenant provisioned, with its HostBill and CRM identifiers and the vendor
+	// resources created for it. A tenant can be looked up by any of its identifiers.
+	api.HandleFunc("GET /api/v1/inventory/tenants", app.requirePermission("inventory:read", app.listInventoryHandler))
+	api.HandleFunc("GET /api/v1/inventory/tenants/{id}", app.requirePermission("inventory:read", app.showInventoryHandler))
+	api.HandleFunc("GET /api/v1/inventory/lookup/{ref}", app.requirePermission("inventory:read", app.lookupInventoryHandler))
 
 	return app.gracefulRecovery(app.logRequest((commonHeaders(mux))))
 }
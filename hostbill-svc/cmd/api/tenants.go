@@ -0,0 +1,571 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/inventory"
+	"github.com/CloudKey-io/hostbill-svc/internal/saga"
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
+)
+
+// errNotTenants is returned by a step that finds a resource by the tenant's name which
+// isn't the tenant's: another tenant's, or one made by hand. It is left alone rather than
+// adopted, and undone with the tenant.
+var errNotTenants = errors.New("already exists and isn't the tenant's")
+
+// crmIdentifier is what a tenant may be identified by. It ends up in URLs and in the
+// name of the file its state is kept in.
+var crmIdentifier = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// tenantRequest is the order of a customer to onboard in every vendor: a VCD
+// organization, a Veeam organization, a ZORG and a Duo subaccount. Capacity is in MHz,
+// MB and GB.
+type tenantRequest struct {
+	OrganizationName string      `json:"OrganizationName"`
+	CompanyName      string      `json:"CompanyName"`
+	CrmIdentifier    string      `json:"CrmIdentifier"`
+	CpuMHz           int         `json:"CpuMHz"`
+	MemoryMB         int         `json:"MemoryMB"`
+	StorageGb        int         `json:"StorageGb"`
+	AdminUsername    string      `json:"AdminUsername"`
+	AdminEmail       string      `json:"AdminEmail"`
+	AdminFullName    string      `json:"AdminFullName"`
+	QuotaGb          json.Number `json:"QuotaGb"`
+	Country          string      `json:"Country"`
+	State            string      `json:"State"`
+	PostalCode       string      `json:"PostalCode"`
+
+	// HostbillOrderId and HostbillServiceId are only recorded in the inventory.
+	HostbillOrderId   string `json:"HostbillOrderId"`
+	HostbillServiceId string `json:"HostbillServiceId"`
+}
+
+// vcdOrder is the VCD part of the order.
+func (t tenantRequest) vcdOrder() services.VCDCreateOrgRequest {
+	return services.VCDCreateOrgRequest{
+		OrganizationName:  t.OrganizationName,
+		CompanyName:       t.CompanyName,
+		CrmIdentifier:     t.CrmIdentifier,
+		CpuMHz:            t.CpuMHz,
+		MemoryMB:          t.MemoryMB,
+		StorageGb:         t.StorageGb,
+		AdminUsername:     t.AdminUsername,
+		AdminEmail:        t.AdminEmail,
+		AdminFullName:     t.AdminFullName,
+		HostbillOrderId:   t.HostbillOrderId,
+		HostbillServiceId: t.HostbillServiceId,
+	}
+}
+
+// zertoOrder is the Zerto part of the order.
+func (t tenantRequest) zertoOrder() services.ZertoCreateOrgRequest {
+	return services.ZertoCreateOrgRequest{
+		Name:          t.OrganizationName,
+		CrmIdentifier: t.CrmIdentifier,
+		TenantInfo: services.ZertoTenantInfo{
+			Country:    t.Country,
+			State:      t.State,
+			PostalCode: t.PostalCode,
+		},
+		HostbillOrderId:   t.HostbillOrderId,
+		HostbillServiceId: t.HostbillServiceId,
+	}
+}
+
+// inventoryTenant is the inventory tenant of the order.
+func (t tenantRequest) inventoryTenant() inventory.Tenant {
+	return vcdInventoryTenant(t.vcdOrder())
+}
+
+// The results of the provisioning steps. Created is false when the resource already
+// existed, in which case it is left alone if the workflow is compensated.
+type (
+	tenantVcdOrg struct {
+		Org     *services.VCDOrg `json:"org"`
+		Created bool             `json:"created"`
+	}
+	tenantVcd struct {
+		*services.VCDProvisionResult
+		// AdminPasswordReset is set when the admin may have been created before a
+		// restart, with a password that was lost.
+		AdminPasswordReset bool `json:"admin_password_reset_required,omitempty"`
+	}
+	tenantVeeamOrg struct {
+		Org     *services.VeeamOrg `json:"org"`
+		Created bool               `json:"created"`
+	}
+	tenantZorg struct {
+		Zorg    *services.Zorg `json:"zorg"`
+		Created bool           `json:"created"`
+	}
+	tenantDuoAccount struct {
+		Account *services.DuoAccount `json:"account"`
+		Created bool                 `json:"created"`
+	}
+)
+
+// tenantSteps are the steps of onboarding a tenant. The VCD organization comes first,
+// as the Veeam organization is the backup configuration of it, and undoing it deletes
+// everything provisioned in it, so the "vcd" step has nothing of its own to undo.
+func (app *application) tenantSteps() []saga.Step {
+	return []saga.Step{
+		{Name: "vcd_org", Do: app.createTenantVcdOrg, Undo: app.deleteTenantVcdOrg},
+		{Name: "vcd", Do: app.provisionTenantVcd},
+		{Name: "veeam", Do: app.createTenantVeeamOrg, Undo: app.deleteTenantVeeamOrg},
+		{Name: "zerto", Do: app.createTenantZorg, Undo: app.deleteTenantZorg},
+		{Name: "duo", Do: app.createTenantDuoAccount, Undo: app.deleteTenantDuoAccount},
+	}
+}
+
+// tenantInput decodes the order a workflow was started with.
+func tenantInput(w *saga.Workflow) (tenantRequest, error) {
+	var input tenantRequest
+	err := json.Unmarshal(w.Input, &input)
+	return input, err
+}
+
+func (app *application) createTenantVcdOrg(ctx context.Context, w *saga.Workflow) (any, error) {
+	input, err := tenantInput(w)
+	if err != nil {
+		return nil, err
+	}
+
+	p := app.vcdProvisionRequest(input.vcdOrder(), "")
+
+	org, err := app.vcd.GetOrg(ctx, p.OrgName)
+	if err == nil {
+		return tenantVcdOrg{Org: org}, nil
+	}
+	if !errors.Is(err, services.ErrVCDNotFound) {
+		return nil, err
+	}
+
+	org, err = app.vcd.CreateOrg(ctx, services.VCDOrg{
+		Name:        p.OrgName,
+		DisplayName: p.DisplayName,
+		Description: p.Description,
+		IsEnabled:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app.recordInventory(vcdInventoryTenant(input.vcdOrder()),
+		vcdResources(&services.VCDProvisionResult{Org: org}, inventory.StatusActive)...)
+
+	return tenantVcdOrg{Org: org, Created: true}, nil
+}
+
+func (app *application) deleteTenantVcdOrg(ctx context.Context, w *saga.Workflow) error {
+	var result tenantVcdOrg
+	ok, err := w.Result("vcd_org", &result)
+	if err != nil || !ok || !result.Created {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, vcdProvisionTimeout)
+	defer cancel()
+
+	// The organization is looked up again, as it may have been disabled since.
+	org, err := app.vcd.GetOrg(ctx, result.Org.Name)
+	if errors.Is(err, services.ErrVCDNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = app.vcd.DeleteOrg(ctx, org)
+	var vcdErr *services.VCDError
+	if err != nil && !(errors.As(err, &vcdErr) && vcdErr.Status == http.StatusNotFound) {
+		return err
+	}
+
+	// Everything provisioned in the organization went with it.
+	deleted := tenantVcd{VCDProvisionResult: &services.VCDProvisionResult{}}
+	_, err = w.Result("vcd", &deleted)
+	if err != nil {
+		return err
+	}
+	deleted.Org = org
+
+	input, err := tenantInput(w)
+	if err != nil {
+		return err
+	}
+	app.recordInventory(vcdInventoryTenant(input.vcdOrder()), vcdResources(deleted.VCDProvisionResult, inventory.StatusDeleted)...)
+
+	// No one is left to log in with the admin's password.
+	app.tenantPasswords.Delete(w.ID)
+
+	return nil
+}
+
+// provisionTenantVcd sets up the org VDC, admin and edge gateway of the organization.
+// The admin's password is generated by the first attempt and only kept in memory, so
+// that it is never saved with the workflow. Retries use it too, as an earlier attempt
+// may have created the admin before failing.
+func (app *application) provisionTenantVcd(ctx context.Context, w *saga.Workflow) (any, error) {
+	input, err := tenantInput(w)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := vcdPassword()
+	if err != nil {
+		return nil, err
+	}
+	stored, retried := app.tenantPasswords.LoadOrStore(w.ID, password)
+
+	ctx, cancel := context.WithTimeout(ctx, vcdProvisionTimeout)
+	defer cancel()
+
+	result, err := app.vcd.ProvisionOrg(ctx, app.vcdProvisionRequest(input.vcdOrder(), stored.(string)))
+	if err != nil {
+		return nil, err
+	}
+	result.Admin.Password = ""
+
+	// The password is kept for showTenantHandler to hand out once, unless no admin can
+	// have been given it. An admin that already existed when this process first tried
+	// was created before a restart, if by this workflow, and its password is lost.
+	lost := false
+	if !result.AdminCreated && !retried {
+		app.tenantPasswords.Delete(w.ID)
+		lost = stepAttempts(w, "vcd") > 1
+	}
+
+	app.recordInventory(vcdInventoryTenant(input.vcdOrder()), vcdResources(result, inventory.StatusActive)...)
+
+	return tenantVcd{VCDProvisionResult: result, AdminPasswordReset: lost}, nil
+}
+
+// checkTenantOwns returns errNotTenants unless a resource that already exists is the
+// tenant's: the vendor has it under the tenant's CRM identifier, or the inventory records
+// it for the tenant. owner is the CRM identifier the vendor has it under, if any.
+func (app *application) checkTenantOwns(ctx context.Context, crmIdentifier, owner string, r inventory.Resource) error {
+	if owner != "" && owner == crmIdentifier {
+		return nil
+	}
+	if app.inventory == nil {
+		return errNotTenants
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, inventoryTimeout)
+	defer cancel()
+
+	_, total, err := app.inventory.List(ctx, inventory.Filter{
+		CrmIdentifier: crmIdentifier,
+		Vendor:        r.Vendor,
+		ExternalID:    r.ExternalID,
+		Limit:         1,
+	})
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return errNotTenants
+	}
+
+	return nil
+}
+
+// stepAttempts returns how many times the step of the workflow has been tried.
+func stepAttempts(w *saga.Workflow, step string) int {
+	for _, s := range w.Steps {
+		if s.Name == step {
+			return s.Attempts
+		}
+	}
+
+	return 0
+}
+
+func (app *application) createTenantVeeamOrg(ctx context.Context, w *saga.Workflow) (any, error) {
+	input, err := tenantInput(w)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := app.veeam.FindOrg(ctx, input.OrganizationName)
+	if err == nil {
+		// Veeam doesn't know the CRM identifier, so only the inventory can tell.
+		err = app.checkTenantOwns(ctx, input.CrmIdentifier, "", veeamResource(org, inventory.StatusActive))
+		if err != nil {
+			return nil, fmt.Errorf("veeam organization %s %w", org.Name, err)
+		}
+
+		return tenantVeeamOrg{Org: org}, nil
+	}
+	if !errors.Is(err, services.ErrVeeamNotFound) {
+		return nil, err
+	}
+
+	err = app.veeam.CreateOrg(ctx, app.veeamOrgSpec(VeeamCreateOrgRequest{
+		OrganizationName: input.OrganizationName,
+		QuotaGb:          input.QuotaGb,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	org, err = app.veeam.FindOrg(ctx, input.OrganizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	app.recordInventory(input.inventoryTenant(), veeamResource(org, inventory.StatusActive))
+
+	return tenantVeeamOrg{Org: org, Created: true}, nil
+}
+
+func (app *application) deleteTenantVeeamOrg(ctx context.Context, w *saga.Workflow) error {
+	var result tenantVeeamOrg
+	ok, err := w.Result("veeam", &result)
+	if err != nil || !ok || !result.Created {
+		return err
+	}
+
+	err = app.veeam.DeleteOrg(ctx, result.Org)
+	if err != nil && !errors.Is(err, services.ErrVeeamNotFound) {
+		return err
+	}
+
+	app.recordInventory(inventory.Tenant{CrmIdentifier: w.ID}, veeamResource(result.Org, inventory.StatusDeleted))
+
+	return nil
+}
+
+func (app *application) createTenantZorg(ctx context.Context, w *saga.Workflow) (any, error) {
+	input, err := tenantInput(w)
+	if err != nil {
+		return nil, err
+	}
+
+	zorg, err := app.zerto.FindZorg(ctx, input.CrmIdentifier)
+	if err == nil {
+		err = app.checkTenantOwns(ctx, input.CrmIdentifier, zorg.CrmIdentifier, zorgResource(zorg, inventory.StatusActive))
+		if err != nil {
+			return nil, fmt.Errorf("zorg %s %w", zorg.Name, err)
+		}
+
+		return tenantZorg{Zorg: zorg}, nil
+	}
+	if !errors.Is(err, services.ErrZorgNotFound) {
+		return nil, err
+	}
+
+	err = app.zerto.CreateZorg(ctx, zorgSpec(input.zertoOrder()))
+	if err != nil {
+		return nil, err
+	}
+
+	zorg, err = app.zerto.FindZorg(ctx, input.CrmIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	app.recordInventory(input.inventoryTenant(), zorgResource(zorg, inventory.StatusActive))
+
+	return tenantZorg{Zorg: zorg, Created: true}, nil
+}
+
+func (app *application) deleteTenantZorg(ctx context.Context, w *saga.Workflow) error {
+	var result tenantZorg
+	ok, err := w.Result("zerto", &result)
+	if err != nil || !ok || !result.Created {
+		return err
+	}
+
+	err = app.zerto.DeleteZorg(ctx, result.Zorg)
+	if err != nil && !errors.Is(err, services.ErrZorgNotFound) {
+		return err
+	}
+
+	app.recordInventory(inventory.Tenant{CrmIdentifier: w.ID}, zorgResource(result.Zorg, inventory.StatusDeleted))
+
+	return nil
+}
+
+func (app *application) createTenantDuoAccount(ctx context.Context, w *saga.Workflow) (any, error) {
+	input, err := tenantInput(w)
+	if err != nil {
+		return nil, err
+	}
+
+	account, created, err := app.duo.CreateAccount(ctx, input.OrganizationName)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		app.recordInventory(input.inventoryTenant(), duoResource(account, inventory.StatusActive))
+	}
+
+	return tenantDuoAccount{Account: account, Created: created}, nil
+}
+
+func (app *application) deleteTenantDuoAccount(ctx context.Context, w *saga.Workflow) error {
+	var result tenantDuoAccount
+	ok, err := w.Result("duo", &result)
+	if err != nil || !ok || !result.Created {
+		return err
+	}
+
+	err = app.duo.DeleteAccount(ctx, result.Account.AccountID)
+	if err != nil && !errors.Is(err, services.ErrDuoNotFound) {
+		return err
+	}
+
+	app.recordInventory(inventory.Tenant{CrmIdentifier: w.ID}, duoResource(result.Account, inventory.StatusDeleted))
+
+	return nil
+}
+
+// transientError reports whether a step that failed with err may succeed if it is
+// tried again: the vendor was unreachable, overloaded or failed on its side.
+func transientError(err error) bool {
+	var (
+		vcdErr    *services.VCDError
+		veeamErr  *services.VeeamError
+		zertoErr  *services.ZertoError
+		duoErr    *services.DuoError
+		vendorErr *vendorhttp.Error
+		netErr    net.Error
+	)
+
+	status := 0
+	switch {
+	case errors.As(err, &vcdErr):
+		status = vcdErr.Status
+	case errors.As(err, &veeamErr):
+		status = veeamErr.Status
+	case errors.As(err, &zertoErr):
+		status = zertoErr.Status
+	case errors.As(err, &duoErr):
+		status = duoErr.Status
+	case errors.As(err, &vendorErr):
+		status = vendorErr.Status
+	}
+	if status != 0 {
+		return status == http.StatusTooManyRequests || status >= 500
+	}
+
+	if errors.Is(err, vendorhttp.ErrPinMismatch) {
+		return false
+	}
+
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// "POST /api/v1/tenants" endpoint. Starts onboarding a customer in every vendor and
+// answers straight away, with where to follow the workflow.
+func (app *application) createTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if app.tenants == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "tenant provisioning needs vcd, veeam, zerto and duo to be configured")
+		return
+	}
+
+	var input tenantRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Strip whitespace from the OrganizationName field
+	input.OrganizationName = strings.ReplaceAll(input.OrganizationName, " ", "")
+	input.CrmIdentifier = strings.TrimSpace(input.CrmIdentifier)
+
+	errs := validateVcdOrder(input.vcdOrder())
+	if !crmIdentifier.MatchString(input.CrmIdentifier) {
+		errs["CrmIdentifier"] = "must be 1 to 64 letters, digits, hyphens or underscores"
+	}
+	if quotaGb, err := input.QuotaGb.Float64(); err != nil || quotaGb <= 0 {
+		errs["QuotaGb"] = "must be a number greater than zero"
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	workflow, err := app.tenants.Start(r.Context(), input.CrmIdentifier, input)
+	if err != nil {
+		switch {
+		case errors.Is(err, saga.ErrExists):
+			app.errorResponse(w, r, http.StatusConflict, "a tenant with this CrmIdentifier already exists")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.Info("provisioning tenant", "tenant", workflow.ID, "org", input.OrganizationName)
+
+	headers := make(http.Header)
+	headers.Set("Location", "/api/v1/tenants/"+workflow.ID)
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"tenant": workflow}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// "GET /api/v1/tenants/{id}" endpoint. Shows the state of the workflow of the tenant
+// with the CRM identifier {id}, and what each step created. Once the VCD admin has been
+// created, the first caller allowed to order tenants is also given its password, which
+// isn't kept after that.
+func (app *application) showTenantHandler(w http.ResponseWriter, r *http.Request) {
+	if app.tenants == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "tenant provisioning needs vcd, veeam, zerto and duo to be configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	if !crmIdentifier.MatchString(id) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	workflow, err := app.tenants.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, saga.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"tenant": workflow}
+
+	var vcd tenantVcd
+	done, err := workflow.Result("vcd", &vcd)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	claims, ok := jwtauth.FromContext(r.Context())
+	if done && (!ok || claims.HasPermission("tenants:create")) {
+		if password, ok := app.tenantPasswords.LoadAndDelete(id); ok {
+			env["admin_password"] = password
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/inventory"
+	"github.com/CloudKey-io/hostbill-svc/internal/saga"
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+	"github.com/cloudkey-io/service-hub/authkit/jwtauth"
+)
+
+// fakeWorkflows is an in-memory saga.Store.
+type fakeWorkflows struct {
+	mu        sync.Mutex
+	workflows map[string]*saga.Workflow
+}
+
+func newFakeWorkflows(workflows ...*saga.Workflow) *fakeWorkflows {
+	f := &fakeWorkflows{workflows: map[string]*saga.Workflow{}}
+	for _, w := range workflows {
+		f.workflows[w.ID] = w
+	}
+
+	return f
+}
+
+func (f *fakeWorkflows) Create(ctx context.Context, w *saga.Workflow) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.workflows[w.ID]; ok {
+		return saga.ErrExists
+	}
+	f.workflows[w.ID] = w.Clone()
+
+	return nil
+}
+
+func (f *fakeWorkflows) Save(ctx context.Context, w *saga.Workflow) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.workflows[w.ID] = w.Clone()
+	return nil
+}
+
+func (f *fakeWorkflows) Get(ctx context.Context, id string) (*saga.Workflow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w, ok := f.workflows[id]
+	if !ok {
+		return nil, saga.ErrNotFound
+	}
+
+	return w.Clone(), nil
+}
+
+func (f *fakeWorkflows) List(ctx context.Context) ([]*saga.Workflow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var workflows []*saga.Workflow
+	for _, w := range f.workflows {
+		workflows = append(workflows, w.Clone())
+	}
+
+	return workflows, nil
+}
+
+// tenantWorkflow returns a running tenant workflow whose vcd step has the status.
+func tenantWorkflow(id string, vcd saga.Status) *saga.Workflow {
+	w := &saga.Workflow{ID: id, Input: json.RawMessage(`{}`), Status: saga.StatusRunning}
+	for _, name := range []string{"vcd_org", "vcd", "veeam", "zerto", "duo"} {
+		w.Steps = append(w.Steps, saga.StepState{Name: name, Status: saga.StatusPending})
+	}
+
+	w.Steps[0].Status, w.Steps[0].Result = saga.StatusCompleted, json.RawMessage(`{"created":true}`)
+	w.Steps[1].Status = vcd
+	if vcd == saga.StatusCompleted {
+		w.Steps[1].Result = json.RawMessage(`{"admin_created":true}`)
+	}
+
+	return w
+}
+
+func TestShowTenantAdminPassword(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	orderer := &jwtauth.Claims{Permissions: []string{"tenants:*"}}
+	reader := &jwtauth.Claims{Permissions: []string{"tenants:read"}}
+
+	tests := []struct {
+		name   string
+		vcd    saga.Status
+		claims *jwtauth.Claims
+		// want is whether the password is in each of two requests.
+		want [2]bool
+	}{
+		// It is handed out once only.
+		{"admin created", saga.StatusCompleted, orderer, [2]bool{true, false}},
+		{"admin not created yet", saga.StatusRunning, orderer, [2]bool{false, false}},
+		{"caller can't order tenants", saga.StatusCompleted, reader, [2]bool{false, false}},
+		// API keys with the hostbill scope may call every endpoint.
+		{"API key", saga.StatusCompleted, nil, [2]bool{true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger:  logger,
+				tenants: saga.NewRunner(newFakeWorkflows(tenantWorkflow("C1", tt.vcd)), logger),
+			}
+			app.tenantPasswords.Store("C1", "generated-password")
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /api/v1/tenants/{id}", app.showTenantHandler)
+
+			for i, want := range tt.want {
+				req := httptest.NewRequest(http.MethodGet, "/api/v1/tenants/C1", nil)
+				if tt.claims != nil {
+					req = req.WithContext(jwtauth.NewContext(req.Context(), tt.claims))
+				}
+
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("request %d: expected 200, got %d %s", i+1, rec.Code, rec.Body)
+				}
+
+				var body struct {
+					Tenant        saga.Workflow `json:"tenant"`
+					AdminPassword string        `json:"admin_password"`
+				}
+				err := json.NewDecoder(rec.Body).Decode(&body)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if body.Tenant.ID != "C1" {
+					t.Errorf("request %d: expected tenant C1, got %q", i+1, body.Tenant.ID)
+				}
+				if got := body.AdminPassword == "generated-password"; got != want {
+					t.Errorf("request %d: expected the password %v, got %q", i+1, want, body.AdminPassword)
+				}
+			}
+		})
+	}
+}
+
+func TestShowTenantNotFound(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{logger: logger, tenants: saga.NewRunner(newFakeWorkflows(), logger)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/tenants/{id}", app.showTenantHandler)
+
+	for _, path := range []string{"/api/v1/tenants/C9", "/api/v1/tenants/not%20valid"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, rec.Code)
+		}
+	}
+}
+
+// A Veeam organization or ZORG that already exists is only taken over when it is the
+// tenant's.
+func TestTenantStepsAdoptOnlyTheTenants(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	veeam := veeamServer(t, &services.VeeamOrg{UID: "urn:veeam:VCloudOrganizationConfig:a1", Name: "acme"})
+	zerto := zertoServer(t,
+		&services.Zorg{ZorgIdentifier: "z1", Name: "acme", CrmIdentifier: "C1"},
+		&services.Zorg{ZorgIdentifier: "z2", Name: "beta", CrmIdentifier: "C2"},
+	)
+
+	// There is no inventory, so nothing it records can show the organization is C1's.
+	app := &application{
+		logger: logger,
+		veeam:  newTestVeeamClient(t, veeam.URL, logger),
+		zerto:  newTestZertoClient(t, zerto.URL, logger),
+	}
+	w := &saga.Workflow{ID: "C1", Input: json.RawMessage(`{"OrganizationName": "acme", "CrmIdentifier": "C1"}`)}
+
+	_, err := app.createTenantVeeamOrg(ctx, w)
+	if !errors.Is(err, errNotTenants) {
+		t.Errorf("expected the veeam organization to be refused, got %v", err)
+	}
+	if transientError(err) {
+		t.Errorf("expected %v not to be retried", err)
+	}
+
+	// The ZORG has C1's CRM identifier.
+	result, err := app.createTenantZorg(ctx, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zorg := result.(tenantZorg); zorg.Created || zorg.Zorg.ZorgIdentifier != "z1" {
+		t.Errorf("expected z1 to be taken over, got %+v", zorg)
+	}
+
+	err = app.checkTenantOwns(ctx, "C1", "C2", zorgResource(&services.Zorg{ZorgIdentifier: "z2"}, inventory.StatusActive))
+	if !errors.Is(err, errNotTenants) {
+		t.Errorf("expected C2's ZORG to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/inventory"
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+)
+
+// vcdProvisionTimeout bounds how long an order may take, creating the org VDC and
+// deploying the edge gateway are the slow parts.
+const vcdProvisionTimeout = 10 * time.Minute
+
+// vcdOrgName is what VCD accepts as an organization name, which also ends up in URLs.
+var vcdOrgName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,63}$`)
+
+// createVcdHandler provisions a VCD organization for a HostBill order. Retrying an
+// order picks up what was already created, so HostBill can safely resend it.
+func (app *application) createVcdHandler(w http.ResponseWriter, r *http.Request) {
+	if app.vcd == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "vcd provisioning is not configured")
+		return
+	}
+
+	var vcdData services.VCDCreateOrgRequest
+
+	// Read and parse the request body
+	err := app.readJSON(w, r, &vcdData)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Strip whitespace from the OrganizationName field
+	vcdData.OrganizationName = strings.ReplaceAll(vcdData.OrganizationName, " ", "")
+
+	errs := validateVcdOrder(vcdData)
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	// Only a new admin gets a password, and this response is the one place it is shown.
+	password, err := vcdPassword()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Provisioning takes longer than the server's write timeout allows.
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(vcdProvisionTimeout + 10*time.Second))
+	if err != nil {
+		app.logger.Error("failed to extend write deadline", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), vcdProvisionTimeout)
+	defer cancel()
+
+	app.logger.Info("provisioning vcd organization", "org", vcdData.OrganizationName, "order", vcdData.CrmIdentifier)
+
+	result, err := app.vcd.ProvisionOrg(ctx, app.vcdProvisionRequest(vcdData, password))
+	if err != nil {
+		var vcdErr *services.VCDError
+		if errors.As(err, &vcdErr) || errors.Is(err, services.ErrVCDNotFound) {
+			app.logError(r, err)
+			app.errorResponse(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.Info("provisioned vcd organization", "org", result.Org.Name, "id", result.Org.ID, "vdc", result.VDC.ID)
+	app.recordInventory(vcdInventoryTenant(vcdData), vcdResources(result, inventory.StatusActive)...)
+
+	env := envelope{"vcd": result}
+	if result.AdminCreated {
+		env["admin_password"] = password
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// vcdProvisionRequest describes the organization to set up for an order, with password
+// for its admin.
+func (app *application) vcdProvisionRequest(order services.VCDCreateOrgRequest, password string) services.VCDProvisionRequest {
+	cfg := app.config.vcd
+
+	displayName := order.CompanyName
+	if displayName == "" {
+		displayName = order.OrganizationName
+	}
+
+	description := "HostBill order"
+	if order.CrmIdentifier != "" {
+		description = "HostBill order " + order.CrmIdentifier
+	}
+
+	return services.VCDProvisionRequest{
+		OrgName:     order.OrganizationName,
+		DisplayName: displayName,
+		Description: description,
+		VDC: services.VCDOrgVDCParams{
+			Name:          order.OrganizationName + "-vdc",
+			Description:   description,
+			ProviderVDC:   cfg.providerVDC,
+			NetworkPool:   cfg.networkPool,
+			StoragePolicy: cfg.storagePolicy,
+			CPUMHz:        order.CpuMHz,
+			MemoryMB:      order.MemoryMB,
+			StorageMB:     order.StorageGb * 1024,
+			NetworkQuota:  10,
+		},
+		AdminRole: cfg.adminRole,
+		Admin: services.VCDUser{
+			Username: order.AdminUsername,
+			FullName: order.AdminFullName,
+			Email:    order.AdminEmail,
+			Password: password,
+		},
+		ExternalNetwork: cfg.externalNetwork,
+	}
+}
+
+// vcdInventoryTenant is the inventory tenant of an order.
+func vcdInventoryTenant(order services.VCDCreateOrgRequest) inventory.Tenant {
+	return inventory.Tenant{
+		CrmIdentifier:     order.CrmIdentifier,
+		Name:              order.OrganizationName,
+		CompanyName:       order.CompanyName,
+		HostbillOrderID:   order.HostbillOrderId,
+		HostbillServiceID: order.HostbillServiceId,
+	}
+}
+
+// validateVcdOrder checks the fields of an order before anything is created in VCD.
+func validateVcdOrder(o services.VCDCreateOrgRequest) map[string]string {
+	errs := map[string]string{}
+
+	if !vcdOrgName.MatchString(o.OrganizationName) {
+		errs["OrganizationName"] = "must be 1 to 64 letters, digits or hyphens"
+	}
+	if o.CpuMHz <= 0 {
+		errs["CpuMHz"] = "must be greater than zero"
+	}
+	if o.MemoryMB <= 0 {
+		errs["MemoryMB"] = "must be greater than zero"
+	}
+	if o.StorageGb <= 0 {
+		errs["StorageGb"] = "must be greater than zero"
+	}
+	if o.AdminUsername == "" {
+		errs["AdminUsername"] = "must be provided"
+	}
+	if !strings.Contains(o.AdminEmail, "@") {
+		errs["AdminEmail"] = "must be a valid email address"
+	}
+
+	return errs
+}
+
+// vcdPassword generates a password for a new org admin.
+func vcdPassword() (string, error) {
+	b := make([]byte, 18)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generating password: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+const vcdOrder = `{"OrganizationName": "Acme Corp", "CrmIdentifier": "C1", "CpuMHz": 2000, "MemoryMB": 4096,
+	"StorageGb": 100, "AdminUsername": "admin", "AdminEmail": "admin@acme.example"}`
+
+// vcdServer is a VCD where the org and its VDC already exist, and the admin does when
+// adminExists is set. Every lookup of the org fails with orgStatus when it is set.
+func vcdServer(t *testing.T, adminExists bool, orgStatus int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		values := func(v ...map[string]string) {
+			json.NewEncoder(w).Encode(map[string]any{"values": v})
+		}
+		admin := map[string]string{"id": "urn:vcloud:user:3", "username": "admin"}
+
+		switch {
+		case r.URL.Path == "/cloudapi/1.0.0/sessions/provider":
+			w.Header().Set("X-VMWARE-VCLOUD-ACCESS-TOKEN", "token")
+		case r.URL.Path == "/cloudapi/1.0.0/orgs" && orgStatus != 0:
+			w.WriteHeader(orgStatus)
+			w.Write([]byte(`{"message": "VCD is down"}`))
+		case r.URL.Path == "/cloudapi/1.0.0/orgs":
+			values(map[string]string{"id": "urn:vcloud:org:1", "name": "AcmeCorp"})
+		case r.URL.Path == "/cloudapi/1.0.0/vdcs":
+			values(map[string]string{"id": "urn:vcloud:vdc:2", "name": "AcmeCorp-vdc"})
+		case r.URL.Path == "/cloudapi/1.0.0/roles":
+			values(map[string]string{"id": "urn:vcloud:role:4", "name": "Organization Administrator"})
+		case r.URL.Path == "/cloudapi/1.0.0/users" && r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(admin)
+		case r.URL.Path == "/cloudapi/1.0.0/users" && adminExists:
+			values(admin)
+		case r.URL.Path == "/cloudapi/1.0.0/users":
+			values()
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func TestCreateVcdHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name        string
+		body        string
+		adminExists bool
+		orgStatus   int
+		want        int
+		// wantPassword is whether the admin's password is in the response.
+		wantPassword bool
+		// wantErrors are the fields a 422 should name.
+		wantErrors []string
+	}{
+		{name: "admin created", body: vcdOrder, want: http.StatusCreated, wantPassword: true},
+		// The order was sent again, and the admin's password was shown the first time.
+		{name: "admin exists", body: vcdOrder, adminExists: true, want: http.StatusCreated},
+		{
+			name:       "invalid order",
+			body:       `{"OrganizationName": "acme_corp", "CpuMHz": 0, "MemoryMB": 1024, "StorageGb": 10, "AdminEmail": "admin"}`,
+			want:       http.StatusUnprocessableEntity,
+			wantErrors: []string{"OrganizationName", "CpuMHz", "AdminUsername", "AdminEmail"},
+		},
+		{name: "VCD fails", body: vcdOrder, orgStatus: http.StatusInternalServerError, want: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := vcdServer(t, tt.adminExists, tt.orgStatus)
+
+			vcd, err := services.NewVCDClient(services.VCDConfig{
+				Username: "hostbill",
+				Password: "secret",
+				HTTP:     vendorhttp.Config{BaseURL: srv.URL, RetryWait: time.Millisecond, Logger: logger},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app := &application{logger: logger, vcd: vcd}
+			app.config.vcd.adminRole = "Organization Administrator"
+
+			rec := httptest.NewRecorder()
+			app.createVcdHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/vcd", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d %s", tt.want, rec.Code, rec.Body)
+			}
+
+			var body struct {
+				VCD           services.VCDProvisionResult `json:"vcd"`
+				AdminPassword string                      `json:"admin_password"`
+				Error         map[string]string           `json:"error"`
+			}
+			json.NewDecoder(rec.Body).Decode(&body)
+
+			if got := body.AdminPassword != ""; got != tt.wantPassword {
+				t.Errorf("expected the password %v, got %q", tt.wantPassword, body.AdminPassword)
+			}
+
+			if tt.wantErrors != nil {
+				if len(body.Error) != len(tt.wantErrors) {
+					t.Errorf("expected errors about %v, got %v", tt.wantErrors, body.Error)
+				}
+				for _, field := range tt.wantErrors {
+					if body.Error[field] == "" {
+						t.Errorf("expected an error about %s, got %v", field, body.Error)
+					}
+				}
+				// Nothing is sent to VCD for an order that isn't valid.
+				if n := requests.Load(); n != 0 {
+					t.Errorf("expected no requests to VCD, got %d", n)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateVcdHandlerNotConfigured(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	rec := httptest.NewRecorder()
+	app.createVcdHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/vcd", strings.NewReader(vcdOrder)))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
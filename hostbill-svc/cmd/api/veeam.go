@@ -1,228 +1,312 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/inventory"
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
 )
 
-// VeeamSessionRequest represents the request body for obtaining a Veeam session token
-type VeeamSessionRequest struct {
-	URL      string `json:"url"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+// VeeamCreateOrgRequest represents the request body for creating a Veeam organization.
+// The backup server, repository and host default to the configured ones, the
+// repository name to -veeam-repository-name or else the organization's name, and the
+// job scheduler to Full.
+type VeeamCreateOrgRequest struct {
+	OrganizationName       string      `json:"OrganizationName"`
+	BackupServerUid        string      `json:"BackupServerUid"`
+	RepositoryUid          string      `json:"RepositoryUid"`
+	QuotaGb                json.Number `json:"QuotaGb"`
+	RepositoryFriendlyName string      `json:"RepositoryFriendlyName"`
+	JobSchedulerType       string      `json:"JobSchedulerType"`
+	HighPriorityJob        bool        `json:"HighPriorityJob"`
+	HostUid                string      `json:"HostUid"`
+
+	// CrmIdentifier, HostbillOrderId and HostbillServiceId are only recorded in the
+	// inventory.
+	CrmIdentifier     string `json:"CrmIdentifier"`
+	HostbillOrderId   string `json:"HostbillOrderId"`
+	HostbillServiceId string `json:"HostbillServiceId"`
 }
 
-// VeeamSessionResponse represents the response body for obtaining a Veeam session token
-type VeeamSessionResponse struct {
-	SessionId string `json:"sessionId"`
+// VeeamCreateOrgResponse represents the response body for creating a Veeam organization
+type VeeamCreateOrgResponse struct {
+	Message string `json:"message"`
 }
 
-func (app *application) veeamSession(v VeeamSessionRequest) (VeeamSessionResponse, error) {
-	// Create POST request
-	req, err := http.NewRequest("POST", v.URL, nil)
-	if err != nil {
-		app.logger.Error("Failed to create Veeam session request", "error", err)
-		return VeeamSessionResponse{}, err
+func (app *application) createVeeamHandler(w http.ResponseWriter, r *http.Request) {
+	if app.veeam == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "veeam is not configured")
+		return
 	}
 
-	// Set Basic authentication header
-	req.SetBasicAuth(v.Username, v.Password)
+	var veeamData VeeamCreateOrgRequest
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+	// Read and parse the request body
+	err := app.readJSON(w, r, &veeamData)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	// Send request
-	client := &http.Client{
-		Transport: transport,
-	}
+	// Strip whitespace from the OrganizationName field
+	veeamData.OrganizationName = strings.ReplaceAll(veeamData.OrganizationName, " ", "")
 
-	app.logger.Info("stuf", "things", v)
+	errs := validateVeeamOrder(veeamData)
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
 
-	res, err := client.Do(req)
+	err = app.veeam.CreateOrg(r.Context(), app.veeamOrgSpec(veeamData))
 	if err != nil {
-		app.logger.Error("Failed to send Veeam session request", "error", err)
-		return VeeamSessionResponse{}, err
+		app.veeamErrorResponse(w, r, err)
+		return
 	}
-	defer res.Body.Close()
 
-	// Check response status code
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		// Read the response body
-		body, err := io.ReadAll(res.Body)
+	app.logger.Info("veeam organization created", "org", veeamData.OrganizationName)
+
+	// Creating an organization doesn't return it, and the inventory needs its UID.
+	if app.inventory != nil {
+		org, err := app.veeam.FindOrg(r.Context(), veeamData.OrganizationName)
 		if err != nil {
-			app.logger.Error("Failed to read Veeam session response body", "error", err)
+			app.logger.Error("looking up created veeam organization", "org", veeamData.OrganizationName, "error", err)
 		} else {
-			app.logger.Error("Veeam session request failed", "status", res.Status, "body", string(body))
-		}
-
-		// Log the response headers
-		var headers strings.Builder
-		for name, values := range res.Header {
-			headers.WriteString(fmt.Sprintf("%s: %s\n", name, strings.Join(values, ", ")))
+			app.recordInventory(inventory.Tenant{
+				CrmIdentifier:     veeamData.CrmIdentifier,
+				Name:              veeamData.OrganizationName,
+				HostbillOrderID:   veeamData.HostbillOrderId,
+				HostbillServiceID: veeamData.HostbillServiceId,
+			}, veeamResource(org, inventory.StatusActive))
 		}
-		app.logger.Error("Veeam session response headers", "headers", headers.String())
+	}
 
-		return VeeamSessionResponse{}, fmt.Errorf("veeam session request failed with status: %s", res.Status)
+	// Return a success response
+	response := map[string]string{"message": "Veeam organization created successfully"}
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
+}
 
-	// Extract the session ID from the response headers
-	sessionId := res.Header.Get("X-RestSvcSessionId")
+// validateVeeamOrder checks the fields of an order before anything is created in Veeam.
+func validateVeeamOrder(o VeeamCreateOrgRequest) map[string]string {
+	errs := map[string]string{}
 
-	if sessionId == "" {
-		app.logger.Error("Failed to extract session ID from response headers")
-		return VeeamSessionResponse{}, fmt.Errorf("failed to extract session ID from response headers")
+	if o.OrganizationName == "" {
+		errs["OrganizationName"] = "must be provided"
 	}
-
-	// Create the response struct
-	responseStruct := VeeamSessionResponse{
-		SessionId: sessionId,
+	if quotaGb, err := o.QuotaGb.Float64(); err != nil || quotaGb <= 0 {
+		errs["QuotaGb"] = "must be a number greater than zero"
+	}
+	if o.JobSchedulerType != "" && o.JobSchedulerType != "Full" && o.JobSchedulerType != "Basic" {
+		errs["JobSchedulerType"] = "must be Full or Basic"
 	}
-	app.logger.Info("Veeam session response", "response", responseStruct)
 
-	return responseStruct, nil
+	return errs
 }
 
-// VeeamCreateOrgRequest represents the request body for creating a Veeam organization
-type VeeamCreateOrgRequest struct {
-	OrganizationName       string      `json:"OrganizationName"`
-	BackupServerUid        string      `json:"BackupServerUid"`
-	RepositoryUid          string      `json:"RepositoryUid"`
-	QuotaGb                json.Number `json:"QuotaGb"`
-	RepositoryFriendlyName string      `json:"RepositoryFriendlyName"`
-	JobSchedulerType       string      `json:"JobSchedulerType"`
-	HighPriorityJob        bool        `json:"HighPriorityJob"`
-	HostUid                string      `json:"HostUid"`
-}
+// veeamOrgSpec is the request body for creating the organization in o, with the
+// configured settings for anything o leaves empty.
+func (app *application) veeamOrgSpec(o VeeamCreateOrgRequest) map[string]any {
+	orDefault := func(s, def string) string {
+		if s != "" {
+			return s
+		}
+		return def
+	}
 
-// VeeamCreateOrgResponse represents the response body for creating a Veeam organization
-type VeeamCreateOrgResponse struct {
-	Message string `json:"message"`
+	return map[string]any{
+		"OrganizationName":       o.OrganizationName,
+		"BackupServerUid":        orDefault(o.BackupServerUid, app.config.veeam.backupServerUID),
+		"RepositoryUid":          orDefault(o.RepositoryUid, app.config.veeam.repositoryUID),
+		"QuotaGb":                o.QuotaGb,
+		"RepositoryFriendlyName": orDefault(o.RepositoryFriendlyName, orDefault(app.config.veeam.repositoryName, o.OrganizationName)),
+		"JobSchedulerType":       orDefault(o.JobSchedulerType, "Full"),
+		"HighPriorityJob":        o.HighPriorityJob,
+		"HostUid":                orDefault(o.HostUid, app.config.veeam.hostUID),
+	}
 }
 
-func (app *application) createVeeamHandler(w http.ResponseWriter, r *http.Request) {
-	var veeamData VeeamCreateOrgRequest
+// veeamOrgFromPath looks up the organization named by the {id} path parameter, which
+// is either its UID or its name. It sends an error response and returns false if it
+// can't.
+func (app *application) veeamOrgFromPath(w http.ResponseWriter, r *http.Request) (*services.VeeamOrg, bool) {
+	if app.veeam == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "veeam is not configured")
+		return nil, false
+	}
 
-	// Read and parse the request body
-	err := app.readJSON(w, r, &veeamData)
+	ref := strings.TrimSpace(r.PathValue("id"))
+	if ref == "" {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	org, err := app.veeam.LookupOrg(r.Context(), ref)
 	if err != nil {
-		app.logger.Error("Failed to read request body", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		app.veeamErrorResponse(w, r, err)
+		return nil, false
 	}
 
-	app.logger.Info("Received request", "body", veeamData)
+	return org, true
+}
 
-	// Obtain a Veeam session token
-	// Need to use Github Secrets here
-	sessionConfig := VeeamSessionRequest{
-		URL:      os.Getenv("VEEAM_URL") + os.Getenv("VEEAM_SESSION"),
-		Username: os.Getenv("VEEAM_USERNAME"),
-		Password: os.Getenv("VEEAM_PASSWORD"),
+// veeamInventoryTenant returns the inventory tenant a change to org is recorded for.
+// Veeam doesn't know the CRM identifier, so it is the one of the tenant the inventory
+// has the organization under, as it was recorded when it was created. An organization
+// the inventory doesn't know is recorded for a tenant with its name.
+func (app *application) veeamInventoryTenant(ctx context.Context, org *services.VeeamOrg) inventory.Tenant {
+	tenant := inventory.Tenant{Name: org.Name}
+	if app.inventory == nil {
+		return tenant
 	}
-	sessionToken, err := app.veeamSession(sessionConfig)
 
-	app.logger.Info("Received request", "sessionToken", sessionToken)
+	ctx, cancel := context.WithTimeout(ctx, inventoryTimeout)
+	defer cancel()
 
+	tenants, _, err := app.inventory.List(ctx, inventory.Filter{Vendor: inventory.VendorVeeam, ExternalID: org.UID, Limit: 1})
 	if err != nil {
-		app.logger.Error("Failed to obtain Veeam session token", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		app.logger.Error("looking up veeam organization in inventory", "org", org.Name, "uid", org.UID, "error", err)
+		return tenant
+	}
+	if len(tenants) == 0 || tenants[0].CrmIdentifier == "" {
+		return tenant
 	}
 
-	// Strip whitespace from the OrganizationName field
-	veeamData.OrganizationName = strings.ReplaceAll(veeamData.OrganizationName, " ", "")
+	return inventory.Tenant{CrmIdentifier: tenants[0].CrmIdentifier}
+}
 
-	// Create the request body for the Veeam API
-	veeamBody := map[string]interface{}{
-		"OrganizationName":       veeamData.OrganizationName,
-		"BackupServerUid":        os.Getenv("VEEAM_BACKUP_SERVER_UID"),
-		"RepositoryUid":          os.Getenv("VEEAM_REPOSITORY_UID"),
-		"QuotaGb":                veeamData.QuotaGb,
-		"RepositoryFriendlyName": "Testing Boii",
-		"JobSchedulerType":       "Full",
-		"HighPriorityJob":        false,
-		"HostUid":                os.Getenv("VEEAM_HOST_UID"),
+// veeamErrorResponse sends the right response for an error from the Veeam API.
+func (app *application) veeamErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var veeamErr *services.VeeamError
+
+	switch {
+	case errors.Is(err, services.ErrVeeamNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "veeam organization not found")
+	case errors.As(err, &veeamErr):
+		app.logError(r, err)
+		app.errorResponse(w, r, http.StatusBadGateway, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
 	}
+}
 
-	// Log the JSON data being sent
-	jsonData, _ := json.Marshal(veeamBody)
-	app.logger.Info("Request JSON data", "data", string(jsonData))
-
-	// Create the POST request to the Veeam API
-	veeamOrgURL := os.Getenv("VEEAM_URL") + os.Getenv("VEEAM_CREATE_ORG")
+// updateVeeamHandler changes the repository quota of an organization.
+func (app *application) updateVeeamHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		QuotaGb json.Number `json:"QuotaGb"`
+	}
 
-	req, err := http.NewRequest("POST", veeamOrgURL, bytes.NewBuffer(jsonData))
+	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.logger.Error("Failed to create Veeam API request", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-RestSvcSessionId", sessionToken.SessionId)
+	quotaGb, err := input.QuotaGb.Float64()
+	if err != nil || quotaGb <= 0 {
+		app.failedValidationResponse(w, r, map[string]string{"QuotaGb": "must be a number greater than zero"})
+		return
+	}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+	org, ok := app.veeamOrgFromPath(w, r)
+	if !ok {
+		return
 	}
 
-	// Send the request to the Veeam API
-	client := &http.Client{
-		Transport: transport,
+	if _, archived := org.ArchivedAt(); archived {
+		app.errorResponse(w, r, http.StatusConflict, "veeam organization is archived")
+		return
 	}
-	resp, err := client.Do(req)
+
+	previous := org.QuotaGb
+
+	err = app.veeam.SetQuota(r.Context(), org, quotaGb)
 	if err != nil {
-		app.logger.Error("Failed to send Veeam API request", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		app.veeamErrorResponse(w, r, err)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Log the response body
-	responseBody, _ := io.ReadAll(resp.Body)
-	app.logger.Info("Response body", "data", string(responseBody))
+	app.logger.Info("veeam quota changed", "org", org.Name, "uid", org.UID, "from", previous, "to", quotaGb)
+	app.recordInventory(app.veeamInventoryTenant(r.Context(), org), veeamResource(org, inventory.StatusActive))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"veeam": org, "previous_quota_gb": previous}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
-	// Check the response status code
-	if resp.StatusCode != http.StatusOK {
-		app.logger.Error("Veeam API request failed", "status", resp.StatusCode)
-		http.Error(w, "Failed to create Veeam organization", resp.StatusCode)
+// archiveVeeamHandler archives an organization: its jobs stop running but its backups
+// are kept, and it can be deleted once the grace period is over.
+func (app *application) archiveVeeamHandler(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.veeamOrgFromPath(w, r)
+	if !ok {
 		return
 	}
 
-	// Return a success response
-	response := map[string]string{"message": "Veeam organization created successfully"}
-	if err := app.writeJSON(w, http.StatusOK, response, nil); err != nil {
-		app.logger.Error("Failed to encode JSON response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	disabled, err := app.veeam.Archive(r.Context(), org, time.Now())
+	if err != nil {
+		app.veeamErrorResponse(w, r, err)
 		return
 	}
-}
 
-func (app *application) updateVeeamHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, "Add Veeam storage...")
+	archivedAt, _ := org.ArchivedAt()
+	deleteAfter := archivedAt.Add(app.config.veeamDeleteGrace)
+
+	app.logger.Info("veeam organization archived", "org", org.Name, "uid", org.UID, "disabled_jobs", len(disabled))
+	app.recordInventory(app.veeamInventoryTenant(r.Context(), org), veeamResource(org, inventory.StatusArchived))
+
+	env := envelope{
+		"veeam":         org,
+		"disabled_jobs": disabled,
+		"archived_at":   archivedAt,
+		"delete_after":  deleteAfter,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
+// deleteVeeamHandler deletes an organization that was archived longer ago than the
+// grace period.
 func (app *application) deleteVeeamHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+	org, ok := app.veeamOrgFromPath(w, r)
+	if !ok {
+		return
+	}
 
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil || id < 1 {
-		app.logger.Error(err.Error())
-		http.NotFound(w, r)
+	archivedAt, archived := org.ArchivedAt()
+	if !archived {
+		app.errorResponse(w, r, http.StatusConflict, "veeam organization must be archived before it is deleted")
 		return
 	}
 
-	fmt.Fprintf(w, "Delete Veeam storage with ID %d...\n", id)
+	deleteAfter := archivedAt.Add(app.config.veeamDeleteGrace)
+	if time.Now().Before(deleteAfter) {
+		message := fmt.Sprintf("veeam organization can be deleted after %s", deleteAfter.UTC().Format(time.RFC3339))
+		app.errorResponse(w, r, http.StatusConflict, message)
+		return
+	}
+
+	err := app.veeam.DeleteOrg(r.Context(), org)
+	if err != nil {
+		app.veeamErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.Info("veeam organization deleted", "org", org.Name, "uid", org.UID)
+	app.recordInventory(app.veeamInventoryTenant(r.Context(), org), veeamResource(org, inventory.StatusDeleted))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"veeam": org, "deleted": true}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+// veeamServer is an Enterprise Manager holding orgs, without jobs, whose changes finish
+// straight away.
+func veeamServer(t *testing.T, orgs ...*services.VeeamOrg) *httptest.Server {
+	var mu sync.Mutex
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		finished := services.VeeamTask{State: "Finished"}
+
+		switch {
+		case r.URL.Path == "/api/sessionMngr/":
+			w.Header().Set("X-RestSvcSessionId", "session")
+		case r.URL.Path == "/api/jobs":
+			json.NewEncoder(w).Encode(map[string]any{"Jobs": []any{}})
+		case r.URL.Path == "/api/vCloud/orgConfigs":
+			json.NewEncoder(w).Encode(map[string]any{"VCloudOrganizationConfigs": orgs})
+		default:
+			id := strings.TrimPrefix(r.URL.Path, "/api/vCloud/orgConfigs/")
+			for i, org := range orgs {
+				if !strings.HasSuffix(org.UID, ":"+id) {
+					continue
+				}
+
+				switch r.Method {
+				case http.MethodGet:
+					json.NewEncoder(w).Encode(org)
+				case http.MethodPut:
+					json.NewDecoder(r.Body).Decode(org)
+					json.NewEncoder(w).Encode(finished)
+				case http.MethodDelete:
+					orgs = append(orgs[:i:i], orgs[i+1:]...)
+					json.NewEncoder(w).Encode(finished)
+				}
+				return
+			}
+
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestVeeamLifecycleHandlers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	grace := 720 * time.Hour
+
+	archived := func(ago time.Duration) string {
+		return "Acme backups [archived " + time.Now().Add(-ago).UTC().Format(time.RFC3339) + "]"
+	}
+
+	tests := []struct {
+		name        string
+		description string
+		method      string
+		path        string
+		body        string
+		want        int
+	}{
+		{"update", "Acme backups", http.MethodPut, "/api/v1/veeam/acme", `{"QuotaGb": 750}`, http.StatusOK},
+		{"update by UID", "Acme backups", http.MethodPut, "/api/v1/veeam/urn:veeam:VCloudOrganizationConfig:a1", `{"QuotaGb": 750}`, http.StatusOK},
+		{"update archived", archived(time.Hour), http.MethodPut, "/api/v1/veeam/acme", `{"QuotaGb": 750}`, http.StatusConflict},
+		{"update without quota", "Acme backups", http.MethodPut, "/api/v1/veeam/acme", `{"QuotaGb": 0}`, http.StatusUnprocessableEntity},
+		{"update unknown", "Acme backups", http.MethodPut, "/api/v1/veeam/beta", `{"QuotaGb": 750}`, http.StatusNotFound},
+		{"archive", "Acme backups", http.MethodPost, "/api/v1/veeam/acme/archive", "", http.StatusOK},
+		{"delete before archiving", "Acme backups", http.MethodDelete, "/api/v1/veeam/acme", "", http.StatusConflict},
+		{"delete inside the grace period", archived(grace - time.Hour), http.MethodDelete, "/api/v1/veeam/acme", "", http.StatusConflict},
+		{"delete after the grace period", archived(grace + time.Hour), http.MethodDelete, "/api/v1/veeam/acme", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org := &services.VeeamOrg{UID: "urn:veeam:VCloudOrganizationConfig:a1", Name: "acme", QuotaGb: 500, Description: tt.description}
+			srv := veeamServer(t, org)
+
+			app := &application{logger: logger, veeam: newTestVeeamClient(t, srv.URL, logger)}
+			app.config.veeamDeleteGrace = grace
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("PUT /api/v1/veeam/{id}", app.updateVeeamHandler)
+			mux.HandleFunc("POST /api/v1/veeam/{id}/archive", app.archiveVeeamHandler)
+			mux.HandleFunc("DELETE /api/v1/veeam/{id}", app.deleteVeeamHandler)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d %s", tt.want, rec.Code, rec.Body)
+			}
+
+			// Nothing is changed by a request that is refused.
+			if tt.want != http.StatusOK && (org.QuotaGb != 500 || org.Description != tt.description) {
+				t.Errorf("expected the organization to be unchanged, got %+v", org)
+			}
+		})
+	}
+}
+
+// Archiving an archived organization answers with the time it was first archived.
+func TestArchiveVeeamHandlerIsIdempotent(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	org := &services.VeeamOrg{UID: "urn:veeam:VCloudOrganizationConfig:a1", Name: "acme", Description: "Acme backups"}
+	srv := veeamServer(t, org)
+
+	app := &application{logger: logger, veeam: newTestVeeamClient(t, srv.URL, logger)}
+	app.config.veeamDeleteGrace = time.Hour
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/veeam/{id}/archive", app.archiveVeeamHandler)
+
+	var archivedAt []time.Time
+	for i := range 2 {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/veeam/acme/archive", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d %s", i+1, rec.Code, rec.Body)
+		}
+
+		var body struct {
+			ArchivedAt  time.Time `json:"archived_at"`
+			DeleteAfter time.Time `json:"delete_after"`
+		}
+		err := json.NewDecoder(rec.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !body.DeleteAfter.Equal(body.ArchivedAt.Add(time.Hour)) {
+			t.Errorf("request %d: expected deletion an hour after %s, got %s", i+1, body.ArchivedAt, body.DeleteAfter)
+		}
+		archivedAt = append(archivedAt, body.ArchivedAt)
+
+		// The next request is a second later, so a new time would show.
+		time.Sleep(time.Second)
+	}
+
+	if !archivedAt[0].Equal(archivedAt[1]) {
+		t.Errorf("expected the same archive time, got %v", archivedAt)
+	}
+	if !strings.HasPrefix(org.Description, "Acme backups [archived ") {
+		t.Errorf("expected the description to be kept, got %q", org.Description)
+	}
+}
+
+func newTestVeeamClient(t *testing.T, url string, logger *slog.Logger) *services.VeeamClient {
+	t.Helper()
+
+	c, err := services.NewVeeamClient(services.VeeamClientConfig{
+		SessionPath:    "/api/sessionMngr/",
+		OrgConfigsPath: "/api/vCloud/orgConfigs",
+		HTTP:           vendorhttp.Config{BaseURL: url, RetryWait: time.Millisecond, Logger: logger},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestVeeamHandlersNotConfigured(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/v1/veeam/{id}", app.deleteVeeamHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/veeam/acme", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
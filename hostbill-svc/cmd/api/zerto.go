@@ -1,204 +1,232 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
+	"time"
 
+	"github.com/CloudKey-io/hostbill-svc/internal/inventory"
 	"github.com/CloudKey-io/hostbill-svc/internal/services"
 )
 
-// TODO: Move API logic to internal/services/zerto.go
-// TODO: Comments
-// TODO: Cleanup extra logs
-
-func (app *application) zertoSession(r services.ZertoSessionRequest) (w services.ZertoSessionResponse, e error) {
-	// Create POST request
-	req, err := http.NewRequest("POST", r.URL, nil)
-	if err != nil {
-		app.logger.Error("Failed to create Zerto session request", "error", err)
-		return services.ZertoSessionResponse{}, err
+// createZertoHandler creates the ZORG of an order and records it in the inventory.
+func (app *application) createZertoHandler(w http.ResponseWriter, r *http.Request) {
+	if app.zerto == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "zerto is not configured")
+		return
 	}
 
-	// Set Basic authentication header
-	req.SetBasicAuth(r.Username, r.Password)
+	var zertoData services.ZertoCreateOrgRequest
 
-	// Disable TLS
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: !app.config.useTLS,
-		},
-	}
-	client := &http.Client{
-		Transport: transport,
+	err := app.readJSON(w, r, &zertoData)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	// Send request
-	res, err := client.Do(req)
+	err = app.zerto.CreateZorg(r.Context(), zorgSpec(zertoData))
 	if err != nil {
-		app.logger.Error("failed to send Zerto session request", "error", err)
-		return services.ZertoSessionResponse{}, err
+		app.zertoErrorResponse(w, r, err)
+		return
 	}
-	defer res.Body.Close()
 
-	// Check response status code
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		// Read the response body
-		body, err := io.ReadAll(res.Body)
+	app.logger.Info("zorg created", "name", zertoData.Name, "crm_identifier", zertoData.CrmIdentifier)
+
+	// Creating a ZORG doesn't return it, and the inventory needs its identifier.
+	if app.inventory != nil {
+		zorg, err := app.zerto.FindZorg(r.Context(), zertoData.CrmIdentifier)
 		if err != nil {
-			app.logger.Error("failed to read Zerto session response body", "error", err)
+			app.logger.Error("looking up created zorg", "crm_identifier", zertoData.CrmIdentifier, "error", err)
 		} else {
-			app.logger.Error("zerto session request failed", "status", res.Status, "body", string(body))
+			app.recordInventory(inventory.Tenant{
+				CrmIdentifier:     zertoData.CrmIdentifier,
+				Name:              zertoData.Name,
+				HostbillOrderID:   zertoData.HostbillOrderId,
+				HostbillServiceID: zertoData.HostbillServiceId,
+			}, zorgResource(zorg, inventory.StatusActive))
 		}
+	}
 
-		// Log the response headers
-		var headers strings.Builder
-		for name, values := range res.Header {
-			headers.WriteString(fmt.Sprintf("%s: %s\n", name, strings.Join(values, ", ")))
-		}
-		app.logger.Error("zerto session response headers", "headers", headers.String())
+	response := map[string]string{"message": "Zerto organization created successfully"}
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
 
-		return services.ZertoSessionResponse{}, fmt.Errorf("zerto session request failed with status: %s", res.Status)
+// zorgSpec is the request body for creating the ZORG of an order.
+func zorgSpec(order services.ZertoCreateOrgRequest) map[string]any {
+	return map[string]any{
+		"Name":          order.Name,
+		"CrmIdentifier": order.CrmIdentifier,
+		"TenantInfo": map[string]any{
+			"CompanyName":             order.Name,
+			"DomainName":              order.Name + ".cloudkey.io",
+			"Country":                 order.TenantInfo.Country,
+			"State":                   order.TenantInfo.State,
+			"PostalCode":              order.TenantInfo.PostalCode,
+			"IsMultiCloudProductType": order.TenantInfo.IsMultiCloudProductType,
+		},
 	}
+}
 
-	// Extract the session ID from the response headers
-	sessionId := res.Header.Get("x-zerto-session")
+// zorgFromPath looks up the ZORG whose CRM identifier is the {id}
+// path parameter. It sends an error response and returns false if it can't.
+func (app *application) zorgFromPath(w http.ResponseWriter, r *http.Request) (*services.Zorg, bool) {
+	if app.zerto == nil {
+		app.errorResponse(w, r, http.StatusServiceUnavailable, "zerto is not configured")
+		return nil, false
+	}
 
-	if sessionId == "" {
-		app.logger.Error("failed to extract session Id from response headers")
-		return services.ZertoSessionResponse{}, fmt.Errorf("failed to extract session Id from response headers")
+	crmIdentifier := strings.TrimSpace(r.PathValue("id"))
+	if crmIdentifier == "" {
+		app.notFoundResponse(w, r)
+		return nil, false
 	}
 
-	// Create the response struct
-	responseStruct := services.ZertoSessionResponse{
-		SessionId: sessionId,
+	zorg, err := app.zerto.FindZorg(r.Context(), crmIdentifier)
+	if err != nil {
+		app.zertoErrorResponse(w, r, err)
+		return nil, false
 	}
-	app.logger.Info("zerto session response", "response", responseStruct)
 
-	return responseStruct, nil
+	return zorg, true
 }
 
-func (app *application) createZertoHandler(w http.ResponseWriter, r *http.Request) {
-	var zertoData services.ZertoCreateOrgRequest
+// zertoErrorResponse sends the right response for an error from the Zerto API.
+func (app *application) zertoErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var zertoErr *services.ZertoError
 
-	// Read and parse the request body
-	err := app.readJSON(w, r, &zertoData)
+	switch {
+	case errors.Is(err, services.ErrZorgNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "zorg not found")
+	case errors.As(err, &zertoErr):
+		app.logError(r, err)
+		app.errorResponse(w, r, http.StatusBadGateway, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateZertoHandler changes the name, tenant info or permissions of a ZORG.
+func (app *application) updateZertoHandler(w http.ResponseWriter, r *http.Request) {
+	var input services.ZertoUpdateOrgRequest
+
+	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.logger.Error("Failed to read request body", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil && strings.TrimSpace(*input.Name) == "" {
+		app.failedValidationResponse(w, r, map[string]string{"Name": "must not be empty"})
 		return
 	}
 
-	app.logger.Info("Received request", "body", zertoData)
+	zorg, ok := app.zorgFromPath(w, r)
+	if !ok {
+		return
+	}
 
-	// Obtain a zerto session token
-	sessionConfig := services.ZertoSessionRequest{
-		URL:      os.Getenv("ZERTO_URL") + os.Getenv("ZERTO_SESSION_START"),
-		Username: os.Getenv("ZERTO_USER"),
-		Password: os.Getenv("ZERTO_PW"),
+	if _, archived := zorg.ArchivedAt(); archived {
+		app.errorResponse(w, r, http.StatusConflict, "zorg is archived")
+		return
 	}
-	sessionToken, err := app.zertoSession(sessionConfig)
 
-	app.logger.Info("Received request", "sessionToken", sessionToken)
+	if input.Name != nil {
+		zorg.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.TenantInfo != nil {
+		zorg.TenantInfo = *input.TenantInfo
+	}
+	if input.Permissions != nil {
+		zorg.Permissions = *input.Permissions
+	}
 
+	err = app.zerto.UpdateZorg(r.Context(), zorg)
 	if err != nil {
-		app.logger.Error("Failed to obtain zerto session token", "URL", sessionConfig.URL)
-		app.logger.Error("Failed to obtain zerto session token", "username", sessionConfig.Username)
-		app.logger.Error("Failed to obtain zerto session token", "password", sessionConfig.Password)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		app.zertoErrorResponse(w, r, err)
 		return
 	}
 
-	// Create the request body for the Zerto API
-	zertoBody := map[string]interface{}{
-		"Name":          zertoData.Name,
-		"CrmIdentifier": zertoData.CrmIdentifier,
-		"TenantInfo": map[string]interface{}{
-			"CompanyName":             zertoData.Name,
-			"DomainName":              zertoData.Name + ".cloudkey.io",
-			"Country":                 zertoData.TenantInfo.Country,
-			"State":                   zertoData.TenantInfo.State,
-			"PostalCode":              zertoData.TenantInfo.PostalCode,
-			"IsMultiCloudProductType": zertoData.TenantInfo.IsMultiCloudProductType,
-		},
+	app.logger.Info("zorg updated", "zorg", zorg.ZorgIdentifier, "crm_identifier", zorg.CrmIdentifier)
+	app.recordInventory(inventory.Tenant{CrmIdentifier: zorg.CrmIdentifier}, zorgResource(zorg, inventory.StatusActive))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"zerto": zorg}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
+}
 
-	// Log the JSON services being sent
-	jsonData, _ := json.Marshal(zertoBody)
-	app.logger.Info("Request JSON services", "services", string(jsonData))
+// archiveZertoHandler archives a ZORG: its users lose access but its VPGs keep
+// replicating, and it can be deleted once the grace period is over. The permissions it
+// had are returned, to restore them if the customer comes back.
+func (app *application) archiveZertoHandler(w http.ResponseWriter, r *http.Request) {
+	zorg, ok := app.zorgFromPath(w, r)
+	if !ok {
+		return
+	}
 
-	// Create the POST request to the Zerto API
-	zertoOrgURL := os.Getenv("ZERTO_URL") + os.Getenv("ZERTO_CREATE_ZORG")
+	previous := zorg.Permissions
 
-	req, err := http.NewRequest("POST", zertoOrgURL, bytes.NewBuffer(jsonData))
+	err := app.zerto.ArchiveZorg(r.Context(), zorg, time.Now())
 	if err != nil {
-		app.logger.Error("Failed to create zerto API request", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		app.zertoErrorResponse(w, r, err)
 		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-zerto-session", sessionToken.SessionId)
+	archivedAt, _ := zorg.ArchivedAt()
 
-	// Disable TLS
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: !app.config.useTLS,
-		},
-	}
-	client := &http.Client{
-		Transport: transport,
+	app.logger.Info("zorg archived", "zorg", zorg.ZorgIdentifier, "crm_identifier", zorg.CrmIdentifier)
+	app.recordInventory(inventory.Tenant{CrmIdentifier: zorg.CrmIdentifier}, zorgResource(zorg, inventory.StatusArchived))
+
+	env := envelope{
+		"zerto":                zorg,
+		"previous_permissions": previous,
+		"archived_at":          archivedAt,
+		"delete_after":         archivedAt.Add(app.config.zertoDeleteGrace),
 	}
 
-	// Send the request to the Zerto API
-	res, err := client.Do(req)
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
-		app.logger.Error("Failed to send Zerto API request", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		app.serverErrorResponse(w, r, err)
 	}
-	defer res.Body.Close()
-
-	// Log response body
-	responseBody, _ := io.ReadAll(res.Body)
-	app.logger.Info("Response body", "services", string(responseBody))
+}
 
-	// Check the response status code
-	if res.StatusCode != http.StatusOK {
-		app.logger.Error("Zerto API request failed", "status", res.StatusCode)
-		http.Error(w, "Failed to create zerto organization", res.StatusCode)
+// deleteZertoHandler deletes a ZORG that was archived longer ago than the grace
+// period.
+func (app *application) deleteZertoHandler(w http.ResponseWriter, r *http.Request) {
+	zorg, ok := app.zorgFromPath(w, r)
+	if !ok {
 		return
 	}
 
-	// Return a success response
-	response := map[string]string{"message": "Zerto organization created successfully"}
-	if err := app.writeJSON(w, http.StatusOK, response, nil); err != nil {
-		app.logger.Error("Failed to encode JSON response", "error", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	archivedAt, archived := zorg.ArchivedAt()
+	if !archived {
+		app.errorResponse(w, r, http.StatusConflict, "zorg must be archived before it is deleted")
 		return
 	}
-}
-
-func (app *application) updateZertoHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, "Add Zerto storage...")
-}
 
-func (app *application) deleteZertoHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
+	deleteAfter := archivedAt.Add(app.config.zertoDeleteGrace)
+	if time.Now().Before(deleteAfter) {
+		message := fmt.Sprintf("zorg can be deleted after %s", deleteAfter.UTC().Format(time.RFC3339))
+		app.errorResponse(w, r, http.StatusConflict, message)
+		return
+	}
 
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil || id < 1 {
-		app.logger.Error(err.Error())
-		http.NotFound(w, r)
+	err := app.zerto.DeleteZorg(r.Context(), zorg)
+	if err != nil {
+		app.zertoErrorResponse(w, r, err)
 		return
 	}
 
-	fmt.Fprintf(w, "Delete Zerto storage with ID %d...\n", id)
+	app.logger.Info("zorg deleted", "zorg", zorg.ZorgIdentifier, "crm_identifier", zorg.CrmIdentifier)
+	app.recordInventory(inventory.Tenant{CrmIdentifier: zorg.CrmIdentifier}, zorgResource(zorg, inventory.StatusDeleted))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"zerto": zorg, "deleted": true}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/services"
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+// zertoServer is a Zerto holding zorgs.
+func zertoServer(t *testing.T, zorgs ...*services.Zorg) *httptest.Server {
+	var mu sync.Mutex
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/v1/session/add":
+			w.Header().Set("x-zerto-session", "session")
+		case r.URL.Path == "/v1/zorgs":
+			json.NewEncoder(w).Encode(zorgs)
+		default:
+			id := strings.TrimPrefix(r.URL.Path, "/v1/zorgs/")
+			for i, zorg := range zorgs {
+				if zorg.ZorgIdentifier != id {
+					continue
+				}
+
+				switch r.Method {
+				case http.MethodPut:
+					json.NewDecoder(r.Body).Decode(zorg)
+				case http.MethodDelete:
+					zorgs = append(zorgs[:i:i], zorgs[i+1:]...)
+				}
+				return
+			}
+
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestZertoLifecycleHandlers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	grace := 720 * time.Hour
+
+	archived := func(ago time.Duration) string {
+		return "Acme [archived " + time.Now().Add(-ago).UTC().Format(time.RFC3339) + "]"
+	}
+
+	tests := []struct {
+		name     string
+		zorgName string
+		method   string
+		path     string
+		body     string
+		want     int
+	}{
+		{"update", "Acme", http.MethodPut, "/api/v1/zerto/C1", `{"Name": "Acme Corp"}`, http.StatusOK},
+		{"update archived", archived(time.Hour), http.MethodPut, "/api/v1/zerto/C1", `{"Name": "Acme Corp"}`, http.StatusConflict},
+		{"update with an empty name", "Acme", http.MethodPut, "/api/v1/zerto/C1", `{"Name": " "}`, http.StatusUnprocessableEntity},
+		{"update unknown", "Acme", http.MethodPut, "/api/v1/zerto/C2", `{"Name": "Acme Corp"}`, http.StatusNotFound},
+		{"archive", "Acme", http.MethodPost, "/api/v1/zerto/C1/archive", "", http.StatusOK},
+		{"delete before archiving", "Acme", http.MethodDelete, "/api/v1/zerto/C1", "", http.StatusConflict},
+		{"delete inside the grace period", archived(grace - time.Hour), http.MethodDelete, "/api/v1/zerto/C1", "", http.StatusConflict},
+		{"delete after the grace period", archived(grace + time.Hour), http.MethodDelete, "/api/v1/zerto/C1", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permissions := services.ZertoPermissions{IsAllowedToCreateVpg: true, IsAllowedToEditVpg: true}
+			zorg := &services.Zorg{ZorgIdentifier: "z1", Name: tt.zorgName, CrmIdentifier: "C1", Permissions: permissions}
+			srv := zertoServer(t, zorg)
+
+			app := &application{logger: logger, zerto: newTestZertoClient(t, srv.URL, logger)}
+			app.config.zertoDeleteGrace = grace
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("PUT /api/v1/zerto/{id}", app.updateZertoHandler)
+			mux.HandleFunc("POST /api/v1/zerto/{id}/archive", app.archiveZertoHandler)
+			mux.HandleFunc("DELETE /api/v1/zerto/{id}", app.deleteZertoHandler)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d %s", tt.want, rec.Code, rec.Body)
+			}
+
+			// Nothing is changed by a request that is refused.
+			if tt.want != http.StatusOK && (zorg.Name != tt.zorgName || zorg.Permissions != permissions) {
+				t.Errorf("expected the ZORG to be unchanged, got %+v", zorg)
+			}
+		})
+	}
+}
+
+// Archiving takes away every permission and answers with the ones the ZORG had, and
+// archiving again answers with the time it was first archived.
+func TestArchiveZertoHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	permissions := services.ZertoPermissions{IsAllowedToCreateVpg: true, IsAllowedToLiveFailoverOrMove: true}
+	zorg := &services.Zorg{ZorgIdentifier: "z1", Name: "Acme", CrmIdentifier: "C1", Permissions: permissions}
+	srv := zertoServer(t, zorg)
+
+	app := &application{logger: logger, zerto: newTestZertoClient(t, srv.URL, logger)}
+	app.config.zertoDeleteGrace = time.Hour
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/zerto/{id}/archive", app.archiveZertoHandler)
+
+	var archivedAt []time.Time
+	for i := range 2 {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/zerto/C1/archive", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d %s", i+1, rec.Code, rec.Body)
+		}
+
+		var body struct {
+			Previous    services.ZertoPermissions `json:"previous_permissions"`
+			ArchivedAt  time.Time                 `json:"archived_at"`
+			DeleteAfter time.Time                 `json:"delete_after"`
+		}
+		err := json.NewDecoder(rec.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && body.Previous != permissions {
+			t.Errorf("expected the previous permissions %+v, got %+v", permissions, body.Previous)
+		}
+		if !body.DeleteAfter.Equal(body.ArchivedAt.Add(time.Hour)) {
+			t.Errorf("request %d: expected deletion an hour after %s, got %s", i+1, body.ArchivedAt, body.DeleteAfter)
+		}
+		archivedAt = append(archivedAt, body.ArchivedAt)
+
+		// The next request is a second later, so a new time would show.
+		time.Sleep(time.Second)
+	}
+
+	if !archivedAt[0].Equal(archivedAt[1]) {
+		t.Errorf("expected the same archive time, got %v", archivedAt)
+	}
+	if zorg.Permissions != (services.ZertoPermissions{}) {
+		t.Errorf("expected every permission to be taken away, got %+v", zorg.Permissions)
+	}
+}
+
+func TestZertoHandlersNotConfigured(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/v1/zerto/{id}", app.deleteZertoHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/zerto/C1", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
+
+func newTestZertoClient(t *testing.T, url string, logger *slog.Logger) *services.ZertoClient {
+	t.Helper()
+
+	c, err := services.NewZertoClient(services.ZertoClientConfig{
+		SessionPath: "/v1/session/add",
+		ZorgsPath:   "/v1/zorgs",
+		HTTP:        vendorhttp.Config{BaseURL: url, RetryWait: time.Millisecond, Logger: logger},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
@@ -1,3 +1,23 @@
 module github.com/CloudKey-io/hostbill-svc
 
 go 1.22.2
+
+require (
+	github.com/cloudkey-io/service-hub/authkit v0.0.0
+	github.com/jackc/pgx/v4 v4.18.3
+)
+
+require (
+	github.com/jackc/chunkreader/v2 v2.0.1 // indirect
+	github.com/jackc/pgconn v1.14.3 // indirect
+	github.com/jackc/pgio v1.0.0 // indirect
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgproto3/v2 v2.3.3 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
+	github.com/jackc/pgtype v1.14.0 // indirect
+	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+	golang.org/x/crypto v0.20.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
+
+replace github.com/cloudkey-io/service-hub/authkit => ../authkit
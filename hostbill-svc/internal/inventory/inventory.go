@@ -0,0 +1,344 @@
+// Package inventory keeps a record in Postgres of the tenants hostbill-svc provisions,
+// the HostBill order and service and the CRM identifier each belongs to, and every
+// vendor resource created for them, so they can be found without asking each vendor.
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when there is no tenant with an ID.
+var ErrNotFound = errors.New("inventory: tenant not found")
+
+// The vendors resources are created in.
+const (
+	VendorVCD   = "vcd"
+	VendorVeeam = "veeam"
+	VendorZerto = "zerto"
+	VendorDuo   = "duo"
+)
+
+// The types of resources.
+const (
+	TypeOrg         = "org"
+	TypeOrgVDC      = "org_vdc"
+	TypeUser        = "user"
+	TypeEdgeGateway = "edge_gateway"
+	TypeOrgConfig   = "org_config"
+	TypeZorg        = "zorg"
+	TypeAccount     = "account"
+)
+
+// The statuses of resources.
+const (
+	StatusActive   = "active"
+	StatusArchived = "archived"
+	StatusDeleted  = "deleted"
+)
+
+// Tenant is a customer, with the identifiers HostBill and the CRM know it by.
+type Tenant struct {
+	ID                int64      `json:"id"`
+	CrmIdentifier     string     `json:"crm_identifier,omitempty"`
+	Name              string     `json:"name"`
+	CompanyName       string     `json:"company_name,omitempty"`
+	HostbillOrderID   string     `json:"hostbill_order_id,omitempty"`
+	HostbillServiceID string     `json:"hostbill_service_id,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	Resources         []Resource `json:"resources"`
+}
+
+// Resource is something created for a tenant in a vendor, found there by its
+// ExternalID.
+type Resource struct {
+	ID         int64     `json:"id"`
+	Vendor     string    `json:"vendor"`
+	Type       string    `json:"type"`
+	ExternalID string    `json:"external_id"`
+	Name       string    `json:"name,omitempty"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Filter narrows down a list of tenants. Empty fields match everything, and Vendor,
+// ExternalID and Status match tenants with such a resource.
+type Filter struct {
+	CrmIdentifier     string
+	HostbillOrderID   string
+	HostbillServiceID string
+	Name              string
+	Vendor            string
+	ExternalID        string
+	Status            string
+	Limit             int
+	Offset            int
+}
+
+// Store reads and writes the inventory. It is safe for concurrent use.
+type Store struct {
+	db *sql.DB
+}
+
+// New returns a store in db, which has been migrated with Migrate.
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// tenantColumns are the columns scanned by scanTenant.
+const tenantColumns = `t.id, coalesce(t.crm_identifier, ''), t.name, t.company_name,
+	coalesce(t.hostbill_order_id, ''), coalesce(t.hostbill_service_id, ''), t.created_at, t.updated_at`
+
+func scanTenant(rows *sql.Rows) (*Tenant, error) {
+	var t Tenant
+	err := rows.Scan(&t.ID, &t.CrmIdentifier, &t.Name, &t.CompanyName,
+		&t.HostbillOrderID, &t.HostbillServiceID, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Resources = []Resource{}
+
+	return &t, nil
+}
+
+// Record saves a tenant and resources created for it. The tenant is found by its CRM
+// identifier, by a resource that was recorded for it before or, failing those, by its
+// name, and the identifiers given fill in or replace the ones it had. A new tenant
+// without a name is named after its CRM identifier. A resource that was recorded
+// before is moved to the tenant, and its name and status are updated.
+func (s *Store) Record(ctx context.Context, t Tenant, resources ...Resource) (*Tenant, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, byResource, err := findTenant(ctx, tx, t, resources)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// A tenant with the CRM identifier, or without one and with the name, may be
+		// recorded at the same time, in which case it is the one this is.
+		err = tx.QueryRowContext(ctx, `
+			insert into tenants (crm_identifier, name, company_name, hostbill_order_id, hostbill_service_id)
+			values (nullif($1, ''), coalesce(nullif($2, ''), $1), $3, nullif($4, ''), nullif($5, ''))
+			on conflict do nothing
+			returning id`,
+			t.CrmIdentifier, t.Name, t.CompanyName, t.HostbillOrderID, t.HostbillServiceID).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			id, _, err = findTenant(ctx, tx, t, resources)
+		}
+	case err == nil:
+		// A tenant only known by a resource keeps its name, as the vendor's name for
+		// the resource may not be the tenant's.
+		name := t.Name
+		if byResource {
+			name = ""
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			update tenants set
+				crm_identifier = coalesce(nullif($2, ''), crm_identifier),
+				name = coalesce(nullif($3, ''), name),
+				company_name = coalesce(nullif($4, ''), company_name),
+				hostbill_order_id = coalesce(nullif($5, ''), hostbill_order_id),
+				hostbill_service_id = coalesce(nullif($6, ''), hostbill_service_id),
+				updated_at = now()
+			where id = $1`,
+			id, t.CrmIdentifier, name, t.CompanyName, t.HostbillOrderID, t.HostbillServiceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recording tenant: %w", err)
+	}
+
+	for _, r := range resources {
+		_, err = tx.ExecContext(ctx, `
+			insert into tenant_resources (tenant_id, vendor, type, external_id, name, status)
+			values ($1, $2, $3, $4, $5, $6)
+			on conflict (vendor, type, external_id) do update set
+				tenant_id = excluded.tenant_id,
+				name = coalesce(nullif(excluded.name, ''), tenant_resources.name),
+				status = excluded.status,
+				updated_at = now()`,
+			id, r.Vendor, r.Type, r.ExternalID, r.Name, r.Status)
+		if err != nil {
+			return nil, fmt.Errorf("recording %s %s %s: %w", r.Vendor, r.Type, r.ExternalID, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Get(ctx, id)
+}
+
+// findTenant returns the ID of the tenant t is, and whether it was found by one of the
+// resources. Handlers that don't get a CRM identifier, such as those archiving or
+// deleting a resource, record the tenant by the resource, or by its name if it hasn't
+// been recorded. A tenant with another CRM identifier is never the one.
+func findTenant(ctx context.Context, tx *sql.Tx, t Tenant, resources []Resource) (int64, bool, error) {
+	var id int64
+
+	if t.CrmIdentifier != "" {
+		err := tx.QueryRowContext(ctx, `select id from tenants where crm_identifier = $1`, t.CrmIdentifier).Scan(&id)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return id, false, err
+		}
+	}
+
+	for _, r := range resources {
+		err := tx.QueryRowContext(ctx, `
+			select t.id from tenant_resources r
+			join tenants t on t.id = r.tenant_id
+			where r.vendor = $1 and r.type = $2 and r.external_id = $3
+				and ($4 = '' or t.crm_identifier is null)`,
+			r.Vendor, r.Type, r.ExternalID, t.CrmIdentifier).Scan(&id)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return id, true, err
+		}
+	}
+
+	err := tx.QueryRowContext(ctx, `
+		select id from tenants
+		where lower(name) = lower($2) and ($1 = '' or crm_identifier is null)
+		order by crm_identifier is null desc, id
+		limit 1`, t.CrmIdentifier, t.Name).Scan(&id)
+
+	return id, false, err
+}
+
+// Get returns the tenant with an ID, with its resources.
+func (s *Store) Get(ctx context.Context, id int64) (*Tenant, error) {
+	tenants, err := s.query(ctx, `select `+tenantColumns+` from tenants t where t.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return tenants[0], nil
+}
+
+// List returns the tenants that match f, oldest first, and how many match in all.
+func (s *Store) List(ctx context.Context, f Filter) ([]*Tenant, int, error) {
+	var (
+		where    []string
+		resource []string
+		args     []any
+	)
+	add := func(conds *[]string, cond, value string) {
+		if value == "" {
+			return
+		}
+		args = append(args, value)
+		*conds = append(*conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	add(&where, "t.crm_identifier = $%d", f.CrmIdentifier)
+	add(&where, "t.hostbill_order_id = $%d", f.HostbillOrderID)
+	add(&where, "t.hostbill_service_id = $%d", f.HostbillServiceID)
+	add(&where, "lower(t.name) = lower($%d)", f.Name)
+	add(&resource, "r.vendor = $%d", f.Vendor)
+	add(&resource, "r.external_id = $%d", f.ExternalID)
+	add(&resource, "r.status = $%d", f.Status)
+
+	if len(resource) > 0 {
+		where = append(where, "exists (select 1 from tenant_resources r where r.tenant_id = t.id and "+strings.Join(resource, " and ")+")")
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = " where " + strings.Join(where, " and ")
+	}
+
+	var total int
+	err := s.db.QueryRowContext(ctx, `select count(*) from tenants t`+filter, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, f.Limit, f.Offset)
+	tenants, err := s.query(ctx, fmt.Sprintf(`select %s from tenants t%s order by t.id limit $%d offset $%d`,
+		tenantColumns, filter, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return tenants, total, nil
+}
+
+// Lookup returns the tenants known by ref, which may be their ID, CRM identifier,
+// HostBill order or service ID, name, or the vendor ID of one of their resources.
+func (s *Store) Lookup(ctx context.Context, ref string) ([]*Tenant, error) {
+	return s.query(ctx, `select `+tenantColumns+` from tenants t
+		where t.id::text = $1
+			or t.crm_identifier = $1
+			or t.hostbill_order_id = $1
+			or t.hostbill_service_id = $1
+			or lower(t.name) = lower($1)
+			or exists (select 1 from tenant_resources r where r.tenant_id = t.id and r.external_id = $1)
+		order by t.id`, ref)
+}
+
+// query returns the tenants selected by query, with their resources.
+func (s *Store) query(ctx context.Context, query string, args ...any) ([]*Tenant, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []*Tenant{}
+	byID := map[int64]*Tenant{}
+	var ids []int64
+
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tenants = append(tenants, t)
+		byID[t.ID] = t
+		ids = append(ids, t.ID)
+	}
+
+	err = rows.Err()
+	if err != nil || len(ids) == 0 {
+		return tenants, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, `
+		select tenant_id, id, vendor, type, external_id, name, status, created_at, updated_at
+		from tenant_resources
+		where tenant_id = any($1)
+		order by id`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tenantID int64
+		var r Resource
+
+		err = rows.Scan(&tenantID, &r.ID, &r.Vendor, &r.Type, &r.ExternalID, &r.Name, &r.Status, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		byID[tenantID].Resources = append(byID[tenantID].Resources, r)
+	}
+
+	return tenants, rows.Err()
+}
@@ -0,0 +1,189 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// Every migration has to load, in order, with a down file next to it for reverting by
+// hand.
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("expected migration %d, got %d_%s", i+1, m.version, m.name)
+		}
+
+		down := fmt.Sprintf("migrations/%04d_%s.down.sql", m.version, m.name)
+		if _, err := fs.Stat(migrationFS, down); err != nil {
+			t.Errorf("expected %s: %v", down, err)
+		}
+	}
+}
+
+// testStore returns a store in a schema of its own in the Postgres at
+// INVENTORY_TEST_DSN, and skips the test without one.
+func testStore(t *testing.T) *Store {
+	dsn := os.Getenv("INVENTORY_TEST_DSN")
+	if dsn == "" {
+		t.Skip("INVENTORY_TEST_DSN is not set")
+	}
+
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	ctx := context.Background()
+	schema := fmt.Sprintf("inventory_test_%d", time.Now().UnixNano())
+
+	_, err = admin.ExecContext(ctx, `create schema `+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.ExecContext(context.Background(), `drop schema `+schema+` cascade`) })
+
+	// Every connection of the store uses the schema.
+	cfg.RuntimeParams["search_path"] = schema
+	name := stdlib.RegisterConnConfig(cfg)
+	t.Cleanup(func() { stdlib.UnregisterConnConfig(name) })
+
+	db, err := sql.Open("pgx", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = Migrate(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(db)
+}
+
+func TestRecord(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	org := Resource{Vendor: VendorVCD, Type: TypeOrg, ExternalID: "urn:vcloud:org:1", Name: "acme", Status: StatusActive}
+	veeam := Resource{Vendor: VendorVeeam, Type: TypeOrgConfig, ExternalID: "urn:veeam:VCloudOrganizationConfig:2", Name: "beta", Status: StatusActive}
+
+	// Each step is recorded after the ones before it, and is expected to land on the
+	// tenant named by want: the tenant of an earlier step, or "new".
+	steps := []struct {
+		name     string
+		tenant   Tenant
+		resource *Resource
+		want     string
+		wantName string
+		wantCRM  string
+	}{
+		{"created", Tenant{CrmIdentifier: "C1", Name: "acme"}, &org, "new", "acme", "C1"},
+		{"by CRM identifier", Tenant{CrmIdentifier: "C1"}, nil, "created", "acme", "C1"},
+		// An archive handler only has the vendor's name for the resource.
+		{"by resource", Tenant{Name: "acme-archived"}, &Resource{Vendor: org.Vendor, Type: org.Type, ExternalID: org.ExternalID, Status: StatusArchived}, "created", "acme", "C1"},
+		{"by name", Tenant{Name: "ACME"}, nil, "created", "acme", "C1"},
+		{"other CRM identifier with the name", Tenant{CrmIdentifier: "C2", Name: "acme"}, nil, "new", "acme", "C2"},
+		{"unclaimed", Tenant{Name: "beta"}, &veeam, "new", "beta", ""},
+		{"claimed by CRM identifier", Tenant{CrmIdentifier: "C3", Name: "beta"}, nil, "unclaimed", "beta", "C3"},
+		{"named after CRM identifier", Tenant{CrmIdentifier: "C4"}, nil, "new", "C4", "C4"},
+	}
+
+	ids := map[string]int64{}
+
+	for _, step := range steps {
+		var resources []Resource
+		if step.resource != nil {
+			resources = append(resources, *step.resource)
+		}
+
+		got, err := s.Record(ctx, step.tenant, resources...)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		if step.want == "new" {
+			for name, id := range ids {
+				if id == got.ID {
+					t.Errorf("%s: expected a new tenant, got the one %s", step.name, name)
+				}
+			}
+		} else if got.ID != ids[step.want] {
+			t.Errorf("%s: expected the tenant %s, got tenant %d", step.name, step.want, got.ID)
+		}
+		ids[step.name] = got.ID
+
+		if got.Name != step.wantName || got.CrmIdentifier != step.wantCRM {
+			t.Errorf("%s: expected %s (%s), got %s (%s)", step.name, step.wantName, step.wantCRM, got.Name, got.CrmIdentifier)
+		}
+	}
+
+	acme, err := s.Get(ctx, ids["created"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acme.Resources) != 1 || acme.Resources[0].Status != StatusArchived || acme.Resources[0].Name != "acme" {
+		t.Errorf("expected the org to be archived and keep its name, got %+v", acme.Resources)
+	}
+}
+
+// Tenants recorded for the first time at the same time aren't duplicated.
+func TestRecordConcurrently(t *testing.T) {
+	s := testStore(t)
+
+	var wg sync.WaitGroup
+	ids := make(chan int64, 10)
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			got, err := s.Record(context.Background(), Tenant{Name: "gamma"},
+				Resource{Vendor: VendorZerto, Type: TypeZorg, ExternalID: fmt.Sprintf("zorg-%d", i), Status: StatusActive})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- got.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[int64]bool{}
+	for id := range ids {
+		seen[id] = true
+	}
+	if len(seen) != 1 {
+		t.Errorf("expected one tenant, got %d", len(seen))
+	}
+
+	tenants, _, err := s.List(context.Background(), Filter{Name: "GAMMA", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 1 || len(tenants[0].Resources) != 10 || !strings.EqualFold(tenants[0].Name, "gamma") {
+		t.Errorf("expected one tenant with every ZORG, got %+v", tenants)
+	}
+}
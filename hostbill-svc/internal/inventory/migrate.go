@@ -0,0 +1,133 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migrationLockID is the Postgres advisory lock held while migrating, so replicas
+// starting at the same time apply each migration once.
+const migrationLockID = 0x686f7374 // "host"
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.up\.sql$`)
+
+// migration is one versioned schema change. The down files are kept next to them for
+// reverting by hand.
+type migration struct {
+	version int
+	name    string
+	up      string
+}
+
+// Migrate applies the migrations that haven't been applied yet, each in its own
+// transaction. They are recorded in inventory_migrations, so the inventory can share a
+// database with other services.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// Advisory locks belong to a session, so everything has to run on one connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, migrationLockID)
+	if err != nil {
+		return fmt.Errorf("taking migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, migrationLockID)
+
+	_, err = conn.ExecContext(ctx, `create table if not exists inventory_migrations (
+		version integer primary key,
+		name text not null,
+		applied_at timestamptz not null
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		var applied bool
+		err = conn.QueryRowContext(ctx, `select exists (select 1 from inventory_migrations where version = $1)`, m.version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		err = runMigration(ctx, conn, m)
+		if err != nil {
+			return fmt.Errorf("applying migration %d_%s: %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadMigrations reads every NNNN_name.up.sql, sorted by version.
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFS, "migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, file := range files {
+		m := migrationName.FindStringSubmatch(path.Base(file))
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_create_tenants.up.sql", file)
+		}
+
+		body, err := fs.ReadFile(migrationFS, file)
+		if err != nil {
+			return nil, err
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		migrations = append(migrations, migration{version: version, name: m[2], up: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+// runMigration runs the SQL of one migration and records it in a single transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, m.up)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into inventory_migrations (version, name, applied_at) values ($1, $2, $3)`,
+		m.version, m.name, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,2 @@
+drop table if exists tenant_resources;
+drop table if exists tenants;
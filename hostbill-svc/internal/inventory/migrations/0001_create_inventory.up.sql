@@ -0,0 +1,30 @@
+create table if not exists tenants (
+	id bigserial primary key,
+	crm_identifier text unique,
+	name text not null,
+	company_name text not null default '',
+	hostbill_order_id text,
+	hostbill_service_id text,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now()
+);
+
+create index if not exists tenants_name_idx on tenants (lower(name));
+create index if not exists tenants_hostbill_order_id_idx on tenants (hostbill_order_id);
+create index if not exists tenants_hostbill_service_id_idx on tenants (hostbill_service_id);
+
+create table if not exists tenant_resources (
+	id bigserial primary key,
+	tenant_id bigint not null references tenants (id) on delete cascade,
+	vendor text not null,
+	type text not null,
+	external_id text not null,
+	name text not null default '',
+	status text not null,
+	created_at timestamptz not null default now(),
+	updated_at timestamptz not null default now(),
+	unique (vendor, type, external_id)
+);
+
+create index if not exists tenant_resources_tenant_id_idx on tenant_resources (tenant_id);
+create index if not exists tenant_resources_external_id_idx on tenant_resources (external_id);
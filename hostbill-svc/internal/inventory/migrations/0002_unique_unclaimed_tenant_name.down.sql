@@ -0,0 +1 @@
+drop index if exists tenants_unclaimed_name_idx;
@@ -0,0 +1,3 @@
+-- Tenants without a CRM identifier are found by their name, so there can only be one of
+-- each. Those with one are already unique by it.
+create unique index if not exists tenants_unclaimed_name_idx on tenants (lower(name)) where crm_identifier is null;
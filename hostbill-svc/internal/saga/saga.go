@@ -0,0 +1,414 @@
+// Package saga runs workflows of steps that each change something in another system,
+// such as creating a tenant in every vendor. The state of a workflow is saved after
+// every step, so it can be shown while it runs and resumed after a restart. Steps that
+// fail for a transient reason are retried, and when one fails for good the steps that
+// ran are undone in reverse order.
+package saga
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Status is the status of a workflow or of one of its steps.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	// StatusCompensating is a workflow whose steps are being undone after one failed.
+	StatusCompensating Status = "compensating"
+	// StatusCompensated is a workflow whose steps were all undone, or a step that was.
+	StatusCompensated Status = "compensated"
+	// StatusFailed is a step that failed for good, or a workflow whose steps couldn't
+	// all be undone, which needs someone to clean up by hand.
+	StatusFailed Status = "failed"
+)
+
+// ErrExists is returned when starting a workflow whose ID is already taken.
+var ErrExists = errors.New("saga: workflow already exists")
+
+// ErrNotFound is returned when there is no workflow with an ID.
+var ErrNotFound = errors.New("saga: workflow not found")
+
+// Step is one step of a workflow. Do returns what the step created, which is saved
+// with the workflow for the steps after it and for Undo. Both must be safe to call
+// again after they were interrupted, and Undo must succeed when there is nothing left
+// to undo.
+type Step struct {
+	Name string
+	Do   func(ctx context.Context, w *Workflow) (any, error)
+	Undo func(ctx context.Context, w *Workflow) error
+}
+
+// StepState is the saved state of a step.
+type StepState struct {
+	Name       string          `json:"name"`
+	Status     Status          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Workflow is the saved state of a workflow.
+type Workflow struct {
+	ID        string          `json:"id"`
+	Input     json.RawMessage `json:"input"`
+	Status    Status          `json:"status"`
+	Steps     []StepState     `json:"steps"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Done reports whether the workflow has finished, one way or the other.
+func (w *Workflow) Done() bool {
+	return w.Status == StatusCompleted || w.Status == StatusCompensated || w.Status == StatusFailed
+}
+
+// Clone returns a deep copy of w.
+func (w *Workflow) Clone() *Workflow {
+	c := *w
+	c.Input = bytes.Clone(w.Input)
+	c.Steps = make([]StepState, len(w.Steps))
+
+	for i, s := range w.Steps {
+		s.Result = bytes.Clone(s.Result)
+		if s.StartedAt != nil {
+			started := *s.StartedAt
+			s.StartedAt = &started
+		}
+		if s.FinishedAt != nil {
+			finished := *s.FinishedAt
+			s.FinishedAt = &finished
+		}
+		c.Steps[i] = s
+	}
+
+	return &c
+}
+
+// Result decodes the result of the named step into dst. It returns false if the step
+// has no result.
+func (w *Workflow) Result(step string, dst any) (bool, error) {
+	for _, s := range w.Steps {
+		if s.Name == step && len(s.Result) > 0 {
+			return true, json.Unmarshal(s.Result, dst)
+		}
+	}
+
+	return false, nil
+}
+
+// Runner runs workflows of the same steps in the background. It is safe for
+// concurrent use.
+type Runner struct {
+	steps  []Step
+	store  Store
+	logger *slog.Logger
+
+	// Retries is how many times a step that failed for a transient reason is tried
+	// again, 3 by default. RetryWait is the wait before the first retry, doubled for
+	// each one after it.
+	Retries   int
+	RetryWait time.Duration
+	// Transient reports whether a step that failed with an error may succeed if it
+	// is tried again. Without it, every error is permanent.
+	Transient func(error) bool
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewRunner returns a runner of the steps, which saves workflows to store.
+func NewRunner(store Store, logger *slog.Logger, steps ...Step) *Runner {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Runner{
+		steps:     steps,
+		store:     store,
+		logger:    logger,
+		Retries:   3,
+		RetryWait: 5 * time.Second,
+		ctx:       ctx,
+		cancel:    cancel,
+		running:   map[string]bool{},
+	}
+}
+
+// Start saves a new workflow with the input and runs it in the background. It returns
+// ErrExists if a workflow with the ID exists, unless that one was compensated, in
+// which case it is started over. The workflow returned is a copy of its state when it
+// started; the runner keeps the one it changes as it runs.
+func (r *Runner) Start(ctx context.Context, id string, input any) (*Workflow, error) {
+	b, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	w := &Workflow{
+		ID:        id,
+		Input:     b,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, step := range r.steps {
+		w.Steps = append(w.Steps, StepState{Name: step.Name, Status: StatusPending})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running[id] {
+		return nil, ErrExists
+	}
+
+	err = r.store.Create(ctx, w)
+	if errors.Is(err, ErrExists) {
+		var previous *Workflow
+		previous, err = r.store.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if previous.Status != StatusCompensated {
+			return nil, ErrExists
+		}
+		err = r.store.Save(ctx, w)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	started := w.Clone()
+	r.launch(w)
+
+	return started, nil
+}
+
+// Get returns the saved state of a workflow.
+func (r *Runner) Get(ctx context.Context, id string) (*Workflow, error) {
+	return r.store.Get(ctx, id)
+}
+
+// Resume runs the workflows that were interrupted by a shutdown again, from the step
+// they were on.
+func (r *Runner) Resume(ctx context.Context) error {
+	workflows, err := r.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range workflows {
+		if w.Done() || r.running[w.ID] {
+			continue
+		}
+
+		r.logger.Info("resuming workflow", "id", w.ID, "status", w.Status)
+		r.launch(w)
+	}
+
+	return nil
+}
+
+// Shutdown interrupts the running workflows and waits until they have saved their
+// state, or ctx is done. They carry on where they stopped at the next Resume.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// launch runs w in the background. The caller holds r.mu.
+func (r *Runner) launch(w *Workflow) {
+	r.running[w.ID] = true
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+		defer func() {
+			r.mu.Lock()
+			delete(r.running, w.ID)
+			r.mu.Unlock()
+		}()
+
+		r.run(r.ctx, w)
+	}()
+}
+
+// run carries w on from its saved state: it runs the steps that haven't completed,
+// then, if one failed, undoes them. It returns early, leaving the state as it is, when
+// ctx is cancelled.
+func (r *Runner) run(ctx context.Context, w *Workflow) {
+	logger := r.logger.With("workflow", w.ID)
+
+	if w.Status == StatusPending || w.Status == StatusRunning {
+		w.Status = StatusRunning
+		r.save(w)
+
+		for i, step := range r.steps {
+			state := &w.Steps[i]
+			if state.Status == StatusCompleted {
+				continue
+			}
+
+			result, err := r.attempt(ctx, w, state, step.Do)
+			if ctx.Err() != nil {
+				return
+			}
+
+			finished := time.Now().UTC()
+			state.FinishedAt = &finished
+
+			if err != nil {
+				logger.Error("workflow step failed", "step", step.Name, "attempts", state.Attempts, "error", err)
+
+				state.Status = StatusFailed
+				state.Error = err.Error()
+				w.Status = StatusCompensating
+				w.Error = fmt.Sprintf("%s: %v", step.Name, err)
+				r.save(w)
+				break
+			}
+
+			b, err := json.Marshal(result)
+			if err != nil {
+				logger.Error("workflow step result can't be saved", "step", step.Name, "error", err)
+			}
+
+			state.Status = StatusCompleted
+			state.Result = b
+			state.Error = ""
+			r.save(w)
+			logger.Info("workflow step completed", "step", step.Name)
+		}
+
+		if w.Status == StatusRunning {
+			w.Status = StatusCompleted
+			r.save(w)
+			logger.Info("workflow completed")
+			return
+		}
+	}
+
+	if w.Status != StatusCompensating {
+		return
+	}
+
+	// The step that failed is undone as well, as it may have created something before
+	// it failed.
+	failed := false
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step, state := r.steps[i], &w.Steps[i]
+		if step.Undo == nil || (state.Status != StatusCompleted && state.Status != StatusFailed) {
+			continue
+		}
+
+		_, err := r.attempt(ctx, w, state, func(ctx context.Context, w *Workflow) (any, error) {
+			return nil, step.Undo(ctx, w)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logger.Error("workflow step couldn't be undone", "step", step.Name, "error", err)
+
+			failed = true
+			state.Error = "undo: " + err.Error()
+			r.save(w)
+			continue
+		}
+
+		finished := time.Now().UTC()
+		state.FinishedAt = &finished
+		state.Status = StatusCompensated
+		r.save(w)
+		logger.Info("workflow step undone", "step", step.Name)
+	}
+
+	w.Status = StatusCompensated
+	if failed {
+		w.Status = StatusFailed
+	}
+	r.save(w)
+	logger.Info("workflow compensated", "status", w.Status)
+}
+
+// attempt calls fn until it succeeds, fails with an error that isn't transient or runs
+// out of retries, counting the attempts in state.
+func (r *Runner) attempt(ctx context.Context, w *Workflow, state *StepState, fn func(context.Context, *Workflow) (any, error)) (any, error) {
+	wait := r.RetryWait
+
+	for retry := 0; ; retry++ {
+		now := time.Now().UTC()
+		if state.StartedAt == nil || state.Status == StatusPending {
+			state.StartedAt = &now
+		}
+		if state.Status == StatusPending {
+			state.Status = StatusRunning
+		}
+		state.Attempts++
+		r.save(w)
+
+		result, err := fn(ctx, w)
+		if err == nil || ctx.Err() != nil {
+			return result, err
+		}
+		if retry >= r.Retries || r.Transient == nil || !r.Transient(err) {
+			return nil, err
+		}
+
+		r.logger.Warn("retrying workflow step", "workflow", w.ID, "step", state.Name, "attempt", state.Attempts, "error", err)
+		state.Error = err.Error()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// save saves w. A failure is only logged: the workflow carries on, and its state is
+// saved again after the next change.
+func (r *Runner) save(w *Workflow) {
+	w.UpdatedAt = time.Now().UTC()
+
+	// Saving isn't cut short by a shutdown, which is when the state matters most.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := r.store.Save(ctx, w)
+	if err != nil {
+		r.logger.Error("saving workflow", "workflow", w.ID, "error", err)
+	}
+}
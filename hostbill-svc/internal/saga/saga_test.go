@@ -0,0 +1,322 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("vendor unreachable")
+
+// recorder records the steps run and undone, in order.
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (rec *recorder) add(call string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.calls = append(rec.calls, call)
+}
+
+func (rec *recorder) list() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return slices.Clone(rec.calls)
+}
+
+// steps returns steps named after names that record their calls in rec. A step fails
+// with the errors in fail, one per attempt.
+func (rec *recorder) steps(names []string, fail map[string][]error) []Step {
+	var steps []Step
+	for _, name := range names {
+		steps = append(steps, Step{
+			Name: name,
+			Do: func(ctx context.Context, w *Workflow) (any, error) {
+				rec.add("do " + name)
+
+				rec.mu.Lock()
+				defer rec.mu.Unlock()
+				if errs := fail[name]; len(errs) > 0 {
+					fail[name] = errs[1:]
+					return nil, errs[0]
+				}
+
+				return map[string]string{"created": name}, nil
+			},
+			Undo: func(ctx context.Context, w *Workflow) error {
+				rec.add("undo " + name)
+				return nil
+			},
+		})
+	}
+
+	return steps
+}
+
+func newTestRunner(t *testing.T, steps ...Step) (*Runner, *FileStore) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(store, slog.New(slog.NewTextHandler(io.Discard, nil)), steps...)
+	r.RetryWait = time.Millisecond
+	r.Transient = func(err error) bool { return errors.Is(err, errTransient) }
+	t.Cleanup(func() { r.Shutdown(context.Background()) })
+
+	return r, store
+}
+
+// waitDone waits until the workflow has finished and returns its saved state.
+func waitDone(t *testing.T, r *Runner, id string) *Workflow {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w, err := r.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.mu.Lock()
+		running := r.running[id]
+		r.mu.Unlock()
+
+		if w.Done() && !running {
+			return w
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("workflow %s didn't finish", id)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	names := []string{"vcd", "veeam", "zerto"}
+
+	tests := []struct {
+		name      string
+		fail      map[string][]error
+		want      Status
+		wantCalls []string
+		wantSteps []Status
+	}{
+		{
+			name:      "every step succeeds",
+			want:      StatusCompleted,
+			wantCalls: []string{"do vcd", "do veeam", "do zerto"},
+			wantSteps: []Status{StatusCompleted, StatusCompleted, StatusCompleted},
+		},
+		{
+			name:      "transient failures are retried",
+			fail:      map[string][]error{"veeam": {errTransient, errTransient}},
+			want:      StatusCompleted,
+			wantCalls: []string{"do vcd", "do veeam", "do veeam", "do veeam", "do zerto"},
+			wantSteps: []Status{StatusCompleted, StatusCompleted, StatusCompleted},
+		},
+		{
+			// The failed step is undone too, as it may have created something.
+			name:      "a step failing for good undoes the ones before it",
+			fail:      map[string][]error{"zerto": {errors.New("bad request")}},
+			want:      StatusCompensated,
+			wantCalls: []string{"do vcd", "do veeam", "do zerto", "undo zerto", "undo veeam", "undo vcd"},
+			wantSteps: []Status{StatusCompensated, StatusCompensated, StatusCompensated},
+		},
+		{
+			name:      "running out of retries",
+			fail:      map[string][]error{"veeam": {errTransient, errTransient, errTransient, errTransient}},
+			want:      StatusCompensated,
+			wantCalls: []string{"do vcd", "do veeam", "do veeam", "do veeam", "do veeam", "undo veeam", "undo vcd"},
+			wantSteps: []Status{StatusCompensated, StatusCompensated, StatusPending},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			if tt.fail == nil {
+				tt.fail = map[string][]error{}
+			}
+			r, _ := newTestRunner(t, rec.steps(names, tt.fail)...)
+
+			_, err := r.Start(context.Background(), "C1", map[string]string{"org": "acme"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := waitDone(t, r, "C1")
+
+			if w.Status != tt.want {
+				t.Errorf("expected %s, got %s (%s)", tt.want, w.Status, w.Error)
+			}
+			if calls := rec.list(); !slices.Equal(calls, tt.wantCalls) {
+				t.Errorf("expected calls %v, got %v", tt.wantCalls, calls)
+			}
+			for i, s := range w.Steps {
+				if s.Status != tt.wantSteps[i] {
+					t.Errorf("expected step %s to be %s, got %s", s.Name, tt.wantSteps[i], s.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	rec := &recorder{}
+	r, _ := newTestRunner(t, rec.steps([]string{"vcd"}, map[string][]error{"vcd": {errors.New("bad request")}})...)
+
+	started, err := r.Start(context.Background(), "C1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The copy returned isn't changed as the workflow runs.
+	waitDone(t, r, "C1")
+	if started.Status != StatusPending || started.Steps[0].Status != StatusPending || started.Steps[0].Attempts != 0 {
+		t.Errorf("expected the started workflow to be left pending, got %s with step %+v", started.Status, started.Steps[0])
+	}
+
+	// A compensated workflow can be started over.
+	_, err = r.Start(context.Background(), "C1", nil)
+	if err != nil {
+		t.Fatalf("expected a compensated workflow to start over, got %v", err)
+	}
+
+	w := waitDone(t, r, "C1")
+	if w.Status != StatusCompleted {
+		t.Fatalf("expected the workflow started over to complete, got %s (%s)", w.Status, w.Error)
+	}
+
+	_, err = r.Start(context.Background(), "C1", nil)
+	if !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists for a completed workflow, got %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	names := []string{"vcd", "veeam", "zerto"}
+
+	tests := []struct {
+		name      string
+		status    Status
+		steps     []Status
+		want      Status
+		wantCalls []string
+	}{
+		{
+			// The step interrupted is run again, and the ones done before aren't.
+			name:      "interrupted while running",
+			status:    StatusRunning,
+			steps:     []Status{StatusCompleted, StatusRunning, StatusPending},
+			want:      StatusCompleted,
+			wantCalls: []string{"do veeam", "do zerto"},
+		},
+		{
+			name:      "interrupted while compensating",
+			status:    StatusCompensating,
+			steps:     []Status{StatusCompleted, StatusFailed, StatusPending},
+			want:      StatusCompensated,
+			wantCalls: []string{"undo veeam", "undo vcd"},
+		},
+		{
+			name:   "finished",
+			status: StatusCompleted,
+			steps:  []Status{StatusCompleted, StatusCompleted, StatusCompleted},
+			want:   StatusCompleted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			r, store := newTestRunner(t, rec.steps(names, map[string][]error{})...)
+
+			saved := &Workflow{ID: "C1", Input: []byte(`{}`), Status: tt.status}
+			for i, name := range names {
+				saved.Steps = append(saved.Steps, StepState{Name: name, Status: tt.steps[i]})
+			}
+			err := store.Create(context.Background(), saved)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = r.Resume(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := waitDone(t, r, "C1")
+
+			if w.Status != tt.want {
+				t.Errorf("expected %s, got %s (%s)", tt.want, w.Status, w.Error)
+			}
+			if calls := rec.list(); !slices.Equal(calls, tt.wantCalls) {
+				t.Errorf("expected calls %v, got %v", tt.wantCalls, calls)
+			}
+		})
+	}
+}
+
+// A workflow interrupted by a shutdown is left as it was, and carries on when another
+// runner resumes it.
+func TestShutdownAndResume(t *testing.T) {
+	rec := &recorder{}
+	block := make(chan struct{})
+
+	steps := rec.steps([]string{"vcd", "veeam"}, map[string][]error{})
+	do := steps[1].Do
+	steps[1].Do = func(ctx context.Context, w *Workflow) (any, error) {
+		close(block)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	r, store := newTestRunner(t, steps...)
+
+	_, err := r.Start(context.Background(), "C1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-block
+	err = r.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := store.Get(context.Background(), "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != StatusRunning || w.Steps[0].Status != StatusCompleted || w.Steps[1].Status != StatusRunning {
+		t.Fatalf("expected the workflow to be saved running on veeam, got %s with %+v", w.Status, w.Steps)
+	}
+
+	steps[1].Do = do
+	restarted := NewRunner(store, slog.New(slog.NewTextHandler(io.Discard, nil)), steps...)
+	t.Cleanup(func() { restarted.Shutdown(context.Background()) })
+
+	err = restarted.Resume(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = waitDone(t, restarted, "C1")
+	if w.Status != StatusCompleted {
+		t.Errorf("expected the resumed workflow to complete, got %s (%s)", w.Status, w.Error)
+	}
+	if w.Steps[1].Attempts != 2 {
+		t.Errorf("expected veeam to have been attempted twice, got %d", w.Steps[1].Attempts)
+	}
+	if calls := rec.list(); !slices.Equal(calls, []string{"do vcd", "do veeam"}) {
+		t.Errorf("expected vcd to run once, got %v", calls)
+	}
+}
@@ -0,0 +1,143 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store saves the state of workflows.
+type Store interface {
+	// Create saves a new workflow, or returns ErrExists if its ID is taken.
+	Create(ctx context.Context, w *Workflow) error
+	// Save saves a workflow, replacing its previous state.
+	Save(ctx context.Context, w *Workflow) error
+	// Get returns a workflow, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Workflow, error)
+	// List returns every workflow.
+	List(ctx context.Context) ([]*Workflow, error)
+}
+
+// FileStore keeps each workflow in a JSON file of its own, named after its ID, in a
+// directory. Files are replaced atomically, so a crash never leaves half a state.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore returns a store in dir, which is created if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of the workflow id. IDs are escaped so that they can't name a
+// file outside the directory.
+func (s *FileStore) path(id string) string {
+	r := strings.NewReplacer("%", "%25", "/", "%2F", "\\", "%5C", ".", "%2E")
+	return filepath.Join(s.dir, r.Replace(id)+".json")
+}
+
+func (s *FileStore) Create(ctx context.Context, w *Workflow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := os.Stat(s.path(w.ID))
+	if err == nil {
+		return ErrExists
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return s.write(w)
+}
+
+func (s *FileStore) Save(ctx context.Context, w *Workflow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(w)
+}
+
+// write writes w to a temporary file, then renames it over the previous state.
+func (s *FileStore) write(w *Workflow) error {
+	b, err := json.MarshalIndent(w, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".workflow-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path(w.ID))
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Workflow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(s.path(id))
+}
+
+func (s *FileStore) read(path string) (*Workflow, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var w Workflow
+	err = json.Unmarshal(b, &w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func (s *FileStore) List(ctx context.Context) ([]*Workflow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	workflows := make([]*Workflow, 0, len(paths))
+	for _, path := range paths {
+		w, err := s.read(path)
+		if err != nil {
+			return nil, err
+		}
+		workflows = append(workflows, w)
+	}
+
+	return workflows, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+// ErrDuoNotFound is returned when duo-svc has no such Duo account.
+var ErrDuoNotFound = errors.New("duo: account not found")
+
+// DuoError is returned when duo-svc answers a request with an error status.
+type DuoError struct {
+	Status  int
+	Message string
+}
+
+func (e *DuoError) Error() string {
+	return fmt.Sprintf("duo request failed with status %d: %s", e.Status, e.Message)
+}
+
+// DuoAccount is a Duo subaccount, one per tenant.
+type DuoAccount struct {
+	AccountID   string `json:"account_id"`
+	Name        string `json:"name"`
+	APIHostname string `json:"api_hostname,omitempty"`
+}
+
+// DuoClientConfig holds what DuoClient needs to reach duo-svc. APIKey is a service-hub
+// API key with the duo scope.
+type DuoClientConfig struct {
+	APIKey string
+	HTTP   vendorhttp.Config
+}
+
+// DuoClient manages Duo subaccounts through duo-svc, which holds the Duo Accounts API
+// credentials. It is safe for concurrent use.
+type DuoClient struct {
+	apiKey string
+	http   *vendorhttp.Client
+}
+
+// NewDuoClient returns a client for the duo-svc at cfg.HTTP.BaseURL.
+func NewDuoClient(cfg DuoClientConfig) (*DuoClient, error) {
+	cfg.HTTP.Name = "duo"
+	cfg.HTTP.DecodeError = decodeDuoError
+
+	client, err := vendorhttp.New(cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DuoClient{apiKey: cfg.APIKey, http: client}, nil
+}
+
+// do sends a request with the API key, decodes the JSON response into out and returns
+// its status.
+func (c *DuoClient) do(ctx context.Context, method, path string, body, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.http.URL(path), reader)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return res.StatusCode, ErrDuoNotFound
+	}
+	if res.StatusCode >= 300 {
+		return res.StatusCode, c.http.Error(res)
+	}
+
+	if out != nil {
+		err = json.NewDecoder(res.Body).Decode(out)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return res.StatusCode, err
+		}
+	}
+
+	return res.StatusCode, nil
+}
+
+// decodeDuoError decodes an error response, which duo-svc sends as {"error": ...}.
+func decodeDuoError(status int, body []byte) error {
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &payload) == nil && len(payload.Error) > 0 {
+		var s string
+		if json.Unmarshal(payload.Error, &s) == nil {
+			message = s
+		} else {
+			message = string(payload.Error)
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return &DuoError{Status: status, Message: message}
+}
+
+// CreateAccount creates the subaccount called name, or returns the one that already
+// has that name. It reports whether the subaccount was created.
+func (c *DuoClient) CreateAccount(ctx context.Context, name string) (*DuoAccount, bool, error) {
+	var res struct {
+		Account DuoAccount `json:"account"`
+	}
+
+	status, err := c.do(ctx, http.MethodPost, "/v1/accounts", map[string]string{"name": name}, &res)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &res.Account, status == http.StatusCreated, nil
+}
+
+// DeleteAccount deletes a subaccount by its account ID.
+func (c *DuoClient) DeleteAccount(ctx context.Context, accountID string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/accounts/"+url.PathEscape(accountID), nil, nil)
+	return err
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// loginTimeout bounds a login, which is shared by every request waiting for it, so it
+// doesn't run on any one request's context.
+const loginTimeout = 30 * time.Second
+
+// Session is a vendor session token. ID is what the vendor logs out by, when that
+// isn't the token. ExpiresAt is zero when the vendor doesn't say, and the manager
+// assumes its TTL instead.
+type Session struct {
+	Token     string
+	ID        string
+	ExpiresAt time.Time
+}
+
+// LoginFunc opens a vendor session.
+type LoginFunc func(ctx context.Context) (Session, error)
+
+// LogoutFunc closes a vendor session.
+type LogoutFunc func(ctx context.Context, session Session) error
+
+// SessionManager keeps one session per vendor and shares it between requests. It logs
+// in again shortly before the session expires, or when the vendor rejects it, and
+// concurrent requests that need a new session wait for a single login.
+type SessionManager struct {
+	name   string
+	logger *slog.Logger
+	login  LoginFunc
+	logout LogoutFunc
+
+	// TTL is how long a session is assumed to last when the vendor doesn't say.
+	TTL time.Duration
+	// RefreshBefore is how long before it expires a session is replaced.
+	RefreshBefore time.Duration
+
+	mu       sync.Mutex
+	current  Session
+	inflight *loginCall
+}
+
+// loginCall is a login in progress, which every caller that needs a session waits for.
+type loginCall struct {
+	done    chan struct{}
+	session Session
+	err     error
+}
+
+// NewSessionManager returns a manager that logs in to the vendor called name when the
+// first request needs a session. logout may be nil.
+func NewSessionManager(name string, logger *slog.Logger, ttl time.Duration, login LoginFunc, logout LogoutFunc) *SessionManager {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SessionManager{
+		name:          name,
+		logger:        logger,
+		login:         login,
+		logout:        logout,
+		TTL:           ttl,
+		RefreshBefore: time.Minute,
+	}
+}
+
+// Token returns the current session token, logging in first if there is no session or
+// it is about to expire.
+func (m *SessionManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+
+	if m.current.Token != "" && time.Now().Before(m.current.ExpiresAt.Add(-m.RefreshBefore)) {
+		token := m.current.Token
+		m.mu.Unlock()
+		return token, nil
+	}
+
+	call := m.inflight
+	if call == nil {
+		call = &loginCall{done: make(chan struct{})}
+		m.inflight = call
+		go m.run(call)
+	}
+
+	m.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.session.Token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// run logs in for everyone waiting on call, and replaces the current session.
+func (m *SessionManager) run(call *loginCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), loginTimeout)
+	defer cancel()
+
+	session, err := m.login(ctx)
+	if err == nil && session.ExpiresAt.IsZero() {
+		session.ExpiresAt = time.Now().Add(m.TTL)
+	}
+
+	m.mu.Lock()
+	previous := m.current
+	if err == nil {
+		m.current = session
+	}
+	m.inflight = nil
+	m.mu.Unlock()
+
+	call.session, call.err = session, err
+	close(call.done)
+
+	if err != nil {
+		m.logger.Error("vendor login failed", "vendor", m.name, "error", err)
+		return
+	}
+
+	m.logger.Info("vendor session opened", "vendor", m.name, "expires_at", session.ExpiresAt)
+
+	// A session replaced before it expired is still open on the vendor's side.
+	if previous.Token != "" {
+		m.end(ctx, previous)
+	}
+}
+
+// Invalidate drops the session with token after the vendor rejected it, so the next
+// request logs in again. Requests still holding an older token don't drop a newer one.
+func (m *SessionManager) Invalidate(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.current.Token == token {
+		m.current = Session{}
+	}
+}
+
+// Close logs out of the current session, on shutdown.
+func (m *SessionManager) Close(ctx context.Context) {
+	m.mu.Lock()
+	session := m.current
+	m.current = Session{}
+	m.mu.Unlock()
+
+	if session.Token != "" {
+		m.end(ctx, session)
+	}
+}
+
+func (m *SessionManager) end(ctx context.Context, session Session) {
+	if m.logout == nil {
+		return
+	}
+
+	err := m.logout(ctx, session)
+	if err != nil {
+		m.logger.Warn("vendor logout failed", "vendor", m.name, "error", err)
+		return
+	}
+
+	m.logger.Info("vendor session closed", "vendor", m.name)
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeLogins is a vendor that hands out sessions t1, t2... and records logouts.
+type fakeLogins struct {
+	mu        sync.Mutex
+	logins    int
+	loggedOut []string
+	expiresIn time.Duration
+	err       error
+}
+
+func (f *fakeLogins) login(ctx context.Context) (Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return Session{}, f.err
+	}
+
+	f.logins++
+	s := Session{Token: fmt.Sprintf("t%d", f.logins)}
+	if f.expiresIn != 0 {
+		s.ExpiresAt = time.Now().Add(f.expiresIn)
+	}
+
+	return s, nil
+}
+
+func (f *fakeLogins) logout(ctx context.Context, s Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.loggedOut = append(f.loggedOut, s.Token)
+	return nil
+}
+
+func TestSessionManagerSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	var logins atomic.Int32
+
+	m := NewSessionManager("test", discard, time.Hour, func(ctx context.Context) (Session, error) {
+		logins.Add(1)
+		<-release
+		return Session{Token: "t1"}, nil
+	}, nil)
+
+	const callers = 20
+
+	var wg sync.WaitGroup
+	tokens := make(chan string, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			token, err := m.Token(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			tokens <- token
+		}()
+	}
+
+	// Let every caller reach the login before it finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(tokens)
+
+	if n := logins.Load(); n != 1 {
+		t.Errorf("expected one login, got %d", n)
+	}
+	for token := range tokens {
+		if token != "t1" {
+			t.Errorf("expected every caller to get t1, got %q", token)
+		}
+	}
+}
+
+func TestSessionManagerToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		expiresIn     time.Duration
+		between       func(m *SessionManager)
+		wantToken     string
+		wantLoggedOut []string
+	}{
+		{
+			name:      "session reused",
+			expiresIn: time.Hour,
+			wantToken: "t1",
+		},
+		{
+			// The session replaced is still open on the vendor's side.
+			name:          "session about to expire",
+			expiresIn:     30 * time.Second,
+			wantToken:     "t2",
+			wantLoggedOut: []string{"t1"},
+		},
+		{
+			// Vendors that don't say when sessions expire get the manager's TTL.
+			name:      "no expiry from the vendor",
+			wantToken: "t1",
+		},
+		{
+			name:      "session rejected",
+			expiresIn: time.Hour,
+			between:   func(m *SessionManager) { m.Invalidate("t1") },
+			wantToken: "t2",
+		},
+		{
+			// A request that held an older token doesn't drop the current one.
+			name:      "older session rejected",
+			expiresIn: time.Hour,
+			between:   func(m *SessionManager) { m.Invalidate("t0") },
+			wantToken: "t1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vendor := &fakeLogins{expiresIn: tt.expiresIn}
+			m := NewSessionManager("test", discard, time.Hour, vendor.login, vendor.logout)
+
+			_, err := m.Token(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.between != nil {
+				tt.between(m)
+			}
+
+			token, err := m.Token(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != tt.wantToken {
+				t.Errorf("expected %s, got %s", tt.wantToken, token)
+			}
+
+			// The replaced session is logged out of after the new one is handed out.
+			want := strings.Join(tt.wantLoggedOut, ",")
+			var loggedOut string
+			for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+				vendor.mu.Lock()
+				loggedOut = strings.Join(vendor.loggedOut, ",")
+				vendor.mu.Unlock()
+				if loggedOut == want {
+					break
+				}
+			}
+			if loggedOut != want {
+				t.Errorf("expected logouts [%s], got [%s]", want, loggedOut)
+			}
+		})
+	}
+}
+
+func TestSessionManagerLoginFailure(t *testing.T) {
+	vendor := &fakeLogins{err: errors.New("bad credentials")}
+	m := NewSessionManager("test", discard, time.Hour, vendor.login, vendor.logout)
+
+	_, err := m.Token(context.Background())
+	if err == nil {
+		t.Fatal("expected the login error")
+	}
+
+	// A failed login isn't kept; the next request tries again.
+	vendor.mu.Lock()
+	vendor.err = nil
+	vendor.mu.Unlock()
+
+	token, err := m.Token(context.Background())
+	if err != nil || token != "t1" {
+		t.Errorf("expected t1 after logging in again, got %q, %v", token, err)
+	}
+}
+
+// A request the vendor rejects with a 401 is sent once more with a new session.
+func TestVeeamLogsInAgainOn401(t *testing.T) {
+	var logins, rejected atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/sessionMngr/":
+			w.Header().Set("X-RestSvcSessionId", fmt.Sprintf("s%d", logins.Add(1)))
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/api/logonSessions/"):
+			w.WriteHeader(http.StatusNoContent)
+		case r.Header.Get("X-RestSvcSessionId") == "s1":
+			rejected.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			json.NewEncoder(w).Encode(VeeamOrg{UID: "urn:veeam:VCloudOrganizationConfig:abc", Name: "acme"})
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewVeeamClient(VeeamClientConfig{
+		SessionPath:    "/api/sessionMngr/",
+		OrgConfigsPath: "/api/vCloud/orgConfigs",
+		HTTP:           vendorhttp.Config{BaseURL: srv.URL, Logger: discard},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	org, err := c.GetOrg(context.Background(), "urn:veeam:VCloudOrganizationConfig:abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if org.Name != "acme" {
+		t.Errorf("expected acme, got %q", org.Name)
+	}
+	if logins.Load() != 2 || rejected.Load() != 1 {
+		t.Errorf("expected one rejection and two logins, got %d and %d", rejected.Load(), logins.Load())
+	}
+}
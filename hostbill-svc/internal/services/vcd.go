@@ -1,82 +1,777 @@
 package services
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
 )
 
-// VcdSessionRequest represents the request body for obtaining a zerto session token
-type VcdSessionRequest struct {
-	URL      string `json:"url"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+// ErrVCDNotFound is returned when a VCD lookup matches nothing.
+var ErrVCDNotFound = errors.New("vcd: not found")
+
+// VCDError is returned when VCD answers a request with an error status. Code is VCD's
+// minor error code, such as DUPLICATE_NAME, when it sends one.
+type VCDError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *VCDError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("vcd request failed with status %d (%s): %s", e.Status, e.Code, e.Message)
+	}
+
+	return fmt.Sprintf("vcd request failed with status %d: %s", e.Status, e.Message)
+}
+
+// VCDConfig holds what VCDClient needs to reach VCD. Username may name the org to log
+// in to as user@org; without one it is a provider (System) login. HTTP sets the URL,
+// trust and timeouts.
+type VCDConfig struct {
+	Username   string
+	Password   string
+	APIVersion string
+	HTTP       vendorhttp.Config
+}
+
+// VCDClient talks to the vCloud Director CloudAPI, and to the legacy API for the few
+// operations CloudAPI doesn't cover. It logs in with the CloudAPI session endpoint and
+// shares the bearer token between requests, logging in again before it expires or when
+// it is rejected. It is safe for concurrent use.
+type VCDClient struct {
+	username   string
+	password   string
+	apiVersion string
+	http       *vendorhttp.Client
+
+	// TaskInterval is how often long running tasks are polled.
+	TaskInterval time.Duration
+
+	sessions *SessionManager
+}
+
+// VCDEntityRef is a reference to another VCD entity, by URN and name.
+type VCDEntityRef struct {
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id"`
+}
+
+// VCDOrg is a VCD organization, one per tenant.
+type VCDOrg struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description,omitempty"`
+	IsEnabled   bool   `json:"isEnabled"`
+}
+
+// VCDProviderVDC is a provider VDC org VDCs are carved out of.
+type VCDProviderVDC struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// VCDOrgVDC is an organization's virtual datacenter.
+type VCDOrgVDC struct {
+	ID             string       `json:"id"`
+	Name           string       `json:"name"`
+	Org            VCDEntityRef `json:"org"`
+	AllocationType string       `json:"allocationType,omitempty"`
+}
+
+// VCDOrgVDCParams describes an org VDC to create from a provider VDC with the Flex
+// allocation model. Capacity is in MHz and MB.
+type VCDOrgVDCParams struct {
+	Name          string
+	Description   string
+	ProviderVDC   string
+	NetworkPool   string
+	StoragePolicy string
+	CPUMHz        int
+	MemoryMB      int
+	StorageMB     int
+	NetworkQuota  int
+}
+
+// VCDUser is a local user of an organization. Password is only sent when creating
+// the user.
+type VCDUser struct {
+	ID            string       `json:"id,omitempty"`
+	Username      string       `json:"username"`
+	FullName      string       `json:"fullName,omitempty"`
+	Email         string       `json:"email,omitempty"`
+	Password      string       `json:"password,omitempty"`
+	Enabled       bool         `json:"enabled"`
+	RoleEntityRef VCDEntityRef `json:"roleEntityRef"`
+	ProviderType  string       `json:"providerType"`
+}
+
+// VCDEdgeGateway is an NSX-T edge gateway of an org VDC.
+type VCDEdgeGateway struct {
+	ID                 string                 `json:"id,omitempty"`
+	Name               string                 `json:"name"`
+	Description        string                 `json:"description,omitempty"`
+	OwnerRef           VCDEntityRef           `json:"ownerRef"`
+	EdgeGatewayUplinks []VCDEdgeGatewayUplink `json:"edgeGatewayUplinks"`
+}
+
+// VCDEdgeGatewayUplink connects an edge gateway to an external network.
+type VCDEdgeGatewayUplink struct {
+	UplinkID   string     `json:"uplinkId"`
+	UplinkName string     `json:"uplinkName,omitempty"`
+	Subnets    VCDSubnets `json:"subnets"`
+	Dedicated  bool       `json:"dedicated"`
+}
+
+// VCDSubnets is the list of subnets of a network or uplink.
+type VCDSubnets struct {
+	Values []VCDSubnet `json:"values"`
 }
 
-// VcdSessionResponse represents the response body for obtaining a zerto session token
-type VcdSessionResponse struct {
-	SessionId string `json:"sessionId"`
+// VCDSubnet is one subnet. For an edge gateway uplink, TotalIPCount with
+// AutoAllocateIPRanges lets VCD pick the gateway's IPs from the external network.
+type VCDSubnet struct {
+	Gateway              string `json:"gateway"`
+	PrefixLength         int    `json:"prefixLength"`
+	Enabled              bool   `json:"enabled"`
+	PrimaryIP            string `json:"primaryIp,omitempty"`
+	TotalIPCount         int    `json:"totalIpCount,omitempty"`
+	AutoAllocateIPRanges bool   `json:"autoAllocateIpRanges,omitempty"`
 }
 
-func vcdSession(r VcdSessionRequest) (w VcdSessionResponse, e error) {
-	// Create POST request
-	req, err := http.NewRequest("POST", r.URL, nil)
+// VCDExternalNetwork is a provider network edge gateways uplink to.
+type VCDExternalNetwork struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Subnets VCDSubnets `json:"subnets"`
+}
+
+// NewVCDClient returns a client for the VCD at cfg.HTTP.BaseURL. It doesn't log in
+// until the first request.
+func NewVCDClient(cfg VCDConfig) (*VCDClient, error) {
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = "38.0"
+	}
+
+	cfg.HTTP.Name = "vcd"
+	cfg.HTTP.DecodeError = decodeVCDError
+
+	client, err := vendorhttp.New(cfg.HTTP)
 	if err != nil {
-		return VcdSessionResponse{}, err
+		return nil, err
 	}
 
-	// Set Basic authentication header
-	req.SetBasicAuth(r.Username, r.Password)
+	c := &VCDClient{
+		username:     cfg.Username,
+		password:     cfg.Password,
+		apiVersion:   cfg.APIVersion,
+		http:         client,
+		TaskInterval: 2 * time.Second,
+	}
+	c.sessions = NewSessionManager("vcd", cfg.HTTP.Logger, 30*time.Minute, c.login, c.logout)
 
-	// Disable TLS
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+	return c, nil
+}
+
+// Close logs out of the client's session.
+func (c *VCDClient) Close(ctx context.Context) {
+	c.sessions.Close(ctx)
+}
+
+// login opens a CloudAPI session, which lasts for its idle timeout.
+func (c *VCDClient) login(ctx context.Context) (Session, error) {
+	username := c.username
+	endpoint := "/cloudapi/1.0.0/sessions"
+	if !strings.Contains(username, "@") {
+		username += "@System"
+	}
+	if strings.HasSuffix(strings.ToLower(username), "@system") {
+		endpoint = "/cloudapi/1.0.0/sessions/provider"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.http.URL(endpoint), nil)
+	if err != nil {
+		return Session{}, err
 	}
-	client := &http.Client{
-		Transport: transport,
+
+	req.SetBasicAuth(username, c.password)
+	req.Header.Set("Accept", "application/json;version="+c.apiVersion)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return Session{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Session{}, c.http.Error(res)
+	}
+
+	token := res.Header.Get("X-VMWARE-VCLOUD-ACCESS-TOKEN")
+	if token == "" {
+		return Session{}, errors.New("vcd session response has no access token")
+	}
+
+	var session struct {
+		SessionIdleTimeoutMinutes int `json:"sessionIdleTimeoutMinutes"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&session)
+
+	var expiresAt time.Time
+	if session.SessionIdleTimeoutMinutes > 0 {
+		expiresAt = time.Now().Add(time.Duration(session.SessionIdleTimeoutMinutes) * time.Minute)
+	}
+
+	return Session{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// logout ends a session.
+func (c *VCDClient) logout(ctx context.Context, session Session) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.http.URL("/cloudapi/1.0.0/sessions/current"), nil)
+	if err != nil {
+		return err
 	}
 
-	// Send request
-	res, err := client.Do(req)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	req.Header.Set("Accept", "application/json;version="+c.apiVersion)
+
+	res, err := c.http.Do(req)
 	if err != nil {
-		return VcdSessionResponse{}, err
+		return err
 	}
 	defer res.Body.Close()
 
-	// Check response status code
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		// Read the response body
-		body, err := io.ReadAll(res.Body)
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusUnauthorized {
+		return c.http.Error(res)
+	}
+
+	return nil
+}
+
+// vcdRequest is one call to VCD. Legacy API calls use the vendor JSON media types;
+// tenant sets the org a provider acts in.
+type vcdRequest struct {
+	method      string
+	path        string
+	body        any
+	contentType string
+	legacy      bool
+	tenant      string
+}
+
+// do sends req, logging in again once if the session has expired, and decodes a JSON
+// response into out. It returns the response headers, which carry the task to wait
+// on for asynchronous operations.
+func (c *VCDClient) do(ctx context.Context, r vcdRequest, out any) (http.Header, error) {
+	var body []byte
+	if r.body != nil {
+		var err error
+		body, err = json.Marshal(r.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := c.sessions.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, r.method, c.http.URL(r.path), bytes.NewReader(body))
 		if err != nil {
-			return VcdSessionResponse{}, fmt.Errorf("vcd session request failed with status: %s", res.Status)
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+		if r.legacy {
+			req.Header.Set("Accept", "application/*+json;version="+c.apiVersion)
 		} else {
-			return VcdSessionResponse{}, fmt.Errorf("vcd session request failed with body: %s", body)
+			req.Header.Set("Accept", "application/json;version="+c.apiVersion)
+		}
+		if body != nil {
+			contentType := r.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			req.Header.Set("Content-Type", contentType)
+		}
+		if r.tenant != "" {
+			req.Header.Set("X-VMWARE-VCLOUD-TENANT-CONTEXT", urnUUID(r.tenant))
+		}
+
+		res, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
 		}
 
-		// Log the response headers
-		var headers strings.Builder
-		for name, values := range res.Header {
-			headers.WriteString(fmt.Sprintf("%s: %s\n", name, strings.Join(values, ", ")))
+		if res.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			res.Body.Close()
+			c.sessions.Invalidate(token)
+			continue
 		}
 
-		return VcdSessionResponse{}, fmt.Errorf("vcd session request failed with status: %s", res.Status)
+		defer res.Body.Close()
+
+		if res.StatusCode >= 300 {
+			return nil, c.http.Error(res)
+		}
+
+		if out != nil && res.StatusCode != http.StatusNoContent {
+			err = json.NewDecoder(res.Body).Decode(out)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+		}
+
+		return res.Header, nil
+	}
+}
+
+// findOne returns the single CloudAPI entity at path matching the FIQL filter.
+func (c *VCDClient) findOne(ctx context.Context, path, filter, tenant string, out any) error {
+	var page struct {
+		ResultTotal int               `json:"resultTotal"`
+		Values      []json.RawMessage `json:"values"`
+	}
+
+	query := url.Values{"filter": {filter}, "pageSize": {"2"}}
+
+	_, err := c.do(ctx, vcdRequest{method: http.MethodGet, path: path + "?" + query.Encode(), tenant: tenant}, &page)
+	if err != nil {
+		return err
+	}
+
+	switch len(page.Values) {
+	case 0:
+		return ErrVCDNotFound
+	case 1:
+		return json.Unmarshal(page.Values[0], out)
+	default:
+		return fmt.Errorf("vcd: more than one match for %s %s", path, filter)
 	}
+}
+
+// waitTask polls a legacy API task until it finishes.
+func (c *VCDClient) waitTask(ctx context.Context, href string) error {
+	ticker := time.NewTicker(c.TaskInterval)
+	defer ticker.Stop()
+
+	for {
+		var task struct {
+			Status string `json:"status"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		_, err := c.do(ctx, vcdRequest{method: http.MethodGet, path: href, legacy: true}, &task)
+		if err != nil {
+			return err
+		}
+
+		switch task.Status {
+		case "success":
+			return nil
+		case "error", "aborted", "canceled":
+			message := task.Status
+			if task.Error != nil && task.Error.Message != "" {
+				message = task.Error.Message
+			}
+			return fmt.Errorf("vcd task %s: %s", href, message)
+		}
 
-	// Extract the session ID from the response headers
-	sessionId := res.Header.Get("x-vcd-session")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
 
-	if sessionId == "" {
-		return VcdSessionResponse{}, fmt.Errorf("failed to extract session Id from response headers")
+// GetOrg looks up an organization by name.
+func (c *VCDClient) GetOrg(ctx context.Context, name string) (*VCDOrg, error) {
+	var org VCDOrg
+	err := c.findOne(ctx, "/cloudapi/1.0.0/orgs", "name=="+name, "", &org)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create the response struct
-	responseStruct := VcdSessionResponse{
-		SessionId: sessionId,
+	return &org, nil
+}
+
+// CreateOrg creates an organization.
+func (c *VCDClient) CreateOrg(ctx context.Context, org VCDOrg) (*VCDOrg, error) {
+	var created VCDOrg
+	_, err := c.do(ctx, vcdRequest{method: http.MethodPost, path: "/cloudapi/1.0.0/orgs", body: org}, &created)
+	if err != nil {
+		return nil, err
 	}
 
-	return responseStruct, nil
+	return &created, nil
+}
+
+// DeleteOrg deletes an organization with everything in it, its VDCs, edge gateways,
+// vApps and users. VCD only deletes disabled organizations, so it is disabled first.
+func (c *VCDClient) DeleteOrg(ctx context.Context, org *VCDOrg) error {
+	path := "/cloudapi/1.0.0/orgs/" + url.PathEscape(org.ID)
+
+	if org.IsEnabled {
+		disabled := *org
+		disabled.IsEnabled = false
+
+		_, err := c.do(ctx, vcdRequest{method: http.MethodPut, path: path, body: disabled}, nil)
+		if err != nil {
+			return fmt.Errorf("disabling organization: %w", err)
+		}
+	}
+
+	headers, err := c.do(ctx, vcdRequest{method: http.MethodDelete, path: path + "?force=true&recursive=true"}, nil)
+	if err != nil {
+		return err
+	}
+
+	if task := headers.Get("Location"); task != "" {
+		return c.waitTask(ctx, task)
+	}
+
+	return nil
+}
+
+// GetProviderVDC looks up a provider VDC by name.
+func (c *VCDClient) GetProviderVDC(ctx context.Context, name string) (*VCDProviderVDC, error) {
+	var pvdc VCDProviderVDC
+	err := c.findOne(ctx, "/cloudapi/1.0.0/providerVdcs", "name=="+name, "", &pvdc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pvdc, nil
+}
+
+// GetOrgVDC looks up one of an organization's VDCs by name.
+func (c *VCDClient) GetOrgVDC(ctx context.Context, orgID, name string) (*VCDOrgVDC, error) {
+	var vdc VCDOrgVDC
+	err := c.findOne(ctx, "/cloudapi/1.0.0/vdcs", fmt.Sprintf("(name==%s;orgId==%s)", name, orgID), "", &vdc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vdc, nil
+}
+
+// CreateOrgVDC creates a Flex org VDC for an organization from a provider VDC, and
+// waits for VCD to finish creating it. CloudAPI can't create VDCs, so this uses the
+// legacy API.
+func (c *VCDClient) CreateOrgVDC(ctx context.Context, orgID string, params VCDOrgVDCParams) (*VCDOrgVDC, error) {
+	pvdc, err := c.GetProviderVDC(ctx, params.ProviderVDC)
+	if err != nil {
+		return nil, fmt.Errorf("provider vdc %q: %w", params.ProviderVDC, err)
+	}
+
+	var pool VCDEntityRef
+	err = c.findOne(ctx, "/cloudapi/1.0.0/networkPools", "name=="+params.NetworkPool, "", &pool)
+	if err != nil {
+		return nil, fmt.Errorf("network pool %q: %w", params.NetworkPool, err)
+	}
+
+	var policy VCDEntityRef
+	filter := fmt.Sprintf("(name==%s;providerVdcRef.id==%s)", params.StoragePolicy, pvdc.ID)
+	err = c.findOne(ctx, "/cloudapi/1.0.0/pvdcStoragePolicies", filter, "", &policy)
+	if err != nil {
+		return nil, fmt.Errorf("storage policy %q: %w", params.StoragePolicy, err)
+	}
+
+	body := map[string]any{
+		"name":            params.Name,
+		"description":     params.Description,
+		"allocationModel": "Flex",
+		"computeCapacity": map[string]any{
+			"cpu":    map[string]any{"units": "MHz", "allocated": params.CPUMHz, "limit": params.CPUMHz},
+			"memory": map[string]any{"units": "MB", "allocated": params.MemoryMB, "limit": params.MemoryMB},
+		},
+		"vdcStorageProfile": []map[string]any{{
+			"enabled": true,
+			"default": true,
+			"units":   "MB",
+			"limit":   params.StorageMB,
+			"providerVdcStorageProfile": map[string]any{
+				"href": c.http.URL("/api/admin/pvdcStorageProfile/" + urnUUID(policy.ID)),
+			},
+		}},
+		"networkQuota":          params.NetworkQuota,
+		"isThinProvision":       true,
+		"isElastic":             true,
+		"includeMemoryOverhead": true,
+		"usesFastProvisioning":  false,
+		"providerVdcReference":  map[string]any{"href": c.http.URL("/api/admin/providervdc/" + urnUUID(pvdc.ID))},
+		"networkPoolReference":  map[string]any{"href": c.http.URL("/api/admin/extension/networkPool/" + urnUUID(pool.ID))},
+	}
+
+	var created struct {
+		Tasks struct {
+			Task []struct {
+				Href string `json:"href"`
+			} `json:"task"`
+		} `json:"tasks"`
+	}
+
+	_, err = c.do(ctx, vcdRequest{
+		method:      http.MethodPost,
+		path:        "/api/admin/org/" + urnUUID(orgID) + "/vdcsparams",
+		body:        body,
+		contentType: "application/vnd.vmware.admin.createVdcParams+json",
+		legacy:      true,
+	}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task := range created.Tasks.Task {
+		err = c.waitTask(ctx, task.Href)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.GetOrgVDC(ctx, orgID, params.Name)
+}
+
+// GetRole looks up one of an organization's roles by name.
+func (c *VCDClient) GetRole(ctx context.Context, orgID, name string) (*VCDEntityRef, error) {
+	var role VCDEntityRef
+	err := c.findOne(ctx, "/cloudapi/1.0.0/roles", "name=="+name, orgID, &role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+// GetUser looks up one of an organization's users by username.
+func (c *VCDClient) GetUser(ctx context.Context, orgID, username string) (*VCDUser, error) {
+	var user VCDUser
+	err := c.findOne(ctx, "/cloudapi/1.0.0/users", "username=="+username, orgID, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateUser creates a local user in an organization.
+func (c *VCDClient) CreateUser(ctx context.Context, orgID string, user VCDUser) (*VCDUser, error) {
+	if user.ProviderType == "" {
+		user.ProviderType = "LOCAL"
+	}
+
+	var created VCDUser
+	_, err := c.do(ctx, vcdRequest{method: http.MethodPost, path: "/cloudapi/1.0.0/users", body: user, tenant: orgID}, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// GetExternalNetwork looks up an external network by name.
+func (c *VCDClient) GetExternalNetwork(ctx context.Context, name string) (*VCDExternalNetwork, error) {
+	var network VCDExternalNetwork
+	err := c.findOne(ctx, "/cloudapi/1.0.0/externalNetworks", "name=="+name, "", &network)
+	if err != nil {
+		return nil, err
+	}
+
+	return &network, nil
+}
+
+// GetEdgeGateway looks up an edge gateway of an org VDC by name.
+func (c *VCDClient) GetEdgeGateway(ctx context.Context, vdcID, name string) (*VCDEdgeGateway, error) {
+	var gateway VCDEdgeGateway
+	err := c.findOne(ctx, "/cloudapi/1.0.0/edgeGateways", fmt.Sprintf("(name==%s;ownerRef.id==%s)", name, vdcID), "", &gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gateway, nil
+}
+
+// CreateEdgeGateway creates an edge gateway and waits for VCD to finish deploying it.
+func (c *VCDClient) CreateEdgeGateway(ctx context.Context, gateway VCDEdgeGateway) (*VCDEdgeGateway, error) {
+	headers, err := c.do(ctx, vcdRequest{method: http.MethodPost, path: "/cloudapi/1.0.0/edgeGateways", body: gateway}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if task := headers.Get("Location"); task != "" {
+		err = c.waitTask(ctx, task)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.GetEdgeGateway(ctx, gateway.OwnerRef.ID, gateway.Name)
+}
+
+// VCDCreateOrgRequest represents the request body HostBill sends to provision a VCD
+// organization for an order. Capacity is in MHz, MB and GB.
+type VCDCreateOrgRequest struct {
+	OrganizationName string `json:"OrganizationName"`
+	CompanyName      string `json:"CompanyName"`
+	CrmIdentifier    string `json:"CrmIdentifier"`
+	CpuMHz           int    `json:"CpuMHz"`
+	MemoryMB         int    `json:"MemoryMB"`
+	StorageGb        int    `json:"StorageGb"`
+	AdminUsername    string `json:"AdminUsername"`
+	AdminEmail       string `json:"AdminEmail"`
+	AdminFullName    string `json:"AdminFullName"`
+
+	// HostbillOrderId and HostbillServiceId are only recorded in the inventory.
+	HostbillOrderId   string `json:"HostbillOrderId"`
+	HostbillServiceId string `json:"HostbillServiceId"`
+}
+
+// VCDProvisionRequest describes the tenant to set up for an order.
+type VCDProvisionRequest struct {
+	OrgName         string
+	DisplayName     string
+	Description     string
+	VDC             VCDOrgVDCParams
+	AdminRole       string
+	Admin           VCDUser
+	ExternalNetwork string
+}
+
+// VCDProvisionResult holds what was found or created for an order.
+type VCDProvisionResult struct {
+	Org         *VCDOrg         `json:"org"`
+	VDC         *VCDOrgVDC      `json:"vdc"`
+	Admin       *VCDUser        `json:"admin"`
+	EdgeGateway *VCDEdgeGateway `json:"edge_gateway,omitempty"`
+
+	// AdminCreated is false when the admin already existed, so the password in the
+	// request was never set.
+	AdminCreated bool `json:"admin_created"`
+}
+
+// ProvisionOrg sets up an organization with an org VDC, an administrator and, if an
+// external network is given, an edge gateway. Each step reuses what already exists, so
+// an order that failed part way, or is sent twice, can simply be retried.
+func (c *VCDClient) ProvisionOrg(ctx context.Context, p VCDProvisionRequest) (*VCDProvisionResult, error) {
+	var result VCDProvisionResult
+
+	org, err := c.GetOrg(ctx, p.OrgName)
+	if errors.Is(err, ErrVCDNotFound) {
+		org, err = c.CreateOrg(ctx, VCDOrg{
+			Name:        p.OrgName,
+			DisplayName: p.DisplayName,
+			Description: p.Description,
+			IsEnabled:   true,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("organization: %w", err)
+	}
+	result.Org = org
+
+	vdc, err := c.GetOrgVDC(ctx, org.ID, p.VDC.Name)
+	if errors.Is(err, ErrVCDNotFound) {
+		vdc, err = c.CreateOrgVDC(ctx, org.ID, p.VDC)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("org vdc: %w", err)
+	}
+	result.VDC = vdc
+
+	admin, err := c.GetUser(ctx, org.ID, p.Admin.Username)
+	if errors.Is(err, ErrVCDNotFound) {
+		var role *VCDEntityRef
+		role, err = c.GetRole(ctx, org.ID, p.AdminRole)
+		if err != nil {
+			return nil, fmt.Errorf("role %q: %w", p.AdminRole, err)
+		}
+
+		user := p.Admin
+		user.RoleEntityRef = *role
+		user.Enabled = true
+		admin, err = c.CreateUser(ctx, org.ID, user)
+		result.AdminCreated = err == nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("admin user: %w", err)
+	}
+	result.Admin = admin
+
+	if p.ExternalNetwork == "" {
+		return &result, nil
+	}
+
+	name := p.OrgName + "-edge"
+	gateway, err := c.GetEdgeGateway(ctx, vdc.ID, name)
+	if errors.Is(err, ErrVCDNotFound) {
+		var network *VCDExternalNetwork
+		network, err = c.GetExternalNetwork(ctx, p.ExternalNetwork)
+		if err != nil {
+			return nil, fmt.Errorf("external network %q: %w", p.ExternalNetwork, err)
+		}
+		if len(network.Subnets.Values) == 0 {
+			return nil, fmt.Errorf("external network %q has no subnets", p.ExternalNetwork)
+		}
+
+		subnet := network.Subnets.Values[0]
+		gateway, err = c.CreateEdgeGateway(ctx, VCDEdgeGateway{
+			Name:     name,
+			OwnerRef: VCDEntityRef{ID: vdc.ID},
+			EdgeGatewayUplinks: []VCDEdgeGatewayUplink{{
+				UplinkID: network.ID,
+				Subnets: VCDSubnets{Values: []VCDSubnet{{
+					Gateway:              subnet.Gateway,
+					PrefixLength:         subnet.PrefixLength,
+					Enabled:              true,
+					TotalIPCount:         1,
+					AutoAllocateIPRanges: true,
+				}}},
+			}},
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("edge gateway: %w", err)
+	}
+	result.EdgeGateway = gateway
+
+	return &result, nil
+}
+
+// decodeVCDError decodes an error response. CloudAPI and the legacy API both send
+// JSON with a message and a minor error code when asked for JSON.
+func decodeVCDError(status int, body []byte) error {
+	var payload struct {
+		MinorErrorCode string `json:"minorErrorCode"`
+		Message        string `json:"message"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &payload) == nil && payload.Message != "" {
+		message = payload.Message
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return &VCDError{Status: status, Code: payload.MinorErrorCode, Message: message}
+}
+
+// urnUUID returns the UUID at the end of a VCD URN such as urn:vcloud:org:<uuid>,
+// which is what the legacy API and the tenant context header expect.
+func urnUUID(urn string) string {
+	return urn[strings.LastIndex(urn, ":")+1:]
 }
@@ -0,0 +1,433 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+// fakeVCD is a VCD that keeps its entities in memory. It serves the CloudAPI
+// sessions, queries, creates, updates and deletes VCDClient uses, and the legacy API
+// calls for org VDCs and tasks.
+type fakeVCD struct {
+	mu sync.Mutex
+	// logins are the endpoint and user of each login, and tokens the sessions open.
+	logins []string
+	tokens map[string]bool
+	// requests are the method and URI of every request made with a session.
+	requests []string
+	// entities are kept by CloudAPI collection, such as "orgs".
+	entities map[string][]map[string]any
+	// tasks are the polls left before each task finishes, and failTasks makes them
+	// finish with an error.
+	tasks     map[string]int
+	failTasks bool
+	ids       int
+}
+
+func newFakeVCD() *fakeVCD {
+	return &fakeVCD{tokens: map[string]bool{}, entities: map[string][]map[string]any{}, tasks: map[string]int{}}
+}
+
+// add stores an entity in a collection with a new URN, and returns it.
+func (f *fakeVCD) add(collection string, entity map[string]any) map[string]any {
+	f.ids++
+	entity["id"] = fmt.Sprintf("urn:vcloud:%s:%d", strings.TrimSuffix(collection, "s"), f.ids)
+	f.entities[collection] = append(f.entities[collection], entity)
+
+	return entity
+}
+
+// task starts a task that is still running the first time it is polled.
+func (f *fakeVCD) task(r *http.Request) string {
+	f.ids++
+	href := fmt.Sprintf("http://%s/api/task/%d", r.Host, f.ids)
+	f.tasks[href[strings.Index(href, "/api/"):]] = 1
+
+	return href
+}
+
+// expireSessions ends every session, as VCD does after their idle timeout.
+func (f *fakeVCD) expireSessions() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tokens = map[string]bool{}
+}
+
+// changes returns the requests that changed something.
+func (f *fakeVCD) changes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var changes []string
+	for _, r := range f.requests {
+		if !strings.HasPrefix(r, http.MethodGet) {
+			changes = append(changes, r)
+		}
+	}
+
+	return changes
+}
+
+func (f *fakeVCD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/cloudapi/1.0.0/sessions") {
+		user, _, _ := r.BasicAuth()
+		f.logins = append(f.logins, r.URL.Path+" "+user)
+
+		token := fmt.Sprintf("token-%d", len(f.logins))
+		f.tokens[token] = true
+
+		w.Header().Set("X-VMWARE-VCLOUD-ACCESS-TOKEN", token)
+		json.NewEncoder(w).Encode(map[string]int{"sessionIdleTimeoutMinutes": 30})
+		return
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !f.tokens[token] {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.URL.Path == "/cloudapi/1.0.0/sessions/current" {
+		delete(f.tokens, token)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if left, ok := f.tasks[r.URL.Path]; ok {
+		status := "running"
+		switch {
+		case left > 0:
+			f.tasks[r.URL.Path]--
+		case f.failTasks:
+			status = "error"
+		default:
+			status = "success"
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"status": status, "error": map[string]string{"message": "task failed"}})
+		return
+	}
+
+	if org, ok := strings.CutPrefix(r.URL.Path, "/api/admin/org/"); ok && r.Method == http.MethodPost {
+		f.add("vdcs", map[string]any{"name": body["name"], "orgId": "urn:vcloud:org:" + strings.TrimSuffix(org, "/vdcsparams")})
+
+		json.NewEncoder(w).Encode(map[string]any{"tasks": map[string]any{"task": []map[string]string{{"href": f.task(r)}}}})
+		return
+	}
+
+	collection, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/cloudapi/1.0.0/"), "/")
+	entities := f.entities[collection]
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		values := []map[string]any{}
+		for _, e := range entities {
+			if matches(e, r.URL.Query().Get("filter")) {
+				values = append(values, e)
+			}
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"resultTotal": len(values), "values": values})
+
+	case r.Method == http.MethodPost && id == "":
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.add(collection, body))
+
+	case r.Method == http.MethodPut:
+		for i, e := range entities {
+			if e["id"] == id {
+				body["id"] = id
+				entities[i] = body
+			}
+		}
+
+		json.NewEncoder(w).Encode(body)
+
+	case r.Method == http.MethodDelete:
+		for i, e := range entities {
+			if e["id"] != id {
+				continue
+			}
+
+			// VCD only deletes disabled organizations.
+			if e["isEnabled"] == true {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"minorErrorCode": "BAD_REQUEST", "message": "organization is enabled"})
+				return
+			}
+
+			f.entities[collection] = append(entities[:i:i], entities[i+1:]...)
+			w.Header().Set("Location", f.task(r))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// matches reports whether an entity matches a FIQL filter of == conditions, such as
+// (name==acme;orgId==urn:vcloud:org:1).
+func matches(entity map[string]any, filter string) bool {
+	for _, condition := range strings.Split(strings.Trim(filter, "()"), ";") {
+		field, want, _ := strings.Cut(condition, "==")
+
+		var value any = entity
+		for _, key := range strings.Split(field, ".") {
+			m, _ := value.(map[string]any)
+			value = m[key]
+		}
+
+		if fmt.Sprint(value) != want {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newTestVCD returns a client logging in to a fake VCD as username.
+func newTestVCD(t *testing.T, username string) (*fakeVCD, *VCDClient) {
+	t.Helper()
+
+	vcd := newFakeVCD()
+	srv := httptest.NewServer(vcd)
+	t.Cleanup(srv.Close)
+
+	c, err := NewVCDClient(VCDConfig{
+		Username: username,
+		Password: "secret",
+		HTTP:     vendorhttp.Config{BaseURL: srv.URL, RetryWait: time.Millisecond, Logger: discard},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.TaskInterval = time.Millisecond
+
+	return vcd, c
+}
+
+func TestVCDSession(t *testing.T) {
+	tests := []struct {
+		username  string
+		wantLogin string
+	}{
+		{"hostbill", "/cloudapi/1.0.0/sessions/provider hostbill@System"},
+		{"hostbill@system", "/cloudapi/1.0.0/sessions/provider hostbill@system"},
+		// Users of an org log in to the tenant endpoint.
+		{"admin@acme", "/cloudapi/1.0.0/sessions admin@acme"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			vcd, c := newTestVCD(t, tt.username)
+			vcd.add("orgs", map[string]any{"name": "acme"})
+
+			ctx := context.Background()
+
+			// Requests share the session.
+			for range 2 {
+				_, err := c.GetOrg(ctx, "acme")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(vcd.logins) != 1 || vcd.logins[0] != tt.wantLogin {
+				t.Fatalf("expected one login, %s, got %v", tt.wantLogin, vcd.logins)
+			}
+
+			// A request the session has expired for is sent again with a new one.
+			vcd.expireSessions()
+
+			_, err := c.GetOrg(ctx, "acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vcd.logins) != 2 {
+				t.Errorf("expected to log in again, got %v", vcd.logins)
+			}
+		})
+	}
+}
+
+func TestVCDFindOne(t *testing.T) {
+	vcd, c := newTestVCD(t, "hostbill")
+	vcd.add("orgs", map[string]any{"name": "acme", "isEnabled": true})
+	vcd.add("orgs", map[string]any{"name": "beta", "isEnabled": true})
+
+	tests := []struct {
+		name    string
+		filter  string
+		want    string
+		wantErr error
+	}{
+		{"one match", "name==acme", "acme", nil},
+		{"no match", "name==gamma", "", ErrVCDNotFound},
+		{"more than one match", "isEnabled==true", "", errors.New("more than one")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var org VCDOrg
+			err := c.findOne(context.Background(), "/cloudapi/1.0.0/orgs", tt.filter, "", &org)
+
+			switch {
+			case tt.wantErr == ErrVCDNotFound:
+				if !errors.Is(err, ErrVCDNotFound) {
+					t.Errorf("expected ErrVCDNotFound, got %v", err)
+				}
+			case tt.wantErr != nil:
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr.Error()) {
+					t.Errorf("expected an error about %q, got %v", tt.wantErr, err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case org.Name != tt.want:
+				t.Errorf("expected %s, got %q", tt.want, org.Name)
+			}
+		})
+	}
+
+	// Two results are enough to tell one match from several.
+	if !strings.Contains(vcd.requests[0], "pageSize=2") {
+		t.Errorf("expected a page of 2, got %s", vcd.requests[0])
+	}
+}
+
+func TestVCDProvisionOrgIsIdempotent(t *testing.T) {
+	vcd, c := newTestVCD(t, "hostbill")
+	pvdc := vcd.add("providerVdcs", map[string]any{"name": "pvdc"})
+	vcd.add("networkPools", map[string]any{"name": "pool"})
+	vcd.add("pvdcStoragePolicies", map[string]any{"name": "ssd", "providerVdcRef": map[string]any{"id": pvdc["id"]}})
+	vcd.add("roles", map[string]any{"name": "Organization Administrator"})
+
+	p := VCDProvisionRequest{
+		OrgName:     "acme",
+		DisplayName: "Acme",
+		VDC:         VCDOrgVDCParams{Name: "acme-vdc", ProviderVDC: "pvdc", NetworkPool: "pool", StoragePolicy: "ssd", CPUMHz: 1000, MemoryMB: 1024, StorageMB: 1024},
+		AdminRole:   "Organization Administrator",
+		Admin:       VCDUser{Username: "admin", Password: "generated"},
+	}
+
+	first, err := c.ProvisionOrg(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.AdminCreated {
+		t.Error("expected the admin to be created")
+	}
+
+	created := vcd.changes()
+	want := []string{
+		"POST /cloudapi/1.0.0/orgs",
+		"POST /api/admin/org/5/vdcsparams",
+		"POST /cloudapi/1.0.0/users",
+	}
+	if !reflect.DeepEqual(created, want) {
+		t.Errorf("expected %v, got %v", want, created)
+	}
+
+	// An order sent again finds everything and creates nothing.
+	second, err := c.ProvisionOrg(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.AdminCreated {
+		t.Error("expected the admin to be found, not created")
+	}
+	if changes := vcd.changes(); len(changes) != len(created) {
+		t.Errorf("expected nothing more to be changed, got %v", changes[len(created):])
+	}
+
+	if second.Org.ID != first.Org.ID || second.VDC.ID != first.VDC.ID || second.Admin.ID != first.Admin.ID {
+		t.Errorf("expected the same org, vdc and admin, got %+v and %+v", first, second)
+	}
+}
+
+func TestVCDDeleteOrg(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		failTasks bool
+		want      []string
+	}{
+		{
+			// VCD only deletes disabled organizations.
+			name:    "enabled",
+			enabled: true,
+			want: []string{
+				"PUT /cloudapi/1.0.0/orgs/urn:vcloud:org:1",
+				"DELETE /cloudapi/1.0.0/orgs/urn:vcloud:org:1?force=true&recursive=true",
+				"GET /api/task/2",
+				"GET /api/task/2",
+			},
+		},
+		{
+			name: "disabled",
+			want: []string{
+				"DELETE /cloudapi/1.0.0/orgs/urn:vcloud:org:1?force=true&recursive=true",
+				"GET /api/task/2",
+				"GET /api/task/2",
+			},
+		},
+		{
+			name:      "task fails",
+			failTasks: true,
+			want: []string{
+				"DELETE /cloudapi/1.0.0/orgs/urn:vcloud:org:1?force=true&recursive=true",
+				"GET /api/task/2",
+				"GET /api/task/2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vcd, c := newTestVCD(t, "hostbill")
+			vcd.failTasks = tt.failTasks
+			vcd.add("orgs", map[string]any{"name": "acme", "isEnabled": tt.enabled})
+
+			org, err := c.GetOrg(context.Background(), "acme")
+			if err != nil {
+				t.Fatal(err)
+			}
+			vcd.requests = nil
+
+			err = c.DeleteOrg(context.Background(), org)
+			if tt.failTasks {
+				if err == nil || !strings.Contains(err.Error(), "task failed") {
+					t.Errorf("expected the task's error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(vcd.requests, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, vcd.requests)
+			}
+		})
+	}
+}
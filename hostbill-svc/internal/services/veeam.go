@@ -1,5 +1,20 @@
 package services
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
 type VeeamConfig struct {
 	OrganizationName       string
 	BackupServerUid        string
@@ -11,3 +26,447 @@ type VeeamConfig struct {
 	HighPriorityJob        bool
 	HostUid                string
 }
+
+// ErrVeeamNotFound is returned when no Veeam organization matches a name or ID.
+var ErrVeeamNotFound = errors.New("veeam: organization not found")
+
+// veeamArchivedMarker is appended to the description of an archived organization,
+// followed by the time it was archived and a closing bracket.
+const veeamArchivedMarker = "[archived "
+
+// VeeamError is returned when Veeam answers a request with an error status.
+type VeeamError struct {
+	Status  int
+	Message string
+}
+
+func (e *VeeamError) Error() string {
+	return fmt.Sprintf("veeam request failed with status %d: %s", e.Status, e.Message)
+}
+
+// VeeamOrg is the Veeam configuration of a vCloud organization, which holds its
+// repository quota.
+type VeeamOrg struct {
+	UID              string  `json:"UID"`
+	Name             string  `json:"Name"`
+	OrganizationName string  `json:"OrganizationName,omitempty"`
+	QuotaGb          float64 `json:"QuotaGb"`
+	Description      string  `json:"Description"`
+}
+
+// ArchivedAt returns when the organization was archived, if it has been.
+func (o *VeeamOrg) ArchivedAt() (time.Time, bool) {
+	i := strings.LastIndex(o.Description, veeamArchivedMarker)
+	if i < 0 || !strings.HasSuffix(o.Description, "]") {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, o.Description[i+len(veeamArchivedMarker):len(o.Description)-1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// VeeamJob is a backup job. OrgConfigUid is the organization configuration of the
+// tenant that owns the job, and is empty for the provider's own jobs.
+type VeeamJob struct {
+	UID             string `json:"UID"`
+	Name            string `json:"Name"`
+	ScheduleEnabled bool   `json:"ScheduleEnabled"`
+	OrgConfigUid    string `json:"OrgConfigUid"`
+}
+
+// VeeamTask is an asynchronous Enterprise Manager task, returned by changes.
+type VeeamTask struct {
+	TaskId string `json:"TaskId"`
+	State  string `json:"State"`
+	Result *struct {
+		Success bool   `json:"Success"`
+		Message string `json:"Message"`
+	} `json:"Result"`
+}
+
+// VeeamClientConfig holds what VeeamClient needs to reach Enterprise Manager.
+// SessionPath is where sessions are opened, and organization configurations live
+// under OrgConfigsPath.
+type VeeamClientConfig struct {
+	SessionPath    string
+	OrgConfigsPath string
+	Username       string
+	Password       string
+	HTTP           vendorhttp.Config
+}
+
+// VeeamClient calls the Veeam Backup Enterprise Manager REST API. It shares one
+// session between requests, logging in again before it expires or when it is
+// rejected, and is safe for concurrent use.
+type VeeamClient struct {
+	sessionPath    string
+	orgConfigsPath string
+	username       string
+	password       string
+	http           *vendorhttp.Client
+	sessions       *SessionManager
+
+	// TaskInterval is how often asynchronous tasks are polled.
+	TaskInterval time.Duration
+}
+
+// NewVeeamClient returns a client for the Enterprise Manager at cfg.HTTP.BaseURL. It
+// doesn't log in until the first request.
+func NewVeeamClient(cfg VeeamClientConfig) (*VeeamClient, error) {
+	cfg.HTTP.Name = "veeam"
+	cfg.HTTP.DecodeError = decodeVeeamError
+
+	client, err := vendorhttp.New(cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &VeeamClient{
+		sessionPath:    cfg.SessionPath,
+		orgConfigsPath: "/" + strings.Trim(cfg.OrgConfigsPath, "/"),
+		username:       cfg.Username,
+		password:       cfg.Password,
+		http:           client,
+		TaskInterval:   2 * time.Second,
+	}
+	// Enterprise Manager doesn't say when sessions expire; they time out after 15
+	// minutes without use.
+	c.sessions = NewSessionManager("veeam", cfg.HTTP.Logger, 15*time.Minute, c.login, c.logout)
+
+	return c, nil
+}
+
+// Close logs out of the client's session.
+func (c *VeeamClient) Close(ctx context.Context) {
+	c.sessions.Close(ctx)
+}
+
+// login opens a session, whose token comes back in the X-RestSvcSessionId header.
+func (c *VeeamClient) login(ctx context.Context) (Session, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.http.URL(c.sessionPath), nil)
+	if err != nil {
+		return Session{}, err
+	}
+
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return Session{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return Session{}, c.http.Error(res)
+	}
+
+	token := res.Header.Get("X-RestSvcSessionId")
+	if token == "" {
+		return Session{}, errors.New("failed to extract session ID from response headers")
+	}
+
+	var logon struct {
+		SessionId string `json:"SessionId"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&logon)
+
+	return Session{Token: token, ID: logon.SessionId}, nil
+}
+
+// logout ends a session.
+func (c *VeeamClient) logout(ctx context.Context, session Session) error {
+	if session.ID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.http.URL("/api/logonSessions/"+url.PathEscape(session.ID)), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("X-RestSvcSessionId", session.Token)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusUnauthorized {
+		return c.http.Error(res)
+	}
+
+	return nil
+}
+
+// do sends a JSON request, logging in again once if the session has expired, and
+// decodes the JSON response into out.
+func (c *VeeamClient) do(ctx context.Context, method, path string, body, out any) error {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := c.sessions.Token(ctx)
+		if err != nil {
+			return err
+		}
+
+		var reader io.Reader
+		if b != nil {
+			reader = bytes.NewReader(b)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, c.http.URL(path), reader)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("X-RestSvcSessionId", token)
+		req.Header.Set("Accept", "application/json")
+		if b != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		res, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			res.Body.Close()
+			c.sessions.Invalidate(token)
+			continue
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode == http.StatusNotFound {
+			return ErrVeeamNotFound
+		}
+		if res.StatusCode >= 300 {
+			return c.http.Error(res)
+		}
+
+		if out != nil && res.StatusCode != http.StatusNoContent {
+			err = json.NewDecoder(res.Body).Decode(out)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// decodeVeeamError decodes an error response. Enterprise Manager sends a message and,
+// for failures inside the backup server, the message of the exception behind it.
+func decodeVeeamError(status int, body []byte) error {
+	var payload struct {
+		Message                     string `json:"Message"`
+		FirstChanceExceptionMessage string `json:"FirstChanceExceptionMessage"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &payload) == nil {
+		switch {
+		case payload.FirstChanceExceptionMessage != "":
+			message = payload.FirstChanceExceptionMessage
+		case payload.Message != "":
+			message = payload.Message
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return &VeeamError{Status: status, Message: message}
+}
+
+// change sends a request that starts a task, and waits for the task to finish.
+func (c *VeeamClient) change(ctx context.Context, method, path string, body any) error {
+	var task VeeamTask
+	err := c.do(ctx, method, path, body, &task)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(c.TaskInterval)
+	defer ticker.Stop()
+
+	for task.TaskId != "" && task.State != "Finished" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		err = c.do(ctx, http.MethodGet, "/api/tasks/"+url.PathEscape(task.TaskId), nil, &task)
+		if err != nil {
+			return err
+		}
+	}
+
+	if task.Result != nil && !task.Result.Success {
+		return fmt.Errorf("veeam task %s failed: %s", task.TaskId, task.Result.Message)
+	}
+
+	return nil
+}
+
+// CreateOrg creates an organization configuration and waits for Enterprise Manager to
+// finish.
+func (c *VeeamClient) CreateOrg(ctx context.Context, spec any) error {
+	return c.change(ctx, http.MethodPost, c.orgConfigsPath, spec)
+}
+
+// LookupOrg finds an organization by its stored UID, or by name.
+func (c *VeeamClient) LookupOrg(ctx context.Context, ref string) (*VeeamOrg, error) {
+	if IsVeeamUID(ref) {
+		return c.GetOrg(ctx, ref)
+	}
+
+	return c.FindOrg(ctx, ref)
+}
+
+// GetOrg returns the organization with the given UID.
+func (c *VeeamClient) GetOrg(ctx context.Context, uid string) (*VeeamOrg, error) {
+	var org VeeamOrg
+	err := c.do(ctx, http.MethodGet, c.orgPath(uid)+"?format=Entity", nil, &org)
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// FindOrg returns the organization with the given name. Names are compared without
+// spaces, as they are stripped when organizations are created.
+func (c *VeeamClient) FindOrg(ctx context.Context, name string) (*VeeamOrg, error) {
+	var list struct {
+		Orgs []VeeamOrg `json:"VCloudOrganizationConfigs"`
+	}
+
+	err := c.do(ctx, http.MethodGet, c.orgConfigsPath+"?format=Entity", nil, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.ReplaceAll(name, " ", "")
+	for _, org := range list.Orgs {
+		if strings.EqualFold(org.Name, name) || strings.EqualFold(org.OrganizationName, name) {
+			return &org, nil
+		}
+	}
+
+	return nil, ErrVeeamNotFound
+}
+
+// SetQuota changes the organization's repository quota.
+func (c *VeeamClient) SetQuota(ctx context.Context, org *VeeamOrg, quotaGb float64) error {
+	err := c.change(ctx, http.MethodPut, c.orgPath(org.UID), map[string]any{
+		"QuotaGb":     quotaGb,
+		"Description": org.Description,
+	})
+	if err != nil {
+		return err
+	}
+
+	org.QuotaGb = quotaGb
+
+	return nil
+}
+
+// Jobs returns the organization's backup jobs. They are matched by the organization's
+// UID rather than by their names, which tenants choose and may start with another
+// organization's name.
+func (c *VeeamClient) Jobs(ctx context.Context, org *VeeamOrg) ([]VeeamJob, error) {
+	var list struct {
+		Jobs []VeeamJob `json:"Jobs"`
+	}
+
+	err := c.do(ctx, http.MethodGet, "/api/jobs?format=Entity", nil, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []VeeamJob{}
+	for _, job := range list.Jobs {
+		if job.OrgConfigUid != "" && strings.EqualFold(urnUUID(job.OrgConfigUid), urnUUID(org.UID)) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+// Archive disables the schedule of each of the organization's jobs, so nothing new is
+// backed up while its restore points are kept, and marks it archived at the end of its
+// description. It returns the jobs it disabled. Archiving an archived organization
+// changes nothing.
+func (c *VeeamClient) Archive(ctx context.Context, org *VeeamOrg, now time.Time) ([]VeeamJob, error) {
+	jobs, err := c.Jobs(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+
+	disabled := []VeeamJob{}
+	for _, job := range jobs {
+		if !job.ScheduleEnabled {
+			continue
+		}
+
+		err = c.change(ctx, http.MethodPost, "/api/jobs/"+urnUUID(job.UID)+"?action=toggleScheduleEnabled", nil)
+		if err != nil {
+			return disabled, fmt.Errorf("disabling job %s: %w", job.Name, err)
+		}
+
+		job.ScheduleEnabled = false
+		disabled = append(disabled, job)
+	}
+
+	if _, archived := org.ArchivedAt(); archived {
+		return disabled, nil
+	}
+
+	description := veeamArchivedMarker + now.UTC().Format(time.RFC3339) + "]"
+	if org.Description != "" {
+		description = org.Description + " " + description
+	}
+
+	err = c.change(ctx, http.MethodPut, c.orgPath(org.UID), map[string]any{
+		"QuotaGb":     org.QuotaGb,
+		"Description": description,
+	})
+	if err != nil {
+		return disabled, err
+	}
+
+	org.Description = description
+
+	return disabled, nil
+}
+
+// DeleteOrg removes the organization's configuration.
+func (c *VeeamClient) DeleteOrg(ctx context.Context, org *VeeamOrg) error {
+	return c.change(ctx, http.MethodDelete, c.orgPath(org.UID), nil)
+}
+
+func (c *VeeamClient) orgPath(uid string) string {
+	return c.orgConfigsPath + "/" + url.PathEscape(urnUUID(uid))
+}
+
+// IsVeeamUID reports whether ref is a Veeam UID, such as
+// urn:veeam:VCloudOrganizationConfig:<guid>, rather than a name.
+func IsVeeamUID(ref string) bool {
+	return strings.HasPrefix(strings.ToLower(ref), "urn:veeam:")
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+// fakeVeeam is an Enterprise Manager that keeps organizations and jobs in memory. Every
+// change starts a task that is still running the first time it is polled.
+type fakeVeeam struct {
+	mu   sync.Mutex
+	orgs []*VeeamOrg
+	jobs []*VeeamJob
+	// changes are the method and URI of every request that changed something.
+	changes []string
+	tasks   map[string]int
+}
+
+func (f *fakeVeeam) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/api/sessionMngr/" {
+		w.Header().Set("X-RestSvcSessionId", "session")
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		f.changes = append(f.changes, r.Method+" "+r.URL.RequestURI())
+	}
+
+	startTask := func() {
+		id := fmt.Sprintf("task-%d", len(f.changes))
+		f.tasks[id] = 1
+		json.NewEncoder(w).Encode(VeeamTask{TaskId: id, State: "Running"})
+	}
+
+	if id, ok := strings.CutPrefix(r.URL.Path, "/api/tasks/"); ok {
+		task := VeeamTask{TaskId: id, State: "Running"}
+		if f.tasks[id]--; f.tasks[id] < 0 {
+			task.State = "Finished"
+			task.Result = &struct {
+				Success bool   `json:"Success"`
+				Message string `json:"Message"`
+			}{Success: true}
+		}
+
+		json.NewEncoder(w).Encode(task)
+		return
+	}
+
+	if id, ok := strings.CutPrefix(r.URL.Path, "/api/jobs/"); ok {
+		for _, job := range f.jobs {
+			if urnUUID(job.UID) == id {
+				job.ScheduleEnabled = !job.ScheduleEnabled
+			}
+		}
+
+		startTask()
+		return
+	}
+
+	switch id, _ := strings.CutPrefix(r.URL.Path, "/api/vCloud/orgConfigs/"); {
+	case r.URL.Path == "/api/jobs":
+		json.NewEncoder(w).Encode(map[string]any{"Jobs": f.jobs})
+
+	case r.URL.Path == "/api/vCloud/orgConfigs":
+		json.NewEncoder(w).Encode(map[string]any{"VCloudOrganizationConfigs": f.orgs})
+
+	default:
+		for i, org := range f.orgs {
+			if urnUUID(org.UID) != id {
+				continue
+			}
+
+			switch r.Method {
+			case http.MethodGet:
+				json.NewEncoder(w).Encode(org)
+			case http.MethodPut:
+				json.NewDecoder(r.Body).Decode(org)
+				startTask()
+			case http.MethodDelete:
+				f.orgs = append(f.orgs[:i:i], f.orgs[i+1:]...)
+				startTask()
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newTestVeeam returns a client for a fake Enterprise Manager holding orgs and jobs.
+func newTestVeeam(t *testing.T, orgs []*VeeamOrg, jobs []*VeeamJob) (*fakeVeeam, *VeeamClient) {
+	t.Helper()
+
+	veeam := &fakeVeeam{orgs: orgs, jobs: jobs, tasks: map[string]int{}}
+	srv := httptest.NewServer(veeam)
+	t.Cleanup(srv.Close)
+
+	c, err := NewVeeamClient(VeeamClientConfig{
+		SessionPath:    "/api/sessionMngr/",
+		OrgConfigsPath: "/api/vCloud/orgConfigs",
+		HTTP:           vendorhttp.Config{BaseURL: srv.URL, RetryWait: time.Millisecond, Logger: discard},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.TaskInterval = time.Millisecond
+
+	return veeam, c
+}
+
+func TestVeeamOrgArchivedAt(t *testing.T) {
+	archivedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		description string
+		want        bool
+	}{
+		{"", false},
+		{"Acme backups", false},
+		{"[archived 2026-01-02T03:04:05Z]", true},
+		{"Acme backups [archived 2026-01-02T03:04:05Z]", true},
+		// The marker is only ever at the end.
+		{"[archived 2026-01-02T03:04:05Z] Acme backups", false},
+		{"Acme backups [archived yesterday]", false},
+		{"archived 2026-01-02T03:04:05Z", false},
+	}
+
+	for _, tt := range tests {
+		org := VeeamOrg{Description: tt.description}
+
+		got, ok := org.ArchivedAt()
+		if ok != tt.want {
+			t.Errorf("%q: expected archived %v, got %v", tt.description, tt.want, ok)
+		}
+		if ok && !got.Equal(archivedAt) {
+			t.Errorf("%q: expected %s, got %s", tt.description, archivedAt, got)
+		}
+	}
+}
+
+func TestVeeamArchive(t *testing.T) {
+	acme := &VeeamOrg{UID: "urn:veeam:VCloudOrganizationConfig:a1", Name: "acme", QuotaGb: 500, Description: "Acme backups"}
+	acmeCorp := &VeeamOrg{UID: "urn:veeam:VCloudOrganizationConfig:b2", Name: "acme_corp", QuotaGb: 100}
+
+	jobs := []*VeeamJob{
+		{UID: "urn:veeam:Job:1", Name: "acme_daily", ScheduleEnabled: true, OrgConfigUid: acme.UID},
+		{UID: "urn:veeam:Job:2", Name: "acme_weekly", OrgConfigUid: acme.UID},
+		// Another customer's job, whose name starts with acme's.
+		{UID: "urn:veeam:Job:3", Name: "acme_corp_daily", ScheduleEnabled: true, OrgConfigUid: acmeCorp.UID},
+		// The provider's own job, named like acme's.
+		{UID: "urn:veeam:Job:4", Name: "acme_provider", ScheduleEnabled: true},
+	}
+
+	veeam, c := newTestVeeam(t, []*VeeamOrg{acme, acmeCorp}, jobs)
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	org, err := c.GetOrg(ctx, acme.UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled, err := c.Archive(ctx, org, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(disabled) != 1 || disabled[0].Name != "acme_daily" {
+		t.Errorf("expected acme_daily to be disabled, got %+v", disabled)
+	}
+	for _, job := range jobs[2:] {
+		if !job.ScheduleEnabled {
+			t.Errorf("expected %s to keep running", job.Name)
+		}
+	}
+
+	// The description is kept, with the time it was archived after it.
+	want := "Acme backups [archived 2026-01-02T03:04:05Z]"
+	if org.Description != want || acme.Description != want {
+		t.Errorf("expected the description %q, got %q and %q in Veeam", want, org.Description, acme.Description)
+	}
+	if acme.QuotaGb != 500 {
+		t.Errorf("expected the quota to be kept, got %v", acme.QuotaGb)
+	}
+
+	// Archiving again changes nothing, and keeps the time it was first archived.
+	changes := len(veeam.changes)
+
+	disabled, err = c.Archive(ctx, org, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(disabled) != 0 || len(veeam.changes) != changes {
+		t.Errorf("expected nothing to change, got %+v and %v", disabled, veeam.changes[changes:])
+	}
+	if archivedAt, _ := org.ArchivedAt(); !archivedAt.Equal(now) {
+		t.Errorf("expected to stay archived at %s, got %s", now, archivedAt)
+	}
+}
+
+func TestVeeamSetQuotaAndDelete(t *testing.T) {
+	acme := &VeeamOrg{UID: "urn:veeam:VCloudOrganizationConfig:a1", Name: "acme", QuotaGb: 500, Description: "Acme backups"}
+	_, c := newTestVeeam(t, []*VeeamOrg{acme}, nil)
+	ctx := context.Background()
+
+	org, err := c.LookupOrg(ctx, "ACME")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.SetQuota(ctx, org, 750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if org.QuotaGb != 750 || acme.QuotaGb != 750 || acme.Description != "Acme backups" {
+		t.Errorf("expected a quota of 750 and the description kept, got %+v", acme)
+	}
+
+	err = c.DeleteOrg(ctx, org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.LookupOrg(ctx, acme.UID); err != ErrVeeamNotFound {
+		t.Errorf("expected the organization to be gone, got %v", err)
+	}
+}
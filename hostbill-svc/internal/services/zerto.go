@@ -1,31 +1,350 @@
 package services
 
-// ZertoSessionRequest represents the request body for obtaining a zerto session token
-type ZertoSessionRequest struct {
-	URL      string `json:"url"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
 
-// ZertoSessionResponse represents the response body for obtaining a zerto session token
-type ZertoSessionResponse struct {
-	SessionId string `json:"sessionId"`
+// ZertoTenantInfo is the customer information of a ZORG.
+type ZertoTenantInfo struct {
+	CompanyName             string `json:"CompanyName"`
+	DomainName              string `json:"DomainName"`
+	Country                 string `json:"Country"`
+	State                   string `json:"State"`
+	PostalCode              string `json:"PostalCode"`
+	IsMultiCloudProductType bool   `json:"IsMultiCloudProductType"`
 }
 
 type ZertoCreateOrgRequest struct {
-	Name          string `json:"Name"`
-	CrmIdentifier string `json:"CrmIdentifier"`
-	TenantInfo    struct {
-		CompanyName             string `json:"CompanyName"`
-		DomainName              string `json:"DomainName"`
-		Country                 string `json:"Country"`
-		State                   string `json:"State"`
-		PostalCode              string `json:"PostalCode"`
-		IsMultiCloudProductType bool   `json:"IsMultiCloudProductType"`
-	} `json:"TenantInfo"`
+	Name          string          `json:"Name"`
+	CrmIdentifier string          `json:"CrmIdentifier"`
+	TenantInfo    ZertoTenantInfo `json:"TenantInfo"`
+
+	// HostbillOrderId and HostbillServiceId are only recorded in the inventory.
+	HostbillOrderId   string `json:"HostbillOrderId"`
+	HostbillServiceId string `json:"HostbillServiceId"`
 }
 
 // zertoCreateOrgResponse represents the response body for creating a zerto organization
 type ZertoCreateOrgResponse struct {
 	Message string `json:"message"`
 }
+
+// ErrZorgNotFound is returned when no ZORG has a CRM identifier.
+var ErrZorgNotFound = errors.New("zerto: zorg not found")
+
+// zorgArchivedMarker is appended to the name of an archived ZORG, followed by the time
+// it was archived and a closing bracket.
+const zorgArchivedMarker = " [archived "
+
+// ZertoError is returned when Zerto answers a request with an error status.
+type ZertoError struct {
+	Status  int
+	Message string
+}
+
+func (e *ZertoError) Error() string {
+	return fmt.Sprintf("zerto request failed with status %d: %s", e.Status, e.Message)
+}
+
+// ZertoPermissions are what a ZORG's users may do with their VPGs in the Zerto
+// self-service portal.
+type ZertoPermissions struct {
+	IsAllowedToCreateVpg          bool `json:"IsAllowedToCreateVpg"`
+	IsAllowedToEditVpg            bool `json:"IsAllowedToEditVpg"`
+	IsAllowedToDeleteVpg          bool `json:"IsAllowedToDeleteVpg"`
+	IsAllowedToLiveFailoverOrMove bool `json:"IsAllowedToLiveFailoverOrMove"`
+	IsAllowedToTestFailover       bool `json:"IsAllowedToTestFailover"`
+}
+
+// Zorg is a Zerto organization, one per tenant, found by its CRM identifier.
+type Zorg struct {
+	ZorgIdentifier string           `json:"ZorgIdentifier"`
+	Name           string           `json:"Name"`
+	CrmIdentifier  string           `json:"CrmIdentifier"`
+	TenantInfo     ZertoTenantInfo  `json:"TenantInfo"`
+	Permissions    ZertoPermissions `json:"Permissions"`
+}
+
+// ArchivedAt returns when the ZORG was archived, if it has been.
+func (z *Zorg) ArchivedAt() (time.Time, bool) {
+	i := strings.LastIndex(z.Name, zorgArchivedMarker)
+	if i < 0 || !strings.HasSuffix(z.Name, "]") {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, z.Name[i+len(zorgArchivedMarker):len(z.Name)-1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// ZertoUpdateOrgRequest represents the request body for updating a ZORG. Fields left
+// out are kept.
+type ZertoUpdateOrgRequest struct {
+	Name        *string           `json:"Name"`
+	TenantInfo  *ZertoTenantInfo  `json:"TenantInfo"`
+	Permissions *ZertoPermissions `json:"Permissions"`
+}
+
+// ZertoClientConfig holds what ZertoClient needs to reach Zerto. SessionPath is where
+// sessions are started, and ZORGs live under ZorgsPath.
+type ZertoClientConfig struct {
+	SessionPath string
+	ZorgsPath   string
+	Username    string
+	Password    string
+	HTTP        vendorhttp.Config
+}
+
+// ZertoClient calls the Zerto Cloud Manager REST API. It shares one session between
+// requests, logging in again before it expires or when it is rejected, and is safe
+// for concurrent use.
+type ZertoClient struct {
+	sessionPath string
+	zorgsPath   string
+	username    string
+	password    string
+	http        *vendorhttp.Client
+	sessions    *SessionManager
+}
+
+// NewZertoClient returns a client for the Zerto at cfg.HTTP.BaseURL. It doesn't log in
+// until the first request.
+func NewZertoClient(cfg ZertoClientConfig) (*ZertoClient, error) {
+	cfg.HTTP.Name = "zerto"
+	cfg.HTTP.DecodeError = decodeZertoError
+
+	client, err := vendorhttp.New(cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ZertoClient{
+		sessionPath: cfg.SessionPath,
+		zorgsPath:   "/" + strings.Trim(cfg.ZorgsPath, "/"),
+		username:    cfg.Username,
+		password:    cfg.Password,
+		http:        client,
+	}
+	// Zerto doesn't say when sessions expire; they time out after 30 minutes without
+	// use.
+	c.sessions = NewSessionManager("zerto", cfg.HTTP.Logger, 25*time.Minute, c.login, c.logout)
+
+	return c, nil
+}
+
+// Close logs out of the client's session.
+func (c *ZertoClient) Close(ctx context.Context) {
+	c.sessions.Close(ctx)
+}
+
+// login starts a session, whose token comes back in the x-zerto-session header.
+func (c *ZertoClient) login(ctx context.Context) (Session, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.http.URL(c.sessionPath), nil)
+	if err != nil {
+		return Session{}, err
+	}
+
+	req.SetBasicAuth(c.username, c.password)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return Session{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return Session{}, c.http.Error(res)
+	}
+
+	token := res.Header.Get("x-zerto-session")
+	if token == "" {
+		return Session{}, errors.New("failed to extract session Id from response headers")
+	}
+
+	return Session{Token: token}, nil
+}
+
+// logout ends a session.
+func (c *ZertoClient) logout(ctx context.Context, session Session) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.http.URL("/v1/session"), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("x-zerto-session", session.Token)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusUnauthorized {
+		return c.http.Error(res)
+	}
+
+	return nil
+}
+
+// do sends a JSON request, logging in again once if the session has expired, and
+// decodes the JSON response into out.
+func (c *ZertoClient) do(ctx context.Context, method, path string, body, out any) error {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := c.sessions.Token(ctx)
+		if err != nil {
+			return err
+		}
+
+		var reader io.Reader
+		if b != nil {
+			reader = bytes.NewReader(b)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, c.http.URL(path), reader)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("x-zerto-session", token)
+		req.Header.Set("Accept", "application/json")
+		if b != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		res, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			res.Body.Close()
+			c.sessions.Invalidate(token)
+			continue
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode == http.StatusNotFound {
+			return ErrZorgNotFound
+		}
+		if res.StatusCode >= 300 {
+			return c.http.Error(res)
+		}
+
+		if out != nil && res.StatusCode != http.StatusNoContent {
+			err = json.NewDecoder(res.Body).Decode(out)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// decodeZertoError decodes an error response. The ZORG API sends a Message, newer
+// Zerto APIs problem details with a title and detail.
+func decodeZertoError(status int, body []byte) error {
+	var payload struct {
+		Message string `json:"Message"`
+		Title   string `json:"title"`
+		Detail  string `json:"detail"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &payload) == nil {
+		switch {
+		case payload.Message != "":
+			message = payload.Message
+		case payload.Detail != "":
+			message = payload.Detail
+		case payload.Title != "":
+			message = payload.Title
+		}
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return &ZertoError{Status: status, Message: message}
+}
+
+// CreateZorg creates a ZORG.
+func (c *ZertoClient) CreateZorg(ctx context.Context, spec any) error {
+	return c.do(ctx, http.MethodPost, c.zorgsPath, spec, nil)
+}
+
+// FindZorg returns the ZORG with the given CRM identifier.
+func (c *ZertoClient) FindZorg(ctx context.Context, crmIdentifier string) (*Zorg, error) {
+	var zorgs []Zorg
+	err := c.do(ctx, http.MethodGet, c.zorgsPath, nil, &zorgs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zorg := range zorgs {
+		if zorg.CrmIdentifier == crmIdentifier {
+			return &zorg, nil
+		}
+	}
+
+	return nil, ErrZorgNotFound
+}
+
+// UpdateZorg saves the name, tenant info and permissions of zorg.
+func (c *ZertoClient) UpdateZorg(ctx context.Context, zorg *Zorg) error {
+	return c.do(ctx, http.MethodPut, c.zorgPath(zorg), zorg, nil)
+}
+
+// ArchiveZorg takes away every permission of the ZORG's users and marks it archived.
+// Its VPGs are left as they are, so replication carries on and nothing is lost if the
+// customer comes back. Archiving an archived ZORG changes nothing.
+func (c *ZertoClient) ArchiveZorg(ctx context.Context, zorg *Zorg, now time.Time) error {
+	if _, archived := zorg.ArchivedAt(); archived {
+		return nil
+	}
+
+	archived := *zorg
+	archived.Permissions = ZertoPermissions{}
+	archived.Name = zorg.Name + zorgArchivedMarker + now.UTC().Format(time.RFC3339) + "]"
+
+	err := c.UpdateZorg(ctx, &archived)
+	if err != nil {
+		return err
+	}
+
+	*zorg = archived
+
+	return nil
+}
+
+// DeleteZorg removes the ZORG.
+func (c *ZertoClient) DeleteZorg(ctx context.Context, zorg *Zorg) error {
+	return c.do(ctx, http.MethodDelete, c.zorgPath(zorg), nil, nil)
+}
+
+func (c *ZertoClient) zorgPath(zorg *Zorg) string {
+	return c.zorgsPath + "/" + url.PathEscape(zorg.ZorgIdentifier)
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudKey-io/hostbill-svc/internal/vendorhttp"
+)
+
+// fakeZerto is a Zerto Cloud Manager that keeps ZORGs in memory.
+type fakeZerto struct {
+	mu    sync.Mutex
+	zorgs []*Zorg
+	// changes are the method and path of every request that changed something.
+	changes []string
+}
+
+func (f *fakeZerto) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/v1/session/add" {
+		w.Header().Set("x-zerto-session", "session")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		f.changes = append(f.changes, r.Method+" "+r.URL.Path)
+	}
+
+	if r.URL.Path == "/v1/zorgs" {
+		json.NewEncoder(w).Encode(f.zorgs)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/zorgs/")
+	for i, zorg := range f.zorgs {
+		if zorg.ZorgIdentifier != id {
+			continue
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			json.NewDecoder(r.Body).Decode(zorg)
+		case http.MethodDelete:
+			f.zorgs = append(f.zorgs[:i:i], f.zorgs[i+1:]...)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// newTestZerto returns a client for a fake Zerto holding zorgs.
+func newTestZerto(t *testing.T, zorgs ...*Zorg) (*fakeZerto, *ZertoClient) {
+	t.Helper()
+
+	zerto := &fakeZerto{zorgs: zorgs}
+	srv := httptest.NewServer(zerto)
+	t.Cleanup(srv.Close)
+
+	c, err := NewZertoClient(ZertoClientConfig{
+		SessionPath: "/v1/session/add",
+		ZorgsPath:   "/v1/zorgs",
+		HTTP:        vendorhttp.Config{BaseURL: srv.URL, RetryWait: time.Millisecond, Logger: discard},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return zerto, c
+}
+
+func TestZorgArchivedAt(t *testing.T) {
+	archivedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Acme", false},
+		{"Acme [archived 2026-01-02T03:04:05Z]", true},
+		// A name that already had brackets in it.
+		{"Acme [eu] [archived 2026-01-02T03:04:05Z]", true},
+		{"Acme [archived 2026-01-02T03:04:05Z] again", false},
+		{"Acme [archived yesterday]", false},
+		{"Acme [archived 2026-01-02T03:04:05Z", false},
+		{"[archived 2026-01-02T03:04:05Z]", false},
+	}
+
+	for _, tt := range tests {
+		zorg := Zorg{Name: tt.name}
+
+		got, ok := zorg.ArchivedAt()
+		if ok != tt.want {
+			t.Errorf("%q: expected archived %v, got %v", tt.name, tt.want, ok)
+		}
+		if ok && !got.Equal(archivedAt) {
+			t.Errorf("%q: expected %s, got %s", tt.name, archivedAt, got)
+		}
+	}
+}
+
+func TestZertoArchiveZorg(t *testing.T) {
+	acme := &Zorg{
+		ZorgIdentifier: "z1",
+		Name:           "Acme",
+		CrmIdentifier:  "C1",
+		TenantInfo:     ZertoTenantInfo{CompanyName: "Acme", Country: "US"},
+		Permissions:    ZertoPermissions{IsAllowedToCreateVpg: true, IsAllowedToEditVpg: true, IsAllowedToTestFailover: true},
+	}
+	zerto, c := newTestZerto(t, acme)
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	zorg, err := c.FindZorg(ctx, "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.ArchiveZorg(ctx, zorg, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Acme [archived 2026-01-02T03:04:05Z]"
+	if zorg.Name != want || acme.Name != want {
+		t.Errorf("expected the name %q, got %q and %q in Zerto", want, zorg.Name, acme.Name)
+	}
+	if zorg.Permissions != (ZertoPermissions{}) || acme.Permissions != (ZertoPermissions{}) {
+		t.Errorf("expected every permission to be taken away, got %+v in Zerto", acme.Permissions)
+	}
+	if acme.CrmIdentifier != "C1" || acme.TenantInfo.Country != "US" {
+		t.Errorf("expected the rest of the ZORG to be kept, got %+v", acme)
+	}
+
+	// Archiving again changes nothing, and keeps the time it was first archived.
+	changes := len(zerto.changes)
+
+	err = c.ArchiveZorg(ctx, zorg, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zerto.changes) != changes {
+		t.Errorf("expected nothing to change, got %v", zerto.changes[changes:])
+	}
+	if archivedAt, _ := zorg.ArchivedAt(); !archivedAt.Equal(now) {
+		t.Errorf("expected to stay archived at %s, got %s", now, archivedAt)
+	}
+}
+
+func TestZertoDeleteZorg(t *testing.T) {
+	_, c := newTestZerto(t, &Zorg{ZorgIdentifier: "z1", Name: "Acme", CrmIdentifier: "C1"})
+	ctx := context.Background()
+
+	zorg, err := c.FindZorg(ctx, "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.DeleteZorg(ctx, zorg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.FindZorg(ctx, "C1"); err != ErrZorgNotFound {
+		t.Errorf("expected the ZORG to be gone, got %v", err)
+	}
+}
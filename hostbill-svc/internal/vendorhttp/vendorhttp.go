@@ -0,0 +1,426 @@
+// Package vendorhttp is the HTTP client the vendor integrations (VCD, Veeam, Zerto and
+// duo-svc) share. It decides how far each vendor's appliance is trusted, bounds and
+// retries requests, logs them with secrets redacted, and turns error responses into
+// the vendor's own error type.
+package vendorhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxErrorBody is how much of an error response is read to decode it.
+const maxErrorBody = 64 << 10
+
+// maxLoggedBody is how much of a request or response body is logged, after it has
+// been redacted.
+const maxLoggedBody = 4 << 10
+
+// redacted replaces secrets in logs.
+const redacted = "[REDACTED]"
+
+// ErrPinMismatch is returned when a vendor presents a certificate other than the
+// pinned one.
+var ErrPinMismatch = errors.New("certificate doesn't match the pinned SHA-256")
+
+// secretHeaders carry credentials or session tokens of one of the vendors.
+var secretHeaders = map[string]bool{
+	"Authorization":                true,
+	"Cookie":                       true,
+	"Set-Cookie":                   true,
+	"X-Vmware-Vcloud-Access-Token": true,
+	"X-Vcloud-Authorization":       true,
+	"X-Restsvcsessionid":           true,
+	"X-Zerto-Session":              true,
+	"X-Api-Key":                    true,
+}
+
+// Config describes how to reach one vendor.
+type Config struct {
+	// Name is the vendor's name in logs and errors.
+	Name string
+	// BaseURL is what request paths are relative to.
+	BaseURL string
+
+	// CAFile is a PEM bundle of the CAs the vendor's certificate is checked
+	// against, instead of the system's.
+	CAFile string
+	// PinnedSHA256 is the hex SHA-256 of the vendor's DER certificate. When set,
+	// only that certificate is accepted, whoever issued it, which suits
+	// appliances with self-signed certificates.
+	PinnedSHA256 string
+	// Insecure turns off certificate checks. It is only meant for labs.
+	Insecure bool
+
+	// Timeout bounds each attempt of a request, 30 seconds by default.
+	Timeout time.Duration
+	// Retries is how many times an idempotent request is retried after a
+	// network error or a 429, 502, 503 or 504, 2 by default. RetryWait is the
+	// wait before the first retry, doubled for each one after it.
+	Retries   int
+	RetryWait time.Duration
+
+	// Logger logs requests and responses at debug level when Debug is set.
+	Logger *slog.Logger
+	Debug  bool
+
+	// DecodeError turns an error response into the vendor's error type. Without
+	// one errors are an *Error.
+	DecodeError func(status int, body []byte) error
+}
+
+// Error is an error response from a vendor without an error decoder.
+type Error struct {
+	Vendor  string
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s request failed with status %d: %s", e.Vendor, e.Status, e.Message)
+}
+
+// Client sends requests to one vendor. It is safe for concurrent use.
+type Client struct {
+	name        string
+	baseURL     string
+	client      *http.Client
+	retries     int
+	retryWait   time.Duration
+	logger      *slog.Logger
+	debug       bool
+	decodeError func(status int, body []byte) error
+}
+
+// New returns a client for the vendor described by cfg. It fails if the CA bundle
+// can't be read or the pin isn't a SHA-256.
+func New(cfg Config) (*Client, error) {
+	tlsConfig, err := trust(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Name, err)
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	} else if cfg.Retries == 0 {
+		cfg.Retries = 2
+	}
+	if cfg.RetryWait <= 0 {
+		cfg.RetryWait = 500 * time.Millisecond
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Client{
+		name:        cfg.Name,
+		baseURL:     strings.TrimSuffix(cfg.BaseURL, "/"),
+		client:      &http.Client{Transport: transport, Timeout: cfg.Timeout},
+		retries:     cfg.Retries,
+		retryWait:   cfg.RetryWait,
+		logger:      cfg.Logger,
+		debug:       cfg.Debug,
+		decodeError: cfg.DecodeError,
+	}, nil
+}
+
+// trust returns the TLS configuration for cfg's certificate settings.
+func trust(cfg Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.PinnedSHA256 != "" {
+		pin, err := hex.DecodeString(strings.ReplaceAll(cfg.PinnedSHA256, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, errors.New("pinned certificate must be a hex SHA-256")
+		}
+
+		// The pin replaces the chain check, which self-signed appliance
+		// certificates wouldn't pass. VerifyConnection still runs.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no server certificate")
+			}
+
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(sum[:], pin) {
+				return fmt.Errorf("%s: %w", cfg.Name, ErrPinMismatch)
+			}
+
+			return nil
+		}
+
+		return tlsConfig, nil
+	}
+
+	tlsConfig.InsecureSkipVerify = cfg.Insecure
+
+	return tlsConfig, nil
+}
+
+// URL returns the absolute URL of path, which may already be absolute, as the hrefs
+// vendors return are.
+func (c *Client) URL(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+
+	return c.baseURL + path
+}
+
+// Do sends req. Idempotent requests are retried after network errors and
+// responses that say the vendor is busy or briefly unavailable; the body of a
+// retried request must be rewindable, as it is for requests made with a bytes
+// reader.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	retries := 0
+	if idempotent(req) {
+		retries = c.retries
+	}
+
+	wait := c.retryWait
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		res, err := c.send(req)
+
+		if attempt >= retries || !retryable(req.Context(), res, err) {
+			return res, err
+		}
+
+		if res != nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, maxErrorBody))
+			res.Body.Close()
+		}
+
+		c.logger.Warn("retrying vendor request", "vendor", c.name, "method", req.Method, "url", req.URL.Redacted(), "attempt", attempt+1, "error", errOrStatus(res, err))
+	}
+}
+
+// send sends req once, logging it and its response in debug mode.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if !c.debug {
+		return c.client.Do(req)
+	}
+
+	var reqBody []byte
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			reqBody, _ = io.ReadAll(io.LimitReader(body, maxErrorBody))
+			body.Close()
+		}
+	}
+
+	c.logger.Debug("vendor request",
+		"vendor", c.name,
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"headers", redactHeaders(req.Header),
+		"body", redactBody(reqBody),
+	)
+
+	start := time.Now()
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Debug("vendor request failed", "vendor", c.name, "method", req.Method, "url", req.URL.Redacted(), "duration", time.Since(start), "error", err)
+		return nil, err
+	}
+
+	// Read the start of the body for the log and put it back in front of the rest.
+	resBody, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(resBody), res.Body), res.Body}
+
+	c.logger.Debug("vendor response",
+		"vendor", c.name,
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"status", res.StatusCode,
+		"duration", time.Since(start),
+		"headers", redactHeaders(res.Header),
+		"body", redactBody(resBody),
+	)
+
+	return res, nil
+}
+
+// Error reads the error response res and decodes it into the vendor's error type.
+// The caller still closes the body.
+func (c *Client) Error(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+
+	if c.decodeError != nil {
+		return c.decodeError(res.StatusCode, body)
+	}
+
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(res.StatusCode)
+	}
+
+	return &Error{Vendor: c.name, Status: res.StatusCode, Message: message}
+}
+
+// idempotent reports whether req can be sent again without changing anything twice.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+
+	return false
+}
+
+// retryable reports whether a request that got res or err is worth sending again. A
+// certificate that isn't trusted won't be the next time either.
+func retryable(ctx context.Context, res *http.Response, err error) bool {
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) || errors.Is(err, ErrPinMismatch) {
+			return false
+		}
+
+		return ctx.Err() == nil
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+func errOrStatus(res *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return res.Status
+}
+
+// redactHeaders returns h for logging, without the values of credential headers.
+func redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		if secretHeaders[http.CanonicalHeaderKey(name)] {
+			out[name] = redacted
+			continue
+		}
+		out[name] = strings.Join(values, ", ")
+	}
+
+	return out
+}
+
+// redactBody returns a body for logging, with the values of JSON fields whose names
+// suggest a secret replaced, cut to maxLoggedBody.
+func redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var out string
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		b, err := json.Marshal(redactValue(v))
+		if err == nil {
+			out = string(b)
+		}
+	}
+	if out == "" {
+		// Not JSON, or cut short; redact what looks like secret fields anyway.
+		out = secretPair.ReplaceAllString(string(body), `"$1":"`+redacted+`"`)
+	}
+
+	if len(out) > maxLoggedBody {
+		out = out[:maxLoggedBody] + "..."
+	}
+
+	return out
+}
+
+// secretPair matches a JSON string field whose name suggests a secret.
+var secretPair = regexp.MustCompile(`(?i)"(\w*(?:password|secret|token|session|credential|privatekey)\w*)"\s*:\s*"(?:[^"\\]|\\.)*"?`)
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if secretField(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+
+	return v
+}
+
+// secretField reports whether a JSON field name suggests a secret.
+func secretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"password", "secret", "token", "session", "credential", "privatekey"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,51 @@
+#!/bin/bash
+
+# Validate correct number of args
+if [ "$#" -ne 7 ]; then
+	echo "Usage: $0 <organization_name> <crm_identifier> <cpu_mhz> <memory_mb> <storage_gb> <admin_username> <admin_email>"
+	exit 1
+fi
+
+# Split input based on "=" and get the value (index 1)
+organization_name="$(cut -d'=' -f2 <<<"$1")"
+crm_identifier="$(cut -d'=' -f2 <<<"$2")"
+cpu_mhz="$(cut -d'=' -f2 <<<"$3")"
+memory_mb="$(cut -d'=' -f2 <<<"$4")"
+storage_gb="$(cut -d'=' -f2 <<<"$5")"
+admin_username="$(cut -d'=' -f2 <<<"$6")"
+admin_email="$(cut -d'=' -f2 <<<"$7")"
+
+# Validate capacity as whole numbers
+for value in "$cpu_mhz" "$memory_mb" "$storage_gb"; do
+	if ! [[ "$value" =~ ^[0-9]+$ ]]; then
+		echo "error: cpu_mhz, memory_mb and storage_gb must be whole numbers."
+		exit 1
+	fi
+done
+
+url="${HOSTBILL_SVC_URL:-http://localhost:4000}/api/v1/vcd"
+
+json_data=$(
+	cat <<EOF2
+{
+    "OrganizationName": "$organization_name",
+    "CrmIdentifier": "$crm_identifier",
+    "CpuMHz": $cpu_mhz,
+    "MemoryMB": $memory_mb,
+    "StorageGb": $storage_gb,
+    "AdminUsername": "$admin_username",
+    "AdminEmail": "$admin_email"
+}
+EOF2
+)
+
+echo "Sending the following JSON data: $json_data"
+
+# Provisioning can take a few minutes, and needs an API key with vcd:create.
+response=$(curl -k -X POST "$url" \
+	-H "Content-Type: application/json" \
+	-H "X-API-Key: $API_KEY" \
+	--max-time 660 \
+	-d "$json_data")
+
+echo "Response from server: $response"
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// adminScope is the scope given to credentials that may read every tenant's logs.
+const adminScope = "*"
+
+type contextKey string
+
+const scopeContextKey = contextKey("scope")
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errForbiddenTenant    = errors.New("credentials are not allowed to read this tenant")
+	errForbiddenWrite     = errors.New("credentials are not allowed to write to this tenant")
+)
+
+// scope describes what a caller is allowed to read. Admins see every tenant, everyone
+// else only sees entries for their own tenant.
+type scope struct {
+	TenantID string
+	Admin    bool
+}
+
+// tenantFor returns the tenant a query should be restricted to, given the tenant the
+// caller asked for. An empty result means every tenant.
+func (s scope) tenantFor(requested string) (string, error) {
+	if s.Admin {
+		return requested, nil
+	}
+
+	if requested != "" && requested != s.TenantID {
+		return "", errForbiddenTenant
+	}
+
+	return s.TenantID, nil
+}
+
+// writeTenant returns the tenant an entry is written to, given the tenant the entry
+// asked for. Entries written with a tenant key always land in that tenant; only admin
+// keys may pick another one. The HTTP, RPC and gRPC paths all write through it.
+func (s scope) writeTenant(requested string) (string, error) {
+	tenant, err := s.tenantFor(requested)
+	if err != nil {
+		return "", errForbiddenWrite
+	}
+
+	return tenant, nil
+}
+
+// credential is one API key, stored as a hash so the plain text only lives in the
+// environment.
+type credential struct {
+	hash  [sha256.Size]byte
+	scope scope
+}
+
+type credentials []credential
+
+// loadCredentials parses a comma separated list of key:scope pairs, where scope is
+// either a tenant ID or "*" for admin access, e.g. "k1:tenant-a,k2:*".
+func loadCredentials(raw string) (credentials, error) {
+	var creds credentials
+
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, tenant, ok := strings.Cut(pair, ":")
+		if !ok || key == "" || tenant == "" {
+			return nil, fmt.Errorf("invalid LOG_API_KEYS entry, expected key:scope")
+		}
+
+		creds = append(creds, credential{
+			hash: sha256.Sum256([]byte(key)),
+			scope: scope{
+				TenantID: tenant,
+				Admin:    tenant == adminScope,
+			},
+		})
+	}
+
+	return creds, nil
+}
+
+// lookup finds the scope for a presented key. Every credential is compared so the
+// time taken doesn't leak which key matched.
+func (c credentials) lookup(key string) (scope, bool) {
+	hash := sha256.Sum256([]byte(key))
+
+	var found scope
+	var ok bool
+
+	for _, cred := range c {
+		if subtle.ConstantTimeCompare(hash[:], cred.hash[:]) == 1 {
+			found = cred.scope
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+// authenticate requires a known API key in the Authorization header and stores the
+// caller's scope in the request context.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.errorJSON(w, errors.New("missing or malformed authorization header"), http.StatusUnauthorized)
+			return
+		}
+
+		s, ok := app.Credentials.lookup(key)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.errorJSON(w, errInvalidCredentials, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), scopeContextKey, s)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// scopeFromContext returns the scope stored by authenticate.
+func scopeFromContext(r *http.Request) scope {
+	s, ok := r.Context().Value(scopeContextKey).(scope)
+	if !ok {
+		panic("missing scope value in request context")
+	}
+
+	return s
+}
@@ -2,25 +2,49 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
 
 	"github.com/cloudkey-io/service-hub/logger-svc/data"
 	"github.com/cloudkey-io/service-hub/logger-svc/logs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type LogServer struct {
 	logs.UnimplementedLogServiceServer
-	Models data.Models
+	Models      data.Models
+	Credentials credentials
 }
 
+// WriteLog logs an entry in the tenant of the caller's key, sent as a bearer key in
+// the authorization metadata.
 func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
 	input := req.GetLogEntry()
 
+	s, ok := l.Credentials.lookup(bearerKey(ctx))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, errInvalidCredentials.Error())
+	}
+
+	tenant, err := s.writeTenant(input.GetTenantId())
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
 	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
+		TenantID: tenant,
+		Name:     input.GetName(),
+		Level:    input.GetLevel(),
+		Service:  input.GetService(),
+		Data:     input.GetData(),
 	}
 
-	err := l.Models.LogEntry.Insert(logEntry)
+	err = l.Models.LogEntry.Insert(ctx, logEntry)
 	if err != nil {
 		res := &logs.LogResponse{Result: "failed"}
 		return res, err
@@ -29,3 +53,34 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	res := &logs.LogResponse{Result: "logged!"}
 	return res, nil
 }
+
+// bearerKey returns the key in the incoming authorization metadata, if any.
+func bearerKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if key, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return key
+		}
+	}
+
+	return ""
+}
+
+// gRPCListen serves the LogService over gRPC, backed by the same models as the HTTP
+// and RPC paths.
+func (app *application) gRPCListen() {
+	listen, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+
+	s := grpc.NewServer()
+
+	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models, Credentials: app.Credentials})
+
+	log.Printf("gRPC Server started on port %s", gRpcPort)
+
+	if err := s.Serve(listen); err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+}
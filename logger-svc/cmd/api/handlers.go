@@ -1,28 +1,55 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/cloudkey-io/service-hub/logger-svc/data"
 )
 
+// tailInterval is how often TailLogs polls the store for new entries.
+const tailInterval = time.Second
+
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	TenantID string `json:"tenant_id"`
+	Level    string `json:"level"`
+	Service  string `json:"service"`
 }
 
+// WriteLog stores an entry in the caller's tenant. Only admin credentials may write to
+// another tenant, or to none.
 func (app *application) WriteLog(w http.ResponseWriter, r *http.Request) {
 	// read json into var
 	var requestPayload JSONPayload
-	_ = app.readJSON(w, r, &requestPayload)
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tenant, err := scopeFromContext(r).writeTenant(requestPayload.TenantID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
 
 	// insert data
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		TenantID: tenant,
+		Name:     requestPayload.Name,
+		Level:    requestPayload.Level,
+		Service:  requestPayload.Service,
+		Data:     requestPayload.Data,
 	}
 
-	err := app.Models.LogEntry.Insert(event)
+	err = app.Models.LogEntry.Insert(r.Context(), event)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -35,3 +62,277 @@ func (app *application) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
+
+// QueryLogs returns the entries matching the query string, limited to the tenants the
+// caller is allowed to see.
+func (app *application) QueryLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := app.readFilter(r)
+	if err != nil {
+		app.filterError(w, err)
+		return
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = data.DefaultLimit
+	}
+
+	entries, err := app.Models.LogEntry.Find(r.Context(), filter)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d entries", len(entries)),
+		Data:    entries,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// TailLogs streams new entries to the client as server-sent events until the client
+// goes away.
+func (app *application) TailLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := app.readFilter(r)
+	if err != nil {
+		app.filterError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	// Stored timestamps only have millisecond precision, so the cursor does too, and
+	// entries sharing the cursor's timestamp are remembered to avoid sending them twice.
+	cursor := filter.From
+	if cursor.IsZero() {
+		cursor = time.Now().Truncate(time.Millisecond)
+	}
+	sent := make(map[string]bool)
+
+	filter.Ascending = true
+	filter.Limit = data.MaxLimit
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+
+	for {
+		filter.From = cursor
+
+		entries, err := app.Models.LogEntry.Find(r.Context(), filter)
+		if err != nil {
+			if r.Context().Err() == nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+				flusher.Flush()
+			}
+			return
+		}
+
+		for _, entry := range entries {
+			if sent[entry.ID] {
+				continue
+			}
+
+			out, err := json.Marshal(entry)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %s\ndata: %s\n\n", entry.ID, out)
+
+			if entry.CreatedAt.After(cursor) {
+				cursor = entry.CreatedAt
+				clear(sent)
+			}
+			sent[entry.ID] = true
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExportLogs writes every entry matching the query string as a download, either as
+// newline delimited JSON (the default) or as CSV with format=csv.
+func (app *application) ExportLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := app.readFilter(r)
+	if err != nil {
+		app.filterError(w, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "ndjson" && format != "csv" {
+		app.errorJSON(w, errors.New("format must be ndjson or csv"))
+		return
+	}
+
+	entries, err := app.Models.LogEntry.Find(r.Context(), filter)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("logs-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+
+		out := csv.NewWriter(w)
+		out.Write([]string{"id", "tenant_id", "name", "level", "service", "data", "created_at", "updated_at"})
+		for _, entry := range entries {
+			out.Write([]string{
+				entry.ID,
+				entry.TenantID,
+				entry.Name,
+				entry.Level,
+				entry.Service,
+				entry.Data,
+				entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+				entry.UpdatedAt.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		out.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return
+		}
+	}
+}
+
+// LogStats returns entry counts grouped by name, level, service and time bucket, plus
+// the most frequent messages, over a time range. The range defaults to the last day.
+func (app *application) LogStats(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	tenant, err := scopeFromContext(r).tenantFor(qs.Get("tenant_id"))
+	if err != nil {
+		app.filterError(w, err)
+		return
+	}
+
+	query := data.StatsQuery{
+		TenantID: tenant,
+		To:       time.Now(),
+		Bucket:   qs.Get("bucket"),
+	}
+	query.From = query.To.Add(-24 * time.Hour)
+
+	if v := qs.Get("from"); v != "" {
+		query.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			app.errorJSON(w, errors.New("from must be an RFC 3339 timestamp"))
+			return
+		}
+	}
+
+	if v := qs.Get("to"); v != "" {
+		query.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			app.errorJSON(w, errors.New("to must be an RFC 3339 timestamp"))
+			return
+		}
+	}
+
+	if v := qs.Get("top"); v != "" {
+		query.Top, err = strconv.Atoi(v)
+		if err != nil || query.Top < 1 {
+			app.errorJSON(w, errors.New("top must be a positive number"))
+			return
+		}
+	}
+
+	err = query.Validate()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	stats, err := app.Models.LogEntry.Stats(r.Context(), query)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d entries", stats.Total),
+		Data:    stats,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// readFilter builds a data.Filter from the query string, restricted to what the
+// caller's scope allows. Times use RFC 3339.
+func (app *application) readFilter(r *http.Request) (data.Filter, error) {
+	qs := r.URL.Query()
+
+	tenant, err := scopeFromContext(r).tenantFor(qs.Get("tenant_id"))
+	if err != nil {
+		return data.Filter{}, err
+	}
+
+	filter := data.Filter{
+		TenantID: tenant,
+		Name:     qs.Get("name"),
+		Level:    qs.Get("level"),
+		Service:  qs.Get("service"),
+	}
+
+	if v := qs.Get("from"); v != "" {
+		filter.From, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return data.Filter{}, errors.New("from must be an RFC 3339 timestamp")
+		}
+	}
+
+	if v := qs.Get("to"); v != "" {
+		filter.To, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return data.Filter{}, errors.New("to must be an RFC 3339 timestamp")
+		}
+	}
+
+	if v := qs.Get("limit"); v != "" {
+		filter.Limit, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || filter.Limit < 1 || filter.Limit > data.MaxLimit {
+			return data.Filter{}, fmt.Errorf("limit must be between 1 and %d", data.MaxLimit)
+		}
+	}
+
+	return filter, nil
+}
+
+// filterError sends the right status for an error returned by readFilter.
+func (app *application) filterError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errForbiddenTenant) {
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	app.errorJSON(w, err)
+}
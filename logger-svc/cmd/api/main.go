@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
 	"time"
 
 	"github.com/cloudkey-io/service-hub/logger-svc/data"
@@ -22,39 +23,48 @@ const (
 	gRpcPort = "50001"
 )
 
-var client *mongo.Client
-
 type application struct {
-	Models data.Models
+	Models      data.Models
+	Credentials credentials
 }
 
 func main() {
-	// connect to mongo
-	mongoClient, err := connectToMongo()
+	// open the configured log store
+	store, err := openStore()
 	if err != nil {
 		log.Panic(err)
 	}
-	client = mongoClient
 
 	// create a context in order to disconnect
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	// close connection
+	// close the store
 	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
+		if err = store.Close(ctx); err != nil {
 			panic(err)
 		}
 	}()
 
+	creds, err := loadCredentials(os.Getenv("LOG_API_KEYS"))
+	if err != nil {
+		log.Panic(err)
+	}
+
 	app := application{
-		Models: data.New(client),
+		Models:      data.New(store),
+		Credentials: creds,
 	}
 
 	// Register RPC server
-	err = rpc.Register(new(RPCServer))
+	err = rpc.Register(&RPCServer{Models: app.Models, Credentials: app.Credentials})
+	if err != nil {
+		log.Panic(err)
+	}
 	go app.rpcListen()
 
+	go app.gRPCListen()
+
 	// start web server
 	log.Println("Starting service on port", webPort)
 	srv := &http.Server{
@@ -85,6 +95,45 @@ func (app *application) rpcListen() error {
 	}
 }
 
+// openStore picks the storage backend from the LOG_STORE environment variable.
+// "mongo" (the default) connects to Mongo, "file" uses the embedded file store in
+// LOG_STORE_DIR, which is handy for local development without Mongo.
+func openStore() (data.LogStore, error) {
+	switch backend := os.Getenv("LOG_STORE"); backend {
+	case "", "mongo":
+		mongoClient, err := connectToMongo()
+		if err != nil {
+			return nil, err
+		}
+
+		store := data.NewMongoStore(mongoClient)
+
+		err = store.CreateIndexes(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		return store, nil
+
+	case "file":
+		dir := os.Getenv("LOG_STORE_DIR")
+		if dir == "" {
+			dir = "./log-data"
+		}
+
+		store, err := data.OpenFileStore(dir, data.DefaultSegmentSize)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("Using file log store in", dir)
+		return store, nil
+
+	default:
+		return nil, fmt.Errorf("unknown LOG_STORE %q", backend)
+	}
+}
+
 func connectToMongo() (*mongo.Client, error) {
 	// create connection options
 	clientOptions := options.Client().ApplyURI(mongoURL)
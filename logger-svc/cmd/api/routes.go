@@ -23,7 +23,18 @@ func (app *application) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
-	mux.Post("/log", app.WriteLog)
+	// Writing and reading logs require credentials, which also decide the tenants a
+	// caller may write to and see.
+	mux.With(app.authenticate).Post("/log", app.WriteLog)
+
+	mux.Route("/logs", func(mux chi.Router) {
+		mux.Use(app.authenticate)
+
+		mux.Get("/", app.QueryLogs)
+		mux.Get("/tail", app.TailLogs)
+		mux.Get("/export", app.ExportLogs)
+		mux.Get("/stats", app.LogStats)
+	})
 
 	return mux
 }
@@ -3,27 +3,46 @@ package main
 import (
 	"context"
 	"log"
-	"time"
 
 	"github.com/cloudkey-io/service-hub/logger-svc/data"
 )
 
 // Methods that take this as a receiver are available over RPC, as long as they
 // are exported.
-type RPCServer struct{}
+type RPCServer struct {
+	Models      data.Models
+	Credentials credentials
+}
 
+// RPCPayload is an entry to log. net/rpc has no headers, so the caller's key from
+// LOG_API_KEYS travels in APIKey.
 type RPCPayload struct {
-	Name string
-	Data string
+	APIKey   string
+	Name     string
+	Data     string
+	TenantID string
+	Level    string
+	Service  string
 }
 
-// LogInfo logs an entry to the database.
+// LogInfo logs an entry to the configured log store, in the tenant of the caller's key.
 func (r *RPCServer) LogInfo(payload RPCPayload, res *string) error {
-	collection := client.Database("logs").Collection("logs")
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
-		Name:      payload.Name,
-		Data:      payload.Data,
-		CreatedAt: time.Now(),
+	s, ok := r.Credentials.lookup(payload.APIKey)
+	if !ok {
+		return errInvalidCredentials
+	}
+
+	tenant, err := s.writeTenant(payload.TenantID)
+	if err != nil {
+		return err
+	}
+
+	err = r.Models.LogEntry.Insert(context.Background(), data.LogEntry{
+		TenantID: tenant,
+		Name:     payload.Name,
+		Level:    payload.Level,
+		Service:  payload.Service,
+		Data:     payload.Data,
 	})
 	if err != nil {
 		log.Println("Error inserting log entry: ", err)
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudkey-io/service-hub/logger-svc/data"
+	"github.com/cloudkey-io/service-hub/logger-svc/logs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func testModels(t *testing.T) data.Models {
+	t.Helper()
+
+	store, err := data.OpenFileStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+
+	return data.New(store)
+}
+
+// The RPC and gRPC paths scope entries to the caller's key the way POST /log does.
+func TestWriteTenant(t *testing.T) {
+	creds, err := loadCredentials("tenant-key:tenant-a,admin-key:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		requested string
+		want      string
+		wantErr   error
+		wantCode  codes.Code
+	}{
+		{"tenant key", "tenant-key", "", "tenant-a", nil, codes.OK},
+		{"tenant key for its own tenant", "tenant-key", "tenant-a", "tenant-a", nil, codes.OK},
+		{"tenant key for another tenant", "tenant-key", "tenant-b", "", errForbiddenWrite, codes.PermissionDenied},
+		{"admin key picks the tenant", "admin-key", "tenant-b", "tenant-b", nil, codes.OK},
+		{"unknown key", "other-key", "tenant-a", "", errInvalidCredentials, codes.Unauthenticated},
+		{"no key", "", "tenant-a", "", errInvalidCredentials, codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models := testModels(t)
+			rpcServer := &RPCServer{Models: models, Credentials: creds}
+			logServer := &LogServer{Models: models, Credentials: creds}
+
+			var res string
+			err := rpcServer.LogInfo(RPCPayload{APIKey: tt.key, Name: "rpc", TenantID: tt.requested}, &res)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("RPC: expected %v, got %v", tt.wantErr, err)
+			}
+
+			ctx := context.Background()
+			if tt.key != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.key))
+			}
+			_, err = logServer.WriteLog(ctx, &logs.LogRequest{LogEntry: &logs.Log{Name: "grpc", TenantId: tt.requested}})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("gRPC: expected %v, got %v", tt.wantCode, err)
+			}
+
+			entries, err := models.LogEntry.All(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != nil {
+				if len(entries) != 0 {
+					t.Errorf("expected nothing to be logged, got %d entries", len(entries))
+				}
+				return
+			}
+			if len(entries) != 2 {
+				t.Fatalf("expected 2 entries, got %d", len(entries))
+			}
+			for _, entry := range entries {
+				if entry.TenantID != tt.want {
+					t.Errorf("%s: expected tenant %q, got %q", entry.Name, tt.want, entry.TenantID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,546 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultSegmentSize is the size a segment file may reach before a new one is started.
+	DefaultSegmentSize = 16 << 20 // 16 MB
+
+	segmentExt = ".seg"
+	indexName  = "index.jsonl"
+)
+
+// FileStore is an embedded LogStore for local development and for tests that don't
+// have Mongo. Entries are appended as JSON lines to numbered segment files, and an
+// append-only index file records where the latest version of each entry lives.
+// Updates append a new version of the entry rather than rewriting it in place.
+type FileStore struct {
+	mu          sync.RWMutex
+	dir         string
+	segmentSize int64
+
+	index      map[string]indexEntry
+	byTenant   map[string]map[string]struct{}
+	segments   map[int]*os.File
+	active     int
+	activeSize int64
+	indexFile  *os.File
+}
+
+// indexEntry points at one JSON line inside a segment file.
+type indexEntry struct {
+	ID      string `json:"id"`
+	Tenant  string `json:"tenant,omitempty"`
+	Segment int    `json:"segment"`
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+}
+
+// OpenFileStore opens, or creates, a file store in dir. Segments are rolled over once
+// they reach segmentSize bytes; pass 0 to use DefaultSegmentSize. Any entries that made
+// it into a segment but not into the index (for example after a crash) are recovered.
+func OpenFileStore(dir string, segmentSize int64) (*FileStore, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		dir:         dir,
+		segmentSize: segmentSize,
+	}
+
+	err = f.open()
+	if err != nil {
+		f.closeFiles()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *FileStore) Insert(ctx context.Context, entry LogEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+
+	return f.write(LogEntry{
+		ID:        primitive.NewObjectID().Hex(),
+		TenantID:  entry.TenantID,
+		Name:      entry.Name,
+		Level:     levelOrDefault(entry.Level),
+		Service:   entry.Service,
+		Data:      entry.Data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+func (f *FileStore) All(ctx context.Context) ([]*LogEntry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	logs := make([]*LogEntry, 0, len(f.index))
+
+	for _, ie := range f.index {
+		entry, err := f.read(ie)
+		if err != nil {
+			return nil, err
+		}
+
+		logs = append(logs, entry)
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].CreatedAt.After(logs[j].CreatedAt)
+	})
+
+	return logs, nil
+}
+
+func (f *FileStore) GetOne(ctx context.Context, id string) (*LogEntry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	ie, ok := f.index[id]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+
+	return f.read(ie)
+}
+
+// Find returns the entries matching filter, newest first unless filter.Ascending is set.
+// Tenant scoped lookups only read that tenant's entries.
+func (f *FileStore) Find(ctx context.Context, filter Filter) ([]*LogEntry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var logs []*LogEntry
+
+	visit := func(ie indexEntry) error {
+		entry, err := f.read(ie)
+		if err != nil {
+			return err
+		}
+		if filter.Matches(entry) {
+			logs = append(logs, entry)
+		}
+		return nil
+	}
+
+	if filter.TenantID != "" {
+		for id := range f.byTenant[filter.TenantID] {
+			if err := visit(f.index[id]); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for _, ie := range f.index {
+			if err := visit(ie); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		if filter.Ascending {
+			return logs[i].CreatedAt.Before(logs[j].CreatedAt)
+		}
+		return logs[i].CreatedAt.After(logs[j].CreatedAt)
+	})
+
+	if filter.Limit > 0 && int64(len(logs)) > filter.Limit {
+		logs = logs[:filter.Limit]
+	}
+
+	if logs == nil {
+		logs = []*LogEntry{}
+	}
+
+	return logs, nil
+}
+
+// Stats computes statistics in memory over the entries in the query's range.
+func (f *FileStore) Stats(ctx context.Context, q StatsQuery) (*Stats, error) {
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := f.Find(ctx, Filter{TenantID: q.TenantID, From: q.From, To: q.To})
+	if err != nil {
+		return nil, err
+	}
+
+	return computeStats(entries, q), nil
+}
+
+func (f *FileStore) Update(ctx context.Context, entry LogEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ie, ok := f.index[entry.ID]
+	if !ok {
+		return ErrNoRecord
+	}
+
+	current, err := f.read(ie)
+	if err != nil {
+		return err
+	}
+
+	current.Name = entry.Name
+	current.Data = entry.Data
+	current.UpdatedAt = time.Now()
+
+	return f.write(*current)
+}
+
+// DropCollection removes every segment and the index, leaving an empty store behind.
+func (f *FileStore) DropCollection(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closeFiles()
+
+	paths, err := filepath.Glob(filepath.Join(f.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	paths = append(paths, filepath.Join(f.dir, indexName))
+
+	for _, p := range paths {
+		err := os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return f.open()
+}
+
+// Close flushes and closes the segment and index files.
+func (f *FileStore) Close(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if active, ok := f.segments[f.active]; ok {
+		if err := active.Sync(); err != nil {
+			return err
+		}
+	}
+	if f.indexFile != nil {
+		if err := f.indexFile.Sync(); err != nil {
+			return err
+		}
+	}
+
+	f.closeFiles()
+
+	return nil
+}
+
+// open loads the index, recovers anything missing from it, and opens the newest
+// segment for appending.
+func (f *FileStore) open() error {
+	f.index = make(map[string]indexEntry)
+	f.byTenant = make(map[string]map[string]struct{})
+	f.segments = make(map[int]*os.File)
+
+	seqs, err := f.segmentSeqs()
+	if err != nil {
+		return err
+	}
+
+	f.indexFile, err = os.OpenFile(filepath.Join(f.dir, indexName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	last, err := f.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range seqs {
+		file, err := os.OpenFile(f.segmentPath(seq), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		f.segments[seq] = file
+	}
+
+	if len(seqs) == 0 {
+		seqs = append(seqs, 1)
+		err = f.openSegment(1)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replay anything written after the last indexed entry.
+	for _, seq := range seqs {
+		if seq < last.Segment {
+			continue
+		}
+
+		var from int64
+		if seq == last.Segment {
+			from = last.Offset + last.Length + 1
+		}
+
+		err := f.recoverSegment(seq, from)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.active = seqs[len(seqs)-1]
+
+	info, err := f.segments[f.active].Stat()
+	if err != nil {
+		return err
+	}
+	f.activeSize = info.Size()
+
+	return nil
+}
+
+// loadIndex reads the index file into memory and returns the entry that was written
+// last. A torn final line is cut off so the next append starts cleanly.
+func (f *FileStore) loadIndex() (indexEntry, error) {
+	var last indexEntry
+
+	_, err := f.indexFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return last, err
+	}
+
+	reader := bufio.NewReader(f.indexFile)
+	var good int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return last, err
+		}
+
+		var ie indexEntry
+		if json.Unmarshal(bytes.TrimSpace(line), &ie) != nil {
+			break
+		}
+
+		f.track(ie)
+		last = ie
+		good += int64(len(line))
+	}
+
+	return last, f.indexFile.Truncate(good)
+}
+
+// recoverSegment indexes every complete line in a segment from offset onwards.
+func (f *FileStore) recoverSegment(seq int, offset int64) error {
+	file := f.segments[seq]
+
+	_, err := file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Drop a partially written trailing entry.
+			return file.Truncate(offset)
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry LogEntry
+		err = json.Unmarshal(bytes.TrimSpace(line), &entry)
+		if err != nil {
+			return fmt.Errorf("data: corrupt entry in segment %d at offset %d: %w", seq, offset, err)
+		}
+
+		ie := indexEntry{
+			ID:      entry.ID,
+			Tenant:  entry.TenantID,
+			Segment: seq,
+			Offset:  offset,
+			Length:  int64(len(line)) - 1,
+		}
+
+		err = f.appendIndex(ie)
+		if err != nil {
+			return err
+		}
+
+		offset += int64(len(line))
+	}
+}
+
+// write appends entry to the active segment, rolling over to a new segment when the
+// current one is full, and records its location in the index.
+func (f *FileStore) write(entry LogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if f.activeSize > 0 && f.activeSize+int64(len(line)) > f.segmentSize {
+		err := f.segments[f.active].Sync()
+		if err != nil {
+			return err
+		}
+
+		err = f.openSegment(f.active + 1)
+		if err != nil {
+			return err
+		}
+
+		f.active++
+		f.activeSize = 0
+	}
+
+	_, err = f.segments[f.active].Write(line)
+	if err != nil {
+		return err
+	}
+
+	ie := indexEntry{
+		ID:      entry.ID,
+		Tenant:  entry.TenantID,
+		Segment: f.active,
+		Offset:  f.activeSize,
+		Length:  int64(len(line)) - 1,
+	}
+	f.activeSize += int64(len(line))
+
+	return f.appendIndex(ie)
+}
+
+func (f *FileStore) appendIndex(ie indexEntry) error {
+	line, err := json.Marshal(ie)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.indexFile.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	f.track(ie)
+
+	return nil
+}
+
+// track records ie in the in-memory indexes.
+func (f *FileStore) track(ie indexEntry) {
+	f.index[ie.ID] = ie
+
+	ids, ok := f.byTenant[ie.Tenant]
+	if !ok {
+		ids = make(map[string]struct{})
+		f.byTenant[ie.Tenant] = ids
+	}
+	ids[ie.ID] = struct{}{}
+}
+
+func (f *FileStore) read(ie indexEntry) (*LogEntry, error) {
+	file, ok := f.segments[ie.Segment]
+	if !ok {
+		return nil, fmt.Errorf("data: missing segment %d", ie.Segment)
+	}
+
+	buf := make([]byte, ie.Length)
+	_, err := file.ReadAt(buf, ie.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry LogEntry
+	err = json.Unmarshal(buf, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (f *FileStore) openSegment(seq int) error {
+	file, err := os.OpenFile(f.segmentPath(seq), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	f.segments[seq] = file
+
+	return nil
+}
+
+// segmentSeqs returns the sequence numbers of the segments on disk, oldest first.
+func (f *FileStore) segmentSeqs() ([]int, error) {
+	paths, err := filepath.Glob(filepath.Join(f.dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []int
+	for _, p := range paths {
+		seq, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(p), segmentExt))
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+
+	sort.Ints(seqs)
+
+	return seqs, nil
+}
+
+func (f *FileStore) segmentPath(seq int) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%08d%s", seq, segmentExt))
+}
+
+func (f *FileStore) closeFiles() {
+	for seq, file := range f.segments {
+		file.Close()
+		delete(f.segments, seq)
+	}
+
+	if f.indexFile != nil {
+		f.indexFile.Close()
+		f.indexFile = nil
+	}
+}
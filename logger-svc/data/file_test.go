@@ -0,0 +1,290 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, dir string, segmentSize int64) *FileStore {
+	t.Helper()
+
+	store, err := OpenFileStore(dir, segmentSize)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+
+	return store
+}
+
+func TestFileStoreInsertAndGet(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t, t.TempDir(), 0)
+	defer store.Close(ctx)
+
+	for _, name := range []string{"first", "second", "third"} {
+		err := store.Insert(ctx, LogEntry{Name: name, Data: name + " data"})
+		if err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+
+	logs, err := store.All(ctx)
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(logs))
+	}
+	if logs[0].Name != "third" {
+		t.Errorf("expected newest entry first, got %q", logs[0].Name)
+	}
+
+	entry, err := store.GetOne(ctx, logs[1].ID)
+	if err != nil {
+		t.Fatalf("GetOne: %v", err)
+	}
+	if entry.Data != "second data" {
+		t.Errorf("expected %q, got %q", "second data", entry.Data)
+	}
+
+	_, err = store.GetOne(ctx, "missing")
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("expected ErrNoRecord, got %v", err)
+	}
+}
+
+func TestFileStoreUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t, t.TempDir(), 0)
+	defer store.Close(ctx)
+
+	err := store.Insert(ctx, LogEntry{Name: "auth", Data: "logged in"})
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	logs, _ := store.All(ctx)
+	original := logs[0]
+
+	err = store.Update(ctx, LogEntry{ID: original.ID, Name: "auth", Data: "logged out"})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	entry, err := store.GetOne(ctx, original.ID)
+	if err != nil {
+		t.Fatalf("GetOne: %v", err)
+	}
+	if entry.Data != "logged out" {
+		t.Errorf("expected updated data, got %q", entry.Data)
+	}
+	if !entry.CreatedAt.Equal(original.CreatedAt) {
+		t.Errorf("expected created_at to be preserved")
+	}
+
+	logs, _ = store.All(ctx)
+	if len(logs) != 1 {
+		t.Errorf("expected update not to add an entry, got %d entries", len(logs))
+	}
+
+	err = store.Update(ctx, LogEntry{ID: "missing"})
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("expected ErrNoRecord, got %v", err)
+	}
+}
+
+func TestFileStoreSegmentsAndReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// A tiny segment size forces a new segment for nearly every entry.
+	store := openTestStore(t, dir, 128)
+
+	for i := 0; i < 10; i++ {
+		err := store.Insert(ctx, LogEntry{Name: "event", Data: "some reasonably long payload"})
+		if err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(segments) < 2 {
+		t.Fatalf("expected entries to roll over into several segments, got %d", len(segments))
+	}
+
+	err := store.Close(ctx)
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	store = openTestStore(t, dir, 128)
+	defer store.Close(ctx)
+
+	logs, err := store.All(ctx)
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(logs) != 10 {
+		t.Errorf("expected 10 entries after reopening, got %d", len(logs))
+	}
+}
+
+func TestFileStoreRebuildsIndex(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := openTestStore(t, dir, 0)
+
+	for i := 0; i < 3; i++ {
+		store.Insert(ctx, LogEntry{Name: "event", Data: "payload"})
+	}
+	store.Close(ctx)
+
+	err := os.Remove(filepath.Join(dir, indexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash halfway through writing an entry.
+	seg, err := os.OpenFile(filepath.Join(dir, "00000001"+segmentExt), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg.WriteString(`{"_id":"torn","name":"ev`)
+	seg.Close()
+
+	store = openTestStore(t, dir, 0)
+
+	logs, err := store.All(ctx)
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("expected 3 recovered entries, got %d", len(logs))
+	}
+
+	err = store.Insert(ctx, LogEntry{Name: "event", Data: "after recovery"})
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	store.Close(ctx)
+
+	store = openTestStore(t, dir, 0)
+	defer store.Close(ctx)
+
+	logs, _ = store.All(ctx)
+	if len(logs) != 4 {
+		t.Errorf("expected 4 entries, got %d", len(logs))
+	}
+}
+
+func TestFileStoreDropCollection(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t, t.TempDir(), 0)
+	defer store.Close(ctx)
+
+	store.Insert(ctx, LogEntry{Name: "event", Data: "payload"})
+
+	err := store.DropCollection(ctx)
+	if err != nil {
+		t.Fatalf("DropCollection: %v", err)
+	}
+
+	logs, _ := store.All(ctx)
+	if len(logs) != 0 {
+		t.Errorf("expected empty store, got %d entries", len(logs))
+	}
+
+	err = store.Insert(ctx, LogEntry{Name: "event", Data: "payload"})
+	if err != nil {
+		t.Fatalf("Insert after drop: %v", err)
+	}
+}
+
+func TestFileStoreFindByTenant(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t, t.TempDir(), 0)
+	defer store.Close(ctx)
+
+	for _, tenant := range []string{"acme", "acme", "globex", ""} {
+		store.Insert(ctx, LogEntry{TenantID: tenant, Name: "event", Data: "payload"})
+	}
+
+	logs, err := store.Find(ctx, Filter{TenantID: "acme"})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 acme entries, got %d", len(logs))
+	}
+	for _, entry := range logs {
+		if entry.TenantID != "acme" {
+			t.Errorf("expected only acme entries, got tenant %q", entry.TenantID)
+		}
+	}
+
+	logs, _ = store.Find(ctx, Filter{Limit: 3, Ascending: true})
+	if len(logs) != 3 {
+		t.Fatalf("expected limit to be applied, got %d entries", len(logs))
+	}
+	if logs[0].TenantID != "acme" {
+		t.Errorf("expected oldest entry first, got tenant %q", logs[0].TenantID)
+	}
+}
+
+func TestFileStoreStats(t *testing.T) {
+	ctx := context.Background()
+	store := openTestStore(t, t.TempDir(), 0)
+	defer store.Close(ctx)
+
+	entries := []LogEntry{
+		{TenantID: "acme", Name: "auth", Level: "error", Service: "auth-svc", Data: "login failed"},
+		{TenantID: "acme", Name: "auth", Level: "error", Service: "auth-svc", Data: "login failed"},
+		{TenantID: "acme", Name: "auth", Service: "auth-svc", Data: "logged in"},
+		{TenantID: "globex", Name: "auth", Level: "error", Service: "auth-svc", Data: "login failed"},
+	}
+	for _, entry := range entries {
+		store.Insert(ctx, entry)
+	}
+
+	now := time.Now()
+	stats, err := store.Stats(ctx, StatsQuery{
+		TenantID: "acme",
+		From:     now.Add(-time.Hour),
+		To:       now.Add(time.Hour),
+		Bucket:   BucketDay,
+		Top:      1,
+	})
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+
+	if stats.Total != 3 {
+		t.Errorf("expected 3 acme entries, got %d", stats.Total)
+	}
+
+	var errorCount, infoCount int64
+	for _, group := range stats.Groups {
+		switch group.Level {
+		case "error":
+			errorCount += group.Count
+		case DefaultLevel:
+			infoCount += group.Count
+		}
+	}
+	if errorCount != 2 || infoCount != 1 {
+		t.Errorf("expected 2 errors and 1 info, got %d and %d", errorCount, infoCount)
+	}
+
+	if len(stats.TopMessages) != 1 || stats.TopMessages[0].Message != "login failed" {
+		t.Errorf("expected login failed as the top message, got %+v", stats.TopMessages)
+	}
+
+	_, err = store.Stats(ctx, StatsQuery{From: now.Add(-365 * 24 * time.Hour), To: now, Bucket: BucketMinute})
+	if err == nil {
+		t.Error("expected an error for a range with too many buckets")
+	}
+}
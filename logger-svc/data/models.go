@@ -2,145 +2,95 @@ package data
 
 import (
 	"context"
-	"log"
+	"errors"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var client *mongo.Client
+// DefaultLevel is used for entries written without a level.
+const DefaultLevel = "info"
 
-func New(mongo *mongo.Client) Models {
-	client = mongo
+const (
+	// DefaultLimit is the number of entries a query returns when no limit is given.
+	DefaultLimit = 100
+	// MaxLimit caps the number of entries a single query may return.
+	MaxLimit = 1000
+)
 
+// ErrNoRecord is returned by a LogStore when a lookup does not match any entry.
+var ErrNoRecord = errors.New("data: no matching log entry found")
+
+// LogStore is implemented by every storage backend the logger service can write to.
+// The HTTP, RPC and gRPC paths only ever talk to this interface, so swapping Mongo
+// for the embedded file store is a configuration change.
+type LogStore interface {
+	Insert(ctx context.Context, entry LogEntry) error
+	All(ctx context.Context) ([]*LogEntry, error)
+	GetOne(ctx context.Context, id string) (*LogEntry, error)
+	Find(ctx context.Context, filter Filter) ([]*LogEntry, error)
+	Stats(ctx context.Context, query StatsQuery) (*Stats, error)
+	Update(ctx context.Context, entry LogEntry) error
+	DropCollection(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// New is the function used to create an instance of the data package. It wraps the
+// chosen storage backend in the Models type used throughout the application.
+func New(store LogStore) Models {
 	return Models{
-		LogEntry: LogEntry{},
+		LogEntry: store,
 	}
 }
 
+// Models is the type for this package. LogEntry is backed by whichever LogStore
+// was handed to New.
 type Models struct {
-	LogEntry LogEntry
+	LogEntry LogStore
 }
 
+// LogEntry is the structure which holds one log entry.
 type LogEntry struct {
 	ID        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	TenantID  string    `bson:"tenant_id" json:"tenant_id,omitempty"`
 	Name      string    `bson:"name" json:"name"`
+	Level     string    `bson:"level" json:"level"`
+	Service   string    `bson:"service" json:"service,omitempty"`
 	Data      string    `bson:"data" json:"data"`
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 }
 
-func (l *LogEntry) Insert(entry LogEntry) error {
-	collection := client.Database("logs").Collection("logs")
-
-	_, err := collection.InsertOne(context.TODO(), LogEntry{
-		Name:      entry.Name,
-		Data:      entry.Data,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	})
-	if err != nil {
-		log.Println("Error inserting into logs:", err)
-		return err
-	}
-
-	return nil
-}
-
-func (l *LogEntry) All() ([]*LogEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
-
-	opts := options.Find()
-	opts.SetSort(bson.D{{"created_at", -1}})
-
-	cursor, err := collection.Find(context.TODO(), bson.D{}, opts)
-	if err != nil {
-		log.Println("Finding all docs error:", err)
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var logs []*LogEntry
-
-	for cursor.Next(ctx) {
-		var item LogEntry
-
-		err := cursor.Decode(&item)
-		if err != nil {
-			log.Print("Error decoding log into slice:", err)
-			return nil, err
-		} else {
-			logs = append(logs, &item)
-		}
-	}
-
-	return logs, nil
-}
-
-func (l *LogEntry) GetOne(id string) (*LogEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
-
-	docID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
-	}
-
-	var entry LogEntry
-	err = collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&entry)
-	if err != nil {
-		return nil, err
-	}
-
-	return &entry, nil
-}
-
-func (l *LogEntry) DropCollection() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
-
-	if err := collection.Drop(ctx); err != nil {
-		return err
-	}
-
-	return nil
+// Filter narrows the entries returned by LogStore.Find. Zero values are ignored, so an
+// empty Filter matches every entry.
+type Filter struct {
+	TenantID string
+	Name     string
+	Level    string
+	Service  string
+	// From is inclusive and To is exclusive.
+	From time.Time
+	To   time.Time
+	// Limit of 0 returns every matching entry.
+	Limit int64
+	// Ascending returns the oldest entries first, the default is newest first.
+	Ascending bool
 }
 
-func (l *LogEntry) Update() (*mongo.UpdateResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	collection := client.Database("logs").Collection("logs")
-
-	docID, err := primitive.ObjectIDFromHex(l.ID)
-	if err != nil {
-		return nil, err
-	}
-
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": docID},
-		bson.D{
-			{"$set", bson.D{
-				{"name", l.Name},
-				{"data", l.Data},
-				{"updated_at", time.Now()},
-			}},
-		},
-	)
-	if err != nil {
-		return nil, err
+// Matches reports whether entry satisfies every condition in the filter.
+func (f Filter) Matches(entry *LogEntry) bool {
+	switch {
+	case f.TenantID != "" && entry.TenantID != f.TenantID:
+		return false
+	case f.Name != "" && entry.Name != f.Name:
+		return false
+	case f.Level != "" && entry.Level != f.Level:
+		return false
+	case f.Service != "" && entry.Service != f.Service:
+		return false
+	case !f.From.IsZero() && entry.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !entry.CreatedAt.Before(f.To):
+		return false
 	}
 
-	return result, nil
+	return true
 }
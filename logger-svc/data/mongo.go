@@ -0,0 +1,327 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore is the LogStore backed by the "logs" collection in Mongo.
+type MongoStore struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// NewMongoStore returns a MongoStore that uses the given, already connected, client.
+func NewMongoStore(client *mongo.Client) *MongoStore {
+	return &MongoStore{
+		client:     client,
+		collection: client.Database("logs").Collection("logs"),
+	}
+}
+
+func (m *MongoStore) Insert(ctx context.Context, entry LogEntry) error {
+	_, err := m.collection.InsertOne(ctx, LogEntry{
+		TenantID:  entry.TenantID,
+		Name:      entry.Name,
+		Level:     levelOrDefault(entry.Level),
+		Service:   entry.Service,
+		Data:      entry.Data,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Println("Error inserting into logs:", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoStore) All(ctx context.Context) ([]*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := m.collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		log.Println("Finding all docs error:", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var logs []*LogEntry
+
+	for cursor.Next(ctx) {
+		var item LogEntry
+
+		err := cursor.Decode(&item)
+		if err != nil {
+			log.Print("Error decoding log into slice:", err)
+			return nil, err
+		}
+
+		logs = append(logs, &item)
+	}
+
+	return logs, nil
+}
+
+func (m *MongoStore) GetOne(ctx context.Context, id string) (*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNoRecord
+	}
+
+	var entry LogEntry
+	err = m.collection.FindOne(ctx, bson.M{"_id": docID}).Decode(&entry)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Find returns the entries matching filter, newest first unless filter.Ascending is set.
+func (m *MongoStore) Find(ctx context.Context, filter Filter) ([]*LogEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	query := bson.D{}
+	if filter.TenantID != "" {
+		query = append(query, bson.E{Key: "tenant_id", Value: filter.TenantID})
+	}
+	if filter.Name != "" {
+		query = append(query, bson.E{Key: "name", Value: filter.Name})
+	}
+	if filter.Level != "" {
+		query = append(query, bson.E{Key: "level", Value: filter.Level})
+	}
+	if filter.Service != "" {
+		query = append(query, bson.E{Key: "service", Value: filter.Service})
+	}
+
+	createdAt := bson.D{}
+	if !filter.From.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: filter.From})
+	}
+	if !filter.To.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: filter.To})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	order := -1
+	if filter.Ascending {
+		order = 1
+	}
+
+	opts := options.Find()
+	opts.SetSort(bson.D{{Key: "created_at", Value: order}, {Key: "_id", Value: order}})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+
+	cursor, err := m.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	logs := []*LogEntry{}
+
+	err = cursor.All(ctx, &logs)
+	if err != nil {
+		return nil, err
+	}
+
+	return logs, nil
+}
+
+// Stats runs a single aggregation that counts entries per bucket, name, level and
+// service, finds the most frequent messages, and totals the range.
+func (m *MongoStore) Stats(ctx context.Context, q StatsQuery) (*Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+	size, _ := bucketSize(q.Bucket)
+
+	match := bson.D{{Key: "created_at", Value: bson.D{
+		{Key: "$gte", Value: q.From},
+		{Key: "$lt", Value: q.To},
+	}}}
+	if q.TenantID != "" {
+		match = append(match, bson.E{Key: "tenant_id", Value: q.TenantID})
+	}
+
+	// Round created_at down to the start of its bucket: created_at - (created_at % size).
+	bucket := bson.D{{Key: "$subtract", Value: bson.A{
+		"$created_at",
+		bson.D{{Key: "$mod", Value: bson.A{
+			bson.D{{Key: "$toLong", Value: "$created_at"}},
+			size.Milliseconds(),
+		}}},
+	}}}
+
+	groups := bson.A{
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{
+				{Key: "bucket", Value: bucket},
+				{Key: "name", Value: "$name"},
+				{Key: "level", Value: "$level"},
+				{Key: "service", Value: "$service"},
+			}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "bucket", Value: "$_id.bucket"},
+			{Key: "name", Value: "$_id.name"},
+			{Key: "level", Value: "$_id.level"},
+			{Key: "service", Value: "$_id.service"},
+			{Key: "count", Value: 1},
+		}}},
+	}
+
+	top := bson.A{
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$data"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: q.Top}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "message", Value: "$_id"},
+			{Key: "count", Value: 1},
+		}}},
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$facet", Value: bson.D{
+			{Key: "groups", Value: groups},
+			{Key: "top", Value: top},
+			{Key: "total", Value: bson.A{bson.D{{Key: "$count", Value: "n"}}}},
+		}}},
+	}
+
+	cursor, err := m.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Groups []StatsGroup   `bson:"groups"`
+		Top    []MessageCount `bson:"top"`
+		Total  []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+	}
+
+	err = cursor.All(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		From:        q.From,
+		To:          q.To,
+		Bucket:      q.Bucket,
+		Groups:      []StatsGroup{},
+		TopMessages: []MessageCount{},
+	}
+
+	if len(results) > 0 {
+		if results[0].Groups != nil {
+			stats.Groups = results[0].Groups
+		}
+		if results[0].Top != nil {
+			stats.TopMessages = results[0].Top
+		}
+		if len(results[0].Total) > 0 {
+			stats.Total = results[0].Total[0].N
+		}
+	}
+
+	sortGroups(stats.Groups)
+
+	return stats, nil
+}
+
+func (m *MongoStore) Update(ctx context.Context, entry LogEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	docID, err := primitive.ObjectIDFromHex(entry.ID)
+	if err != nil {
+		return ErrNoRecord
+	}
+
+	result, err := m.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": docID},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "name", Value: entry.Name},
+				{Key: "data", Value: entry.Data},
+				{Key: "updated_at", Value: time.Now()},
+			}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m *MongoStore) DropCollection(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	return m.collection.Drop(ctx)
+}
+
+// CreateIndexes makes sure the indexes used by tenant scoped queries exist. It is safe
+// to call on every start up.
+func (m *MongoStore) CreateIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	_, err := m.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	})
+
+	return err
+}
+
+// Close disconnects the underlying Mongo client.
+func (m *MongoStore) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Buckets that stats can be grouped into.
+const (
+	BucketMinute = "minute"
+	BucketHour   = "hour"
+	BucketDay    = "day"
+)
+
+// maxBuckets caps how many time buckets a single stats query may span, so a minute
+// breakdown can't be asked for over a whole year.
+const maxBuckets = 10_000
+
+// DefaultTop is the number of most frequent messages returned when none is given.
+const DefaultTop = 10
+
+// StatsQuery describes a statistics request over the half open range [From, To).
+type StatsQuery struct {
+	TenantID string
+	From     time.Time
+	To       time.Time
+	Bucket   string
+	Top      int
+}
+
+// Validate checks the query and fills in defaults.
+func (q *StatsQuery) Validate() error {
+	if q.Bucket == "" {
+		q.Bucket = BucketHour
+	}
+
+	size, err := bucketSize(q.Bucket)
+	if err != nil {
+		return err
+	}
+
+	if q.From.IsZero() || q.To.IsZero() {
+		return errors.New("from and to are required")
+	}
+	if !q.From.Before(q.To) {
+		return errors.New("from must be before to")
+	}
+	if q.To.Sub(q.From)/size > maxBuckets {
+		return fmt.Errorf("range spans more than %d %s buckets", maxBuckets, q.Bucket)
+	}
+
+	if q.Top == 0 {
+		q.Top = DefaultTop
+	}
+	if q.Top < 0 || q.Top > MaxLimit {
+		return fmt.Errorf("top must be between 1 and %d", MaxLimit)
+	}
+
+	return nil
+}
+
+// StatsGroup is the number of entries with the same name, level and service within
+// one time bucket.
+type StatsGroup struct {
+	Bucket  time.Time `bson:"bucket" json:"bucket"`
+	Name    string    `bson:"name" json:"name"`
+	Level   string    `bson:"level" json:"level"`
+	Service string    `bson:"service" json:"service"`
+	Count   int64     `bson:"count" json:"count"`
+}
+
+// MessageCount is how often one message occurred over the whole range.
+type MessageCount struct {
+	Message string `bson:"message" json:"message"`
+	Count   int64  `bson:"count" json:"count"`
+}
+
+// Stats is the result of a StatsQuery. Groups are ordered by bucket, then by count
+// descending.
+type Stats struct {
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Bucket      string         `json:"bucket"`
+	Total       int64          `json:"total"`
+	Groups      []StatsGroup   `json:"groups"`
+	TopMessages []MessageCount `json:"top_messages"`
+}
+
+func bucketSize(bucket string) (time.Duration, error) {
+	switch bucket {
+	case BucketMinute:
+		return time.Minute, nil
+	case BucketHour:
+		return time.Hour, nil
+	case BucketDay:
+		return 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("bucket must be %s, %s or %s", BucketMinute, BucketHour, BucketDay)
+	}
+}
+
+// computeStats builds Stats in memory, for stores that can't aggregate natively. The
+// entries must already be limited to the query's tenant and time range.
+func computeStats(entries []*LogEntry, q StatsQuery) *Stats {
+	size, _ := bucketSize(q.Bucket)
+
+	type groupKey struct {
+		bucket               time.Time
+		name, level, service string
+	}
+
+	groups := make(map[groupKey]int64)
+	messages := make(map[string]int64)
+
+	for _, entry := range entries {
+		key := groupKey{
+			bucket:  entry.CreatedAt.UTC().Truncate(size),
+			name:    entry.Name,
+			level:   entry.Level,
+			service: entry.Service,
+		}
+		groups[key]++
+		messages[entry.Data]++
+	}
+
+	stats := &Stats{
+		From:        q.From,
+		To:          q.To,
+		Bucket:      q.Bucket,
+		Total:       int64(len(entries)),
+		Groups:      []StatsGroup{},
+		TopMessages: []MessageCount{},
+	}
+
+	for key, count := range groups {
+		stats.Groups = append(stats.Groups, StatsGroup{
+			Bucket:  key.bucket,
+			Name:    key.name,
+			Level:   key.level,
+			Service: key.service,
+			Count:   count,
+		})
+	}
+	sortGroups(stats.Groups)
+
+	for message, count := range messages {
+		stats.TopMessages = append(stats.TopMessages, MessageCount{Message: message, Count: count})
+	}
+	sort.Slice(stats.TopMessages, func(i, j int) bool {
+		a, b := stats.TopMessages[i], stats.TopMessages[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Message < b.Message
+	})
+	if len(stats.TopMessages) > q.Top {
+		stats.TopMessages = stats.TopMessages[:q.Top]
+	}
+
+	return stats
+}
+
+func sortGroups(groups []StatsGroup) {
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		switch {
+		case !a.Bucket.Equal(b.Bucket):
+			return a.Bucket.Before(b.Bucket)
+		case a.Count != b.Count:
+			return a.Count > b.Count
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		case a.Level != b.Level:
+			return a.Level < b.Level
+		default:
+			return a.Service < b.Service
+		}
+	})
+}
+
+func levelOrDefault(level string) string {
+	if level == "" {
+		return DefaultLevel
+	}
+
+	return level
+}
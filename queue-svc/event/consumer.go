@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -38,8 +39,9 @@ func (consumer *Consumer) setup() error {
 }
 
 type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 func (consumer *Consumer) Listen(topics []string) error {
@@ -69,7 +71,15 @@ func (consumer *Consumer) Listen(topics []string) error {
 
 	forever := make(chan bool)
 	go func() {
+		seen := newSeenIDs(seenWindow)
+
 		for d := range messages {
+			// auth-svc delivers its events at least once, with the same message ID
+			// each time, so an event published again isn't logged twice.
+			if !seen.add(d.MessageId) {
+				continue
+			}
+
 			var payload Payload
 			_ = json.Unmarshal(d.Body, &payload)
 
@@ -83,6 +93,39 @@ func (consumer *Consumer) Listen(topics []string) error {
 	return nil
 }
 
+// seenWindow is how many message IDs Listen remembers to drop duplicates.
+const seenWindow = 10000
+
+// seenIDs remembers the last message IDs added to it.
+type seenIDs struct {
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newSeenIDs(size int) *seenIDs {
+	return &seenIDs{ids: make(map[string]bool, size), order: make([]string, size)}
+}
+
+// add records id and reports whether it wasn't seen before. Messages without an ID
+// can't be told apart, so they are always new.
+func (s *seenIDs) add(id string) bool {
+	if id == "" {
+		return true
+	}
+	if s.ids[id] {
+		return false
+	}
+
+	// Forget the oldest ID to make room.
+	delete(s.ids, s.order[s.next])
+	s.order[s.next] = id
+	s.next = (s.next + 1) % len(s.order)
+	s.ids[id] = true
+
+	return true
+}
+
 func handlePayload(payload Payload) {
 	switch payload.Name {
 	case "log", "event":
@@ -113,6 +156,10 @@ func logEvent(entry Payload) error {
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	// logger-svc only takes entries from callers with a key.
+	if key := os.Getenv("LOG_API_KEY"); key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
 
 	client := &http.Client{}
 
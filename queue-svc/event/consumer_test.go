@@ -0,0 +1,29 @@
+package event
+
+import "testing"
+
+func TestSeenIDs(t *testing.T) {
+	seen := newSeenIDs(2)
+
+	steps := []struct {
+		id   string
+		want bool
+	}{
+		{"auth-svc-event-1", true},
+		{"auth-svc-event-1", false},
+		{"", true},
+		{"", true},
+		{"auth-svc-event-2", true},
+		{"auth-svc-event-1", false},
+		// Only the last two IDs are remembered.
+		{"auth-svc-event-3", true},
+		{"auth-svc-event-1", true},
+		{"auth-svc-event-3", false},
+	}
+
+	for i, step := range steps {
+		if got := seen.add(step.id); got != step.want {
+			t.Errorf("step %d: expected %s to be new %v, got %v", i+1, step.id, step.want, got)
+		}
+	}
+}
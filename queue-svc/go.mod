@@ -2,4 +2,4 @@ module github.com/Cloudkey-io/service-hub/queue-svc
 
 go 1.22.2
 
-require github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+require github.com/rabbitmq/amqp091-go v1.10.0
@@ -28,7 +28,7 @@ func main() {
 		panic(err)
 	}
 
-	err = consumer.Listen([]string{"log.INFO", "log.WARNING", "log.ERROR"})
+	err = consumer.Listen([]string{"log.INFO", "log.WARNING", "log.ERROR", "auth.#"})
 	if err != nil {
 		log.Println(err)
 	}